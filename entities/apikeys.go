@@ -0,0 +1,21 @@
+package entities
+
+import "time"
+
+// APIKey authenticates a service. Only the hash of the key is stored, the key itself is only returned when it is
+// created or rotated
+type APIKey struct {
+	ID string `bson:"_id"`
+	// Hash is the hex encoded SHA-256 of the key, which finds it when verifying a request
+	Hash    string `bson:"hash"`
+	Service string `bson:"service"`
+	// Scopes are the permissions granted to the service
+	Scopes    []string  `bson:"scopes"`
+	CreatedAt time.Time `bson:"created_at"`
+	// ExpiresAt is when the key stops being valid, keys without it never expire
+	ExpiresAt time.Time `bson:"expires_at,omitempty"`
+	RotatedAt time.Time `bson:"rotated_at,omitempty"`
+	// LastUsedAt is when the key last authenticated a request, it is only stored once per minute at most
+	LastUsedAt time.Time `bson:"last_used_at,omitempty"`
+	RevokedAt  time.Time `bson:"revoked_at,omitempty"`
+}
@@ -3,7 +3,67 @@ package entities
 import "google.golang.org/grpc/status"
 
 var (
-	InvalidEmailError           = status.Error(3, "entered email is not valid")
-	AlreadyRegisteredEmailError = status.Error(6, "email already registered")
-	NotFoundUser                = status.Error(5, "could not find user")
+	InvalidEmailError             = status.Error(3, "entered email is not valid")
+	AlreadyRegisteredEmailError   = status.Error(6, "email already registered")
+	NotFoundUser                  = status.Error(5, "could not find user")
+	EmptyPasswordError            = status.Error(3, "password cannot be empty")
+	VersionMismatchError          = status.Error(9, "user version does not match the expected one")
+	InvalidVersionError           = status.Error(3, "expected user version is not valid")
+	InvalidPageSizeError          = status.Error(3, "page size cannot be negative")
+	InvalidPageTokenError         = status.Error(3, "page token is not valid for this request")
+	NotFoundWebhook               = status.Error(5, "could not find webhook")
+	InvalidWebhookURLError        = status.Error(3, "webhook url must be an absolute http or https url")
+	InvalidWebhookTypeError       = status.Error(3, "webhook event types are not valid")
+	InvalidWebhookFormatError     = status.Error(3, "webhook event format is not valid")
+	EmptyWebhookReplayError       = status.Error(3, "replay must select deliveries, dead letters or a sequence")
+	WebhooksDisabledError         = status.Error(12, "webhooks are not enabled")
+	InvalidCredentialsError       = status.Error(16, "invalid email or password")
+	InvalidRefreshTokenError      = status.Error(16, "refresh token is not valid")
+	InvalidAccessTokenError       = status.Error(16, "access token is not valid")
+	InvalidAPIKeyError            = status.Error(16, "API key is not valid")
+	MissingCredentialsError       = status.Error(16, "request must be authenticated with an access token or an API key")
+	UnsupportedAuthorizationError = status.Error(16, "authorization must be a Bearer access token or an ApiKey")
+	NotFoundRefreshToken          = status.Error(5, "could not find refresh token")
+	AuthDisabledError             = status.Error(12, "authentication is not enabled")
+	InvalidRoleError              = status.Error(3, "role must be support or admin")
+	NotFoundAPIKey                = status.Error(5, "could not find API key")
+	RevokedAPIKeyError            = status.Error(9, "API key was revoked")
+	InvalidAPIKeyServiceError     = status.Error(3, "API key service must be a name of letters, digits, dots, dashes or underscores")
+	InvalidAPIKeyExpiryError      = status.Error(3, "API key expiry must be in the future")
+	APIKeysDisabledError          = status.Error(12, "API keys are not enabled")
 )
+
+// ImmutableFieldError is returned when an update mask contains a field that cannot be modified
+func ImmutableFieldError(field string) error {
+	return status.Errorf(3, "field %q cannot be updated", field)
+}
+
+// UnknownFieldError is returned when an update mask contains a field that does not exist in users
+func UnknownFieldError(field string) error {
+	return status.Errorf(3, "unknown user field %q", field)
+}
+
+// InvalidOrderByError is returned when users are requested to be sorted in a way that is not supported
+func InvalidOrderByError(orderBy string) error {
+	return status.Errorf(3, "users cannot be ordered by %q", orderBy)
+}
+
+// UnknownEventFieldError is returned when events are filtered by a field that is not described in them
+func UnknownEventFieldError(field string) error {
+	return status.Errorf(3, "events cannot be filtered by changes of field %q", field)
+}
+
+// UnknownWebhookFieldError is returned when a webhook update mask contains a field that cannot be updated
+func UnknownWebhookFieldError(field string) error {
+	return status.Errorf(3, "unknown webhook field %q", field)
+}
+
+// PermissionDeniedError is returned when the caller lacks the permission a request requires
+func PermissionDeniedError(permission string) error {
+	return status.Errorf(7, "permission %q is required", permission)
+}
+
+// InvalidAPIKeyScopeError is returned when an API key scope is not a permission services can be granted
+func InvalidAPIKeyScopeError(scope string) error {
+	return status.Errorf(3, "unknown API key scope %q", scope)
+}
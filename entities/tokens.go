@@ -0,0 +1,18 @@
+package entities
+
+import "time"
+
+// RefreshToken is an issued refresh token. Only the hash of the token is stored, so stored tokens cannot be used
+type RefreshToken struct {
+	// Hash is the hex encoded SHA-256 of the token, which identifies it
+	Hash string `bson:"_id"`
+	// FamilyID is shared by every token obtained from the same login, which are revoked together
+	FamilyID  string    `bson:"family_id"`
+	UserID    string    `bson:"user_id"`
+	CreatedAt time.Time `bson:"created_at"`
+	ExpiresAt time.Time `bson:"expires_at"`
+	// UsedAt is when the token was exchanged for a new one, tokens can only be used once
+	UsedAt time.Time `bson:"used_at,omitempty"`
+	// RevokedAt is when the family of the token was revoked, by a logout or by the reuse of one of its tokens
+	RevokedAt time.Time `bson:"revoked_at,omitempty"`
+}
@@ -10,9 +10,27 @@ type User struct {
 	FirstName string             `bson:"first_name,omitempty"`
 	LastName  string             `bson:"last_name,omitempty"`
 	Nickname  string             `bson:"nickname,omitempty"`
-	Password  string             `bson:"password,omitempty"`
-	Email     string             `bson:"email,omitempty"`
-	Country   string             `bson:"country,omitempty"`
-	CreatedAt time.Time          `bson:"created_at,omitempty"`
-	UpdatedAt time.Time          `bson:"updated_at,omitempty"`
+	// Password holds legacy plain text passwords, it is emptied once the password is hashed
+	Password     string        `bson:"password,omitempty"`
+	PasswordHash *PasswordHash `bson:"password_hash,omitempty"`
+	Email        string        `bson:"email,omitempty"`
+	Country      string        `bson:"country,omitempty"`
+	CreatedAt    time.Time     `bson:"created_at,omitempty"`
+	UpdatedAt    time.Time     `bson:"updated_at,omitempty"`
+	// Version is increased on every update to detect concurrent modifications
+	Version int64 `bson:"version,omitempty"`
+	// Roles decide what the user is allowed to do. Users stored before roles existed have none, and are
+	// treated as having the user role
+	Roles []string `bson:"roles,omitempty"`
+}
+
+// PasswordHash stores a derived password key along with the algorithm and cost used to
+// derive it, so the cost can be raised later on without invalidating stored passwords
+type PasswordHash struct {
+	Algorithm   string `bson:"algorithm"`
+	Memory      uint32 `bson:"memory"`
+	Iterations  uint32 `bson:"iterations"`
+	Parallelism uint8  `bson:"parallelism"`
+	Salt        []byte `bson:"salt"`
+	Key         []byte `bson:"key"`
 }
@@ -0,0 +1,139 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+	"userManagement/entities"
+	"userManagement/infra/database"
+)
+
+// APIKeyPrefix starts every API key, so leaked keys are easy to recognise
+const APIKeyPrefix = "umk_"
+
+// apiKeyTouchInterval is the minimum time between two stores of the last use of a key, sparing a write per request
+const apiKeyTouchInterval = time.Minute
+
+var serviceNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// ValidateScopes checks that every scope is a permission which can be granted to services
+func ValidateScopes(scopes []string) error {
+	for _, scope := range scopes {
+		if !scopePermissions[Permission(scope)] {
+			return entities.InvalidAPIKeyScopeError(scope)
+		}
+	}
+	return nil
+}
+
+// APIKeys issues the API keys of services and verifies the ones received in requests.
+// Only the SHA-256 of the keys is stored, so they are only known when they are created or rotated
+type APIKeys struct {
+	store database.APIKeyStoreInterface
+}
+
+// NewAPIKeys creates the API keys of the store
+func NewAPIKeys(store database.APIKeyStoreInterface) *APIKeys {
+	return &APIKeys{store: store}
+}
+
+// Create issues a key for the service with the scopes, which never expires when the expiry is zero.
+// It returns the stored key along with the key itself
+func (k *APIKeys) Create(ctx context.Context, service string, scopes []string, expiresAt time.Time) (*entities.APIKey, string, error) {
+	if !serviceNamePattern.MatchString(service) {
+		return nil, "", entities.InvalidAPIKeyServiceError
+	}
+	if err := ValidateScopes(scopes); err != nil {
+		return nil, "", err
+	}
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	if !expiresAt.IsZero() && !expiresAt.After(now) {
+		return nil, "", entities.InvalidAPIKeyExpiryError
+	}
+
+	id, err := randomHex(12)
+	if err != nil {
+		return nil, "", err
+	}
+	secret, err := newAPIKey()
+	if err != nil {
+		return nil, "", err
+	}
+	key := &entities.APIKey{
+		ID:        id,
+		Hash:      hashToken(secret),
+		Service:   service,
+		Scopes:    append([]string{}, scopes...),
+		CreatedAt: now,
+	}
+	if !expiresAt.IsZero() {
+		key.ExpiresAt = expiresAt.UTC().Truncate(time.Millisecond)
+	}
+	if err := k.store.CreateAPIKey(ctx, key); err != nil {
+		return nil, "", err
+	}
+	return key, secret, nil
+}
+
+// List returns the keys of the service, or every key when it is empty
+func (k *APIKeys) List(ctx context.Context, service string) ([]*entities.APIKey, error) {
+	return k.store.ListAPIKeys(ctx, service)
+}
+
+// Rotate replaces a key which was not revoked with a new one, keeping its service, scopes and expiry.
+// The previous key is no longer valid, returning the stored key along with the new one
+func (k *APIKeys) Rotate(ctx context.Context, id string) (*entities.APIKey, string, error) {
+	secret, err := newAPIKey()
+	if err != nil {
+		return nil, "", err
+	}
+	key, err := k.store.RotateAPIKey(ctx, id, hashToken(secret), time.Now())
+	if err != nil {
+		return nil, "", err
+	}
+	return key, secret, nil
+}
+
+// Revoke revokes a key, which cannot be used or rotated anymore
+func (k *APIKeys) Revoke(ctx context.Context, id string) (*entities.APIKey, error) {
+	return k.store.RevokeAPIKey(ctx, id, time.Now())
+}
+
+// VerifyAPIKey returns the service of a key which was neither revoked nor expired, storing when it was used
+func (k *APIKeys) VerifyAPIKey(ctx context.Context, secret string) (*Principal, error) {
+	if !strings.HasPrefix(secret, APIKeyPrefix) {
+		return nil, entities.InvalidAPIKeyError
+	}
+	key, err := k.store.FindAPIKey(ctx, hashToken(secret))
+	if err == entities.NotFoundAPIKey {
+		return nil, entities.InvalidAPIKeyError
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if !key.RevokedAt.IsZero() || (!key.ExpiresAt.IsZero() && !now.Before(key.ExpiresAt)) {
+		return nil, entities.InvalidAPIKeyError
+	}
+	if now.Sub(key.LastUsedAt) >= apiKeyTouchInterval {
+		// The request is authenticated even if its use cannot be stored
+		if err := k.store.TouchAPIKey(ctx, key.ID, now); err != nil {
+			log.Printf("Could not store the use of API key %s: %v", key.ID, err)
+		}
+	}
+	return &Principal{Kind: PrincipalService, ID: key.Service, Scopes: key.Scopes, KeyID: key.ID}, nil
+}
+
+// newAPIKey returns a new key of 32 random bytes, encoded as base64url after the prefix
+func newAPIKey() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return APIKeyPrefix + base64.RawURLEncoding.EncodeToString(secret), nil
+}
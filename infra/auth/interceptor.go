@@ -0,0 +1,233 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"strings"
+	"userManagement/entities"
+	pb "userManagement/proto"
+)
+
+const (
+	// AuthorizationHeader carries a Bearer access token or an ApiKey, as in "Bearer eyJhbGci..." or "ApiKey 3f9a..."
+	AuthorizationHeader = "authorization"
+	// APIKeyHeader carries an API key alone, the gateway forwards it from the X-Api-Key header
+	APIKeyHeader = "x-api-key"
+	// ClientCertificateHeader carries the subject of the client certificate the gateway verified for a REST caller,
+	// which is only trusted on connections authenticated with the gateway certificate
+	ClientCertificateHeader = "x-client-certificate"
+
+	bearerScheme = "bearer"
+	apiKeyScheme = "apikey"
+
+	PrincipalUser    = "user"
+	PrincipalService = "service"
+	// PrincipalCertificate identifies the callers of public methods without credentials by their client certificate
+	PrincipalCertificate = "certificate"
+)
+
+// DefaultPublicMethods are the methods called without credentials: signing up and obtaining tokens
+var DefaultPublicMethods = []string{"CreateUser", "Login", "RefreshToken", "Logout"}
+
+// Principal is who performs an authenticated request
+type Principal struct {
+	// Kind is PrincipalUser for users authenticated by an access token, PrincipalService for API keys
+	// and PrincipalCertificate for callers of public methods identified by their client certificate alone
+	Kind string
+	// ID is the user id of users, the name of services and the certificate subject of certificates
+	ID    string
+	Email string
+	// Roles decide the permissions of users, which have the ones granted when their access token was issued
+	Roles []string
+	// Scopes are the permissions of services, granted to the API key which authenticated them
+	Scopes []string
+	// KeyID is the id of the API key which authenticated a service
+	KeyID string
+	// Certificate is the subject of the client certificate verified on the connection, as in "CN=reports,O=Acme"
+	Certificate string
+}
+
+// String returns the kind and id of the principal, as in "user:6390b6...", "service:notification-consumer"
+// or "certificate:CN=reports"
+func (p *Principal) String() string {
+	return p.Kind + ":" + p.ID
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a context carrying the principal performing the request
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFromContext returns the principal performing the request, which is not found for anonymous requests
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	principal, found := ctx.Value(principalKey{}).(*Principal)
+	return principal, found
+}
+
+// APIKeyVerifier checks the API keys of services, returning the principal they belong to
+type APIKeyVerifier interface {
+	VerifyAPIKey(ctx context.Context, key string) (*Principal, error)
+}
+
+// Authenticator authenticates the requests to the grpc server with access tokens or API keys,
+// letting anonymous requests call the public methods only
+type Authenticator struct {
+	tokens  *Issuer
+	apiKeys APIKeyVerifier
+	public  map[string]bool
+	// GatewaySubject is the subject of the client certificate of the REST gateway. The calls on connections verified
+	// with it are identified by the certificate of the REST caller the gateway forwards, if any, instead
+	GatewaySubject string
+}
+
+// NewAuthenticator creates an authenticator verifying access tokens with the issuer and API keys with the verifier,
+// which can be nil to reject every API key. Public methods are the names of UserManagement methods
+func NewAuthenticator(tokens *Issuer, apiKeys APIKeyVerifier, publicMethods []string) *Authenticator {
+	public := map[string]bool{}
+	for _, method := range publicMethods {
+		public[FullMethodName(method)] = true
+	}
+	return &Authenticator{tokens: tokens, apiKeys: apiKeys, public: public}
+}
+
+// FullMethodName returns the grpc name of a UserManagement method, as received by the interceptors
+func FullMethodName(method string) string {
+	return "/" + pb.UserManagement_ServiceDesc.ServiceName + "/" + method
+}
+
+// ValidateMethods checks that every name is a method of the UserManagement service
+func ValidateMethods(methods []string) error {
+	known := map[string]bool{}
+	for _, method := range pb.UserManagement_ServiceDesc.Methods {
+		known[method.MethodName] = true
+	}
+	for _, stream := range pb.UserManagement_ServiceDesc.Streams {
+		known[stream.StreamName] = true
+	}
+	for _, method := range methods {
+		if !known[method] {
+			return fmt.Errorf("unknown UserManagement method %q", method)
+		}
+	}
+	return nil
+}
+
+// Authenticate returns the principal of the credentials found in the request metadata
+func (a *Authenticator) Authenticate(ctx context.Context) (*Principal, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if keys := md.Get(APIKeyHeader); len(keys) > 0 {
+		return a.verifyAPIKey(ctx, keys[0])
+	}
+	authorization := md.Get(AuthorizationHeader)
+	if len(authorization) == 0 {
+		return nil, entities.MissingCredentialsError
+	}
+
+	scheme, credentials, _ := strings.Cut(strings.TrimSpace(authorization[0]), " ")
+	credentials = strings.TrimSpace(credentials)
+	switch strings.ToLower(scheme) {
+	case bearerScheme:
+		claims, err := a.tokens.Verify(credentials)
+		if err != nil {
+			return nil, err
+		}
+		return &Principal{Kind: PrincipalUser, ID: claims.Subject, Email: claims.Email, Roles: claims.Roles}, nil
+	case apiKeyScheme:
+		return a.verifyAPIKey(ctx, credentials)
+	}
+	return nil, entities.UnsupportedAuthorizationError
+}
+
+func (a *Authenticator) verifyAPIKey(ctx context.Context, key string) (*Principal, error) {
+	if a.apiKeys == nil || key == "" {
+		return nil, entities.InvalidAPIKeyError
+	}
+	return a.apiKeys.VerifyAPIKey(ctx, key)
+}
+
+// UnaryInterceptor authenticates the unary calls, adding their principal to the context
+func (a *Authenticator) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := a.authenticate(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamInterceptor authenticates the streams, adding their principal to the stream context
+func (a *Authenticator) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := a.authenticate(stream.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &authenticatedStream{ServerStream: stream, ctx: ctx})
+	}
+}
+
+// authenticate returns the context of the call carrying its principal. Public methods are also called without
+// credentials, or with invalid ones, such as an expired access token sent along a refresh. Those calls are
+// identified by the client certificate of the connection, if it has one, which grants no permission by itself
+func (a *Authenticator) authenticate(ctx context.Context, method string) (context.Context, error) {
+	subject := a.callerCertificate(ctx)
+	principal, err := a.Authenticate(ctx)
+	if err != nil {
+		if !a.public[method] {
+			return nil, err
+		}
+		if subject == "" {
+			return ctx, nil
+		}
+		principal = &Principal{Kind: PrincipalCertificate, ID: subject}
+	}
+	if subject != "" {
+		principal.Certificate = subject
+	}
+	return WithPrincipal(ctx, principal), nil
+}
+
+// callerCertificate returns the subject of the client certificate of the caller, which the gateway forwards for the
+// REST callers. The forwarded subject of any other connection is ignored, so callers cannot claim a certificate
+func (a *Authenticator) callerCertificate(ctx context.Context) string {
+	subject := certificateSubject(ctx)
+	if a.GatewaySubject == "" || subject != a.GatewaySubject {
+		return subject
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	if forwarded := md.Get(ClientCertificateHeader); len(forwarded) > 0 {
+		return forwarded[0]
+	}
+	return ""
+}
+
+// certificateSubject returns the subject of the client certificate verified on the connection of the call,
+// which is empty without TLS or client certificate
+func certificateSubject(ctx context.Context) string {
+	p, found := peer.FromContext(ctx)
+	if !found {
+		return ""
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	return info.State.VerifiedChains[0][0].Subject.String()
+}
+
+// authenticatedStream replaces the context of a stream with the one carrying its principal
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,164 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v4"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	AlgorithmRS256 = "RS256"
+	AlgorithmEdDSA = "EdDSA"
+
+	// minRSABits is the smallest RSA key accepted, as recommended for RS256 by RFC 7518
+	minRSABits = 2048
+)
+
+// Key signs and verifies access tokens. Keys loaded without their private part only verify them
+type Key struct {
+	ID      string
+	method  jwt.SigningMethod
+	private crypto.PrivateKey
+	public  crypto.PublicKey
+}
+
+// Algorithm returns the JWT algorithm of the key, RS256 or EdDSA
+func (k *Key) Algorithm() string {
+	return k.method.Alg()
+}
+
+// KeySet holds the keys verifying access tokens, by id, and the one signing new tokens. Keys are rotated by adding
+// a new key, signing with it, and removing the previous one once the tokens it signed expired
+type KeySet struct {
+	signing *Key
+	keys    map[string]*Key
+}
+
+// LoadKeys reads every .pem file of the directory as a key whose id is the file name without extension. Files can
+// hold RSA or Ed25519 private keys, in PKCS #8 form or PKCS #1 for RSA, or public keys, which only verify tokens.
+// The signing key is the private key with the received id, which can be empty when there is a single private key
+func LoadKeys(dir, signingKeyID string) (*KeySet, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	set := &KeySet{keys: map[string]*Key{}}
+	var private []*Key
+	for _, path := range paths {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("could not read key file: %w", err)
+		}
+		key, err := parseKey(strings.TrimSuffix(filepath.Base(path), ".pem"), content)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key file %s: %w", path, err)
+		}
+		set.keys[key.ID] = key
+		if key.private != nil {
+			private = append(private, key)
+		}
+	}
+
+	switch {
+	case signingKeyID != "":
+		key, found := set.keys[signingKeyID]
+		if !found || key.private == nil {
+			return nil, fmt.Errorf("no private key with id %q found in %s", signingKeyID, dir)
+		}
+		set.signing = key
+	case len(private) == 1:
+		set.signing = private[0]
+	case len(private) == 0:
+		return nil, fmt.Errorf("no private key found in %s", dir)
+	default:
+		return nil, fmt.Errorf("%d private keys found in %s, the signing one must be selected", len(private), dir)
+	}
+	return set, nil
+}
+
+// GenerateKeys creates a key set holding a new Ed25519 key with a random id
+func GenerateKeys() (*KeySet, error) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	id, err := randomHex(8)
+	if err != nil {
+		return nil, err
+	}
+	key, err := newKey(id, private)
+	if err != nil {
+		return nil, err
+	}
+	return &KeySet{signing: key, keys: map[string]*Key{key.ID: key}}, nil
+}
+
+// SigningKey returns the key signing new tokens
+func (s *KeySet) SigningKey() *Key {
+	return s.signing
+}
+
+// Key returns the key with the received id
+func (s *KeySet) Key(id string) (*Key, bool) {
+	key, found := s.keys[id]
+	return key, found
+}
+
+// parseKey decodes the first PEM block of a key file
+func parseKey(id string, content []byte) (*Key, error) {
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, errors.New("no PEM encoded key found")
+	}
+
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newKey(id, key)
+}
+
+func newKey(id string, key interface{}) (*Key, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		if k.N.BitLen() < minRSABits {
+			return nil, fmt.Errorf("RSA keys must have at least %d bits", minRSABits)
+		}
+		return &Key{ID: id, method: jwt.SigningMethodRS256, private: k, public: &k.PublicKey}, nil
+	case *rsa.PublicKey:
+		if k.N.BitLen() < minRSABits {
+			return nil, fmt.Errorf("RSA keys must have at least %d bits", minRSABits)
+		}
+		return &Key{ID: id, method: jwt.SigningMethodRS256, public: k}, nil
+	case ed25519.PrivateKey:
+		return &Key{ID: id, method: jwt.SigningMethodEdDSA, private: k, public: k.Public()}, nil
+	case ed25519.PublicKey:
+		return &Key{ID: id, method: jwt.SigningMethodEdDSA, public: k}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %T, only RSA and Ed25519 keys are supported", key)
+}
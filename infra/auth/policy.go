@@ -0,0 +1,71 @@
+package auth
+
+// Roles of the users, stored along them and carried by their access tokens
+const (
+	RoleUser    = "user"
+	RoleSupport = "support"
+	RoleAdmin   = "admin"
+)
+
+// Permission is an action on the service. The ones ending in .self only allow acting on the caller's own user
+type Permission string
+
+const (
+	PermissionReadSelf       Permission = "users.read.self"
+	PermissionUpdateSelf     Permission = "users.update.self"
+	PermissionDeleteSelf     Permission = "users.delete.self"
+	PermissionRead           Permission = "users.read"
+	PermissionUpdate         Permission = "users.update"
+	PermissionUpdatePassword Permission = "users.update_password"
+	PermissionDelete         Permission = "users.delete"
+	PermissionList           Permission = "users.list"
+	PermissionVerifyPassword Permission = "users.verify_password"
+	PermissionWatch          Permission = "events.watch"
+	PermissionWebhooks       Permission = "webhooks.manage"
+	PermissionRoles          Permission = "roles.manage"
+	PermissionAPIKeys        Permission = "apikeys.manage"
+)
+
+// rolePermissions are the permissions granted by every role. Users act on themselves, support also reads,
+// edits and watches any user but cannot set their passwords, and admins can do everything
+var rolePermissions = map[string][]Permission{
+	RoleUser: {PermissionReadSelf, PermissionUpdateSelf, PermissionDeleteSelf},
+	RoleSupport: {PermissionReadSelf, PermissionUpdateSelf, PermissionDeleteSelf,
+		PermissionRead, PermissionUpdate, PermissionWatch},
+	RoleAdmin: {PermissionReadSelf, PermissionUpdateSelf, PermissionDeleteSelf,
+		PermissionRead, PermissionUpdate, PermissionUpdatePassword, PermissionDelete, PermissionList,
+		PermissionVerifyPassword, PermissionWatch, PermissionWebhooks, PermissionRoles, PermissionAPIKeys},
+}
+
+// scopePermissions are the permissions which can be granted to services, the ones on their own user do not apply
+var scopePermissions = map[Permission]bool{
+	PermissionRead: true, PermissionUpdate: true, PermissionUpdatePassword: true, PermissionDelete: true, PermissionList: true,
+	PermissionVerifyPassword: true, PermissionWatch: true, PermissionWebhooks: true, PermissionRoles: true,
+	PermissionAPIKeys: true,
+}
+
+// IsGrantableRole reports whether the role can be granted and revoked. The user role cannot, every user has it
+func IsGrantableRole(role string) bool {
+	return role == RoleSupport || role == RoleAdmin
+}
+
+// Can reports whether a scope or any role of the principal grants the permission. Users always have the user role
+func (p *Principal) Can(permission Permission) bool {
+	for _, scope := range p.Scopes {
+		if Permission(scope) == permission {
+			return true
+		}
+	}
+	roles := p.Roles
+	if p.Kind == PrincipalUser {
+		roles = append([]string{RoleUser}, roles...)
+	}
+	for _, role := range roles {
+		for _, granted := range rolePermissions[role] {
+			if granted == permission {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,256 @@
+// Package auth issues the signed JWT access tokens and the opaque refresh tokens users obtain by logging in,
+// and authenticates the requests to the grpc server with access tokens or API keys.
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v4"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"log"
+	"strings"
+	"time"
+	"userManagement/entities"
+	"userManagement/infra/database"
+	pb "userManagement/proto"
+)
+
+// TokenType is the type of the issued access tokens, sent before them in the authorization header
+const TokenType = "Bearer"
+
+// Config holds the settings of the issued tokens, the keys signing them and the methods called without them
+type Config struct {
+	// KeysDir holds the PEM files of the keys signing and verifying access tokens. A key is generated at startup
+	// when it is empty, so the tokens issued before a restart are no longer valid after it
+	KeysDir string
+	// SigningKey is the id of the key signing new access tokens, needed when KeysDir has several private keys
+	SigningKey string
+	// Issuer and Audience are the iss and aud claims of the access tokens, which are checked when verifying them
+	Issuer   string
+	Audience string
+
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+
+	// PublicMethods is the comma separated list of the UserManagement methods called without credentials
+	PublicMethods string
+	// Admin is the email of a user granted the admin role at startup, so roles can be managed before any admin exists
+	Admin string
+}
+
+// DefaultConfig returns the authentication settings used when nothing else is configured
+func DefaultConfig() Config {
+	return Config{
+		Issuer:          "usermanagement",
+		Audience:        "usermanagement",
+		AccessTokenTTL:  15 * time.Minute,
+		RefreshTokenTTL: 30 * 24 * time.Hour,
+		PublicMethods:   strings.Join(DefaultPublicMethods, ","),
+	}
+}
+
+// Validate checks that tokens can be issued with the config, and that the public methods exist
+func (c Config) Validate() error {
+	if c.Issuer == "" || c.Audience == "" {
+		return errors.New("token issuer and audience are required")
+	}
+	if c.AccessTokenTTL <= 0 || c.RefreshTokenTTL <= 0 {
+		return errors.New("access and refresh token lifetimes must be positive")
+	}
+	if c.SigningKey != "" && c.KeysDir == "" {
+		return errors.New("a signing key can only be selected along with the keys directory")
+	}
+	return ValidateMethods(c.Public())
+}
+
+// Public returns the names of the public methods
+func (c Config) Public() []string {
+	var methods []string
+	for _, method := range strings.Split(c.PublicMethods, ",") {
+		if method = strings.TrimSpace(method); method != "" {
+			methods = append(methods, method)
+		}
+	}
+	return methods
+}
+
+// Subject is the user the tokens are issued to
+type Subject struct {
+	UserID string
+	Email  string
+	Roles  []string
+}
+
+// Claims are the claims of the access tokens, whose subject is the user id. Roles are the ones of the user when
+// the token was issued, so granting or revoking a role takes effect once the token is refreshed
+type Claims struct {
+	Email string   `json:"email,omitempty"`
+	Roles []string `json:"roles,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// Issuer issues access and refresh tokens. Every login starts a family of refresh tokens, each of them exchanged
+// once for a new pair with a token of the same family. Using a token of a family twice revokes the whole family,
+// since one of the uses was done by someone who stole it
+type Issuer struct {
+	config Config
+	keys   *KeySet
+	store  database.RefreshTokenStoreInterface
+}
+
+// NewIssuer creates an issuer signing with the received keys and storing refresh tokens in the store
+func NewIssuer(config Config, keys *KeySet, store database.RefreshTokenStoreInterface) *Issuer {
+	return &Issuer{config: config, keys: keys, store: store}
+}
+
+// Login issues the tokens of a new login of the subject
+func (i *Issuer) Login(ctx context.Context, subject Subject) (*pb.TokenResponse, error) {
+	familyID, err := randomHex(16)
+	if err != nil {
+		return nil, err
+	}
+	return i.issue(ctx, subject, familyID)
+}
+
+// Refresh exchanges a refresh token for new tokens of the same family. The subject is looked up again, so the new
+// access token describes the user as it is now, and tokens of deleted users are revoked
+func (i *Issuer) Refresh(ctx context.Context, refreshToken string, lookup func(ctx context.Context, userID string) (Subject, error)) (*pb.TokenResponse, error) {
+	now := time.Now()
+	token, err := i.store.UseRefreshToken(ctx, hashToken(refreshToken), now)
+	if err == entities.NotFoundRefreshToken {
+		return nil, entities.InvalidRefreshTokenError
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !token.RevokedAt.IsZero() || token.ExpiresAt.Before(now) {
+		return nil, entities.InvalidRefreshTokenError
+	}
+	if !token.UsedAt.IsZero() {
+		log.Printf("Refresh token of user %s used twice, revoking its family %s", token.UserID, token.FamilyID)
+		if _, err := i.store.RevokeRefreshTokenFamily(ctx, token.FamilyID, now); err != nil {
+			return nil, err
+		}
+		return nil, entities.InvalidRefreshTokenError
+	}
+
+	subject, err := lookup(ctx, token.UserID)
+	if status.Code(err) == codes.NotFound {
+		if _, err := i.store.RevokeRefreshTokenFamily(ctx, token.FamilyID, now); err != nil {
+			return nil, err
+		}
+		return nil, entities.InvalidRefreshTokenError
+	}
+	if err != nil {
+		return nil, err
+	}
+	return i.issue(ctx, subject, token.FamilyID)
+}
+
+// Logout revokes the family of the refresh token, reporting whether it had tokens which were not revoked yet
+func (i *Issuer) Logout(ctx context.Context, refreshToken string) (bool, error) {
+	token, err := i.store.GetRefreshToken(ctx, hashToken(refreshToken))
+	if err == entities.NotFoundRefreshToken {
+		return false, entities.InvalidRefreshTokenError
+	}
+	if err != nil {
+		return false, err
+	}
+	revoked, err := i.store.RevokeRefreshTokenFamily(ctx, token.FamilyID, time.Now())
+	return revoked > 0, err
+}
+
+// Verify checks the signature, lifetime, issuer and audience of an access token, returning its claims
+func (i *Issuer) Verify(accessToken string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(accessToken, claims, func(token *jwt.Token) (interface{}, error) {
+		id, _ := token.Header["kid"].(string)
+		key, found := i.keys.Key(id)
+		if !found {
+			return nil, fmt.Errorf("unknown key %q", id)
+		}
+		if token.Method.Alg() != key.Algorithm() {
+			return nil, fmt.Errorf("key %q does not sign %s tokens", id, token.Method.Alg())
+		}
+		return key.public, nil
+	}, jwt.WithValidMethods([]string{AlgorithmRS256, AlgorithmEdDSA}))
+	if err != nil {
+		return nil, entities.InvalidAccessTokenError
+	}
+	if !claims.VerifyIssuer(i.config.Issuer, true) || !claims.VerifyAudience(i.config.Audience, true) || claims.Subject == "" {
+		return nil, entities.InvalidAccessTokenError
+	}
+	return claims, nil
+}
+
+// issue signs a new access token and stores a new refresh token of the family
+func (i *Issuer) issue(ctx context.Context, subject Subject, familyID string) (*pb.TokenResponse, error) {
+	now := time.Now().UTC().Truncate(time.Second)
+	tokenID, err := randomHex(16)
+	if err != nil {
+		return nil, err
+	}
+	key := i.keys.SigningKey()
+	accessToken := jwt.NewWithClaims(key.method, Claims{
+		Email: subject.Email,
+		Roles: subject.Roles,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        tokenID,
+			Issuer:    i.config.Issuer,
+			Subject:   subject.UserID,
+			Audience:  jwt.ClaimStrings{i.config.Audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(i.config.AccessTokenTTL)),
+		},
+	})
+	accessToken.Header["kid"] = key.ID
+	signed, err := accessToken.SignedString(key.private)
+	if err != nil {
+		return nil, fmt.Errorf("could not sign access token: %w", err)
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	refreshToken := base64.RawURLEncoding.EncodeToString(secret)
+	err = i.store.CreateRefreshToken(ctx, &entities.RefreshToken{
+		Hash:      hashToken(refreshToken),
+		FamilyID:  familyID,
+		UserID:    subject.UserID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(i.config.RefreshTokenTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.TokenResponse{
+		AccessToken:      signed,
+		TokenType:        TokenType,
+		ExpiresIn:        int64(i.config.AccessTokenTTL.Seconds()),
+		RefreshToken:     refreshToken,
+		RefreshExpiresIn: int64(i.config.RefreshTokenTTL.Seconds()),
+	}, nil
+}
+
+// hashToken returns the hex encoded SHA-256 of a refresh token, which is what the store keeps
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(size int) (string, error) {
+	value := make([]byte, size)
+	if _, err := rand.Read(value); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(value), nil
+}
@@ -0,0 +1,169 @@
+// Package certs builds the TLS configs of the grpc and REST servers and of their clients, loading the certificates
+// from PEM files which are loaded again when they change, so certificates are renewed without restarting.
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// DefaultReloadInterval is the minimum time between two checks for changes of the certificate files
+const DefaultReloadInterval = 10 * time.Second
+
+// Config holds the TLS settings of the grpc and REST servers, which serve plain text when no certificate is set
+type Config struct {
+	// CertFile and KeyFile hold the PEM certificate chain and private key of the servers
+	CertFile string
+	KeyFile  string
+	// ClientCAFile holds the CA certificates verifying the client certificates, which are only requested when it is set
+	ClientCAFile string
+	// RequireClientCert rejects the clients without a certificate signed by the client CAs
+	RequireClientCert bool
+	// ReloadInterval is the minimum time between two checks for changes of the files, done on new connections
+	ReloadInterval time.Duration
+	// Gateway is the client config of the REST gateway, which calls the grpc server through the loopback
+	Gateway ClientConfig
+}
+
+// ClientConfig holds the TLS settings of a client of the grpc server
+type ClientConfig struct {
+	// CertFile and KeyFile hold the client certificate and key, sent to servers verifying client certificates
+	CertFile string
+	KeyFile  string
+	// CAFile holds the CA certificates verifying the server, the system ones are used when empty
+	CAFile string
+	// ServerName is the name expected in the server certificate
+	ServerName         string
+	InsecureSkipVerify bool
+}
+
+// DefaultConfig returns the TLS settings used when nothing else is configured, which serve plain text
+func DefaultConfig() Config {
+	return Config{
+		ReloadInterval: DefaultReloadInterval,
+		Gateway:        ClientConfig{ServerName: "localhost"},
+	}
+}
+
+// Enabled reports whether the servers serve TLS
+func (c Config) Enabled() bool {
+	return c.CertFile != ""
+}
+
+// Validate checks that certificates and keys are set in pairs, and that client certificates are verified against a CA
+// which the gateway certificate is also needed for when they are required
+func (c Config) Validate() error {
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return errors.New("the TLS certificate and key files must be set together")
+	}
+	if c.ClientCAFile != "" && !c.Enabled() {
+		return errors.New("client certificates can only be verified along with the TLS certificate")
+	}
+	if c.RequireClientCert && c.ClientCAFile == "" {
+		return errors.New("the client CA file is required to require client certificates")
+	}
+	if c.ReloadInterval <= 0 {
+		return errors.New("the TLS reload interval must be positive")
+	}
+	if (c.Gateway.CertFile == "") != (c.Gateway.KeyFile == "") {
+		return errors.New("the gateway certificate and key files must be set together")
+	}
+	if c.RequireClientCert && c.Gateway.CertFile == "" {
+		return errors.New("the gateway certificate is required to require client certificates")
+	}
+	return nil
+}
+
+// ServerTLSConfig returns the TLS config of a server offering the protocols, which takes the certificate and the client
+// CAs of the reloader on every handshake. Client certificates are verified when the reloader has CAs
+func ServerTLSConfig(reloader *Reloader, requireClientCert bool, protocols ...string) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: protocols,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return reloader.Certificate()
+		},
+		// The config of the handshake replaces this one, so it repeats every setting
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			certificate, err := reloader.Certificate()
+			if err != nil {
+				return nil, err
+			}
+			config := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				NextProtos:   protocols,
+				Certificates: []tls.Certificate{*certificate},
+			}
+			if pool := reloader.CAs(); pool != nil {
+				config.ClientCAs = pool
+				config.ClientAuth = tls.VerifyClientCertIfGiven
+				if requireClientCert {
+					config.ClientAuth = tls.RequireAndVerifyClientCert
+				}
+			}
+			return config, nil
+		},
+	}
+}
+
+// ClientTLSConfig returns the TLS config of a client, which sends the certificate of the config, loaded again when it
+// changes, to the servers asking for it
+func ClientTLSConfig(cfg ClientConfig, reloadInterval time.Duration) (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+	if cfg.CAFile != "" {
+		pool, err := loadCAs(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+	if cfg.CertFile != "" {
+		reloader, err := NewReloader(cfg.CertFile, cfg.KeyFile, "", reloadInterval)
+		if err != nil {
+			return nil, err
+		}
+		config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return reloader.Certificate()
+		}
+	}
+	return config, nil
+}
+
+// CertificateSubject returns the subject of the first certificate of a PEM file, as in "CN=gateway,O=Acme"
+func CertificateSubject(certFile string) (string, error) {
+	content, err := os.ReadFile(certFile)
+	if err != nil {
+		return "", fmt.Errorf("could not read certificate file: %w", err)
+	}
+	block, _ := pem.Decode(content)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return "", fmt.Errorf("no certificate found in %s", certFile)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return "", fmt.Errorf("could not parse certificate of %s: %w", certFile, err)
+	}
+	return cert.Subject.String(), nil
+}
+
+// loadCAs returns the pool of the CA certificates of a PEM file
+func loadCAs(path string) (*x509.CertPool, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read CA file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(content) {
+		return nil, fmt.Errorf("no certificates found in CA file %s", path)
+	}
+	return pool, nil
+}
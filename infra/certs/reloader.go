@@ -0,0 +1,123 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Reloader keeps a certificate and key pair, along with an optional CA bundle, loading them again when their files
+// change. Changes are checked on use, at most once every interval, so no goroutine is needed to watch the files
+type Reloader struct {
+	certFile string
+	keyFile  string
+	caFile   string
+	interval time.Duration
+
+	mu          sync.Mutex
+	certificate *tls.Certificate
+	cas         *x509.CertPool
+	modTimes    []time.Time
+	checkedAt   time.Time
+}
+
+// NewReloader loads the certificate and key, and the CA bundle when its file is not empty
+func NewReloader(certFile, keyFile, caFile string, interval time.Duration) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile, caFile: caFile, interval: interval}
+	modTimes, err := r.modTimesOf()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.load(modTimes); err != nil {
+		return nil, err
+	}
+	r.checkedAt = time.Now()
+	return r, nil
+}
+
+// Certificate returns the current certificate, which is loaded again first when its files changed
+func (r *Reloader) Certificate() (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.reloadIfChanged()
+	return r.certificate, nil
+}
+
+// CAs returns the current CA certificates, or nil when the reloader has no CA bundle
+func (r *Reloader) CAs() *x509.CertPool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.reloadIfChanged()
+	return r.cas
+}
+
+// reloadIfChanged loads the files again when the interval elapsed since the last check and any of them changed.
+// Files which cannot be loaded, as a certificate renewed before its key, keep the previous ones until the next check
+func (r *Reloader) reloadIfChanged() {
+	now := time.Now()
+	if now.Sub(r.checkedAt) < r.interval {
+		return
+	}
+	r.checkedAt = now
+
+	modTimes, err := r.modTimesOf()
+	if err != nil {
+		log.Printf("Could not check TLS files for changes: %v", err)
+		return
+	}
+	if !changed(r.modTimes, modTimes) {
+		return
+	}
+	if err := r.load(modTimes); err != nil {
+		log.Printf("Could not reload TLS files, keeping the previous ones: %v", err)
+		return
+	}
+	log.Printf("Reloaded TLS certificate %s", r.certFile)
+}
+
+// load reads the files, which were modified at the received times
+func (r *Reloader) load(modTimes []time.Time) error {
+	certificate, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("could not load TLS certificate: %w", err)
+	}
+	var cas *x509.CertPool
+	if r.caFile != "" {
+		if cas, err = loadCAs(r.caFile); err != nil {
+			return err
+		}
+	}
+	r.certificate = &certificate
+	r.cas = cas
+	r.modTimes = modTimes
+	return nil
+}
+
+// modTimesOf returns the modification times of the files
+func (r *Reloader) modTimesOf() ([]time.Time, error) {
+	var modTimes []time.Time
+	for _, path := range []string{r.certFile, r.keyFile, r.caFile} {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		modTimes = append(modTimes, info.ModTime())
+	}
+	return modTimes, nil
+}
+
+func changed(previous, current []time.Time) bool {
+	for i := range current {
+		if !previous[i].Equal(current[i]) {
+			return true
+		}
+	}
+	return false
+}
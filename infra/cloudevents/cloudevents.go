@@ -0,0 +1,273 @@
+// Package cloudevents encodes user events as CloudEvents 1.0, in the JSON structured and binary HTTP modes
+// and in the protobuf format.
+//
+// Every event has the com.usermanagement.user.created, updated or deleted type, the user id as subject and
+// its sequence in the sequence extension attribute. The data is always the UserEvent.
+package cloudevents
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	pb "userManagement/proto"
+)
+
+const (
+	SpecVersion = "1.0"
+	// TypePrefix is followed by the lower cased action of the event, such as created
+	TypePrefix = "com.usermanagement.user."
+
+	// StructuredContentType is the content type of the events encoded in structured mode
+	StructuredContentType = "application/cloudevents+json"
+	// DataContentType is the content type of the data of the events, the UserEvent as JSON
+	DataContentType = "application/json"
+
+	// headerPrefix starts the HTTP headers of the attributes in binary mode
+	headerPrefix = "Ce-"
+)
+
+// Config holds the attributes shared by every event
+type Config struct {
+	// Source is the URI reference identifying this service as producer of the events
+	Source string
+	// DataSchema is the URI of the schema of the data, the UserEvent definition published in the swagger file
+	DataSchema string
+}
+
+// DefaultConfig returns the attributes used when nothing else is configured
+func DefaultConfig() Config {
+	return Config{
+		Source:     "/usermanagement",
+		DataSchema: "http://localhost:8081/swagger.json#/definitions/userManagementUserEvent",
+	}
+}
+
+// Validate checks that the config attributes are valid URI references
+func (c Config) Validate() error {
+	if c.Source == "" {
+		return errors.New("cloudevents source is required")
+	}
+	if strings.ContainsAny(c.Source+c.DataSchema, " \t\n") {
+		return fmt.Errorf("cloudevents source and dataschema must be URI references, got %q and %q", c.Source, c.DataSchema)
+	}
+	return nil
+}
+
+// Event is a user event with its CloudEvents attributes
+type Event struct {
+	ID         string
+	Source     string
+	Type       string
+	Subject    string
+	Time       time.Time
+	DataSchema string
+	Sequence   int64
+	Data       *pb.UserEvent
+}
+
+// New describes the user event as a CloudEvent. Attributes missing in the config take their default value
+func New(config Config, event *pb.UserEvent) Event {
+	defaults := DefaultConfig()
+	if config.Source == "" {
+		config.Source = defaults.Source
+	}
+	return Event{
+		ID:         event.EventId,
+		Source:     config.Source,
+		Type:       Type(event.Type),
+		Subject:    event.UserId,
+		Time:       event.Timestamp.AsTime(),
+		DataSchema: config.DataSchema,
+		Sequence:   event.Sequence,
+		Data:       event,
+	}
+}
+
+// Type returns the CloudEvents type of the user event type, such as com.usermanagement.user.created
+func Type(eventType pb.UserEventType) string {
+	return TypePrefix + strings.TrimPrefix(strings.ToLower(eventType.String()), "user_")
+}
+
+// structured is the JSON structured mode representation of an event
+type structured struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Subject         string          `json:"subject,omitempty"`
+	Time            string          `json:"time,omitempty"`
+	DataContentType string          `json:"datacontenttype"`
+	DataSchema      string          `json:"dataschema,omitempty"`
+	Sequence        string          `json:"sequence,omitempty"`
+	Data            json.RawMessage `json:"data"`
+}
+
+// MarshalJSON encodes the event in JSON structured mode
+func (e Event) MarshalJSON() ([]byte, error) {
+	data, err := protojson.Marshal(e.Data)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(structured{
+		SpecVersion:     SpecVersion,
+		ID:              e.ID,
+		Source:          e.Source,
+		Type:            e.Type,
+		Subject:         e.Subject,
+		Time:            formatTime(e.Time),
+		DataContentType: DataContentType,
+		DataSchema:      e.DataSchema,
+		Sequence:        formatSequence(e.Sequence),
+		Data:            data,
+	})
+}
+
+// UnmarshalJSON decodes an event encoded in JSON structured mode
+func (e *Event) UnmarshalJSON(content []byte) error {
+	var s structured
+	if err := json.Unmarshal(content, &s); err != nil {
+		return err
+	}
+	if s.SpecVersion != SpecVersion {
+		return fmt.Errorf("unsupported cloudevents specversion %q", s.SpecVersion)
+	}
+	data := &pb.UserEvent{}
+	if err := protojson.Unmarshal(s.Data, data); err != nil {
+		return fmt.Errorf("invalid cloudevent data: %w", err)
+	}
+	event, err := newParsedEvent(s.ID, s.Source, s.Type, s.Subject, s.Time, s.DataSchema, s.Sequence, data)
+	if err != nil {
+		return err
+	}
+	*e = event
+	return nil
+}
+
+// WriteBinary sets the attributes of the event as ce- headers, returning the body carrying its data
+func (e Event) WriteBinary(header http.Header) ([]byte, error) {
+	body, err := protojson.Marshal(e.Data)
+	if err != nil {
+		return nil, err
+	}
+	header.Set("Content-Type", DataContentType)
+	header.Set(headerPrefix+"Specversion", SpecVersion)
+	header.Set(headerPrefix+"Id", e.ID)
+	header.Set(headerPrefix+"Source", e.Source)
+	header.Set(headerPrefix+"Type", e.Type)
+	setIfNotEmpty(header, headerPrefix+"Subject", e.Subject)
+	setIfNotEmpty(header, headerPrefix+"Time", formatTime(e.Time))
+	setIfNotEmpty(header, headerPrefix+"Dataschema", e.DataSchema)
+	setIfNotEmpty(header, headerPrefix+"Sequence", formatSequence(e.Sequence))
+	return body, nil
+}
+
+// ReadHTTP decodes an event received in an HTTP request or response, in structured or binary mode
+// depending on its content type
+func ReadHTTP(header http.Header, body []byte) (Event, error) {
+	if mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type")); mediaType == StructuredContentType {
+		var event Event
+		err := json.Unmarshal(body, &event)
+		return event, err
+	}
+
+	if header.Get(headerPrefix+"Specversion") != SpecVersion {
+		return Event{}, fmt.Errorf("unsupported cloudevents specversion %q", header.Get(headerPrefix+"Specversion"))
+	}
+	data := &pb.UserEvent{}
+	if err := protojson.Unmarshal(body, data); err != nil {
+		return Event{}, fmt.Errorf("invalid cloudevent data: %w", err)
+	}
+	return newParsedEvent(
+		header.Get(headerPrefix+"Id"),
+		header.Get(headerPrefix+"Source"),
+		header.Get(headerPrefix+"Type"),
+		header.Get(headerPrefix+"Subject"),
+		header.Get(headerPrefix+"Time"),
+		header.Get(headerPrefix+"Dataschema"),
+		header.Get(headerPrefix+"Sequence"),
+		data,
+	)
+}
+
+// Proto encodes the event in the CloudEvents protobuf format, with the UserEvent as proto data
+func (e Event) Proto() (*pb.CloudEvent, error) {
+	data, err := anypb.New(e.Data)
+	if err != nil {
+		return nil, err
+	}
+	attributes := map[string]*pb.CloudEventAttributeValue{
+		"datacontenttype": {Attr: &pb.CloudEventAttributeValue_CeString{CeString: "application/protobuf"}},
+	}
+	if e.Subject != "" {
+		attributes["subject"] = &pb.CloudEventAttributeValue{Attr: &pb.CloudEventAttributeValue_CeString{CeString: e.Subject}}
+	}
+	if formatTime(e.Time) != "" {
+		attributes["time"] = &pb.CloudEventAttributeValue{Attr: &pb.CloudEventAttributeValue_CeTimestamp{CeTimestamp: timestamppb.New(e.Time)}}
+	}
+	if e.DataSchema != "" {
+		attributes["dataschema"] = &pb.CloudEventAttributeValue{Attr: &pb.CloudEventAttributeValue_CeUri{CeUri: e.DataSchema}}
+	}
+	if e.Sequence != 0 {
+		attributes["sequence"] = &pb.CloudEventAttributeValue{Attr: &pb.CloudEventAttributeValue_CeString{CeString: formatSequence(e.Sequence)}}
+	}
+	return &pb.CloudEvent{
+		Id:          e.ID,
+		Source:      e.Source,
+		SpecVersion: SpecVersion,
+		Type:        e.Type,
+		Attributes:  attributes,
+		Data:        &pb.CloudEvent_ProtoData{ProtoData: data},
+	}, nil
+}
+
+// newParsedEvent builds an event from its decoded attributes, checking the required ones
+func newParsedEvent(id, source, eventType, subject, eventTime, dataSchema, sequence string, data *pb.UserEvent) (Event, error) {
+	if id == "" || source == "" || eventType == "" {
+		return Event{}, errors.New("cloudevent id, source and type are required")
+	}
+	event := Event{ID: id, Source: source, Type: eventType, Subject: subject, DataSchema: dataSchema, Data: data}
+	if eventTime != "" {
+		parsed, err := time.Parse(time.RFC3339Nano, eventTime)
+		if err != nil {
+			return Event{}, fmt.Errorf("invalid cloudevent time: %w", err)
+		}
+		event.Time = parsed
+	}
+	if sequence != "" {
+		parsed, err := strconv.ParseInt(sequence, 10, 64)
+		if err != nil {
+			return Event{}, fmt.Errorf("invalid cloudevent sequence: %w", err)
+		}
+		event.Sequence = parsed
+	}
+	return event, nil
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() || t.Unix() == 0 {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// formatSequence returns the sequence as the string the sequence extension defines, empty when it is unknown
+func formatSequence(sequence int64) string {
+	if sequence == 0 {
+		return ""
+	}
+	return strconv.FormatInt(sequence, 10)
+}
+
+func setIfNotEmpty(header http.Header, key, value string) {
+	if value != "" {
+		header.Set(key, value)
+	}
+}
@@ -0,0 +1,266 @@
+// Package config loads the service settings from flags, environment variables and a config file.
+//
+// Every setting is identified by its flag name. The same name is used as key in the JSON config file,
+// and, upper cased with dashes replaced by underscores and prefixed by USER_MANAGEMENT_, as environment
+// variable. For example, the mongo URI can be set with -mongo-uri, "mongo-uri" or USER_MANAGEMENT_MONGO_URI.
+// Flags take precedence over environment variables, which take precedence over the config file.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+	"userManagement/infra/auth"
+	"userManagement/infra/certs"
+	"userManagement/infra/cloudevents"
+	"userManagement/infra/database"
+	"userManagement/infra/gateway"
+	"userManagement/infra/notification"
+	"userManagement/infra/webhook"
+)
+
+const (
+	envPrefix      = "USER_MANAGEMENT_"
+	configFlag     = "config"
+	DatabaseMongo  = "mongo"
+	DatabaseMemory = "memory"
+)
+
+// Config holds every setting of the service
+type Config struct {
+	ConfigFile string
+	Database   string
+	Mongo      database.MongoConfig
+	Timeouts   database.Timeouts
+	Broker     notification.Config
+	// EventRetention is the time user events are kept to be replayed, they are kept forever when it is 0
+	EventRetention time.Duration
+	// RelayInterval is the time between checks for user events to publish, besides the ones done when events are stored
+	RelayInterval time.Duration
+	// Publisher selects the message bus the user events are published to besides the streams
+	Publisher notification.PublisherConfig
+	Webhooks  webhook.Config
+	// CloudEvents holds the attributes shared by the user events encoded as CloudEvents
+	CloudEvents cloudevents.Config
+	// WatchHeartbeat is the time between heartbeats sent to idle watchers of the REST API
+	WatchHeartbeat time.Duration
+	// WatchAllowedOrigins is the comma separated list of the origins browsers can watch the REST API from,
+	// besides the one of the gateway
+	WatchAllowedOrigins string
+	// Auth holds the settings of the tokens issued by logging in
+	Auth auth.Config
+	// TLS holds the certificates of the grpc and REST servers, and of the gateway calling the grpc one
+	TLS certs.Config
+}
+
+// Default returns the settings used when nothing else is configured
+func Default() Config {
+	return Config{
+		Database: DatabaseMongo,
+		Mongo:    database.DefaultMongoConfig(),
+		Timeouts: database.DefaultTimeouts(),
+		Broker:   notification.DefaultConfig(),
+
+		EventRetention: database.DefaultEventRetention,
+		RelayInterval:  notification.DefaultRelayInterval,
+		Publisher:      notification.DefaultPublisherConfig(),
+		Webhooks:       webhook.DefaultConfig(),
+		CloudEvents:    cloudevents.DefaultConfig(),
+		WatchHeartbeat: gateway.DefaultHeartbeatInterval,
+		Auth:           auth.DefaultConfig(),
+		TLS:            certs.DefaultConfig(),
+	}
+}
+
+// Load builds the service config from the received command line arguments, the environment and the
+// config file, if one is set through the -config flag or the USER_MANAGEMENT_CONFIG environment variable
+func Load(args []string) (*Config, error) {
+	// A first pass is needed to know which config file must be read before applying the flags
+	preCfg := Default()
+	preFlags := newFlagSet(&preCfg)
+	preFlags.SetOutput(io.Discard)
+	if err := applyEnv(preFlags); err != nil {
+		return nil, err
+	}
+	if err := preFlags.Parse(args); err != nil && err != flag.ErrHelp {
+		return nil, err
+	}
+
+	cfg := Default()
+	flags := newFlagSet(&cfg)
+	if preCfg.ConfigFile != "" {
+		if err := applyFile(flags, preCfg.ConfigFile); err != nil {
+			return nil, err
+		}
+	}
+	if err := applyEnv(flags); err != nil {
+		return nil, err
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if cfg.Database != DatabaseMongo && cfg.Database != DatabaseMemory {
+		return nil, fmt.Errorf("unknown database backend %q", cfg.Database)
+	}
+	if err := cfg.Broker.Validate(); err != nil {
+		return nil, err
+	}
+	if err := cfg.Publisher.Validate(); err != nil {
+		return nil, err
+	}
+	if err := cfg.Webhooks.Validate(); err != nil {
+		return nil, err
+	}
+	if err := cfg.CloudEvents.Validate(); err != nil {
+		return nil, err
+	}
+	if err := cfg.Auth.Validate(); err != nil {
+		return nil, err
+	}
+	if err := cfg.TLS.Validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// newFlagSet registers every setting as a flag bound to the received config
+func newFlagSet(cfg *Config) *flag.FlagSet {
+	fs := flag.NewFlagSet("userManagement", flag.ContinueOnError)
+
+	fs.StringVar(&cfg.ConfigFile, configFlag, cfg.ConfigFile, "Path to a JSON config file, keyed by flag name")
+	fs.StringVar(&cfg.Database, "database", cfg.Database, "Database used to store users: mongo or memory")
+
+	fs.StringVar(&cfg.Mongo.URI, "mongo-uri", cfg.Mongo.URI, "Mongo connection URI")
+	fs.StringVar(&cfg.Mongo.Database, "mongo-database", cfg.Mongo.Database, "Mongo database name")
+	fs.StringVar(&cfg.Mongo.Collection, "mongo-collection", cfg.Mongo.Collection, "Mongo collection storing the users")
+	fs.StringVar(&cfg.Mongo.EventsCollection, "mongo-events-collection", cfg.Mongo.EventsCollection, "Mongo collection storing the user events")
+	fs.StringVar(&cfg.Mongo.WebhooksCollection, "mongo-webhooks-collection", cfg.Mongo.WebhooksCollection, "Mongo collection storing the webhooks")
+	fs.StringVar(&cfg.Mongo.RefreshTokensCollection, "mongo-refresh-tokens-collection", cfg.Mongo.RefreshTokensCollection, "Mongo collection storing the refresh tokens")
+	fs.StringVar(&cfg.Mongo.APIKeysCollection, "mongo-api-keys-collection", cfg.Mongo.APIKeysCollection, "Mongo collection storing the API keys")
+	fs.StringVar(&cfg.Mongo.Username, "mongo-username", cfg.Mongo.Username, "Mongo user, authentication is disabled when empty")
+	fs.StringVar(&cfg.Mongo.Password, "mongo-password", cfg.Mongo.Password, "Mongo user password")
+	fs.StringVar(&cfg.Mongo.AuthSource, "mongo-auth-source", cfg.Mongo.AuthSource, "Mongo database used to authenticate")
+	fs.BoolVar(&cfg.Mongo.TLS, "mongo-tls", cfg.Mongo.TLS, "Connect to mongo using TLS")
+	fs.StringVar(&cfg.Mongo.TLSCAFile, "mongo-tls-ca-file", cfg.Mongo.TLSCAFile, "CA certificates used to verify the mongo server")
+	fs.BoolVar(&cfg.Mongo.TLSInsecureSkipVerify, "mongo-tls-insecure", cfg.Mongo.TLSInsecureSkipVerify, "Skip the mongo server certificate verification")
+	fs.Uint64Var(&cfg.Mongo.MaxPoolSize, "mongo-max-pool-size", cfg.Mongo.MaxPoolSize, "Maximum number of connections to mongo")
+	fs.DurationVar(&cfg.Mongo.ConnectTimeout, "mongo-connect-timeout", cfg.Mongo.ConnectTimeout, "Timeout when opening a connection to mongo")
+	fs.DurationVar(&cfg.Mongo.ServerSelectionTimeout, "mongo-server-selection-timeout", cfg.Mongo.ServerSelectionTimeout, "Timeout when selecting a mongo server for an operation")
+
+	fs.DurationVar(&cfg.Timeouts.Read, "db-read-timeout", cfg.Timeouts.Read, "Default timeout of database reads when the caller sets no deadline")
+	fs.DurationVar(&cfg.Timeouts.Write, "db-write-timeout", cfg.Timeouts.Write, "Default timeout of database writes when the caller sets no deadline")
+	fs.DurationVar(&cfg.Timeouts.List, "db-list-timeout", cfg.Timeouts.List, "Default timeout of user listings when the caller sets no deadline")
+
+	fs.IntVar(&cfg.Broker.BufferSize, "notify-buffer-size", cfg.Broker.BufferSize, "Number of notifications buffered for each subscribed stream")
+	fs.StringVar((*string)(&cfg.Broker.Policy), "notify-slow-consumer-policy", string(cfg.Broker.Policy), "What to do when a stream buffer is full: drop-oldest, disconnect or block")
+	fs.DurationVar(&cfg.Broker.BlockTimeout, "notify-block-timeout", cfg.Broker.BlockTimeout, "Time to wait for a full stream buffer with the block policy before dropping the notification")
+	fs.DurationVar(&cfg.EventRetention, "event-retention", cfg.EventRetention, "Time user events are kept to be replayed, 0 keeps them forever")
+	fs.DurationVar(&cfg.WatchHeartbeat, "watch-heartbeat-interval", cfg.WatchHeartbeat, "Time between heartbeats sent to idle watchers of /v1/users:watch")
+	fs.StringVar(&cfg.WatchAllowedOrigins, "watch-allowed-origins", cfg.WatchAllowedOrigins, "Comma separated origins browsers can watch /v1/users:watch from besides the gateway one, * allows any")
+	fs.DurationVar(&cfg.RelayInterval, "event-relay-interval", cfg.RelayInterval, "Time between checks for stored user events to publish, which also happen every time events are stored")
+
+	fs.StringVar(&cfg.Publisher.Kind, "event-publisher", cfg.Publisher.Kind, "Message bus the user events are also published to: inprocess, nats or kafka")
+	fs.StringVar(&cfg.Publisher.Format, "event-publisher-format", cfg.Publisher.Format, "Encoding of the user events published to the message bus: json or cloudevents")
+	fs.StringVar(&cfg.Publisher.NATS.URL, "nats-url", cfg.Publisher.NATS.URL, "NATS server the user events are published to")
+	fs.StringVar(&cfg.Publisher.NATS.Subject, "nats-subject", cfg.Publisher.NATS.Subject, "Prefix of the NATS subjects, followed by a dot and the lower cased event type")
+	fs.BoolVar(&cfg.Publisher.NATS.Embedded, "nats-embedded", cfg.Publisher.NATS.Embedded, "Start a NATS server in the process instead of connecting to -nats-url")
+	fs.StringVar(&cfg.Publisher.NATS.EmbeddedHost, "nats-embedded-host", cfg.Publisher.NATS.EmbeddedHost, "Host the embedded NATS server listens on")
+	fs.IntVar(&cfg.Publisher.NATS.EmbeddedPort, "nats-embedded-port", cfg.Publisher.NATS.EmbeddedPort, "Port the embedded NATS server listens on, -1 picks a random one")
+	fs.DurationVar(&cfg.Publisher.NATS.Timeout, "nats-timeout", cfg.Publisher.NATS.Timeout, "Timeout when connecting to NATS and waiting for it to receive every event")
+	fs.StringVar(&cfg.Publisher.Kafka.Brokers, "kafka-brokers", cfg.Publisher.Kafka.Brokers, "Comma separated addresses of the Kafka brokers the user events are published to")
+	fs.StringVar(&cfg.Publisher.Kafka.Topic, "kafka-topic", cfg.Publisher.Kafka.Topic, "Kafka topic the user events are published to, keyed by user id")
+	fs.DurationVar(&cfg.Publisher.Kafka.Timeout, "kafka-timeout", cfg.Publisher.Kafka.Timeout, "Time waited for Kafka to acknowledge every user event")
+
+	fs.IntVar(&cfg.Webhooks.MaxAttempts, "webhook-max-attempts", cfg.Webhooks.MaxAttempts, "Failed attempts after which a webhook delivery is moved to the dead letters")
+	fs.DurationVar(&cfg.Webhooks.InitialBackoff, "webhook-initial-backoff", cfg.Webhooks.InitialBackoff, "Time waited to retry a webhook delivery after its first failure, doubling after every other one")
+	fs.DurationVar(&cfg.Webhooks.MaxBackoff, "webhook-max-backoff", cfg.Webhooks.MaxBackoff, "Maximum time waited between attempts of a webhook delivery")
+	fs.DurationVar(&cfg.Webhooks.Timeout, "webhook-timeout", cfg.Webhooks.Timeout, "Time waited for a webhook to respond")
+	fs.DurationVar(&cfg.Webhooks.PollInterval, "webhook-poll-interval", cfg.Webhooks.PollInterval, "Time between checks for new events and due retries to send to webhooks")
+
+	fs.StringVar(&cfg.CloudEvents.Source, "cloudevents-source", cfg.CloudEvents.Source, "CloudEvents source attribute of the user events, a URI reference identifying the service")
+	fs.StringVar(&cfg.CloudEvents.DataSchema, "cloudevents-dataschema", cfg.CloudEvents.DataSchema, "CloudEvents dataschema attribute of the user events, the URI of the UserEvent schema")
+
+	fs.StringVar(&cfg.Auth.KeysDir, "jwt-keys-dir", cfg.Auth.KeysDir, "Directory of the PEM keys signing and verifying access tokens, named after their key id. A key is generated at startup when empty")
+	fs.StringVar(&cfg.Auth.SigningKey, "jwt-signing-key", cfg.Auth.SigningKey, "Id of the key signing new access tokens, needed when the keys directory has several private keys")
+	fs.StringVar(&cfg.Auth.Issuer, "jwt-issuer", cfg.Auth.Issuer, "Issuer claim of the access tokens")
+	fs.StringVar(&cfg.Auth.Audience, "jwt-audience", cfg.Auth.Audience, "Audience claim of the access tokens")
+	fs.DurationVar(&cfg.Auth.AccessTokenTTL, "access-token-ttl", cfg.Auth.AccessTokenTTL, "Time access tokens are valid for")
+	fs.StringVar(&cfg.Auth.PublicMethods, "auth-public-methods", cfg.Auth.PublicMethods, "Comma separated UserManagement methods which can be called without an access token or an API key")
+	fs.DurationVar(&cfg.Auth.RefreshTokenTTL, "refresh-token-ttl", cfg.Auth.RefreshTokenTTL, "Time refresh tokens are valid for, every refresh issues a new one valid for this time")
+	fs.StringVar(&cfg.Auth.Admin, "auth-admin", cfg.Auth.Admin, "Email of a user granted the admin role at startup, when it exists")
+
+	fs.StringVar(&cfg.TLS.CertFile, "tls-cert-file", cfg.TLS.CertFile, "PEM certificate chain of the grpc and REST servers, which serve plain text when empty")
+	fs.StringVar(&cfg.TLS.KeyFile, "tls-key-file", cfg.TLS.KeyFile, "PEM private key of the servers certificate")
+	fs.StringVar(&cfg.TLS.ClientCAFile, "tls-client-ca-file", cfg.TLS.ClientCAFile, "CA certificates verifying client certificates, which are only requested when set")
+	fs.BoolVar(&cfg.TLS.RequireClientCert, "tls-require-client-cert", cfg.TLS.RequireClientCert, "Reject the clients without a certificate signed by the client CAs")
+	fs.DurationVar(&cfg.TLS.ReloadInterval, "tls-reload-interval", cfg.TLS.ReloadInterval, "Minimum time between checks for changes of the certificate files, which are then loaded again")
+	fs.StringVar(&cfg.TLS.Gateway.CertFile, "tls-gateway-cert-file", cfg.TLS.Gateway.CertFile, "Client certificate of the REST gateway, sent to the grpc server when it verifies client certificates")
+	fs.StringVar(&cfg.TLS.Gateway.KeyFile, "tls-gateway-key-file", cfg.TLS.Gateway.KeyFile, "Private key of the REST gateway client certificate")
+	fs.StringVar(&cfg.TLS.Gateway.CAFile, "tls-gateway-ca-file", cfg.TLS.Gateway.CAFile, "CA certificates the REST gateway verifies the grpc server with, the system ones are used when empty")
+	fs.StringVar(&cfg.TLS.Gateway.ServerName, "tls-gateway-server-name", cfg.TLS.Gateway.ServerName, "Name the REST gateway expects in the grpc server certificate")
+
+	return fs
+}
+
+// applyEnv sets every flag which has a matching environment variable
+func applyEnv(fs *flag.FlagSet) error {
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		value, found := os.LookupEnv(EnvName(f.Name))
+		if !found || err != nil {
+			return
+		}
+		if setErr := fs.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("invalid value for %s: %w", EnvName(f.Name), setErr)
+		}
+	})
+	return err
+}
+
+// applyFile sets the flags found as keys in the received JSON config file
+func applyFile(fs *flag.FlagSet, path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("could not read config file: %w", err)
+	}
+
+	// Numbers are kept as written, so they are parsed by the flag they belong to
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	var values map[string]interface{}
+	if err := decoder.Decode(&values); err != nil {
+		return fmt.Errorf("could not parse config file %s: %w", path, err)
+	}
+
+	for name, value := range values {
+		if name == configFlag || fs.Lookup(name) == nil {
+			return fmt.Errorf("unknown setting %q in config file %s", name, path)
+		}
+		if err := fs.Set(name, fmt.Sprint(value)); err != nil {
+			return fmt.Errorf("invalid value for %q in config file %s: %w", name, path, err)
+		}
+	}
+	return nil
+}
+
+// EnvName returns the environment variable used to set the received flag
+func EnvName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// WatchOrigins returns the origins browsers can watch the REST API from, besides the one of the gateway
+func (c Config) WatchOrigins() []string {
+	var origins []string
+	for _, origin := range strings.Split(c.WatchAllowedOrigins, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	return origins
+}
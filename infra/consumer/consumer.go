@@ -0,0 +1,263 @@
+// Package consumer receives the user events streamed by NotifyUserChanges and writes them to sinks,
+// reconnecting with jittered exponential backoff and resuming after the last event written.
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"userManagement/infra/certs"
+	pb "userManagement/proto"
+)
+
+// Config holds the settings of a consumer
+type Config struct {
+	// Target is the address of the grpc server
+	Target string
+	TLS    bool
+	// TLSCAFile holds the CA certificates used to verify the server, the system ones are used when empty
+	TLSCAFile             string
+	TLSServerName         string
+	TLSInsecureSkipVerify bool
+	// TLSCertFile and TLSKeyFile hold the client certificate sent to servers verifying them, loaded again when it changes
+	TLSCertFile string
+	TLSKeyFile  string
+	// APIKey authenticates the consumer, which needs the events.watch scope. It is sent in plain text without TLS
+	APIKey string
+	// ResumeAfter is the sequence of the last event written before the consumer started
+	ResumeAfter int64
+	// StateFile keeps the sequence of the last event written, so a restarted consumer resumes after it.
+	// It takes precedence over ResumeAfter when it exists
+	StateFile string
+	// Types selects the types of the events received, every event is received when empty
+	Types []pb.UserEventType
+	// InitialBackoff is the base time waited before the first reconnection, which doubles after every other one
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// DefaultConfig returns the consumer settings used when nothing else is configured
+func DefaultConfig() Config {
+	return Config{
+		Target:         "localhost:5566",
+		InitialBackoff: 500 * time.Millisecond,
+		MaxBackoff:     30 * time.Second,
+	}
+}
+
+// Validate checks that the config can be used to create a consumer
+func (c Config) Validate() error {
+	if c.Target == "" {
+		return errors.New("consumer target is required")
+	}
+	if c.InitialBackoff <= 0 || c.MaxBackoff < c.InitialBackoff {
+		return errors.New("consumer backoff must be positive and its maximum cannot be lower than the initial one")
+	}
+	if c.ResumeAfter < 0 {
+		return fmt.Errorf("consumer resume sequence cannot be negative, got %d", c.ResumeAfter)
+	}
+	return nil
+}
+
+// Backoff returns the time waited before the received reconnection attempt, starting at 1. It doubles with
+// every attempt up to the maximum, and a random half of it is discounted so consumers do not reconnect at once
+func (c Config) Backoff(attempt int) time.Duration {
+	backoff := c.InitialBackoff
+	for i := 1; i < attempt && backoff < c.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > c.MaxBackoff {
+		backoff = c.MaxBackoff
+	}
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+// Consumer writes the streamed user events to its sink, in order and at least once
+type Consumer struct {
+	config Config
+	sink   Sink
+	// last is the sequence of the last event written to the sink
+	last int64
+	// attempt counts the reconnections since the last event received
+	attempt int
+	// started is when the first stream was opened. Until an event is written, reconnections replay the events
+	// since then, so those received before failing are not lost
+	started time.Time
+}
+
+// New creates a consumer writing to the received sink, resuming after the sequence found in the state file
+func New(config Config, sink Sink) (*Consumer, error) {
+	consumer := &Consumer{config: config, sink: sink, last: config.ResumeAfter}
+	if config.StateFile == "" {
+		return consumer, nil
+	}
+	content, err := os.ReadFile(config.StateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return consumer, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read consumer state: %w", err)
+	}
+	if consumer.last, err = strconv.ParseInt(strings.TrimSpace(string(content)), 10, 64); err != nil {
+		return nil, fmt.Errorf("invalid consumer state file %s: %w", config.StateFile, err)
+	}
+	return consumer, nil
+}
+
+// Last returns the sequence of the last event written to the sink
+func (c *Consumer) Last() int64 {
+	return c.last
+}
+
+// Run receives the events until the context ends, reconnecting whenever the stream fails. It only returns
+// an error when the server rejects the request, since retrying it would fail again
+func (c *Consumer) Run(ctx context.Context) error {
+	options, err := c.dialOptions()
+	if err != nil {
+		return err
+	}
+	conn, err := grpc.DialContext(ctx, c.config.Target, options...)
+	if err != nil {
+		return fmt.Errorf("could not connect to %s: %w", c.config.Target, err)
+	}
+	defer conn.Close()
+	client := pb.NewUserManagementClient(conn)
+
+	for {
+		err := c.consume(ctx, client)
+		if ctx.Err() != nil {
+			return nil
+		}
+		switch status.Code(err) {
+		case codes.InvalidArgument, codes.Unimplemented, codes.Unauthenticated, codes.PermissionDenied:
+			return err
+		}
+
+		c.attempt++
+		backoff := c.config.Backoff(c.attempt)
+		log.Printf("Stream to %s failed, reconnecting in %s after event %d: %v", c.config.Target, backoff.Round(time.Millisecond), c.last, err)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+	}
+}
+
+// consume opens a stream resuming after the last event written and writes its events until it fails
+func (c *Consumer) consume(ctx context.Context, client pb.UserManagementClient) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	req := &pb.NotifyUserChangesReq{ResumeAfter: c.last, Types: c.config.Types}
+	if c.last == 0 && !c.started.IsZero() {
+		req.SinceTimestamp = timestamppb.New(c.started)
+	}
+	opened := time.Now()
+	stream, err := client.NotifyUserChanges(ctx, req, grpc.WaitForReady(true))
+	if err != nil {
+		return err
+	}
+	if c.started.IsZero() {
+		c.started = opened
+	}
+	log.Printf("Streaming user events from %s after event %d", c.config.Target, c.last)
+
+	for {
+		event, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		c.attempt = 0
+		// Events replayed again after a reconnection were already written
+		if event.Sequence != 0 && event.Sequence <= c.last {
+			continue
+		}
+		// An event whose write is cancelled when the consumer stops is not recorded, so it is written again
+		if err := c.sink.Write(ctx, event); err != nil {
+			return fmt.Errorf("could not write event %d: %w", event.Sequence, err)
+		}
+		if event.Sequence != 0 {
+			c.last = event.Sequence
+			if err := c.saveState(); err != nil {
+				log.Printf("Could not save consumer state: %v", err)
+			}
+		}
+	}
+}
+
+// saveState replaces the state file with the last sequence, writing it aside first so it is never left half written
+func (c *Consumer) saveState() error {
+	if c.config.StateFile == "" {
+		return nil
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(c.config.StateFile), filepath.Base(c.config.StateFile)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(strconv.FormatInt(c.last, 10)); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), c.config.StateFile)
+}
+
+func (c *Consumer) dialOptions() ([]grpc.DialOption, error) {
+	transport, err := c.transportCredentials()
+	if err != nil {
+		return nil, err
+	}
+	options := []grpc.DialOption{grpc.WithTransportCredentials(transport)}
+	if c.config.APIKey != "" {
+		options = append(options, grpc.WithPerRPCCredentials(apiKeyCredentials{key: c.config.APIKey, tls: c.config.TLS}))
+	}
+	return options, nil
+}
+
+func (c *Consumer) transportCredentials() (credentials.TransportCredentials, error) {
+	if !c.config.TLS {
+		return insecure.NewCredentials(), nil
+	}
+	tlsConfig, err := certs.ClientTLSConfig(certs.ClientConfig{
+		CertFile:           c.config.TLSCertFile,
+		KeyFile:            c.config.TLSKeyFile,
+		CAFile:             c.config.TLSCAFile,
+		ServerName:         c.config.TLSServerName,
+		InsecureSkipVerify: c.config.TLSInsecureSkipVerify,
+	}, certs.DefaultReloadInterval)
+	if err != nil {
+		return nil, fmt.Errorf("could not load consumer TLS config: %w", err)
+	}
+	return credentials.NewTLS(tlsConfig), nil
+}
+
+// apiKeyCredentials sends the API key of the consumer in the x-api-key header of every call
+type apiKeyCredentials struct {
+	key string
+	tls bool
+}
+
+func (a apiKeyCredentials) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{"x-api-key": a.key}, nil
+}
+
+// RequireTransportSecurity lets the key be sent without TLS only when the consumer was not configured to use it
+func (a apiKeyCredentials) RequireTransportSecurity() bool {
+	return a.tls
+}
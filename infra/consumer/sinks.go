@@ -0,0 +1,360 @@
+package consumer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"google.golang.org/protobuf/encoding/protojson"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"userManagement/infra/cloudevents"
+	pb "userManagement/proto"
+)
+
+const (
+	SinkStdout = "stdout"
+	SinkFile   = "file"
+	SinkHTTP   = "http"
+	SinkExec   = "exec"
+
+	// FormatJSON writes the UserEvent as JSON
+	FormatJSON = "json"
+	// FormatCloudEvents writes the events as CloudEvents in JSON structured mode
+	FormatCloudEvents = "cloudevents"
+)
+
+// Sink is where a consumer writes the events it receives. An event is received again when writing it fails
+type Sink interface {
+	Write(ctx context.Context, event *pb.UserEvent) error
+	Close() error
+}
+
+// SinkConfig selects the sinks the events are written to and holds their settings
+type SinkConfig struct {
+	// Sinks is the comma separated list of sinks: stdout, file, http or exec
+	Sinks  string
+	Format string
+	// File is the path of the file sink, which is rotated when it reaches FileMaxSize bytes keeping FileMaxBackups
+	// older files, named after the file with .1, .2 and so on appended
+	File           string
+	FileMaxSize    int64
+	FileMaxBackups int
+	// URL receives every event as an HTTP POST request, which must respond with a 2xx status within HTTPTimeout
+	URL         string
+	HTTPTimeout time.Duration
+	// Command is run for every event, with the event as its standard input and its id, type and sequence as the
+	// USER_EVENT_ID, USER_EVENT_TYPE and USER_EVENT_SEQUENCE environment variables. It is split by spaces and
+	// run without a shell, and it must exit with status 0 within ExecTimeout
+	Command     string
+	ExecTimeout time.Duration
+}
+
+// DefaultSinkConfig returns the sink settings used when nothing else is configured
+func DefaultSinkConfig() SinkConfig {
+	return SinkConfig{
+		Sinks:          SinkStdout,
+		Format:         FormatJSON,
+		File:           "events.jsonl",
+		FileMaxSize:    100 << 20,
+		FileMaxBackups: 5,
+		HTTPTimeout:    10 * time.Second,
+		ExecTimeout:    30 * time.Second,
+	}
+}
+
+// NewSink creates the sinks of the config, which are written in the listed order
+func NewSink(config SinkConfig) (Sink, error) {
+	encode, err := NewEncoder(config.Format)
+	if err != nil {
+		return nil, err
+	}
+
+	var sinks multiSink
+	for _, kind := range strings.Split(config.Sinks, ",") {
+		var sink Sink
+		switch strings.TrimSpace(kind) {
+		case SinkStdout:
+			sink = NewWriterSink(os.Stdout, encode)
+		case SinkFile:
+			sink, err = NewFileSink(config.File, config.FileMaxSize, config.FileMaxBackups, encode)
+		case SinkHTTP:
+			sink, err = NewHTTPSink(config.URL, config.HTTPTimeout, config.Format, encode)
+		case SinkExec:
+			sink, err = NewExecSink(config.Command, config.ExecTimeout, encode)
+		default:
+			err = fmt.Errorf("unknown sink %q", kind)
+		}
+		if err != nil {
+			_ = sinks.Close()
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+	if len(sinks) == 1 {
+		return sinks[0], nil
+	}
+	return sinks, nil
+}
+
+// Encoder returns the single line JSON representation of an event
+type Encoder func(event *pb.UserEvent) ([]byte, error)
+
+// NewEncoder returns the encoder of the received format, json or cloudevents
+func NewEncoder(format string) (Encoder, error) {
+	switch format {
+	case FormatJSON:
+		return func(event *pb.UserEvent) ([]byte, error) {
+			content, err := protojson.Marshal(event)
+			if err != nil {
+				return nil, err
+			}
+			// protojson may add spaces, compacting keeps every event in a single line
+			var compacted bytes.Buffer
+			err = json.Compact(&compacted, content)
+			return compacted.Bytes(), err
+		}, nil
+	case FormatCloudEvents:
+		return func(event *pb.UserEvent) ([]byte, error) {
+			return json.Marshal(cloudevents.New(cloudevents.Config{}, event))
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown event format %q", format)
+}
+
+type multiSink []Sink
+
+func (m multiSink) Write(ctx context.Context, event *pb.UserEvent) error {
+	for _, sink := range m {
+		if err := sink.Write(ctx, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m multiSink) Close() error {
+	var first error
+	for _, sink := range m {
+		if err := sink.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// WriterSink writes every event as a JSON line
+type WriterSink struct {
+	mu     sync.Mutex
+	writer io.Writer
+	encode Encoder
+}
+
+// NewWriterSink creates a sink writing JSON lines to the received writer, which is not closed with the sink
+func NewWriterSink(writer io.Writer, encode Encoder) *WriterSink {
+	return &WriterSink{writer: writer, encode: encode}
+}
+
+func (s *WriterSink) Write(_ context.Context, event *pb.UserEvent) error {
+	line, err := s.encode(event)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.writer.Write(append(line, '\n'))
+	return err
+}
+
+func (s *WriterSink) Close() error {
+	return nil
+}
+
+// FileSink appends every event as a JSON line to a file, rotating it when it reaches its maximum size
+type FileSink struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	encode     Encoder
+	file       *os.File
+	size       int64
+}
+
+// NewFileSink opens the file, creating it when it does not exist
+func NewFileSink(path string, maxSize int64, maxBackups int, encode Encoder) (*FileSink, error) {
+	if path == "" || maxSize <= 0 || maxBackups < 0 {
+		return nil, errors.New("file sink needs a path, a positive maximum size and a non negative number of backups")
+	}
+	sink := &FileSink{path: path, maxSize: maxSize, maxBackups: maxBackups, encode: encode}
+	if err := sink.open(); err != nil {
+		return nil, err
+	}
+	return sink, nil
+}
+
+func (s *FileSink) open() error {
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	s.file, s.size = file, info.Size()
+	return nil
+}
+
+func (s *FileSink) Write(_ context.Context, event *pb.UserEvent) error {
+	line, err := s.encode(event)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.size > 0 && s.size+int64(len(line)) > s.maxSize {
+		if err := s.rotate(); err != nil {
+			return fmt.Errorf("could not rotate %s: %w", s.path, err)
+		}
+	}
+	written, err := s.file.Write(line)
+	s.size += int64(written)
+	return err
+}
+
+// rotate shifts the backups, dropping the oldest one, moves the file to the first backup and opens a new one
+func (s *FileSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	if s.maxBackups == 0 {
+		if err := os.Remove(s.path); err != nil {
+			return err
+		}
+		return s.open()
+	}
+	for i := s.maxBackups - 1; i > 0; i-- {
+		err := os.Rename(s.backup(i), s.backup(i+1))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	if err := os.Rename(s.path, s.backup(1)); err != nil {
+		return err
+	}
+	return s.open()
+}
+
+func (s *FileSink) backup(i int) string {
+	return s.path + "." + strconv.Itoa(i)
+}
+
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+// HTTPSink posts every event to an URL
+type HTTPSink struct {
+	url         string
+	contentType string
+	client      *http.Client
+	encode      Encoder
+}
+
+// NewHTTPSink creates a sink posting the events to the received http or https URL
+func NewHTTPSink(url string, timeout time.Duration, format string, encode Encoder) (*HTTPSink, error) {
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return nil, fmt.Errorf("http sink url must be an http or https url, got %q", url)
+	}
+	contentType := "application/json"
+	if format == FormatCloudEvents {
+		contentType = cloudevents.StructuredContentType
+	}
+	return &HTTPSink{url: url, contentType: contentType, client: &http.Client{Timeout: timeout}, encode: encode}, nil
+}
+
+func (s *HTTPSink) Write(ctx context.Context, event *pb.UserEvent) error {
+	body, err := s.encode(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", s.contentType)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected response status %s", resp.Status)
+	}
+	return nil
+}
+
+func (s *HTTPSink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
+
+// ExecSink runs a command for every event
+type ExecSink struct {
+	args    []string
+	timeout time.Duration
+	encode  Encoder
+}
+
+// NewExecSink creates a sink running the received command, split by spaces, which is killed when it runs longer
+// than the timeout. Commands are not limited when the timeout is 0
+func NewExecSink(command string, timeout time.Duration, encode Encoder) (*ExecSink, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return nil, errors.New("exec sink command is required")
+	}
+	return &ExecSink{args: args, timeout: timeout, encode: encode}, nil
+}
+
+func (s *ExecSink) Write(ctx context.Context, event *pb.UserEvent) error {
+	input, err := s.encode(event)
+	if err != nil {
+		return err
+	}
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+	cmd := exec.CommandContext(ctx, s.args[0], s.args[1:]...)
+	cmd.Stdin = bytes.NewReader(append(input, '\n'))
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(),
+		"USER_EVENT_ID="+event.EventId,
+		"USER_EVENT_TYPE="+event.Type.String(),
+		"USER_EVENT_SEQUENCE="+strconv.FormatInt(event.Sequence, 10),
+	)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("command %s failed: %w", s.args[0], err)
+	}
+	return nil
+}
+
+func (s *ExecSink) Close() error {
+	return nil
+}
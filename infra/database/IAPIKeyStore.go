@@ -0,0 +1,25 @@
+package database
+
+import (
+	"context"
+	"time"
+	"userManagement/entities"
+)
+
+// APIKeyStoreInterface stores the API keys of the services, which are found by id or by the hash of the key
+type APIKeyStoreInterface interface {
+	// CreateAPIKey stores a new API key
+	CreateAPIKey(ctx context.Context, key *entities.APIKey) error
+	// GetAPIKey returns the key with the received id
+	GetAPIKey(ctx context.Context, id string) (*entities.APIKey, error)
+	// FindAPIKey returns the key with the received hash
+	FindAPIKey(ctx context.Context, hash string) (*entities.APIKey, error)
+	// ListAPIKeys returns the keys of the service, or every key when it is empty, sorted by creation time
+	ListAPIKeys(ctx context.Context, service string) ([]*entities.APIKey, error)
+	// RotateAPIKey replaces the hash of a key which was not revoked, returning the key after the change
+	RotateAPIKey(ctx context.Context, id, hash string, now time.Time) (*entities.APIKey, error)
+	// RevokeAPIKey marks a key as revoked, returning it after the change. Revoked keys are returned unchanged
+	RevokeAPIKey(ctx context.Context, id string, now time.Time) (*entities.APIKey, error)
+	// TouchAPIKey stores when the key was last used
+	TouchAPIKey(ctx context.Context, id string, now time.Time) error
+}
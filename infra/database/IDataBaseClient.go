@@ -1,11 +1,19 @@
 package database
 
-import pb "userManagement/proto"
+import (
+	"context"
+	pb "userManagement/proto"
+)
 
 type AdapterInterface interface {
-	CreateUser(req *pb.CreateUserReq) (string, error)
-	GetUser(req *pb.GetUserReq) (*pb.UserActionResponse, error)
-	UpdateUser(req *pb.UpdateUserReq) (*pb.UserActionResponse, error)
-	DeleteUser(req *pb.DeleteUserReq) (*pb.DeletionActionResponse, error)
-	GetAllUsers(req *pb.ListUsersReq) (*pb.ListActionResponse, error)
+	CreateUser(ctx context.Context, req *pb.CreateUserReq) (string, error)
+	GetUser(ctx context.Context, req *pb.GetUserReq) (*pb.UserActionResponse, error)
+	UpdateUser(ctx context.Context, req *pb.UpdateUserReq) (*pb.UserActionResponse, error)
+	DeleteUser(ctx context.Context, req *pb.DeleteUserReq) (*pb.DeletionActionResponse, error)
+	GetAllUsers(ctx context.Context, req *pb.ListUsersReq) (*pb.ListActionResponse, error)
+	VerifyPassword(ctx context.Context, req *pb.VerifyPasswordReq) (*pb.VerifyPasswordResponse, error)
+	// GrantRole and RevokeRole add or remove a role of a user, returning the user as it is after the change.
+	// Granting a role the user has, or revoking one it does not have, leaves the user unchanged
+	GrantRole(ctx context.Context, req *pb.GrantRoleReq) (*pb.UserActionResponse, error)
+	RevokeRole(ctx context.Context, req *pb.RevokeRoleReq) (*pb.UserActionResponse, error)
 }
@@ -0,0 +1,66 @@
+package database
+
+import (
+	"context"
+	"time"
+	pb "userManagement/proto"
+)
+
+// EventLogInterface is an append-only store of user events. It is the outbox the user changes are written
+// to along with their events, which are delivered afterwards, and it is used to replay the events a client missed
+type EventLogInterface interface {
+	// AppendEvent stores the event as not delivered, setting its sequence to a number greater than the one
+	// of every stored event
+	AppendEvent(ctx context.Context, event *pb.UserEvent) error
+	// ListEvents returns the stored events matching the query, sorted by sequence
+	ListEvents(ctx context.Context, query EventQuery) ([]*pb.UserEvent, error)
+	// ListUndeliveredEvents returns the oldest events not delivered yet, sorted by sequence
+	ListUndeliveredEvents(ctx context.Context, limit int) ([]*pb.UserEvent, error)
+	// MarkEventsDelivered marks the events with the received sequences as delivered. Only the published events are
+	// marked, since an event with a lower sequence may still be stored after the ones listed before it
+	MarkEventsDelivered(ctx context.Context, sequences []int64) error
+	// MarkEventsDispatched marks the events up to the received sequence as read by the webhook dispatcher.
+	// Retention only removes the events which were both delivered and dispatched
+	MarkEventsDispatched(ctx context.Context, through int64) error
+	// LastSequence returns the sequence given to the last appended event, 0 when none was appended
+	LastSequence(ctx context.Context) (int64, error)
+	// Appended receives a value after events are appended by this process, so they can be delivered without delay
+	Appended() <-chan struct{}
+}
+
+// EventQuery selects the events to list. Events after the After sequence are returned when it is set,
+// otherwise events from the Since time, and every stored event when none is set
+type EventQuery struct {
+	After int64
+	Since time.Time
+	// Limit caps the number of events returned, no limit is applied when it is 0
+	Limit int
+}
+
+// SequenceGapTimeout is the time after which a missing sequence is taken for an event which failed to be stored.
+// Until then, readers following the event log wait for it, since mongo without transactions may store it after
+// later ones
+const SequenceGapTimeout = time.Minute
+
+// SequenceGap is a range of missing sequences, from First to Last
+type SequenceGap struct {
+	First, Last int64
+}
+
+// ContiguousEvents returns the events read after the cursor up to the first missing sequence, which may belong
+// to an event not stored yet. Missing sequences are skipped once the event after them is older than the gap timeout,
+// and returned as well
+func ContiguousEvents(cursor int64, events []*pb.UserEvent, now time.Time) ([]*pb.UserEvent, []SequenceGap) {
+	var gaps []SequenceGap
+	next := cursor + 1
+	for i, event := range events {
+		if event.Sequence != next {
+			if now.Sub(event.Timestamp.AsTime()) < SequenceGapTimeout {
+				return events[:i], gaps
+			}
+			gaps = append(gaps, SequenceGap{First: next, Last: event.Sequence - 1})
+		}
+		next = event.Sequence + 1
+	}
+	return events, gaps
+}
@@ -0,0 +1,21 @@
+package database
+
+import (
+	"context"
+	"time"
+	"userManagement/entities"
+)
+
+// RefreshTokenStoreInterface stores the issued refresh tokens, by the hash of the token
+type RefreshTokenStoreInterface interface {
+	// CreateRefreshToken stores a new refresh token
+	CreateRefreshToken(ctx context.Context, token *entities.RefreshToken) error
+	// GetRefreshToken returns the token with the received hash
+	GetRefreshToken(ctx context.Context, hash string) (*entities.RefreshToken, error)
+	// UseRefreshToken marks the token with the received hash as used at the received time, returning it as it was
+	// before. Tokens already used or revoked are returned without changes, so reusing them can be detected
+	UseRefreshToken(ctx context.Context, hash string, now time.Time) (*entities.RefreshToken, error)
+	// RevokeRefreshTokenFamily marks every token of the family which was not revoked yet as revoked,
+	// returning how many were
+	RevokeRefreshTokenFamily(ctx context.Context, familyID string, now time.Time) (int, error)
+}
@@ -0,0 +1,46 @@
+package database
+
+import (
+	"context"
+	"time"
+	pb "userManagement/proto"
+)
+
+// WebhookStoreInterface stores the registered webhooks and the deliveries of events to them
+type WebhookStoreInterface interface {
+	// CreateWebhook stores a new webhook, setting its id and creation time
+	CreateWebhook(ctx context.Context, webhook *pb.Webhook) (*pb.Webhook, error)
+	// GetWebhook returns a webhook, including its secret
+	GetWebhook(ctx context.Context, id string) (*pb.Webhook, error)
+	// ListWebhooks returns every webhook sorted by creation time
+	ListWebhooks(ctx context.Context) ([]*pb.Webhook, error)
+	// UpdateWebhook replaces the stored webhook with the same id, setting its update time
+	UpdateWebhook(ctx context.Context, webhook *pb.Webhook) (*pb.Webhook, error)
+	// DeleteWebhook removes a webhook along with its deliveries, reporting whether it existed
+	DeleteWebhook(ctx context.Context, id string) (bool, error)
+
+	// CreateDeliveries stores new deliveries, setting their ids and creation time
+	CreateDeliveries(ctx context.Context, deliveries []*pb.WebhookDelivery) error
+	// UpdateDelivery replaces the stored delivery with the same id, setting its update time
+	UpdateDelivery(ctx context.Context, delivery *pb.WebhookDelivery) error
+	// ListDeliveries returns the deliveries matching the query, newest first
+	ListDeliveries(ctx context.Context, query DeliveryQuery) ([]*pb.WebhookDelivery, error)
+	// ListDueDeliveries returns the pending deliveries of the received webhooks whose next attempt is not
+	// after the received time, sorted by next attempt
+	ListDueDeliveries(ctx context.Context, now time.Time, webhookIDs []string, limit int) ([]*pb.WebhookDelivery, error)
+
+	// GetDispatchCursor returns the sequence of the last event whose deliveries were created, 0 when there is none
+	GetDispatchCursor(ctx context.Context) (int64, error)
+	// SetDispatchCursor stores the sequence of the last event whose deliveries were created
+	SetDispatchCursor(ctx context.Context, sequence int64) error
+}
+
+// DeliveryQuery selects the deliveries of a webhook to list. Every delivery of the webhook is selected
+// when neither the status nor the ids are set
+type DeliveryQuery struct {
+	WebhookID string
+	Status    pb.WebhookDeliveryStatus
+	IDs       []string
+	// Limit caps the number of deliveries returned, no limit is applied when it is 0
+	Limit int
+}
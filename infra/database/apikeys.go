@@ -0,0 +1,136 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+	"userManagement/entities"
+)
+
+// MongoAPIKeyStore stores API keys in a mongo collection, where the hashes of the keys are unique
+type MongoAPIKeyStore struct {
+	Collection *mongo.Collection
+}
+
+// NewMongoAPIKeyStore creates the API key store in the database of the received client, along with its indexes
+func NewMongoAPIKeyStore(ctx context.Context, client *MongoClient, collection string) (*MongoAPIKeyStore, error) {
+	store := &MongoAPIKeyStore{Collection: client.Collection.Database().Collection(collection)}
+
+	_, err := store.Collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "hash", Value: 1}},
+			Options: options.Index().SetName("hash_unique").SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "service", Value: 1}, {Key: "created_at", Value: 1}},
+			Options: options.Index().SetName("service"),
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not create API keys indexes: %w", err)
+	}
+	return store, nil
+}
+
+// CreateAPIKey stores the key
+func (m *MongoAPIKeyStore) CreateAPIKey(ctx context.Context, key *entities.APIKey) error {
+	_, err := m.Collection.InsertOne(ctx, key)
+	return err
+}
+
+// GetAPIKey returns the key with the received id
+func (m *MongoAPIKeyStore) GetAPIKey(ctx context.Context, id string) (*entities.APIKey, error) {
+	return m.findAPIKey(ctx, bson.D{{Key: "_id", Value: id}})
+}
+
+// FindAPIKey returns the key with the received hash
+func (m *MongoAPIKeyStore) FindAPIKey(ctx context.Context, hash string) (*entities.APIKey, error) {
+	return m.findAPIKey(ctx, bson.D{{Key: "hash", Value: hash}})
+}
+
+// ListAPIKeys returns the keys of the service, or every key when it is empty, in creation order
+func (m *MongoAPIKeyStore) ListAPIKeys(ctx context.Context, service string) ([]*entities.APIKey, error) {
+	filter := bson.D{}
+	if service != "" {
+		filter = bson.D{{Key: "service", Value: service}}
+	}
+	cursor, err := m.Collection.Find(ctx, filter,
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	keys := []*entities.APIKey{}
+	if err := cursor.All(ctx, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// RotateAPIKey replaces the hash of a key unless it was revoked, which is checked in the same update
+func (m *MongoAPIKeyStore) RotateAPIKey(ctx context.Context, id, hash string, now time.Time) (*entities.APIKey, error) {
+	var key entities.APIKey
+	err := m.Collection.FindOneAndUpdate(ctx,
+		bson.D{
+			{Key: "_id", Value: id},
+			{Key: "revoked_at", Value: bson.D{{Key: "$exists", Value: false}}},
+		},
+		bson.D{{Key: "$set", Value: bson.D{
+			{Key: "hash", Value: hash},
+			{Key: "rotated_at", Value: now.UTC().Truncate(time.Millisecond)},
+		}}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&key)
+	if err == mongo.ErrNoDocuments {
+		if _, err := m.GetAPIKey(ctx, id); err != nil {
+			return nil, err
+		}
+		return nil, entities.RevokedAPIKeyError
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// RevokeAPIKey marks a key as revoked, unless it was revoked before
+func (m *MongoAPIKeyStore) RevokeAPIKey(ctx context.Context, id string, now time.Time) (*entities.APIKey, error) {
+	_, err := m.Collection.UpdateOne(ctx,
+		bson.D{
+			{Key: "_id", Value: id},
+			{Key: "revoked_at", Value: bson.D{{Key: "$exists", Value: false}}},
+		},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "revoked_at", Value: now.UTC().Truncate(time.Millisecond)}}}})
+	if err != nil {
+		return nil, err
+	}
+	return m.GetAPIKey(ctx, id)
+}
+
+// TouchAPIKey stores when the key was last used
+func (m *MongoAPIKeyStore) TouchAPIKey(ctx context.Context, id string, now time.Time) error {
+	result, err := m.Collection.UpdateOne(ctx,
+		bson.D{{Key: "_id", Value: id}},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "last_used_at", Value: now.UTC().Truncate(time.Millisecond)}}}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return entities.NotFoundAPIKey
+	}
+	return nil
+}
+
+func (m *MongoAPIKeyStore) findAPIKey(ctx context.Context, filter bson.D) (*entities.APIKey, error) {
+	var key entities.APIKey
+	err := m.Collection.FindOne(ctx, filter).Decode(&key)
+	if err == mongo.ErrNoDocuments {
+		return nil, entities.NotFoundAPIKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
@@ -2,42 +2,198 @@ package database
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
 	"log"
 	"net/mail"
+	"os"
+	"strings"
 	"time"
 	"userManagement/entities"
+	"userManagement/infra/password"
 	pb "userManagement/proto"
 )
 
-var (
-	DBClient *MongoClient
-)
+// userRole is the role every user has, the auth package cannot be imported here since it stores refresh tokens with it
+const userRole = "user"
+
+// MongoConfig holds the settings needed to connect to the mongo users collection
+type MongoConfig struct {
+	URI        string
+	Database   string
+	Collection string
+	// EventsCollection stores the user events, its sequence counter is kept in the collection with the "_counters" suffix
+	EventsCollection string
+	// WebhooksCollection stores the webhooks, their deliveries are kept in the collection with the "_deliveries" suffix
+	WebhooksCollection string
+	// RefreshTokensCollection stores the hashes of the issued refresh tokens
+	RefreshTokensCollection string
+	// APIKeysCollection stores the API keys of the services, with the hashes of the keys
+	APIKeysCollection string
+
+	Username   string
+	Password   string
+	AuthSource string
+
+	TLS                   bool
+	TLSCAFile             string
+	TLSInsecureSkipVerify bool
+
+	MaxPoolSize            uint64
+	ConnectTimeout         time.Duration
+	ServerSelectionTimeout time.Duration
+}
 
-func init() {
-	// mongo connection initialization
-	clientOptions := options.Client().ApplyURI("mongodb://host.docker.internal:27017/")
-	client, err := mongo.Connect(context.TODO(), clientOptions)
+// DefaultMongoConfig returns the settings used when nothing else is configured,
+// which point to the mongo instance deployed along the service with docker
+func DefaultMongoConfig() MongoConfig {
+	return MongoConfig{
+		URI:                     "mongodb://host.docker.internal:27017/",
+		Database:                "userManagement",
+		Collection:              "users",
+		EventsCollection:        "user_events",
+		WebhooksCollection:      "webhooks",
+		RefreshTokensCollection: "refresh_tokens",
+		APIKeysCollection:       "api_keys",
+		MaxPoolSize:             100,
+		ConnectTimeout:          10 * time.Second,
+		ServerSelectionTimeout:  10 * time.Second,
+	}
+}
+
+type MongoClient struct {
+	Collection *mongo.Collection
+	// EventLog receives the event of every change, written in the same transaction as the change when the
+	// deployment supports transactions. No events are stored when it is nil
+	EventLog *MongoEventLog
+	// transactions is set when mongo is deployed as a replica set or a sharded cluster
+	transactions bool
+}
+
+// NewMongoClient connects to mongo with the received config, checks the connection is usable
+// and creates the collection indexes
+func NewMongoClient(ctx context.Context, cfg MongoConfig) (*MongoClient, error) {
+	clientOptions := options.Client().
+		ApplyURI(cfg.URI).
+		SetMaxPoolSize(cfg.MaxPoolSize).
+		SetConnectTimeout(cfg.ConnectTimeout).
+		SetServerSelectionTimeout(cfg.ServerSelectionTimeout)
+
+	if cfg.Username != "" {
+		clientOptions.SetAuth(options.Credential{
+			Username:   cfg.Username,
+			Password:   cfg.Password,
+			AuthSource: cfg.AuthSource,
+		})
+	}
+
+	if cfg.TLS {
+		tlsConfig, err := getMongoTLSConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+		clientOptions.SetTLSConfig(tlsConfig)
+	}
+
+	client, err := mongo.Connect(ctx, clientOptions)
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("could not connect to mongo: %w", err)
 	}
 
-	// generation of a unique client to interact with mongo
-	DBClient = &MongoClient{
+	if err := client.Ping(ctx, readpref.Primary()); err != nil {
+		_ = client.Disconnect(context.Background())
+		return nil, fmt.Errorf("could not reach mongo: %w", err)
+	}
+
+	mongoClient := &MongoClient{
 		Collection: client.
-			Database("userManagement").
-			Collection("users")}
+			Database(cfg.Database).
+			Collection(cfg.Collection)}
+
+	if err := mongoClient.EnsureIndexes(ctx); err != nil {
+		_ = client.Disconnect(context.Background())
+		return nil, err
+	}
+
+	mongoClient.transactions, err = supportsTransactions(ctx, client)
+	if err != nil {
+		_ = client.Disconnect(context.Background())
+		return nil, fmt.Errorf("could not check mongo deployment: %w", err)
+	}
+	if !mongoClient.transactions {
+		log.Printf("Mongo is not deployed as a replica set, user changes and their events will not be written atomically")
+	}
+	return mongoClient, nil
 }
 
-type MongoClient struct {
-	Collection *mongo.Collection
+// supportsTransactions checks whether mongo is deployed as a replica set or a sharded cluster,
+// which are the deployments supporting multi-document transactions
+func supportsTransactions(ctx context.Context, client *mongo.Client) (bool, error) {
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello)
+	if err != nil {
+		return false, err
+	}
+	return hello.SetName != "" || hello.Msg == "isdbgrid", nil
+}
+
+// writeWithEvent runs a write which returns the event describing the change, and stores the event in the
+// event log. When transactions are supported, both are written in the same transaction, which is retried
+// on transient errors, so the write must not have side effects outside the database.
+// A nil event means nothing was changed
+func (m *MongoClient) writeWithEvent(ctx context.Context, write func(ctx context.Context) (*pb.UserEvent, error)) error {
+	if m.EventLog == nil {
+		_, err := write(ctx)
+		return err
+	}
+
+	if !m.transactions {
+		event, err := write(ctx)
+		if err != nil || event == nil {
+			return err
+		}
+		return m.EventLog.AppendEvent(ctx, event)
+	}
+
+	session, err := m.Collection.Database().Client().StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(context.Background())
+
+	var appended bool
+	_, err = session.WithTransaction(ctx, func(sessionCtx mongo.SessionContext) (interface{}, error) {
+		event, err := write(sessionCtx)
+		if err != nil || event == nil {
+			appended = false
+			return nil, err
+		}
+		appended = true
+		return nil, m.EventLog.appendEvent(sessionCtx, event)
+	})
+	if err == nil && appended {
+		m.EventLog.signal()
+	}
+	return err
+}
+
+// Disconnect closes the connections to mongo
+func (m *MongoClient) Disconnect(ctx context.Context) error {
+	return m.Collection.Database().Client().Disconnect(ctx)
 }
 
 // CreateUser adds a new user to the database.
-func (m *MongoClient) CreateUser(req *pb.CreateUserReq) (string, error) {
+// Email uniqueness is enforced by the unique email index, so concurrent creations cannot register it twice
+func (m *MongoClient) CreateUser(ctx context.Context, req *pb.CreateUserReq) (string, error) {
 	user := req.GetUser()
 
 	_, err := mail.ParseAddress(user.Email)
@@ -45,51 +201,56 @@ func (m *MongoClient) CreateUser(req *pb.CreateUserReq) (string, error) {
 	if err != nil {
 		return "", entities.InvalidEmailError
 	}
+	if user.Password == "" {
+		return "", entities.EmptyPasswordError
+	}
 
-	filter := bson.D{primitive.E{Key: "email", Value: user.Email}}
-
-	var foundUser bson.M
-	err = m.Collection.FindOne(context.Background(), filter).Decode(&foundUser)
-	if err == mongo.ErrNoDocuments {
-		log.Printf("User email is not registered, registering new user...")
-	} else {
-		if err == nil {
-			log.Printf("Coulf not create user: %v", entities.AlreadyRegisteredEmailError)
-			return "", entities.AlreadyRegisteredEmailError
-		}
-		log.Printf("Could not create user: %v", err)
+	passwordHash, err := password.Hash(user.Password, password.DefaultParams)
+	if err != nil {
+		log.Printf("Could not hash password for user with mail %s, %v", user.Email, err)
 		return "", err
 	}
 
 	mongoUser := entities.User{
-		Id:        primitive.NewObjectID(),
-		FirstName: user.FirstName,
-		LastName:  user.LastName,
-		Nickname:  user.GetNickname(),
-		Password:  user.Password,
-		Email:     user.Email,
-		Country:   user.Country,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+		Id:           primitive.NewObjectID(),
+		FirstName:    user.FirstName,
+		LastName:     user.LastName,
+		Nickname:     user.GetNickname(),
+		PasswordHash: passwordHash,
+		Email:        normalizeEmail(user.Email),
+		Country:      user.Country,
+		CreatedAt:    time.Now(),
+		UpdatedAt:    time.Now(),
+		Version:      1,
+		Roles:        []string{userRole},
 	}
 
-	createdUser, err := m.Collection.InsertOne(context.Background(), mongoUser)
+	err = m.writeWithEvent(ctx, func(ctx context.Context) (*pb.UserEvent, error) {
+		_, err := m.Collection.InsertOne(ctx, mongoUser)
+		if err != nil {
+			return nil, err
+		}
+		return newUserEvent(ctx, pb.UserEventType_USER_CREATED, nil, &mongoUser), nil
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		log.Printf("Could not create user: %v", entities.AlreadyRegisteredEmailError)
+		return "", entities.AlreadyRegisteredEmailError
+	}
 	if err != nil {
 		log.Printf("Could not create user with mail %s, %v", user.Email, err)
 		return "", err
 	}
 
-	createdID := createdUser.InsertedID.(primitive.ObjectID).Hex()
-	return createdID, nil
+	return mongoUser.Id.Hex(), nil
 }
 
 // GetUser retrieves a user from the database
-func (m *MongoClient) GetUser(req *pb.GetUserReq) (*pb.UserActionResponse, error) {
+func (m *MongoClient) GetUser(ctx context.Context, req *pb.GetUserReq) (*pb.UserActionResponse, error) {
 	id := req.UserId
 	filter := getFindUserFilter(id)
 
 	var foundUser entities.User
-	err := m.Collection.FindOne(context.Background(), filter).Decode(&foundUser)
+	err := m.Collection.FindOne(ctx, filter, options.FindOne().SetCollation(emailCollation)).Decode(&foundUser)
 	if err != nil {
 
 		msg := "Could not find user with id %s: %v"
@@ -101,96 +262,245 @@ func (m *MongoClient) GetUser(req *pb.GetUserReq) (*pb.UserActionResponse, error
 }
 
 // UpdateUser finds a user inside the database and update its fields.
-// Email cannot be updated since is used along _id to identify unique users
-func (m *MongoClient) UpdateUser(req *pb.UpdateUserReq) (*pb.UserActionResponse, error) {
+// Only the fields in the update mask are modified, or every field when the mask is empty.
+// Email cannot be updated since is used along _id to identify unique users.
+// The password is stored hashed
+func (m *MongoClient) UpdateUser(ctx context.Context, req *pb.UpdateUserReq) (*pb.UserActionResponse, error) {
 	id := req.UserId
 	filter := getFindUserFilter(id)
-	user := req.User
+	user := req.GetUser()
+
+	fields, err := getUpdateFields(req)
+	if err != nil {
+		return nil, err
+	}
 
-	update := bson.D{{"$set", bson.D{
-		{"first_name", user.FirstName},
-		{"last_name", user.LastName},
-		{"nickname", user.Nickname},
-		{"password", user.Password},
-		{"country", user.Country},
-		{"updated_at", time.Now()}}}}
+	set := bson.D{}
+	for _, field := range fields {
+		if field != passwordField {
+			set = append(set, primitive.E{Key: field, Value: getUserFieldValue(user, field)})
+		}
+	}
+	set = append(set, primitive.E{Key: "updated_at", Value: time.Now()})
+	update := bson.D{{Key: "$set", Value: set}}
+
+	if containsField(fields, passwordField) {
+		passwordHash, err := password.Hash(user.GetPassword(), password.DefaultParams)
+		if err != nil {
+			log.Printf("Could not hash password for user with id %s, %v", id, err)
+			return nil, err
+		}
+		update = getPasswordUpdate(set, passwordHash)
+	}
+	update = append(update, primitive.E{Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}})
 
+	// The update and the read of the result are done atomically, so the returned user is the one written.
+	// The previous state of the user is read in the same transaction to describe the change in its event
+	opts := options.FindOneAndUpdate().
+		SetReturnDocument(options.After).
+		SetCollation(emailCollation)
 	var updatedUser entities.User
-	m.Collection.FindOneAndUpdate(context.TODO(), filter, update)
-	err := m.Collection.FindOne(context.TODO(), filter).Decode(&updatedUser)
+	err = m.writeWithEvent(ctx, func(ctx context.Context) (*pb.UserEvent, error) {
+		var before entities.User
+		if m.EventLog != nil {
+			err := m.Collection.FindOne(ctx, filter, options.FindOne().SetCollation(emailCollation)).Decode(&before)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		err := m.Collection.
+			FindOneAndUpdate(ctx, withVersionFilter(filter, req.ExpectedVersion), update, opts).
+			Decode(&updatedUser)
+		if err != nil {
+			return nil, err
+		}
+		return newUserEvent(ctx, pb.UserEventType_USER_UPDATED, &before, &updatedUser), nil
+	})
 
+	if err == mongo.ErrNoDocuments && req.ExpectedVersion != 0 {
+		return nil, m.getPreconditionError(ctx, filter)
+	}
 	if err != nil {
 		msg := "Could not update user with id %s: %v"
 		return nil, handleActionError(id, msg, err)
 	}
 
-	req.ProtoMessage()
-
 	return getPbUser(updatedUser)
 }
 
-// DeleteUser removes a user from the database
-func (m *MongoClient) DeleteUser(req *pb.DeleteUserReq) (*pb.DeletionActionResponse, error) {
+// DeleteUser removes a user from the database.
+// When an expected version is received, the user is only removed if its stored version matches it
+func (m *MongoClient) DeleteUser(ctx context.Context, req *pb.DeleteUserReq) (*pb.DeletionActionResponse, error) {
 	id := req.UserId
 
 	filter := getFindUserFilter(id)
 
-	_, err := m.Collection.DeleteOne(context.TODO(), filter)
+	// The deleted user is returned to describe the deletion in its event
+	var deleted bool
+	err := m.writeWithEvent(ctx, func(ctx context.Context) (*pb.UserEvent, error) {
+		var before entities.User
+		err := m.Collection.FindOneAndDelete(ctx,
+			withVersionFilter(filter, req.ExpectedVersion),
+			options.FindOneAndDelete().SetCollation(emailCollation)).
+			Decode(&before)
+		deleted = err == nil
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return newUserEvent(ctx, pb.UserEventType_USER_DELETED, &before, nil), nil
+	})
 	if err != nil {
 		msg := "Could not delete user with id %s: %v"
 		return nil, handleActionError(id, msg, err)
 	}
 
+	if !deleted && req.ExpectedVersion != 0 {
+		if err := m.getPreconditionError(ctx, filter); err != entities.NotFoundUser {
+			return nil, err
+		}
+	}
+
 	return nil, nil
 }
 
-// GetAllUsers Filters by the provided query params in the request and returns all users that match the filter
-func (m *MongoClient) GetAllUsers(req *pb.ListUsersReq) (*pb.ListActionResponse, error) {
-	filter := req.Filter
+// getPreconditionError finds out why a write with a version precondition did not match any user:
+// either the user does not exist or its version is not the expected one
+func (m *MongoClient) getPreconditionError(ctx context.Context, filter bson.D) error {
+	err := m.Collection.FindOne(ctx, filter, options.FindOne().SetCollation(emailCollation)).Err()
+	if err == mongo.ErrNoDocuments {
+		return entities.NotFoundUser
+	}
+	if err != nil {
+		return err
+	}
+	return entities.VersionMismatchError
+}
 
-	var cursor *mongo.Cursor
+// GetAllUsers Filters by the provided query params in the request and returns a page of the users that match the filter.
+// Pages are requested with the token returned in the previous one, which holds the sort values of its last user,
+// so users created or deleted meanwhile do not make the following pages skip or repeat users
+func (m *MongoClient) GetAllUsers(ctx context.Context, req *pb.ListUsersReq) (*pb.ListActionResponse, error) {
+	query, err := getListQuery(req)
+	if err != nil {
+		return nil, err
+	}
 
-	if filter == nil {
-		cursor, _ = m.Collection.Find(context.TODO(), bson.D{{}})
-	} else {
-		filterUser := entities.User{
-			FirstName: filter.FirstName,
-			LastName:  filter.LastName,
-			Nickname:  filter.Nickname,
-			Email:     filter.Email,
-			Country:   filter.Country,
-		}
-		var mongoFilter bson.D
-		mFilter, err := bson.Marshal(filterUser)
-		if err != nil {
-			return nil, err
-		}
-		_ = bson.Unmarshal(mFilter, &mongoFilter)
+	listFilter := query.getMongoFilter()
+	findFilter := listFilter
+	if query.after != nil {
+		findFilter = bson.D{{Key: "$and", Value: bson.A{listFilter, getCursorFilter(query.fields, query.after)}}}
+	}
+
+	opts := options.Find().
+		SetSort(getSortSpec(query.fields)).
+		SetLimit(int64(query.pageSize + 1))
+	cursor, err := m.Collection.Find(ctx, findFilter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []entities.User
+	err = cursor.All(ctx, &results)
+	if err != nil {
+		return nil, err
+	}
+
+	page, err := getPbUserPage(results, query)
+	if err != nil {
+		return nil, err
+	}
 
-		cursor, err = m.Collection.Find(context.TODO(), mongoFilter)
+	if req.ShowTotalSize {
+		page.TotalSize, err = m.Collection.CountDocuments(ctx, listFilter)
 		if err != nil {
 			return nil, err
 		}
 	}
+	return page, nil
+}
 
-	var results []entities.User
-	pbResults := pb.ListActionResponse{
-		Users: []*pb.UserActionResponse{},
+// VerifyPassword checks the received password against the one stored for the user found by id or email.
+// Plain text or outdated password hashes are rehashed after a successful verification
+func (m *MongoClient) VerifyPassword(ctx context.Context, req *pb.VerifyPasswordReq) (*pb.VerifyPasswordResponse, error) {
+	id := req.UserId
+	filter := getFindUserFilter(id)
+
+	var foundUser entities.User
+	err := m.Collection.FindOne(ctx, filter, options.FindOne().SetCollation(emailCollation)).Decode(&foundUser)
+	if err == mongo.ErrNoDocuments {
+		log.Printf("Could not verify password, user with id %s not found", id)
+		password.VerifyMissing(req.Password, password.DefaultParams)
+		return &pb.VerifyPasswordResponse{Valid: false}, nil
 	}
-	err := cursor.All(context.TODO(), &results)
 	if err != nil {
-		return nil, err
+		msg := "Could not verify password for user with id %s: %v"
+		return nil, handleActionError(id, msg, err)
 	}
 
-	for _, result := range results {
-		user, err := getPbUser(result)
+	valid, needsRehash := password.Verify(req.Password, foundUser, password.DefaultParams)
+	if !valid {
+		return &pb.VerifyPasswordResponse{Valid: false}, nil
+	}
+
+	if needsRehash {
+		passwordHash, err := password.Hash(req.Password, password.DefaultParams)
 		if err != nil {
 			return nil, err
 		}
-		pbResults.Users = append(pbResults.Users, user)
+
+		rehashFilter := bson.D{{Key: "_id", Value: foundUser.Id}}
+		update := getPasswordUpdate(bson.D{}, passwordHash)
+		if _, err := m.Collection.UpdateOne(ctx, rehashFilter, update); err != nil {
+			// The credentials were already verified, so failing to upgrade the hash is not fatal
+			log.Printf("Could not rehash password for user with id %s: %v", id, err)
+		}
 	}
 
-	return &pbResults, nil
+	return &pb.VerifyPasswordResponse{Valid: true, Id: foundUser.Id.Hex()}, nil
+}
+
+// GrantRole adds a role to a user found by id or email, increasing its version unless it already had the role
+func (m *MongoClient) GrantRole(ctx context.Context, req *pb.GrantRoleReq) (*pb.UserActionResponse, error) {
+	return m.updateRoles(ctx, req.UserId,
+		primitive.E{Key: "roles", Value: bson.D{{Key: "$ne", Value: req.Role}}},
+		primitive.E{Key: "$addToSet", Value: bson.D{{Key: "roles", Value: req.Role}}})
+}
+
+// RevokeRole removes a role of a user found by id or email, increasing its version unless it did not have the role
+func (m *MongoClient) RevokeRole(ctx context.Context, req *pb.RevokeRoleReq) (*pb.UserActionResponse, error) {
+	return m.updateRoles(ctx, req.UserId,
+		primitive.E{Key: "roles", Value: req.Role},
+		primitive.E{Key: "$pull", Value: bson.D{{Key: "roles", Value: req.Role}}})
+}
+
+// updateRoles applies a change of roles to the user found by id or email when it matches the roles filter,
+// so users the change does not modify are returned as they are. Role changes do not store events,
+// since events describe the profile of the users
+func (m *MongoClient) updateRoles(ctx context.Context, id string, rolesFilter, change primitive.E) (*pb.UserActionResponse, error) {
+	filter := append(getFindUserFilter(id), rolesFilter)
+	update := bson.D{
+		change,
+		{Key: "$set", Value: bson.D{{Key: "updated_at", Value: time.Now()}}},
+		{Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}},
+	}
+	opts := options.FindOneAndUpdate().
+		SetReturnDocument(options.After).
+		SetCollation(emailCollation)
+
+	var updatedUser entities.User
+	err := m.Collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&updatedUser)
+	if err == mongo.ErrNoDocuments {
+		return m.GetUser(ctx, &pb.GetUserReq{UserId: id})
+	}
+	if err != nil {
+		msg := "Could not change roles of user with id %s: %v"
+		return nil, handleActionError(id, msg, err)
+	}
+	return getPbUser(updatedUser)
 }
 
 func handleActionError(id, msg string, err error) error {
@@ -202,12 +512,13 @@ func handleActionError(id, msg string, err error) error {
 }
 
 // getFindUserFilter is an auxiliar function that builds the filter to find users.
-// It is necessary to be able to filter by email and by mongo id
+// It is necessary to be able to filter by email and by mongo id.
+// Emails are normalised, and must be matched using emailCollation
 func getFindUserFilter(id string) bson.D {
 	_, err := mail.ParseAddress(id)
 	var filter bson.D
 	if err == nil {
-		filter = bson.D{primitive.E{Key: "email", Value: id}}
+		filter = bson.D{primitive.E{Key: "email", Value: normalizeEmail(id)}}
 	} else {
 		mongoID, _ := primitive.ObjectIDFromHex(id)
 		filter = bson.D{primitive.E{Key: "_id", Value: mongoID}}
@@ -215,7 +526,80 @@ func getFindUserFilter(id string) bson.D {
 	return filter
 }
 
-// getPbUser builds a protobuf user response from the user entity used to interact with mongo
+// normalizeEmail trims the received email and lower cases its domain, which is case-insensitive,
+// so the same address is always stored and looked up the same way. Invalid emails are only trimmed
+func normalizeEmail(email string) string {
+	email = strings.TrimSpace(email)
+	address, err := mail.ParseAddress(email)
+	if err != nil {
+		return email
+	}
+
+	at := strings.LastIndex(address.Address, "@")
+	return address.Address[:at] + strings.ToLower(address.Address[at:])
+}
+
+// getMongoTLSConfig builds the TLS settings used to connect to mongo, trusting the configured CA file if any
+func getMongoTLSConfig(cfg MongoConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: cfg.TLSInsecureSkipVerify}
+	if cfg.TLSCAFile == "" {
+		return tlsConfig, nil
+	}
+
+	ca, err := os.ReadFile(cfg.TLSCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not read mongo CA file: %w", err)
+	}
+	tlsConfig.RootCAs = x509.NewCertPool()
+	if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificates found in mongo CA file %s", cfg.TLSCAFile)
+	}
+	return tlsConfig, nil
+}
+
+// withVersionFilter adds the expected version to a filter to find users. No version is added when it is 0
+func withVersionFilter(filter bson.D, version int64) bson.D {
+	if version == 0 {
+		return filter
+	}
+	versionFilter := append(bson.D{}, filter...)
+	return append(versionFilter, primitive.E{Key: "version", Value: version})
+}
+
+// getListUsersFilter builds the filter used to list users from the fields set in the received user.
+// A nil user matches every stored user
+func getListUsersFilter(filter *pb.User) (bson.D, error) {
+	if filter == nil {
+		return bson.D{}, nil
+	}
+
+	filterUser := entities.User{
+		FirstName: filter.FirstName,
+		LastName:  filter.LastName,
+		Nickname:  filter.Nickname,
+		Email:     normalizeEmail(filter.Email),
+		Country:   filter.Country,
+	}
+	var listFilter bson.D
+	mFilter, err := bson.Marshal(filterUser)
+	if err != nil {
+		return nil, err
+	}
+	err = bson.Unmarshal(mFilter, &listFilter)
+	return listFilter, err
+}
+
+// getPasswordUpdate builds an update which stores the received password hash, along with the
+// received fields to set, and removes any legacy plain text password
+func getPasswordUpdate(set bson.D, passwordHash *entities.PasswordHash) bson.D {
+	set = append(set, primitive.E{Key: "password_hash", Value: passwordHash})
+	return bson.D{
+		{Key: "$set", Value: set},
+		{Key: "$unset", Value: bson.D{{Key: "password", Value: ""}}}}
+}
+
+// getPbUser builds a protobuf user response from the user entity used to interact with mongo.
+// Passwords are never included in the response
 func getPbUser(foundUser entities.User) (*pb.UserActionResponse, error) {
 	return &pb.UserActionResponse{
 		Id: foundUser.Id.Hex(),
@@ -224,10 +608,46 @@ func getPbUser(foundUser entities.User) (*pb.UserActionResponse, error) {
 			LastName:  foundUser.LastName,
 			Email:     foundUser.Email,
 			Nickname:  foundUser.Nickname,
-			Password:  foundUser.Password,
 			Country:   foundUser.Country,
 		},
 		CreatedAt: foundUser.CreatedAt.String(),
 		UpdatedAt: foundUser.UpdatedAt.String(),
+		Version:   foundUser.Version,
+		Roles:     getUserRoles(foundUser),
 	}, nil
 }
+
+// getUserRoles returns the roles of a user, which always include the user role, even for users stored before roles
+func getUserRoles(user entities.User) []string {
+	if hasRole(user.Roles, userRole) {
+		return user.Roles
+	}
+	return append([]string{userRole}, user.Roles...)
+}
+
+// hasRole reports whether the role is among the received ones
+func hasRole(roles []string, role string) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// getPbUserList builds a protobuf list response from the user entities found
+func getPbUserList(results []entities.User) (*pb.ListActionResponse, error) {
+	pbResults := pb.ListActionResponse{
+		Users: []*pb.UserActionResponse{},
+	}
+
+	for _, result := range results {
+		user, err := getPbUser(result)
+		if err != nil {
+			return nil, err
+		}
+		pbResults.Users = append(pbResults.Users, user)
+	}
+
+	return &pbResults, nil
+}
@@ -0,0 +1,149 @@
+package databasetest
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+	"userManagement/entities"
+	"userManagement/infra/database"
+)
+
+// APIKeyStoreFactory creates an empty API key store. It is called once per test case
+type APIKeyStoreFactory func(t *testing.T) database.APIKeyStoreInterface
+
+type apiKeyStoreCase struct {
+	name string
+	run  func(t *testing.T, store database.APIKeyStoreInterface)
+}
+
+var apiKeyStoreCases = []apiKeyStoreCase{
+	{"CreateAndGetAPIKey", testCreateAndGetAPIKey},
+	{"GetMissingAPIKey", testGetMissingAPIKey},
+	{"ListAPIKeys", testListAPIKeys},
+	{"RotateAPIKey", testRotateAPIKey},
+	{"RevokeAPIKey", testRevokeAPIKey},
+	{"TouchAPIKey", testTouchAPIKey},
+}
+
+// RunAPIKeyStoreConformance runs the API key store conformance suite against the stores created by the factory
+func RunAPIKeyStoreConformance(t *testing.T, newStore APIKeyStoreFactory) {
+	for _, c := range apiKeyStoreCases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			c.run(t, newStore(t))
+		})
+	}
+}
+
+func mustCreateAPIKey(t *testing.T, store database.APIKeyStoreInterface, id, hash, service string) *entities.APIKey {
+	t.Helper()
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	key := &entities.APIKey{
+		ID:        id,
+		Hash:      hash,
+		Service:   service,
+		Scopes:    []string{"users.read"},
+		CreatedAt: now,
+		ExpiresAt: now.Add(time.Hour),
+	}
+	if err := store.CreateAPIKey(context.Background(), key); err != nil {
+		t.Fatalf("Could not create API key: %v", err)
+	}
+	return key
+}
+
+func testCreateAndGetAPIKey(t *testing.T, store database.APIKeyStoreInterface) {
+	created := mustCreateAPIKey(t, store, "1", "a", "reports")
+
+	for _, find := range []func() (*entities.APIKey, error){
+		func() (*entities.APIKey, error) { return store.GetAPIKey(context.Background(), "1") },
+		func() (*entities.APIKey, error) { return store.FindAPIKey(context.Background(), "a") },
+	} {
+		found, err := find()
+		assert.Nil(t, err)
+		assert.EqualValues(t, "1", found.ID)
+		assert.EqualValues(t, "reports", found.Service)
+		assert.EqualValues(t, []string{"users.read"}, found.Scopes)
+		assert.True(t, created.ExpiresAt.Equal(found.ExpiresAt))
+		assert.True(t, found.RevokedAt.IsZero())
+		assert.True(t, found.LastUsedAt.IsZero())
+	}
+}
+
+func testGetMissingAPIKey(t *testing.T, store database.APIKeyStoreInterface) {
+	_, err := store.GetAPIKey(context.Background(), "missing")
+	assert.EqualValues(t, entities.NotFoundAPIKey, err)
+	_, err = store.FindAPIKey(context.Background(), "missing")
+	assert.EqualValues(t, entities.NotFoundAPIKey, err)
+	_, err = store.RotateAPIKey(context.Background(), "missing", "b", time.Now())
+	assert.EqualValues(t, entities.NotFoundAPIKey, err)
+	_, err = store.RevokeAPIKey(context.Background(), "missing", time.Now())
+	assert.EqualValues(t, entities.NotFoundAPIKey, err)
+}
+
+func testListAPIKeys(t *testing.T, store database.APIKeyStoreInterface) {
+	mustCreateAPIKey(t, store, "1", "a", "reports")
+	mustCreateAPIKey(t, store, "2", "b", "billing")
+	mustCreateAPIKey(t, store, "3", "c", "reports")
+
+	keys, err := store.ListAPIKeys(context.Background(), "reports")
+	assert.Nil(t, err)
+	if assert.Len(t, keys, 2) {
+		assert.EqualValues(t, "1", keys[0].ID)
+		assert.EqualValues(t, "3", keys[1].ID)
+	}
+
+	keys, err = store.ListAPIKeys(context.Background(), "")
+	assert.Nil(t, err)
+	assert.Len(t, keys, 3)
+
+	keys, err = store.ListAPIKeys(context.Background(), "missing")
+	assert.Nil(t, err)
+	assert.Len(t, keys, 0)
+}
+
+func testRotateAPIKey(t *testing.T, store database.APIKeyStoreInterface) {
+	mustCreateAPIKey(t, store, "1", "a", "reports")
+
+	rotated, err := store.RotateAPIKey(context.Background(), "1", "b", time.Now())
+	assert.Nil(t, err)
+	assert.EqualValues(t, "b", rotated.Hash)
+	assert.False(t, rotated.RotatedAt.IsZero())
+
+	// The previous hash no longer finds the key
+	_, err = store.FindAPIKey(context.Background(), "a")
+	assert.EqualValues(t, entities.NotFoundAPIKey, err)
+	found, err := store.FindAPIKey(context.Background(), "b")
+	assert.Nil(t, err)
+	assert.EqualValues(t, "1", found.ID)
+}
+
+func testRevokeAPIKey(t *testing.T, store database.APIKeyStoreInterface) {
+	mustCreateAPIKey(t, store, "1", "a", "reports")
+
+	revoked, err := store.RevokeAPIKey(context.Background(), "1", time.Now())
+	assert.Nil(t, err)
+	assert.False(t, revoked.RevokedAt.IsZero())
+
+	// Revoking twice keeps the first revocation
+	again, err := store.RevokeAPIKey(context.Background(), "1", time.Now().Add(time.Hour))
+	assert.Nil(t, err)
+	assert.True(t, revoked.RevokedAt.Equal(again.RevokedAt))
+
+	_, err = store.RotateAPIKey(context.Background(), "1", "b", time.Now())
+	assert.EqualValues(t, entities.RevokedAPIKeyError, err)
+	found, err := store.FindAPIKey(context.Background(), "a")
+	assert.Nil(t, err)
+	assert.False(t, found.RevokedAt.IsZero())
+}
+
+func testTouchAPIKey(t *testing.T, store database.APIKeyStoreInterface) {
+	mustCreateAPIKey(t, store, "1", "a", "reports")
+	now := time.Now().UTC().Truncate(time.Millisecond)
+
+	assert.Nil(t, store.TouchAPIKey(context.Background(), "1", now))
+	found, err := store.GetAPIKey(context.Background(), "1")
+	assert.Nil(t, err)
+	assert.True(t, now.Equal(found.LastUsedAt))
+}
@@ -0,0 +1,782 @@
+// Package databasetest provides the conformance suite every database.AdapterInterface implementation must pass.
+package databasetest
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"testing"
+	"time"
+	"userManagement/entities"
+	"userManagement/infra/database"
+	pb "userManagement/proto"
+)
+
+// AdapterFactory creates an empty database client. It is called once per test case,
+// so every case runs isolated from the rest
+type AdapterFactory func(t *testing.T) database.AdapterInterface
+
+type conformanceCase struct {
+	name string
+	run  func(t *testing.T, client database.AdapterInterface)
+}
+
+var conformanceCases = []conformanceCase{
+	{"CreateUserReturnsID", testCreateUserReturnsID},
+	{"CreateUserInvalidEmail", testCreateUserInvalidEmail},
+	{"CreateUserEmptyPassword", testCreateUserEmptyPassword},
+	{"CreateUserDuplicateEmail", testCreateUserDuplicateEmail},
+	{"CreateUserDuplicateEmailDifferentCase", testCreateUserDuplicateEmailDifferentCase},
+	{"CreateUserNormalizesEmail", testCreateUserNormalizesEmail},
+	{"GetUserByID", testGetUserByID},
+	{"GetUserByEmail", testGetUserByEmail},
+	{"GetMissingUser", testGetMissingUser},
+	{"GetUserOmitsPassword", testGetUserOmitsPassword},
+	{"ListUsersWithoutFilter", testListUsersWithoutFilter},
+	{"ListUsersPartialFilter", testListUsersPartialFilter},
+	{"ListUsersNoMatches", testListUsersNoMatches},
+	{"ListUsersFilterExpression", testListUsersFilterExpression},
+	{"ListUsersInvalidFilterExpression", testListUsersInvalidFilterExpression},
+	{"ListUsersPagination", testListUsersPagination},
+	{"ListUsersOrderBy", testListUsersOrderBy},
+	{"ListUsersPaginationWithChanges", testListUsersPaginationWithChanges},
+	{"ListUsersInvalidPagination", testListUsersInvalidPagination},
+	{"UpdateUser", testUpdateUser},
+	{"UpdateMissingUser", testUpdateMissingUser},
+	{"PartialUpdate", testPartialUpdate},
+	{"PartialUpdatePassword", testPartialUpdatePassword},
+	{"PartialUpdateInvalidMask", testPartialUpdateInvalidMask},
+	{"UpdateIncreasesVersion", testUpdateIncreasesVersion},
+	{"UpdateVersionMismatch", testUpdateVersionMismatch},
+	{"DeleteUser", testDeleteUser},
+	{"DeleteVersionMismatch", testDeleteVersionMismatch},
+	{"DeleteUserIdempotent", testDeleteUserIdempotent},
+	{"VerifyPassword", testVerifyPassword},
+	{"GrantRole", testGrantRole},
+	{"RevokeRole", testRevokeRole},
+	{"RolesOfMissingUser", testRolesOfMissingUser},
+	{"CancelledContext", testCancelledContext},
+}
+
+// RunAdapterConformance runs the whole conformance suite against the clients created by the factory
+func RunAdapterConformance(t *testing.T, newClient AdapterFactory) {
+	for _, c := range conformanceCases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			c.run(t, newClient(t))
+		})
+	}
+}
+
+func newUser(email, firstName, country string) *pb.User {
+	return &pb.User{
+		FirstName: firstName,
+		LastName:  "user",
+		Email:     email,
+		Nickname:  "nick",
+		Password:  "1234",
+		Country:   country,
+	}
+}
+
+// mustCreateUser stores the received user and fails the test if it could not be created
+func mustCreateUser(t *testing.T, client database.AdapterInterface, user *pb.User) string {
+	t.Helper()
+	id, err := client.CreateUser(context.Background(), &pb.CreateUserReq{User: user})
+	if err != nil {
+		t.Fatalf("Could not create user %s: %v", user.Email, err)
+	}
+	return id
+}
+
+func testCreateUserReturnsID(t *testing.T, client database.AdapterInterface) {
+	id := mustCreateUser(t, client, newUser("a@a.com", "testing", "ES"))
+
+	assert.NotEmpty(t, id)
+}
+
+func testCreateUserInvalidEmail(t *testing.T, client database.AdapterInterface) {
+	_, err := client.CreateUser(context.Background(), &pb.CreateUserReq{User: newUser("not-an-email", "testing", "ES")})
+
+	assert.EqualValues(t, entities.InvalidEmailError, err)
+}
+
+func testCreateUserEmptyPassword(t *testing.T, client database.AdapterInterface) {
+	user := newUser("a@a.com", "testing", "ES")
+	user.Password = ""
+	_, err := client.CreateUser(context.Background(), &pb.CreateUserReq{User: user})
+
+	assert.EqualValues(t, entities.EmptyPasswordError, err)
+	_, err = client.GetUser(context.Background(), &pb.GetUserReq{UserId: "a@a.com"})
+	assert.EqualValues(t, entities.NotFoundUser, err)
+}
+
+func testCreateUserDuplicateEmail(t *testing.T, client database.AdapterInterface) {
+	mustCreateUser(t, client, newUser("a@a.com", "testing", "ES"))
+
+	_, err := client.CreateUser(context.Background(), &pb.CreateUserReq{User: newUser("a@a.com", "other", "UK")})
+
+	assert.EqualValues(t, entities.AlreadyRegisteredEmailError, err)
+}
+
+func testCreateUserDuplicateEmailDifferentCase(t *testing.T, client database.AdapterInterface) {
+	mustCreateUser(t, client, newUser("a@a.com", "testing", "ES"))
+
+	for _, email := range []string{"A@a.com", "a@A.COM", " a@a.com "} {
+		_, err := client.CreateUser(context.Background(), &pb.CreateUserReq{User: newUser(email, "other", "UK")})
+		assert.EqualValues(t, entities.AlreadyRegisteredEmailError, err, "email %q must be rejected", email)
+	}
+}
+
+func testCreateUserNormalizesEmail(t *testing.T, client database.AdapterInterface) {
+	id := mustCreateUser(t, client, newUser(" Someone@Example.COM ", "testing", "ES"))
+
+	user, err := client.GetUser(context.Background(), &pb.GetUserReq{UserId: "Someone@example.com"})
+	if err != nil {
+		t.Fatalf("Could not retrieve user by normalised email: %v", err)
+	}
+	assert.EqualValues(t, id, user.Id)
+	assert.EqualValues(t, "Someone@example.com", user.User.Email)
+
+	_, err = client.GetUser(context.Background(), &pb.GetUserReq{UserId: "someone@EXAMPLE.com"})
+	assert.NoError(t, err, "emails must be found ignoring case")
+
+	users, err := client.GetAllUsers(context.Background(), &pb.ListUsersReq{UserFilter: &pb.User{Email: "Someone@EXAMPLE.com"}})
+	if err != nil {
+		t.Fatalf("Could not list users: %v", err)
+	}
+	assert.Len(t, users.Users, 1)
+}
+
+func testGetUserByID(t *testing.T, client database.AdapterInterface) {
+	id := mustCreateUser(t, client, newUser("a@a.com", "testing", "ES"))
+
+	user, err := client.GetUser(context.Background(), &pb.GetUserReq{UserId: id})
+	if err != nil {
+		t.Fatalf("Could not retrieve user by id: %v", err)
+	}
+
+	assert.EqualValues(t, id, user.Id)
+	assert.EqualValues(t, "a@a.com", user.User.Email)
+	assert.EqualValues(t, "testing", user.User.FirstName)
+	assert.EqualValues(t, "ES", user.User.Country)
+}
+
+func testGetUserByEmail(t *testing.T, client database.AdapterInterface) {
+	id := mustCreateUser(t, client, newUser("a@a.com", "testing", "ES"))
+
+	byEmail, err := client.GetUser(context.Background(), &pb.GetUserReq{UserId: "a@a.com"})
+	if err != nil {
+		t.Fatalf("Could not retrieve user by email: %v", err)
+	}
+	byID, err := client.GetUser(context.Background(), &pb.GetUserReq{UserId: id})
+	if err != nil {
+		t.Fatalf("Could not retrieve user by id: %v", err)
+	}
+
+	assert.EqualValues(t, byID, byEmail)
+}
+
+func testGetMissingUser(t *testing.T, client database.AdapterInterface) {
+	_, err := client.GetUser(context.Background(), &pb.GetUserReq{UserId: "missing@a.com"})
+	assert.EqualValues(t, entities.NotFoundUser, err)
+
+	_, err = client.GetUser(context.Background(), &pb.GetUserReq{UserId: "000000000000000000000000"})
+	assert.EqualValues(t, entities.NotFoundUser, err)
+}
+
+func testGetUserOmitsPassword(t *testing.T, client database.AdapterInterface) {
+	mustCreateUser(t, client, newUser("a@a.com", "testing", "ES"))
+
+	user, err := client.GetUser(context.Background(), &pb.GetUserReq{UserId: "a@a.com"})
+	if err != nil {
+		t.Fatalf("Could not retrieve user: %v", err)
+	}
+	users, err := client.GetAllUsers(context.Background(), &pb.ListUsersReq{})
+	if err != nil {
+		t.Fatalf("Could not list users: %v", err)
+	}
+
+	assert.Empty(t, user.User.Password)
+	assert.Empty(t, users.Users[0].User.Password)
+}
+
+func testListUsersWithoutFilter(t *testing.T, client database.AdapterInterface) {
+	mustCreateUser(t, client, newUser("a@a.com", "testing", "ES"))
+	mustCreateUser(t, client, newUser("b@a.com", "other", "UK"))
+
+	users, err := client.GetAllUsers(context.Background(), &pb.ListUsersReq{UserFilter: nil})
+	if err != nil {
+		t.Fatalf("Could not list users: %v", err)
+	}
+
+	assert.Len(t, users.Users, 2)
+}
+
+func testListUsersPartialFilter(t *testing.T, client database.AdapterInterface) {
+	mustCreateUser(t, client, newUser("a@a.com", "testing", "ES"))
+	mustCreateUser(t, client, newUser("b@a.com", "other", "ES"))
+	mustCreateUser(t, client, newUser("c@a.com", "testing", "UK"))
+
+	tests := []struct {
+		name   string
+		filter *pb.User
+		emails []string
+	}{
+		{"ByCountry", &pb.User{Country: "ES"}, []string{"a@a.com", "b@a.com"}},
+		{"ByFirstName", &pb.User{FirstName: "testing"}, []string{"a@a.com", "c@a.com"}},
+		{"ByEmail", &pb.User{Email: "b@a.com"}, []string{"b@a.com"}},
+		{"BySeveralFields", &pb.User{FirstName: "testing", Country: "UK"}, []string{"c@a.com"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users, err := client.GetAllUsers(context.Background(), &pb.ListUsersReq{UserFilter: tt.filter})
+			if err != nil {
+				t.Fatalf("Could not list users: %v", err)
+			}
+
+			var emails []string
+			for _, user := range users.Users {
+				emails = append(emails, user.User.Email)
+			}
+			assert.ElementsMatch(t, tt.emails, emails)
+		})
+	}
+}
+
+func testListUsersNoMatches(t *testing.T, client database.AdapterInterface) {
+	mustCreateUser(t, client, newUser("a@a.com", "testing", "ES"))
+
+	users, err := client.GetAllUsers(context.Background(), &pb.ListUsersReq{UserFilter: &pb.User{Country: "FR"}})
+	if err != nil {
+		t.Fatalf("Could not list users: %v", err)
+	}
+
+	assert.NotNil(t, users.Users)
+	assert.Empty(t, users.Users)
+}
+
+func testListUsersFilterExpression(t *testing.T, client database.AdapterInterface) {
+	mustCreateUser(t, client, &pb.User{FirstName: "Ana", LastName: "Garcia", Email: "a@a.com", Password: "1234", Country: "ES"})
+	mustCreateUser(t, client, &pb.User{FirstName: "Bob", LastName: "Garrido", Email: "b@a.com", Nickname: "bob", Password: "1234", Country: "UK"})
+	mustCreateUser(t, client, &pb.User{FirstName: "Carla", LastName: "Lopez", Email: "c@a.com", Password: "1234", Country: "ES"})
+	time.Sleep(5 * time.Millisecond)
+	since := time.Now().UTC()
+	time.Sleep(5 * time.Millisecond)
+	mustCreateUser(t, client, &pb.User{FirstName: "dani", LastName: "garcia", Email: "d@A.com", Password: "1234", Country: "FR"})
+
+	tests := []struct {
+		name   string
+		filter string
+		emails []string
+	}{
+		{"Equal", `country = "ES"`, []string{"a@a.com", "c@a.com"}},
+		{"UnquotedValue", `country = ES`, []string{"a@a.com", "c@a.com"}},
+		{"NotEqual", `country != "ES"`, []string{"b@a.com", "d@a.com"}},
+		{"Or", `country = "UK" OR country = "FR"`, []string{"b@a.com", "d@a.com"}},
+		{"And", `country = "ES" AND first_name = "Carla"`, []string{"c@a.com"}},
+		{"ImplicitAnd", `country = "ES" first_name = "Carla"`, []string{"c@a.com"}},
+		{"OrBindsTighterThanAnd", `country = "ES" AND first_name = "Ana" OR first_name = "Carla"`, []string{"a@a.com", "c@a.com"}},
+		{"Parentheses", `(country = "ES" AND first_name = "Ana") OR country = "FR"`, []string{"a@a.com", "d@a.com"}},
+		{"Not", `NOT country = "ES"`, []string{"b@a.com", "d@a.com"}},
+		{"Minus", `-country = "ES"`, []string{"b@a.com", "d@a.com"}},
+		{"Prefix", `last_name = "Gar*"`, []string{"a@a.com", "b@a.com"}},
+		{"NotPrefix", `last_name != "Gar*"`, []string{"c@a.com", "d@a.com"}},
+		{"HasIgnoresCase", `last_name : "GARCIA"`, []string{"a@a.com", "d@a.com"}},
+		{"HasPrefix", `last_name : "gar*"`, []string{"a@a.com", "b@a.com", "d@a.com"}},
+		{"EmailIsNormalized", `email = "d@A.COM"`, []string{"d@a.com"}},
+		{"Ordering", `first_name >= "Bob" AND first_name < "dani"`, []string{"b@a.com", "c@a.com"}},
+		{"EmptyValueMatchesMissingFields", `nickname = ""`, []string{"a@a.com", "c@a.com", "d@a.com"}},
+		{"NotEmptyValue", `nickname != ""`, []string{"b@a.com"}},
+		{"OrderingSkipsMissingFields", `nickname < "z"`, []string{"b@a.com"}},
+		{"CreatedAfter", `created_at > "` + since.Format(time.RFC3339Nano) + `"`, []string{"d@a.com"}},
+		{"UpdatedBefore", `updated_at <= "` + since.Format(time.RFC3339Nano) + `"`, []string{"a@a.com", "b@a.com", "c@a.com"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			emails := listAllEmails(t, client, &pb.ListUsersReq{Filter: tt.filter, PageSize: 1})
+			assert.ElementsMatch(t, tt.emails, emails)
+		})
+	}
+
+	t.Run("CombinedWithUserFilter", func(t *testing.T) {
+		emails := listAllEmails(t, client, &pb.ListUsersReq{Filter: `last_name : "gar*"`, UserFilter: &pb.User{Country: "ES"}})
+		assert.ElementsMatch(t, []string{"a@a.com"}, emails)
+	})
+
+	t.Run("TotalSize", func(t *testing.T) {
+		users, err := client.GetAllUsers(context.Background(), &pb.ListUsersReq{Filter: `country = "ES"`, PageSize: 1, ShowTotalSize: true})
+		if err != nil {
+			t.Fatalf("Could not list users: %v", err)
+		}
+		assert.EqualValues(t, 2, users.TotalSize)
+	})
+}
+
+func testListUsersInvalidFilterExpression(t *testing.T, client database.AdapterInterface) {
+	tests := []struct {
+		name   string
+		filter string
+		token  string
+	}{
+		{"UnknownField", `password = "1234"`, `"password"`},
+		{"MissingComparator", `country "ES"`, "position 9"},
+		{"MissingValue", `country =`, "position 10"},
+		{"UnknownComparator", `country => "ES"`, `near ">"`},
+		{"UnbalancedParentheses", `(country = "ES"`, "position 16"},
+		{"UnterminatedString", `country = "ES`, `"\"ES"`},
+		{"TrailingOperator", `country = "ES" AND`, "position 19"},
+		{"HasOnTimestamp", `created_at : "2026-01-01T00:00:00Z"`, `":"`},
+		{"InvalidTimestamp", `created_at > "yesterday"`, `"yesterday"`},
+		{"WildcardInTheMiddle", `last_name = "G*a"`, `"G*a"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := client.GetAllUsers(context.Background(), &pb.ListUsersReq{Filter: tt.filter})
+			assert.EqualValues(t, codes.InvalidArgument, status.Code(err))
+			assert.Contains(t, status.Convert(err).Message(), tt.token)
+		})
+	}
+}
+
+// listAllEmails lists every page of users with the received request, returning the emails in the order received
+func listAllEmails(t *testing.T, client database.AdapterInterface, req *pb.ListUsersReq) []string {
+	t.Helper()
+	var emails []string
+	for pages := 0; ; pages++ {
+		if pages > 100 {
+			t.Fatalf("Too many pages listed, page tokens are not advancing")
+		}
+		users, err := client.GetAllUsers(context.Background(), req)
+		if err != nil {
+			t.Fatalf("Could not list users: %v", err)
+		}
+		if req.PageSize > 0 {
+			assert.LessOrEqual(t, len(users.Users), int(req.PageSize))
+		}
+		for _, user := range users.Users {
+			emails = append(emails, user.User.Email)
+		}
+		if users.NextPageToken == "" {
+			return emails
+		}
+		req.PageToken = users.NextPageToken
+	}
+}
+
+func testListUsersPagination(t *testing.T, client database.AdapterInterface) {
+	var created []string
+	for _, email := range []string{"a@a.com", "b@a.com", "c@a.com", "d@a.com", "e@a.com"} {
+		mustCreateUser(t, client, newUser(email, "testing", "ES"))
+		created = append(created, email)
+	}
+
+	firstPage, err := client.GetAllUsers(context.Background(), &pb.ListUsersReq{PageSize: 2, ShowTotalSize: true})
+	if err != nil {
+		t.Fatalf("Could not list users: %v", err)
+	}
+	assert.Len(t, firstPage.Users, 2)
+	assert.NotEmpty(t, firstPage.NextPageToken)
+	assert.EqualValues(t, 5, firstPage.TotalSize)
+
+	assert.EqualValues(t, created, listAllEmails(t, client, &pb.ListUsersReq{PageSize: 2}),
+		"users must be listed once, in creation order by default")
+}
+
+func testListUsersOrderBy(t *testing.T, client database.AdapterInterface) {
+	mustCreateUser(t, client, newUser("a@a.com", "b", "ES"))
+	mustCreateUser(t, client, newUser("b@a.com", "a", "UK"))
+	mustCreateUser(t, client, newUser("c@a.com", "c", ""))
+	mustCreateUser(t, client, newUser("d@a.com", "a", "ES"))
+	mustCreateUser(t, client, newUser("e@a.com", "d", ""))
+
+	tests := []struct {
+		orderBy string
+		emails  []string
+	}{
+		{"first_name", []string{"b@a.com", "d@a.com", "a@a.com", "c@a.com", "e@a.com"}},
+		{"first_name desc", []string{"e@a.com", "c@a.com", "a@a.com", "b@a.com", "d@a.com"}},
+		{"country, first_name", []string{"c@a.com", "e@a.com", "d@a.com", "a@a.com", "b@a.com"}},
+		{"country desc, first_name desc", []string{"b@a.com", "a@a.com", "d@a.com", "e@a.com", "c@a.com"}},
+		{"created_at", []string{"a@a.com", "b@a.com", "c@a.com", "d@a.com", "e@a.com"}},
+		{"email DESC", []string{"e@a.com", "d@a.com", "c@a.com", "b@a.com", "a@a.com"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.orderBy, func(t *testing.T) {
+			for _, pageSize := range []int32{0, 1, 2} {
+				emails := listAllEmails(t, client, &pb.ListUsersReq{OrderBy: tt.orderBy, PageSize: pageSize})
+				assert.EqualValues(t, tt.emails, emails, "page size %d", pageSize)
+			}
+		})
+	}
+}
+
+func testListUsersPaginationWithChanges(t *testing.T, client database.AdapterInterface) {
+	mustCreateUser(t, client, newUser("b@a.com", "testing", "ES"))
+	mustCreateUser(t, client, newUser("d@a.com", "testing", "ES"))
+	mustCreateUser(t, client, newUser("f@a.com", "testing", "ES"))
+
+	req := &pb.ListUsersReq{OrderBy: "email", PageSize: 2}
+	firstPage, err := client.GetAllUsers(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Could not list users: %v", err)
+	}
+
+	// Users sorted before the page token must not be returned again, and deleted ones must not make users be skipped
+	mustCreateUser(t, client, newUser("a@a.com", "testing", "ES"))
+	mustCreateUser(t, client, newUser("e@a.com", "testing", "ES"))
+	_, _ = client.DeleteUser(context.Background(), &pb.DeleteUserReq{UserId: "d@a.com"})
+
+	req.PageToken = firstPage.NextPageToken
+	secondPage, err := client.GetAllUsers(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Could not list users: %v", err)
+	}
+
+	var emails []string
+	for _, user := range append(firstPage.Users, secondPage.Users...) {
+		emails = append(emails, user.User.Email)
+	}
+	assert.EqualValues(t, []string{"b@a.com", "d@a.com", "e@a.com", "f@a.com"}, emails)
+}
+
+func testListUsersInvalidPagination(t *testing.T, client database.AdapterInterface) {
+	mustCreateUser(t, client, newUser("a@a.com", "testing", "ES"))
+	mustCreateUser(t, client, newUser("b@a.com", "testing", "ES"))
+
+	firstPage, err := client.GetAllUsers(context.Background(), &pb.ListUsersReq{PageSize: 1, OrderBy: "email"})
+	if err != nil {
+		t.Fatalf("Could not list users: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		req  *pb.ListUsersReq
+	}{
+		{"UnknownOrderField", &pb.ListUsersReq{OrderBy: "password"}},
+		{"InvalidOrderDirection", &pb.ListUsersReq{OrderBy: "email up"}},
+		{"RepeatedOrderField", &pb.ListUsersReq{OrderBy: "email, email desc"}},
+		{"NegativePageSize", &pb.ListUsersReq{PageSize: -1}},
+		{"MalformedPageToken", &pb.ListUsersReq{PageToken: "not-a-token"}},
+		{"PageTokenWithOtherOrder", &pb.ListUsersReq{PageToken: firstPage.NextPageToken, OrderBy: "email desc"}},
+		{"PageTokenWithOtherFilter", &pb.ListUsersReq{PageToken: firstPage.NextPageToken, OrderBy: "email", UserFilter: &pb.User{Country: "ES"}}},
+		{"PageTokenWithOtherFilterExpression", &pb.ListUsersReq{PageToken: firstPage.NextPageToken, OrderBy: "email", Filter: `country = "ES"`}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := client.GetAllUsers(context.Background(), tt.req)
+			assert.EqualValues(t, codes.InvalidArgument, status.Code(err))
+		})
+	}
+}
+
+func testUpdateUser(t *testing.T, client database.AdapterInterface) {
+	id := mustCreateUser(t, client, newUser("a@a.com", "testing", "ES"))
+
+	updated, err := client.UpdateUser(context.Background(), &pb.UpdateUserReq{
+		UserId: id,
+		User: &pb.User{
+			FirstName: "testing-updated",
+			LastName:  "user-updated",
+			Email:     "changed@a.com",
+			Nickname:  "b",
+			Country:   "UK",
+		},
+	})
+	if err != nil {
+		t.Fatalf("Could not update user: %v", err)
+	}
+
+	assert.EqualValues(t, id, updated.Id)
+	assert.EqualValues(t, "testing-updated", updated.User.FirstName)
+	assert.EqualValues(t, "user-updated", updated.User.LastName)
+	assert.EqualValues(t, "UK", updated.User.Country)
+	assert.EqualValues(t, "a@a.com", updated.User.Email, "email must not be updated")
+
+	found, err := client.GetUser(context.Background(), &pb.GetUserReq{UserId: "a@a.com"})
+	if err != nil {
+		t.Fatalf("Could not retrieve updated user: %v", err)
+	}
+	assert.EqualValues(t, updated, found)
+}
+
+func testUpdateMissingUser(t *testing.T, client database.AdapterInterface) {
+	_, err := client.UpdateUser(context.Background(), &pb.UpdateUserReq{
+		UserId: "missing@a.com",
+		User:   newUser("missing@a.com", "testing", "ES"),
+	})
+
+	assert.EqualValues(t, entities.NotFoundUser, err)
+}
+
+func testPartialUpdate(t *testing.T, client database.AdapterInterface) {
+	mustCreateUser(t, client, newUser("a@a.com", "testing", "ES"))
+
+	updated, err := client.UpdateUser(context.Background(), &pb.UpdateUserReq{
+		UserId:     "a@a.com",
+		User:       &pb.User{Country: "UK", FirstName: "ignored"},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"country"}},
+	})
+	if err != nil {
+		t.Fatalf("Could not update user: %v", err)
+	}
+
+	assert.EqualValues(t, "UK", updated.User.Country)
+	assert.EqualValues(t, "testing", updated.User.FirstName)
+	assert.EqualValues(t, "user", updated.User.LastName)
+	assert.EqualValues(t, "nick", updated.User.Nickname)
+
+	verification, err := client.VerifyPassword(context.Background(), &pb.VerifyPasswordReq{UserId: "a@a.com", Password: "1234"})
+	if err != nil {
+		t.Fatalf("Could not verify password: %v", err)
+	}
+	assert.True(t, verification.Valid, "fields out of the mask must not be modified")
+}
+
+func testPartialUpdatePassword(t *testing.T, client database.AdapterInterface) {
+	mustCreateUser(t, client, newUser("a@a.com", "testing", "ES"))
+
+	_, err := client.UpdateUser(context.Background(), &pb.UpdateUserReq{
+		UserId:     "a@a.com",
+		User:       &pb.User{Password: "4321"},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"password"}},
+	})
+	if err != nil {
+		t.Fatalf("Could not update user: %v", err)
+	}
+
+	verification, err := client.VerifyPassword(context.Background(), &pb.VerifyPasswordReq{UserId: "a@a.com", Password: "4321"})
+	if err != nil {
+		t.Fatalf("Could not verify password: %v", err)
+	}
+	assert.True(t, verification.Valid)
+
+	_, err = client.UpdateUser(context.Background(), &pb.UpdateUserReq{
+		UserId:     "a@a.com",
+		User:       &pb.User{},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"password"}},
+	})
+	assert.EqualValues(t, entities.EmptyPasswordError, err)
+}
+
+func testPartialUpdateInvalidMask(t *testing.T, client database.AdapterInterface) {
+	mustCreateUser(t, client, newUser("a@a.com", "testing", "ES"))
+
+	for _, path := range []string{"email", "id", "created_at", "updated_at", "unknown"} {
+		_, err := client.UpdateUser(context.Background(), &pb.UpdateUserReq{
+			UserId:     "a@a.com",
+			User:       &pb.User{Email: "b@a.com", Country: "UK"},
+			UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"country", path}},
+		})
+		assert.EqualValues(t, codes.InvalidArgument, status.Code(err), "path %s must be rejected", path)
+	}
+
+	user, err := client.GetUser(context.Background(), &pb.GetUserReq{UserId: "a@a.com"})
+	if err != nil {
+		t.Fatalf("Could not retrieve user: %v", err)
+	}
+	assert.EqualValues(t, "ES", user.User.Country, "rejected updates must not be applied")
+}
+
+func testUpdateIncreasesVersion(t *testing.T, client database.AdapterInterface) {
+	mustCreateUser(t, client, newUser("a@a.com", "testing", "ES"))
+
+	created, err := client.GetUser(context.Background(), &pb.GetUserReq{UserId: "a@a.com"})
+	if err != nil {
+		t.Fatalf("Could not retrieve user: %v", err)
+	}
+	assert.EqualValues(t, 1, created.Version)
+
+	updated, err := client.UpdateUser(context.Background(), &pb.UpdateUserReq{
+		UserId:          "a@a.com",
+		User:            &pb.User{Country: "UK"},
+		UpdateMask:      &fieldmaskpb.FieldMask{Paths: []string{"country"}},
+		ExpectedVersion: created.Version,
+	})
+	if err != nil {
+		t.Fatalf("Could not update user with the current version: %v", err)
+	}
+	assert.EqualValues(t, 2, updated.Version)
+
+	found, err := client.GetUser(context.Background(), &pb.GetUserReq{UserId: "a@a.com"})
+	if err != nil {
+		t.Fatalf("Could not retrieve user: %v", err)
+	}
+	assert.EqualValues(t, updated, found, "the returned user must be the one written")
+}
+
+func testUpdateVersionMismatch(t *testing.T, client database.AdapterInterface) {
+	mustCreateUser(t, client, newUser("a@a.com", "testing", "ES"))
+
+	_, err := client.UpdateUser(context.Background(), &pb.UpdateUserReq{
+		UserId:          "a@a.com",
+		User:            &pb.User{Country: "UK"},
+		UpdateMask:      &fieldmaskpb.FieldMask{Paths: []string{"country"}},
+		ExpectedVersion: 2,
+	})
+	assert.EqualValues(t, entities.VersionMismatchError, err)
+
+	_, err = client.UpdateUser(context.Background(), &pb.UpdateUserReq{
+		UserId:          "missing@a.com",
+		User:            &pb.User{Country: "UK"},
+		UpdateMask:      &fieldmaskpb.FieldMask{Paths: []string{"country"}},
+		ExpectedVersion: 1,
+	})
+	assert.EqualValues(t, entities.NotFoundUser, err)
+
+	user, err := client.GetUser(context.Background(), &pb.GetUserReq{UserId: "a@a.com"})
+	if err != nil {
+		t.Fatalf("Could not retrieve user: %v", err)
+	}
+	assert.EqualValues(t, "ES", user.User.Country)
+	assert.EqualValues(t, 1, user.Version)
+}
+
+func testDeleteVersionMismatch(t *testing.T, client database.AdapterInterface) {
+	mustCreateUser(t, client, newUser("a@a.com", "testing", "ES"))
+
+	_, err := client.DeleteUser(context.Background(), &pb.DeleteUserReq{UserId: "a@a.com", ExpectedVersion: 2})
+	assert.EqualValues(t, entities.VersionMismatchError, err)
+
+	_, err = client.GetUser(context.Background(), &pb.GetUserReq{UserId: "a@a.com"})
+	assert.NoError(t, err, "the user must not be deleted on version mismatch")
+
+	_, err = client.DeleteUser(context.Background(), &pb.DeleteUserReq{UserId: "a@a.com", ExpectedVersion: 1})
+	assert.NoError(t, err)
+
+	_, err = client.DeleteUser(context.Background(), &pb.DeleteUserReq{UserId: "a@a.com", ExpectedVersion: 1})
+	assert.NoError(t, err, "deleting a missing user with an expected version must be idempotent")
+}
+
+func testDeleteUser(t *testing.T, client database.AdapterInterface) {
+	mustCreateUser(t, client, newUser("a@a.com", "testing", "ES"))
+	id := mustCreateUser(t, client, newUser("b@a.com", "testing", "ES"))
+
+	_, err := client.DeleteUser(context.Background(), &pb.DeleteUserReq{UserId: id})
+	if err != nil {
+		t.Fatalf("Could not delete user: %v", err)
+	}
+
+	_, err = client.GetUser(context.Background(), &pb.GetUserReq{UserId: id})
+	assert.EqualValues(t, entities.NotFoundUser, err)
+
+	_, err = client.GetUser(context.Background(), &pb.GetUserReq{UserId: "a@a.com"})
+	assert.NoError(t, err, "only the requested user must be deleted")
+}
+
+func testDeleteUserIdempotent(t *testing.T, client database.AdapterInterface) {
+	mustCreateUser(t, client, newUser("a@a.com", "testing", "ES"))
+
+	for i := 0; i < 2; i++ {
+		_, err := client.DeleteUser(context.Background(), &pb.DeleteUserReq{UserId: "a@a.com"})
+		assert.NoError(t, err)
+	}
+
+	_, err := client.DeleteUser(context.Background(), &pb.DeleteUserReq{UserId: "missing@a.com"})
+	assert.NoError(t, err)
+}
+
+func testVerifyPassword(t *testing.T, client database.AdapterInterface) {
+	id := mustCreateUser(t, client, newUser("a@a.com", "testing", "ES"))
+
+	byEmail, err := client.VerifyPassword(context.Background(), &pb.VerifyPasswordReq{UserId: "a@a.com", Password: "1234"})
+	if err != nil {
+		t.Fatalf("Could not verify password: %v", err)
+	}
+	assert.True(t, byEmail.Valid)
+	assert.EqualValues(t, id, byEmail.Id)
+
+	wrong, err := client.VerifyPassword(context.Background(), &pb.VerifyPasswordReq{UserId: id, Password: "4321"})
+	if err != nil {
+		t.Fatalf("Could not verify password: %v", err)
+	}
+	assert.False(t, wrong.Valid)
+
+	missing, err := client.VerifyPassword(context.Background(), &pb.VerifyPasswordReq{UserId: "missing@a.com", Password: "1234"})
+	if err != nil {
+		t.Fatalf("Could not verify password: %v", err)
+	}
+	assert.False(t, missing.Valid)
+}
+
+func testGrantRole(t *testing.T, client database.AdapterInterface) {
+	id := mustCreateUser(t, client, newUser("a@a.com", "testing", "ES"))
+
+	created, err := client.GetUser(context.Background(), &pb.GetUserReq{UserId: id})
+	if err != nil {
+		t.Fatalf("Could not retrieve user: %v", err)
+	}
+	assert.EqualValues(t, []string{"user"}, created.Roles)
+
+	granted, err := client.GrantRole(context.Background(), &pb.GrantRoleReq{UserId: "a@a.com", Role: "admin"})
+	if err != nil {
+		t.Fatalf("Could not grant role: %v", err)
+	}
+	assert.EqualValues(t, []string{"user", "admin"}, granted.Roles)
+	assert.EqualValues(t, 2, granted.Version)
+
+	again, err := client.GrantRole(context.Background(), &pb.GrantRoleReq{UserId: id, Role: "admin"})
+	if err != nil {
+		t.Fatalf("Could not grant role again: %v", err)
+	}
+	assert.EqualValues(t, granted, again, "granting a role twice must leave the user unchanged")
+
+	found, err := client.GetUser(context.Background(), &pb.GetUserReq{UserId: id})
+	if err != nil {
+		t.Fatalf("Could not retrieve user: %v", err)
+	}
+	assert.EqualValues(t, granted, found)
+}
+
+func testRevokeRole(t *testing.T, client database.AdapterInterface) {
+	id := mustCreateUser(t, client, newUser("a@a.com", "testing", "ES"))
+	for _, role := range []string{"support", "admin"} {
+		if _, err := client.GrantRole(context.Background(), &pb.GrantRoleReq{UserId: id, Role: role}); err != nil {
+			t.Fatalf("Could not grant role: %v", err)
+		}
+	}
+
+	revoked, err := client.RevokeRole(context.Background(), &pb.RevokeRoleReq{UserId: "a@a.com", Role: "support"})
+	if err != nil {
+		t.Fatalf("Could not revoke role: %v", err)
+	}
+	assert.EqualValues(t, []string{"user", "admin"}, revoked.Roles)
+	assert.EqualValues(t, 4, revoked.Version)
+
+	again, err := client.RevokeRole(context.Background(), &pb.RevokeRoleReq{UserId: id, Role: "support"})
+	if err != nil {
+		t.Fatalf("Could not revoke role again: %v", err)
+	}
+	assert.EqualValues(t, revoked, again, "revoking a missing role must leave the user unchanged")
+}
+
+func testRolesOfMissingUser(t *testing.T, client database.AdapterInterface) {
+	_, err := client.GrantRole(context.Background(), &pb.GrantRoleReq{UserId: "missing@a.com", Role: "admin"})
+	assert.EqualValues(t, entities.NotFoundUser, err)
+
+	_, err = client.RevokeRole(context.Background(), &pb.RevokeRoleReq{UserId: "000000000000000000000000", Role: "admin"})
+	assert.EqualValues(t, entities.NotFoundUser, err)
+}
+
+func testCancelledContext(t *testing.T, client database.AdapterInterface) {
+	mustCreateUser(t, client, newUser("a@a.com", "testing", "ES"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := client.GetUser(ctx, &pb.GetUserReq{UserId: "a@a.com"})
+	assert.Error(t, err)
+
+	_, err = client.CreateUser(ctx, &pb.CreateUserReq{User: newUser("b@a.com", "testing", "ES")})
+	assert.Error(t, err)
+
+	_, err = client.GetUser(context.Background(), &pb.GetUserReq{UserId: "b@a.com"})
+	assert.EqualValues(t, entities.NotFoundUser, err, "operations with a cancelled context must not be applied")
+}
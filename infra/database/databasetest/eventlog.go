@@ -0,0 +1,154 @@
+package databasetest
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"testing"
+	"time"
+	"userManagement/infra/database"
+	pb "userManagement/proto"
+)
+
+// EventLogFactory creates an empty event log. It is called once per test case
+type EventLogFactory func(t *testing.T) database.EventLogInterface
+
+type eventLogCase struct {
+	name string
+	run  func(t *testing.T, eventLog database.EventLogInterface)
+}
+
+var eventLogCases = []eventLogCase{
+	{"AppendIncreasesSequence", testAppendIncreasesSequence},
+	{"ListAllEvents", testListAllEvents},
+	{"ListEventsAfterSequence", testListEventsAfterSequence},
+	{"ListEventsSinceTimestamp", testListEventsSinceTimestamp},
+	{"ListEventsLimit", testListEventsLimit},
+	{"ListUndeliveredEvents", testListUndeliveredEvents},
+	{"LastSequence", testLastSequence},
+}
+
+// RunEventLogConformance runs the event log conformance suite against the event logs created by the factory
+func RunEventLogConformance(t *testing.T, newEventLog EventLogFactory) {
+	for _, c := range eventLogCases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			c.run(t, newEventLog(t))
+		})
+	}
+}
+
+// mustAppendEvents stores an event for every received id, with the received timestamp, returning their sequences
+func mustAppendEvents(t *testing.T, eventLog database.EventLogInterface, timestamp time.Time, ids ...string) []int64 {
+	t.Helper()
+	var sequences []int64
+	for _, id := range ids {
+		event := &pb.UserEvent{
+			EventId:   id,
+			Type:      pb.UserEventType_USER_CREATED,
+			UserId:    "user-" + id,
+			Timestamp: timestamppb.New(timestamp),
+			After:     &pb.User{Email: id + "@a.com"},
+		}
+		if err := eventLog.AppendEvent(context.Background(), event); err != nil {
+			t.Fatalf("Could not append event %s: %v", id, err)
+		}
+		sequences = append(sequences, event.Sequence)
+	}
+	return sequences
+}
+
+func listEventIDs(t *testing.T, eventLog database.EventLogInterface, query database.EventQuery) []string {
+	t.Helper()
+	events, err := eventLog.ListEvents(context.Background(), query)
+	if err != nil {
+		t.Fatalf("Could not list events: %v", err)
+	}
+	return getEventIDs(events)
+}
+
+func getEventIDs(events []*pb.UserEvent) []string {
+	ids := []string{}
+	for _, event := range events {
+		ids = append(ids, event.EventId)
+	}
+	return ids
+}
+
+func testAppendIncreasesSequence(t *testing.T, eventLog database.EventLogInterface) {
+	sequences := mustAppendEvents(t, eventLog, time.Now(), "a", "b", "c")
+
+	assert.Greater(t, sequences[0], int64(0))
+	assert.Greater(t, sequences[1], sequences[0])
+	assert.Greater(t, sequences[2], sequences[1])
+}
+
+func testListAllEvents(t *testing.T, eventLog database.EventLogInterface) {
+	sequences := mustAppendEvents(t, eventLog, time.Now(), "a", "b")
+
+	events, err := eventLog.ListEvents(context.Background(), database.EventQuery{})
+	if err != nil {
+		t.Fatalf("Could not list events: %v", err)
+	}
+
+	assert.Len(t, events, 2)
+	assert.EqualValues(t, sequences[1], events[1].Sequence)
+	assert.EqualValues(t, "user-b", events[1].UserId)
+	assert.EqualValues(t, "b@a.com", events[1].After.Email)
+	assert.EqualValues(t, pb.UserEventType_USER_CREATED, events[1].Type)
+}
+
+func testListEventsAfterSequence(t *testing.T, eventLog database.EventLogInterface) {
+	sequences := mustAppendEvents(t, eventLog, time.Now(), "a", "b", "c")
+
+	assert.EqualValues(t, []string{"b", "c"}, listEventIDs(t, eventLog, database.EventQuery{After: sequences[0]}))
+	assert.EqualValues(t, []string{}, listEventIDs(t, eventLog, database.EventQuery{After: sequences[2]}))
+}
+
+func testListEventsSinceTimestamp(t *testing.T, eventLog database.EventLogInterface) {
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	mustAppendEvents(t, eventLog, now.Add(-time.Hour), "old")
+	mustAppendEvents(t, eventLog, now, "a", "b")
+
+	assert.EqualValues(t, []string{"a", "b"}, listEventIDs(t, eventLog, database.EventQuery{Since: now}))
+}
+
+func testListEventsLimit(t *testing.T, eventLog database.EventLogInterface) {
+	mustAppendEvents(t, eventLog, time.Now(), "a", "b", "c")
+
+	assert.EqualValues(t, []string{"a", "b"}, listEventIDs(t, eventLog, database.EventQuery{Limit: 2}))
+}
+
+func testListUndeliveredEvents(t *testing.T, eventLog database.EventLogInterface) {
+	ctx := context.Background()
+	sequences := mustAppendEvents(t, eventLog, time.Now(), "1", "2", "3")
+
+	undelivered, err := eventLog.ListUndeliveredEvents(ctx, 2)
+	assert.Nil(t, err)
+	assert.EqualValues(t, []string{"1", "2"}, getEventIDs(undelivered))
+
+	// Only the received sequences are marked, not the ones before them
+	assert.Nil(t, eventLog.MarkEventsDelivered(ctx, []int64{sequences[1]}))
+	undelivered, err = eventLog.ListUndeliveredEvents(ctx, 0)
+	assert.Nil(t, err)
+	assert.EqualValues(t, []string{"1", "3"}, getEventIDs(undelivered))
+
+	assert.Nil(t, eventLog.MarkEventsDelivered(ctx, []int64{sequences[0]}))
+	undelivered, err = eventLog.ListUndeliveredEvents(ctx, 0)
+	assert.Nil(t, err)
+	assert.EqualValues(t, []string{"3"}, getEventIDs(undelivered))
+
+	// Delivered events are still replayed
+	assert.EqualValues(t, []string{"1", "2", "3"}, listEventIDs(t, eventLog, database.EventQuery{}))
+}
+
+func testLastSequence(t *testing.T, eventLog database.EventLogInterface) {
+	last, err := eventLog.LastSequence(context.Background())
+	assert.Nil(t, err)
+	assert.EqualValues(t, 0, last)
+
+	sequences := mustAppendEvents(t, eventLog, time.Now(), "1", "2")
+	last, err = eventLog.LastSequence(context.Background())
+	assert.Nil(t, err)
+	assert.EqualValues(t, sequences[1], last)
+}
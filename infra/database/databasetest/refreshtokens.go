@@ -0,0 +1,110 @@
+package databasetest
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+	"userManagement/entities"
+	"userManagement/infra/database"
+)
+
+// RefreshTokenStoreFactory creates an empty refresh token store. It is called once per test case
+type RefreshTokenStoreFactory func(t *testing.T) database.RefreshTokenStoreInterface
+
+type refreshTokenStoreCase struct {
+	name string
+	run  func(t *testing.T, store database.RefreshTokenStoreInterface)
+}
+
+var refreshTokenStoreCases = []refreshTokenStoreCase{
+	{"CreateAndGetRefreshToken", testCreateAndGetRefreshToken},
+	{"GetMissingRefreshToken", testGetMissingRefreshToken},
+	{"UseRefreshTokenOnce", testUseRefreshTokenOnce},
+	{"RevokeRefreshTokenFamily", testRevokeRefreshTokenFamily},
+}
+
+// RunRefreshTokenStoreConformance runs the refresh token store conformance suite against the stores created by the factory
+func RunRefreshTokenStoreConformance(t *testing.T, newStore RefreshTokenStoreFactory) {
+	for _, c := range refreshTokenStoreCases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			c.run(t, newStore(t))
+		})
+	}
+}
+
+func mustCreateRefreshToken(t *testing.T, store database.RefreshTokenStoreInterface, hash, familyID string) *entities.RefreshToken {
+	t.Helper()
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	token := &entities.RefreshToken{
+		Hash:      hash,
+		FamilyID:  familyID,
+		UserID:    "user",
+		CreatedAt: now,
+		ExpiresAt: now.Add(time.Hour),
+	}
+	if err := store.CreateRefreshToken(context.Background(), token); err != nil {
+		t.Fatalf("Could not create refresh token: %v", err)
+	}
+	return token
+}
+
+func testCreateAndGetRefreshToken(t *testing.T, store database.RefreshTokenStoreInterface) {
+	created := mustCreateRefreshToken(t, store, "a", "family")
+
+	found, err := store.GetRefreshToken(context.Background(), "a")
+	assert.Nil(t, err)
+	assert.EqualValues(t, "family", found.FamilyID)
+	assert.EqualValues(t, "user", found.UserID)
+	assert.True(t, created.ExpiresAt.Equal(found.ExpiresAt))
+	assert.True(t, found.UsedAt.IsZero())
+	assert.True(t, found.RevokedAt.IsZero())
+}
+
+func testGetMissingRefreshToken(t *testing.T, store database.RefreshTokenStoreInterface) {
+	_, err := store.GetRefreshToken(context.Background(), "missing")
+	assert.EqualValues(t, entities.NotFoundRefreshToken, err)
+
+	_, err = store.UseRefreshToken(context.Background(), "missing", time.Now())
+	assert.EqualValues(t, entities.NotFoundRefreshToken, err)
+}
+
+func testUseRefreshTokenOnce(t *testing.T, store database.RefreshTokenStoreInterface) {
+	mustCreateRefreshToken(t, store, "a", "family")
+
+	first, err := store.UseRefreshToken(context.Background(), "a", time.Now())
+	assert.Nil(t, err)
+	assert.True(t, first.UsedAt.IsZero())
+
+	second, err := store.UseRefreshToken(context.Background(), "a", time.Now())
+	assert.Nil(t, err)
+	assert.False(t, second.UsedAt.IsZero())
+	assert.EqualValues(t, "family", second.FamilyID)
+}
+
+func testRevokeRefreshTokenFamily(t *testing.T, store database.RefreshTokenStoreInterface) {
+	mustCreateRefreshToken(t, store, "a", "family")
+	mustCreateRefreshToken(t, store, "b", "family")
+	mustCreateRefreshToken(t, store, "c", "other")
+
+	revoked, err := store.RevokeRefreshTokenFamily(context.Background(), "family", time.Now())
+	assert.Nil(t, err)
+	assert.EqualValues(t, 2, revoked)
+
+	revoked, err = store.RevokeRefreshTokenFamily(context.Background(), "family", time.Now())
+	assert.Nil(t, err)
+	assert.EqualValues(t, 0, revoked)
+
+	// Revoked tokens are not marked as used
+	token, err := store.UseRefreshToken(context.Background(), "b", time.Now())
+	assert.Nil(t, err)
+	assert.False(t, token.RevokedAt.IsZero())
+	token, err = store.GetRefreshToken(context.Background(), "b")
+	assert.Nil(t, err)
+	assert.True(t, token.UsedAt.IsZero())
+
+	token, err = store.GetRefreshToken(context.Background(), "c")
+	assert.Nil(t, err)
+	assert.True(t, token.RevokedAt.IsZero())
+}
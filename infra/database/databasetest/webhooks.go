@@ -0,0 +1,217 @@
+package databasetest
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"testing"
+	"time"
+	"userManagement/entities"
+	"userManagement/infra/database"
+	pb "userManagement/proto"
+)
+
+// WebhookStoreFactory creates an empty webhook store. It is called once per test case
+type WebhookStoreFactory func(t *testing.T) database.WebhookStoreInterface
+
+type webhookStoreCase struct {
+	name string
+	run  func(t *testing.T, store database.WebhookStoreInterface)
+}
+
+var webhookStoreCases = []webhookStoreCase{
+	{"CreateAndGetWebhook", testCreateAndGetWebhook},
+	{"GetMissingWebhook", testGetMissingWebhook},
+	{"UpdateWebhook", testUpdateWebhook},
+	{"DeleteWebhookRemovesDeliveries", testDeleteWebhookRemovesDeliveries},
+	{"ListDeliveries", testListDeliveries},
+	{"ListDueDeliveries", testListDueDeliveries},
+	{"DispatchCursor", testDispatchCursor},
+}
+
+// RunWebhookStoreConformance runs the webhook store conformance suite against the stores created by the factory
+func RunWebhookStoreConformance(t *testing.T, newStore WebhookStoreFactory) {
+	for _, c := range webhookStoreCases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			c.run(t, newStore(t))
+		})
+	}
+}
+
+func mustCreateWebhook(t *testing.T, store database.WebhookStoreInterface, url string) *pb.Webhook {
+	t.Helper()
+	webhook, err := store.CreateWebhook(context.Background(), &pb.Webhook{
+		Url:    url,
+		Types:  []pb.UserEventType{pb.UserEventType_USER_CREATED},
+		Secret: "secret",
+	})
+	if err != nil {
+		t.Fatalf("Could not create webhook: %v", err)
+	}
+	return webhook
+}
+
+// mustCreateDeliveries stores a pending delivery to the webhook for every received event id, due at the received time
+func mustCreateDeliveries(t *testing.T, store database.WebhookStoreInterface, webhookID string, due time.Time, eventIDs ...string) []*pb.WebhookDelivery {
+	t.Helper()
+	var deliveries []*pb.WebhookDelivery
+	for _, id := range eventIDs {
+		deliveries = append(deliveries, &pb.WebhookDelivery{
+			WebhookId:     webhookID,
+			Event:         &pb.UserEvent{EventId: id, Type: pb.UserEventType_USER_CREATED},
+			Status:        pb.WebhookDeliveryStatus_PENDING,
+			NextAttemptAt: timestamppb.New(due),
+		})
+	}
+	if err := store.CreateDeliveries(context.Background(), deliveries); err != nil {
+		t.Fatalf("Could not create deliveries: %v", err)
+	}
+	return deliveries
+}
+
+func getDeliveryEventIDs(deliveries []*pb.WebhookDelivery) []string {
+	ids := []string{}
+	for _, delivery := range deliveries {
+		ids = append(ids, delivery.Event.EventId)
+	}
+	return ids
+}
+
+func testCreateAndGetWebhook(t *testing.T, store database.WebhookStoreInterface) {
+	created := mustCreateWebhook(t, store, "http://localhost/a")
+	assert.NotEmpty(t, created.Id)
+	assert.NotNil(t, created.CreatedAt)
+
+	found, err := store.GetWebhook(context.Background(), created.Id)
+	assert.Nil(t, err)
+	assert.EqualValues(t, "http://localhost/a", found.Url)
+	assert.EqualValues(t, []pb.UserEventType{pb.UserEventType_USER_CREATED}, found.Types)
+	assert.EqualValues(t, "secret", found.Secret)
+
+	mustCreateWebhook(t, store, "http://localhost/b")
+	webhooks, err := store.ListWebhooks(context.Background())
+	assert.Nil(t, err)
+	assert.Len(t, webhooks, 2)
+	assert.EqualValues(t, "http://localhost/a", webhooks[0].Url)
+}
+
+func testGetMissingWebhook(t *testing.T, store database.WebhookStoreInterface) {
+	_, err := store.GetWebhook(context.Background(), "000000000000000000000000")
+	assert.EqualValues(t, entities.NotFoundWebhook, err)
+
+	_, err = store.UpdateWebhook(context.Background(), &pb.Webhook{Id: "not-an-id"})
+	assert.EqualValues(t, entities.NotFoundWebhook, err)
+
+	deleted, err := store.DeleteWebhook(context.Background(), "000000000000000000000000")
+	assert.Nil(t, err)
+	assert.False(t, deleted)
+}
+
+func testUpdateWebhook(t *testing.T, store database.WebhookStoreInterface) {
+	created := mustCreateWebhook(t, store, "http://localhost/a")
+
+	created.Url = "https://localhost/b"
+	created.Types = nil
+	created.Disabled = true
+	created.Format = pb.EventFormat_CLOUDEVENTS_BINARY
+	updated, err := store.UpdateWebhook(context.Background(), created)
+	assert.Nil(t, err)
+	assert.EqualValues(t, "https://localhost/b", updated.Url)
+	assert.Empty(t, updated.Types)
+	assert.True(t, updated.Disabled)
+	assert.EqualValues(t, pb.EventFormat_CLOUDEVENTS_BINARY, updated.Format)
+	assert.EqualValues(t, created.CreatedAt.AsTime(), updated.CreatedAt.AsTime())
+
+	found, err := store.GetWebhook(context.Background(), created.Id)
+	assert.Nil(t, err)
+	assert.EqualValues(t, "https://localhost/b", found.Url)
+	assert.EqualValues(t, "secret", found.Secret)
+}
+
+func testDeleteWebhookRemovesDeliveries(t *testing.T, store database.WebhookStoreInterface) {
+	webhook := mustCreateWebhook(t, store, "http://localhost/a")
+	kept := mustCreateWebhook(t, store, "http://localhost/b")
+	mustCreateDeliveries(t, store, webhook.Id, time.Now(), "1", "2")
+	mustCreateDeliveries(t, store, kept.Id, time.Now(), "3")
+
+	deleted, err := store.DeleteWebhook(context.Background(), webhook.Id)
+	assert.Nil(t, err)
+	assert.True(t, deleted)
+
+	deliveries, err := store.ListDeliveries(context.Background(), database.DeliveryQuery{WebhookID: webhook.Id})
+	assert.Nil(t, err)
+	assert.Empty(t, deliveries)
+	deliveries, err = store.ListDeliveries(context.Background(), database.DeliveryQuery{WebhookID: kept.Id})
+	assert.Nil(t, err)
+	assert.Len(t, deliveries, 1)
+}
+
+func testListDeliveries(t *testing.T, store database.WebhookStoreInterface) {
+	webhook := mustCreateWebhook(t, store, "http://localhost/a")
+	deliveries := mustCreateDeliveries(t, store, webhook.Id, time.Now(), "1", "2", "3")
+
+	failed := deliveries[1]
+	failed.Status = pb.WebhookDeliveryStatus_DEAD_LETTER
+	failed.Attempts = 3
+	failed.LastStatusCode = 500
+	failed.LastError = "unexpected response status 500 Internal Server Error"
+	assert.Nil(t, store.UpdateDelivery(context.Background(), failed))
+
+	listed, err := store.ListDeliveries(context.Background(), database.DeliveryQuery{WebhookID: webhook.Id})
+	assert.Nil(t, err)
+	assert.EqualValues(t, []string{"3", "2", "1"}, getDeliveryEventIDs(listed))
+
+	listed, err = store.ListDeliveries(context.Background(), database.DeliveryQuery{WebhookID: webhook.Id, Limit: 1})
+	assert.Nil(t, err)
+	assert.EqualValues(t, []string{"3"}, getDeliveryEventIDs(listed))
+
+	listed, err = store.ListDeliveries(context.Background(), database.DeliveryQuery{
+		WebhookID: webhook.Id,
+		Status:    pb.WebhookDeliveryStatus_DEAD_LETTER,
+	})
+	assert.Nil(t, err)
+	assert.EqualValues(t, []string{"2"}, getDeliveryEventIDs(listed))
+	assert.EqualValues(t, 3, listed[0].Attempts)
+	assert.EqualValues(t, 500, listed[0].LastStatusCode)
+	assert.NotEmpty(t, listed[0].LastError)
+
+	listed, err = store.ListDeliveries(context.Background(), database.DeliveryQuery{
+		WebhookID: webhook.Id,
+		IDs:       []string{deliveries[0].Id, deliveries[2].Id},
+	})
+	assert.Nil(t, err)
+	assert.EqualValues(t, []string{"3", "1"}, getDeliveryEventIDs(listed))
+}
+
+func testListDueDeliveries(t *testing.T, store database.WebhookStoreInterface) {
+	webhook := mustCreateWebhook(t, store, "http://localhost/a")
+	other := mustCreateWebhook(t, store, "http://localhost/b")
+	now := time.Now()
+	mustCreateDeliveries(t, store, webhook.Id, now.Add(-time.Second), "late")
+	mustCreateDeliveries(t, store, webhook.Id, now.Add(-time.Minute), "later")
+	mustCreateDeliveries(t, store, webhook.Id, now.Add(time.Minute), "future")
+	mustCreateDeliveries(t, store, other.Id, now.Add(-time.Minute), "other")
+	succeeded := mustCreateDeliveries(t, store, webhook.Id, now.Add(-time.Minute), "succeeded")[0]
+	succeeded.Status = pb.WebhookDeliveryStatus_SUCCEEDED
+	assert.Nil(t, store.UpdateDelivery(context.Background(), succeeded))
+
+	due, err := store.ListDueDeliveries(context.Background(), now, []string{webhook.Id}, 0)
+	assert.Nil(t, err)
+	assert.EqualValues(t, []string{"later", "late"}, getDeliveryEventIDs(due))
+
+	due, err = store.ListDueDeliveries(context.Background(), now, []string{webhook.Id, other.Id}, 2)
+	assert.Nil(t, err)
+	assert.Len(t, due, 2)
+}
+
+func testDispatchCursor(t *testing.T, store database.WebhookStoreInterface) {
+	cursor, err := store.GetDispatchCursor(context.Background())
+	assert.Nil(t, err)
+	assert.EqualValues(t, 0, cursor)
+
+	assert.Nil(t, store.SetDispatchCursor(context.Background(), 42))
+	cursor, err = store.GetDispatchCursor(context.Background())
+	assert.Nil(t, err)
+	assert.EqualValues(t, 42, cursor)
+}
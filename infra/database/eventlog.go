@@ -0,0 +1,256 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"google.golang.org/protobuf/proto"
+	"time"
+	pb "userManagement/proto"
+)
+
+const (
+	// sequenceID identifies the document of the counters collection holding the last event sequence
+	sequenceID = "user_events"
+	// Error codes returned by mongo when a collection or an index do not exist,
+	// and when an index exists with different options
+	namespaceNotFoundCode    = 26
+	indexNotFoundCode        = 27
+	indexOptionsConflictCode = 85
+)
+
+// DefaultEventRetention is the time events are kept when nothing else is configured
+const DefaultEventRetention = 7 * 24 * time.Hour
+
+// eventDocument is the stored form of an event. The whole event is kept as protobuf payload,
+// along with the fields needed to query it
+type eventDocument struct {
+	Sequence   int64     `bson:"_id"`
+	EventId    string    `bson:"event_id"`
+	Type       string    `bson:"type"`
+	UserId     string    `bson:"user_id"`
+	Timestamp  time.Time `bson:"timestamp"`
+	Payload    []byte    `bson:"payload"`
+	Delivered  bool      `bson:"delivered"`
+	Dispatched bool      `bson:"dispatched"`
+}
+
+// MongoEventLog stores events in a mongo collection, taking their sequence from a counter document.
+// Old events are removed by a TTL index on their timestamp, once they were delivered and dispatched
+type MongoEventLog struct {
+	Collection *mongo.Collection
+	Counters   *mongo.Collection
+	appended   chan struct{}
+}
+
+// NewMongoEventLog creates the event log in the database of the received client, along with its indexes.
+// Events older than the retention are removed, and they are kept forever when it is 0
+func NewMongoEventLog(ctx context.Context, client *MongoClient, collection string, retention time.Duration) (*MongoEventLog, error) {
+	db := client.Collection.Database()
+	eventLog := &MongoEventLog{
+		Collection: db.Collection(collection),
+		Counters:   db.Collection(collection + "_counters"),
+		appended:   make(chan struct{}, 1),
+	}
+	if err := eventLog.ensureRetention(ctx, retention); err != nil {
+		return nil, err
+	}
+
+	_, err := eventLog.Collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "delivered", Value: 1}, {Key: "_id", Value: 1}},
+			Options: options.Index().SetName("delivered"),
+		},
+		{
+			Keys:    bson.D{{Key: "dispatched", Value: 1}, {Key: "_id", Value: 1}},
+			Options: options.Index().SetName("dispatched"),
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not create events indexes: %w", err)
+	}
+	return eventLog, nil
+}
+
+// ensureRetention creates the TTL index removing old delivered and dispatched events, updating it when the retention
+// changed. Other events are left out of the index, so they are kept until the relay publishes them and the webhook
+// dispatcher reads them
+func (m *MongoEventLog) ensureRetention(ctx context.Context, retention time.Duration) error {
+	const indexName = "dispatched_timestamp_ttl"
+	// The indexes of previous versions also removed undelivered or undispatched events
+	for _, name := range []string{"timestamp_ttl", "delivered_timestamp_ttl", indexName} {
+		if name == indexName && retention > 0 {
+			continue
+		}
+		_, err := m.Collection.Indexes().DropOne(ctx, name)
+		if err != nil && !isCommandError(err, namespaceNotFoundCode, indexNotFoundCode) {
+			return fmt.Errorf("could not remove events retention: %w", err)
+		}
+	}
+	if retention <= 0 {
+		return nil
+	}
+
+	seconds := int32(retention.Seconds())
+	_, err := m.Collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "timestamp", Value: 1}},
+		Options: options.Index().SetName(indexName).SetExpireAfterSeconds(seconds).
+			SetPartialFilterExpression(bson.D{{Key: "delivered", Value: true}, {Key: "dispatched", Value: true}}),
+	})
+	if isCommandError(err, indexOptionsConflictCode) {
+		err = m.Collection.Database().RunCommand(ctx, bson.D{
+			{Key: "collMod", Value: m.Collection.Name()},
+			{Key: "index", Value: bson.D{{Key: "name", Value: indexName}, {Key: "expireAfterSeconds", Value: seconds}}},
+		}).Err()
+	}
+	if err != nil {
+		return fmt.Errorf("could not set events retention: %w", err)
+	}
+	return nil
+}
+
+// isCommandError reports whether the error is a mongo command error with any of the received codes
+func isCommandError(err error, codes ...int32) bool {
+	var commandErr mongo.CommandError
+	if !errors.As(err, &commandErr) {
+		return false
+	}
+	for _, code := range codes {
+		if commandErr.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// AppendEvent stores the event with the next sequence of the counter document.
+// When called within a transaction, the counter update makes concurrent transactions conflict, so sequences
+// follow the commit order and aborted transactions leave no gaps
+func (m *MongoEventLog) AppendEvent(ctx context.Context, event *pb.UserEvent) error {
+	if err := m.appendEvent(ctx, event); err != nil {
+		return err
+	}
+	m.signal()
+	return nil
+}
+
+func (m *MongoEventLog) appendEvent(ctx context.Context, event *pb.UserEvent) error {
+	var counter struct {
+		Value int64 `bson:"value"`
+	}
+	err := m.Counters.FindOneAndUpdate(ctx,
+		bson.D{{Key: "_id", Value: sequenceID}},
+		bson.D{{Key: "$inc", Value: bson.D{{Key: "value", Value: int64(1)}}}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&counter)
+	if err != nil {
+		return err
+	}
+
+	event.Sequence = counter.Value
+	payload, err := proto.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = m.Collection.InsertOne(ctx, eventDocument{
+		Sequence:  event.Sequence,
+		EventId:   event.EventId,
+		Type:      event.Type.String(),
+		UserId:    event.UserId,
+		Timestamp: event.Timestamp.AsTime(),
+		Payload:   payload,
+	})
+	return err
+}
+
+// signal wakes up whoever is waiting for appended events, without blocking when nobody is
+func (m *MongoEventLog) signal() {
+	select {
+	case m.appended <- struct{}{}:
+	default:
+	}
+}
+
+// ListEvents returns the stored events matching the query, sorted by sequence
+func (m *MongoEventLog) ListEvents(ctx context.Context, query EventQuery) ([]*pb.UserEvent, error) {
+	filter := bson.D{}
+	switch {
+	case query.After > 0:
+		filter = bson.D{{Key: "_id", Value: bson.D{{Key: "$gt", Value: query.After}}}}
+	case !query.Since.IsZero():
+		filter = bson.D{{Key: "timestamp", Value: bson.D{{Key: "$gte", Value: query.Since}}}}
+	}
+	return m.findEvents(ctx, filter, query.Limit)
+}
+
+// ListUndeliveredEvents returns the oldest events not delivered yet
+func (m *MongoEventLog) ListUndeliveredEvents(ctx context.Context, limit int) ([]*pb.UserEvent, error) {
+	return m.findEvents(ctx, bson.D{{Key: "delivered", Value: false}}, limit)
+}
+
+// MarkEventsDelivered marks the events with the received sequences as delivered
+func (m *MongoEventLog) MarkEventsDelivered(ctx context.Context, sequences []int64) error {
+	if len(sequences) == 0 {
+		return nil
+	}
+	_, err := m.Collection.UpdateMany(ctx,
+		bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: sequences}}}, {Key: "delivered", Value: false}},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "delivered", Value: true}}}})
+	return err
+}
+
+// MarkEventsDispatched marks the events up to the received sequence as dispatched. Events stored by previous
+// versions have no dispatched field, and are marked as well
+func (m *MongoEventLog) MarkEventsDispatched(ctx context.Context, through int64) error {
+	_, err := m.Collection.UpdateMany(ctx,
+		bson.D{{Key: "dispatched", Value: bson.D{{Key: "$ne", Value: true}}}, {Key: "_id", Value: bson.D{{Key: "$lte", Value: through}}}},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "dispatched", Value: true}}}})
+	return err
+}
+
+// LastSequence returns the value of the counter document, which may be the sequence of an event being stored
+func (m *MongoEventLog) LastSequence(ctx context.Context) (int64, error) {
+	var counter struct {
+		Value int64 `bson:"value"`
+	}
+	err := m.Counters.FindOne(ctx, bson.D{{Key: "_id", Value: sequenceID}}).Decode(&counter)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return 0, nil
+	}
+	return counter.Value, err
+}
+
+// Appended receives a value after events are appended by this process
+func (m *MongoEventLog) Appended() <-chan struct{} {
+	return m.appended
+}
+
+// findEvents returns the events matching the filter sorted by sequence, up to the received limit if it is not 0
+func (m *MongoEventLog) findEvents(ctx context.Context, filter bson.D, limit int) ([]*pb.UserEvent, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+	cursor, err := m.Collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var documents []eventDocument
+	if err := cursor.All(ctx, &documents); err != nil {
+		return nil, err
+	}
+
+	events := make([]*pb.UserEvent, 0, len(documents))
+	for _, document := range documents {
+		var event pb.UserEvent
+		if err := proto.Unmarshal(document.Payload, &event); err != nil {
+			return nil, fmt.Errorf("could not decode event %d: %w", document.Sequence, err)
+		}
+		events = append(events, &event)
+	}
+	return events, nil
+}
@@ -0,0 +1,74 @@
+package database
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"userManagement/entities"
+	pb "userManagement/proto"
+)
+
+// EventMetadata describes who performed a change and in which request, it is added to the change event
+type EventMetadata struct {
+	Actor     string
+	RequestID string
+}
+
+type eventMetadataKey struct{}
+
+// WithEventMetadata returns a context whose changes are described by the received metadata in their events
+func WithEventMetadata(ctx context.Context, metadata EventMetadata) context.Context {
+	return context.WithValue(ctx, eventMetadataKey{}, metadata)
+}
+
+// newUserEvent builds the event describing a change of a user from its state before and after it.
+// Creations have no previous state and deletions have no following one. A request id is generated
+// when the context has none
+func newUserEvent(ctx context.Context, eventType pb.UserEventType, before, after *entities.User) *pb.UserEvent {
+	metadata, _ := ctx.Value(eventMetadataKey{}).(EventMetadata)
+	event := &pb.UserEvent{
+		EventId:   newEventID(),
+		Type:      eventType,
+		Timestamp: timestamppb.Now(),
+		Actor:     metadata.Actor,
+		RequestId: metadata.RequestID,
+	}
+	if event.RequestId == "" {
+		event.RequestId = newEventID()
+	}
+
+	for _, snapshot := range []*entities.User{before, after} {
+		if snapshot != nil {
+			event.UserId = snapshot.Id.Hex()
+			event.Email = snapshot.Email
+		}
+	}
+	if before != nil {
+		event.Before = getUserSnapshot(*before)
+	}
+	if after != nil {
+		event.After = getUserSnapshot(*after)
+	}
+	return event
+}
+
+// getUserSnapshot builds the protobuf user of an event, which never includes the password
+func getUserSnapshot(user entities.User) *pb.User {
+	return &pb.User{
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+		Email:     user.Email,
+		Nickname:  user.Nickname,
+		Country:   user.Country,
+	}
+}
+
+// newEventID returns a random version 4 UUID
+func newEventID() string {
+	var id [16]byte
+	_, _ = rand.Read(id[:])
+	id[6] = id[6]&0x0f | 0x40
+	id[8] = id[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:])
+}
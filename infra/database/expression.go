@@ -0,0 +1,170 @@
+package database
+
+import (
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"regexp"
+	"strings"
+	"time"
+	"userManagement/entities"
+	"userManagement/infra/filter"
+)
+
+// filterableUserFields are the fields users can be filtered by in list requests
+var filterableUserFields = map[string]filter.FieldType{
+	firstNameField: filter.String,
+	lastNameField:  filter.String,
+	nicknameField:  filter.String,
+	countryField:   filter.String,
+	"email":        filter.String,
+	"created_at":   filter.Timestamp,
+	"updated_at":   filter.Timestamp,
+}
+
+// parseFilterExpression parses the filter of a list request, returning INVALID_ARGUMENT errors
+// that point at the token which could not be parsed
+func parseFilterExpression(input string) (filter.Expr, error) {
+	expr, err := filter.Parse(input, filterableUserFields)
+	var filterErr *filter.Error
+	if errors.As(err, &filterErr) {
+		return nil, status.Error(codes.InvalidArgument, filterErr.Error())
+	}
+	return expr, err
+}
+
+// normalizeComparison normalizes exact email comparisons, as emails are stored normalized
+func normalizeComparison(comparison filter.Comparison) filter.Comparison {
+	if comparison.Field == "email" && !comparison.Prefix && comparison.Operator != filter.Has {
+		comparison.Value = normalizeEmail(comparison.Value)
+	}
+	return comparison
+}
+
+// getMongoExpressionFilter translates a parsed filter into a mongo filter.
+// Empty strings are not stored, so comparisons with "" also match missing fields
+func getMongoExpressionFilter(expr filter.Expr) bson.D {
+	switch expr := expr.(type) {
+	case filter.And:
+		return bson.D{{Key: "$and", Value: bson.A{getMongoExpressionFilter(expr.Left), getMongoExpressionFilter(expr.Right)}}}
+	case filter.Or:
+		return bson.D{{Key: "$or", Value: bson.A{getMongoExpressionFilter(expr.Left), getMongoExpressionFilter(expr.Right)}}}
+	case filter.Not:
+		return bson.D{{Key: "$nor", Value: bson.A{getMongoExpressionFilter(expr.Expr)}}}
+	case filter.Comparison:
+		return getMongoComparisonFilter(normalizeComparison(expr))
+	}
+	return bson.D{}
+}
+
+func getMongoComparisonFilter(comparison filter.Comparison) bson.D {
+	var value interface{} = comparison.Value
+	if comparison.Type == filter.Timestamp {
+		value = comparison.Time
+	}
+
+	var condition interface{}
+	switch {
+	case comparison.Operator == filter.Has:
+		condition = getMongoRegex(comparison, "i")
+	case comparison.Prefix && comparison.Operator == filter.Equal:
+		condition = getMongoRegex(comparison, "")
+	case comparison.Prefix:
+		condition = bson.D{{Key: "$not", Value: getMongoRegex(comparison, "")}}
+	case value == "" && comparison.Operator == filter.Equal:
+		condition = bson.D{{Key: "$in", Value: bson.A{nil, ""}}}
+	case value == "" && comparison.Operator == filter.NotEqual:
+		condition = bson.D{{Key: "$nin", Value: bson.A{nil, ""}}}
+	case comparison.Operator == filter.Equal:
+		condition = value
+	default:
+		condition = bson.D{{Key: mongoOperators[comparison.Operator], Value: value}}
+	}
+	return bson.D{{Key: comparison.Field, Value: condition}}
+}
+
+var mongoOperators = map[filter.Operator]string{
+	filter.NotEqual:     "$ne",
+	filter.Less:         "$lt",
+	filter.LessEqual:    "$lte",
+	filter.Greater:      "$gt",
+	filter.GreaterEqual: "$gte",
+}
+
+// getMongoRegex builds the regular expression matching the whole value, or its start for prefix comparisons
+func getMongoRegex(comparison filter.Comparison, options string) primitive.Regex {
+	pattern := "^" + regexp.QuoteMeta(comparison.Value)
+	if !comparison.Prefix {
+		pattern += "$"
+	}
+	return primitive.Regex{Pattern: pattern, Options: options}
+}
+
+// matchesExpression evaluates a parsed filter against a user entity as mongo evaluates getMongoExpressionFilter.
+// A nil expression matches every user
+func matchesExpression(user entities.User, expr filter.Expr) bool {
+	switch expr := expr.(type) {
+	case filter.And:
+		return matchesExpression(user, expr.Left) && matchesExpression(user, expr.Right)
+	case filter.Or:
+		return matchesExpression(user, expr.Left) || matchesExpression(user, expr.Right)
+	case filter.Not:
+		return !matchesExpression(user, expr.Expr)
+	case filter.Comparison:
+		return matchesComparison(user, normalizeComparison(expr))
+	}
+	return true
+}
+
+func matchesComparison(user entities.User, comparison filter.Comparison) bool {
+	if comparison.Type == filter.Timestamp {
+		stored, _ := getSortValue(user, comparison.Field).(time.Time)
+		return matchesOrder(compareSortValues(stored, comparison.Time), comparison.Operator)
+	}
+
+	stored, _ := getSortValue(user, comparison.Field).(string)
+	switch comparison.Operator {
+	case filter.Has:
+		// Regular expressions never match missing fields
+		if stored == "" {
+			return false
+		}
+		if comparison.Prefix {
+			return strings.HasPrefix(strings.ToLower(stored), strings.ToLower(comparison.Value))
+		}
+		return strings.EqualFold(stored, comparison.Value)
+	case filter.Equal, filter.NotEqual:
+		matches := stored == comparison.Value
+		if comparison.Prefix {
+			matches = stored != "" && strings.HasPrefix(stored, comparison.Value)
+		}
+		return matches == (comparison.Operator == filter.Equal)
+	}
+
+	// Missing fields never match ordering comparisons in mongo
+	if stored == "" {
+		return false
+	}
+	return matchesOrder(strings.Compare(stored, comparison.Value), comparison.Operator)
+}
+
+// matchesOrder reports whether the result of comparing a stored value with a filter value satisfies the operator
+func matchesOrder(result int, operator filter.Operator) bool {
+	switch operator {
+	case filter.Equal:
+		return result == 0
+	case filter.NotEqual:
+		return result != 0
+	case filter.Less:
+		return result < 0
+	case filter.LessEqual:
+		return result <= 0
+	case filter.Greater:
+		return result > 0
+	case filter.GreaterEqual:
+		return result >= 0
+	}
+	return false
+}
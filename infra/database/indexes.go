@@ -0,0 +1,39 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	// emailCollation compares emails ignoring case, so the same address cannot be registered twice
+	// with a different case. Lookups by email must use it to match the unique email index
+	emailCollation = &options.Collation{Locale: "en", Strength: 2}
+)
+
+// EnsureIndexes creates the indexes needed by the users collection when they do not exist: a unique
+// case-insensitive index on email, and indexes on the fields users can be listed by
+func (m *MongoClient) EnsureIndexes(ctx context.Context) error {
+	indexes := []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "email", Value: 1}},
+			Options: options.Index().
+				SetName("email_unique_ci").
+				SetUnique(true).
+				SetCollation(emailCollation),
+		},
+		{Keys: bson.D{{Key: "country", Value: 1}}, Options: options.Index().SetName("country")},
+		{Keys: bson.D{{Key: "first_name", Value: 1}}, Options: options.Index().SetName("first_name")},
+		{Keys: bson.D{{Key: "last_name", Value: 1}}, Options: options.Index().SetName("last_name")},
+		{Keys: bson.D{{Key: "nickname", Value: 1}}, Options: options.Index().SetName("nickname")},
+	}
+
+	_, err := m.Collection.Indexes().CreateMany(ctx, indexes)
+	if err != nil {
+		return fmt.Errorf("could not create users indexes, check there are no emails registered twice: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,390 @@
+package database
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log"
+	"net/mail"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"userManagement/entities"
+	"userManagement/infra/password"
+	pb "userManagement/proto"
+)
+
+// MemoryClient stores users in memory, mirroring the behaviour of MongoClient.
+// Operations fail with the context error when their context is done before they start.
+// It is meant to be used in tests and local development, since users are lost when the service stops.
+type MemoryClient struct {
+	mu    sync.RWMutex
+	users []entities.User
+	// EventLog receives the event of every change, which is stored while holding the client lock,
+	// so events follow the order of the changes. No events are stored when it is nil
+	EventLog *MemoryEventLog
+}
+
+// NewMemoryClient creates an empty in memory database client
+func NewMemoryClient() *MemoryClient {
+	return &MemoryClient{}
+}
+
+// CreateUser adds a new user to the in memory storage.
+func (m *MemoryClient) CreateUser(ctx context.Context, req *pb.CreateUserReq) (string, error) {
+	user := req.GetUser()
+
+	_, err := mail.ParseAddress(user.Email)
+	if err != nil {
+		return "", entities.InvalidEmailError
+	}
+	if user.Password == "" {
+		return "", entities.EmptyPasswordError
+	}
+
+	passwordHash, err := password.Hash(user.Password, password.DefaultParams)
+	if err != nil {
+		log.Printf("Could not hash password for user with mail %s, %v", user.Email, err)
+		return "", err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, found := m.findUser(getFindUserFilter(user.Email)); found {
+		log.Printf("Could not create user: %v", entities.AlreadyRegisteredEmailError)
+		return "", entities.AlreadyRegisteredEmailError
+	}
+
+	now := memoryNow()
+	memoryUser := entities.User{
+		Id:           primitive.NewObjectID(),
+		FirstName:    user.FirstName,
+		LastName:     user.LastName,
+		Nickname:     user.GetNickname(),
+		PasswordHash: passwordHash,
+		Email:        normalizeEmail(user.Email),
+		Country:      user.Country,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+		Version:      1,
+		Roles:        []string{userRole},
+	}
+	m.users = append(m.users, memoryUser)
+	m.appendEvent(ctx, pb.UserEventType_USER_CREATED, nil, &memoryUser)
+
+	return memoryUser.Id.Hex(), nil
+}
+
+// GetUser retrieves a user from the in memory storage
+func (m *MemoryClient) GetUser(ctx context.Context, req *pb.GetUserReq) (*pb.UserActionResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	i, found := m.findUser(getFindUserFilter(req.UserId))
+	if !found {
+		log.Printf("Could not find user with id %s", req.UserId)
+		return nil, entities.NotFoundUser
+	}
+
+	return getPbUser(m.users[i])
+}
+
+// UpdateUser finds a stored user and updates the fields in the update mask, or every field when it is empty.
+// As in MongoClient, the email cannot be updated and the password is stored hashed
+func (m *MemoryClient) UpdateUser(ctx context.Context, req *pb.UpdateUserReq) (*pb.UserActionResponse, error) {
+	id := req.UserId
+	user := req.GetUser()
+
+	fields, err := getUpdateFields(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var passwordHash *entities.PasswordHash
+	if containsField(fields, passwordField) {
+		passwordHash, err = password.Hash(user.GetPassword(), password.DefaultParams)
+		if err != nil {
+			log.Printf("Could not hash password for user with id %s, %v", id, err)
+			return nil, err
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i, found := m.findUser(getFindUserFilter(id))
+	if !found {
+		log.Printf("Could not update user with id %s", id)
+		return nil, entities.NotFoundUser
+	}
+	if req.ExpectedVersion != 0 && req.ExpectedVersion != m.users[i].Version {
+		return nil, entities.VersionMismatchError
+	}
+
+	before := m.users[i]
+	updatedUser := &m.users[i]
+	for _, field := range fields {
+		value := getUserFieldValue(user, field)
+		switch field {
+		case firstNameField:
+			updatedUser.FirstName = value
+		case lastNameField:
+			updatedUser.LastName = value
+		case nicknameField:
+			updatedUser.Nickname = value
+		case countryField:
+			updatedUser.Country = value
+		}
+	}
+	updatedUser.UpdatedAt = memoryNow()
+	updatedUser.Version++
+	if passwordHash != nil {
+		updatedUser.PasswordHash = passwordHash
+		updatedUser.Password = ""
+	}
+	m.appendEvent(ctx, pb.UserEventType_USER_UPDATED, &before, updatedUser)
+
+	return getPbUser(*updatedUser)
+}
+
+// DeleteUser removes a user from the in memory storage. Deleting a missing user is not an error.
+// When an expected version is received, the user is only removed if its stored version matches it
+func (m *MemoryClient) DeleteUser(ctx context.Context, req *pb.DeleteUserReq) (*pb.DeletionActionResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if i, found := m.findUser(getFindUserFilter(req.UserId)); found {
+		if req.ExpectedVersion != 0 && req.ExpectedVersion != m.users[i].Version {
+			return nil, entities.VersionMismatchError
+		}
+		before := m.users[i]
+		m.users = append(m.users[:i], m.users[i+1:]...)
+		m.appendEvent(ctx, pb.UserEventType_USER_DELETED, &before, nil)
+	}
+
+	return nil, nil
+}
+
+// GetAllUsers returns a page of the stored users that match the filter in the request, sorted as MongoClient does
+func (m *MemoryClient) GetAllUsers(ctx context.Context, req *pb.ListUsersReq) (*pb.ListActionResponse, error) {
+	query, err := getListQuery(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var matches []entities.User
+	for _, user := range m.users {
+		if query.matches(user) {
+			matches = append(matches, user)
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return compareUserToValues(matches[i], query.fields, getSortValues(matches[j], query.fields)) < 0
+	})
+
+	var results []entities.User
+	for _, user := range matches {
+		if query.after != nil && compareUserToValues(user, query.fields, query.after) <= 0 {
+			continue
+		}
+		results = append(results, user)
+		if len(results) > query.pageSize {
+			break
+		}
+	}
+
+	page, err := getPbUserPage(results, query)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.ShowTotalSize {
+		page.TotalSize = int64(len(matches))
+	}
+	return page, nil
+}
+
+// VerifyPassword checks the received password against the one stored for the user found by id or email.
+// Plain text or outdated password hashes are rehashed after a successful verification.
+// Hashing is slow on purpose, so it runs without holding the lock of the store
+func (m *MemoryClient) VerifyPassword(ctx context.Context, req *pb.VerifyPasswordReq) (*pb.VerifyPasswordResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	i, found := m.findUser(getFindUserFilter(req.UserId))
+	var foundUser entities.User
+	if found {
+		foundUser = m.users[i]
+	}
+	m.mu.RUnlock()
+	if !found {
+		log.Printf("Could not verify password, user with id %s not found", req.UserId)
+		password.VerifyMissing(req.Password, password.DefaultParams)
+		return &pb.VerifyPasswordResponse{Valid: false}, nil
+	}
+
+	valid, needsRehash := password.Verify(req.Password, foundUser, password.DefaultParams)
+	if !valid {
+		return &pb.VerifyPasswordResponse{Valid: false}, nil
+	}
+
+	if needsRehash {
+		passwordHash, err := password.Hash(req.Password, password.DefaultParams)
+		if err != nil {
+			return nil, err
+		}
+		m.storeRehashedPassword(foundUser, passwordHash)
+	}
+
+	return &pb.VerifyPasswordResponse{Valid: true, Id: foundUser.Id.Hex()}, nil
+}
+
+// storeRehashedPassword replaces the password of the user with the new hash, unless it changed since it was verified.
+// Updates store new hashes instead of modifying them, so comparing the pointers tells whether it changed
+func (m *MemoryClient) storeRehashedPassword(verified entities.User, passwordHash *entities.PasswordHash) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i, found := m.findUser(bson.D{{Key: "_id", Value: verified.Id}})
+	if !found || m.users[i].PasswordHash != verified.PasswordHash || m.users[i].Password != verified.Password {
+		return
+	}
+	m.users[i].PasswordHash = passwordHash
+	m.users[i].Password = ""
+}
+
+// GrantRole adds a role to a stored user, increasing its version unless it already had the role
+func (m *MemoryClient) GrantRole(ctx context.Context, req *pb.GrantRoleReq) (*pb.UserActionResponse, error) {
+	return m.updateRoles(ctx, req.UserId, func(roles []string) []string {
+		if hasRole(roles, req.Role) {
+			return nil
+		}
+		return append(append([]string{}, roles...), req.Role)
+	})
+}
+
+// RevokeRole removes a role of a stored user, increasing its version unless it did not have the role
+func (m *MemoryClient) RevokeRole(ctx context.Context, req *pb.RevokeRoleReq) (*pb.UserActionResponse, error) {
+	return m.updateRoles(ctx, req.UserId, func(roles []string) []string {
+		if !hasRole(roles, req.Role) {
+			return nil
+		}
+		kept := []string{}
+		for _, role := range roles {
+			if role != req.Role {
+				kept = append(kept, role)
+			}
+		}
+		return kept
+	})
+}
+
+// updateRoles replaces the roles of a stored user with the ones returned by change, which returns nil when the
+// user is left unchanged. As in MongoClient, role changes do not store events
+func (m *MemoryClient) updateRoles(ctx context.Context, id string, change func(roles []string) []string) (*pb.UserActionResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i, found := m.findUser(getFindUserFilter(id))
+	if !found {
+		log.Printf("Could not change roles of user with id %s", id)
+		return nil, entities.NotFoundUser
+	}
+
+	user := &m.users[i]
+	if roles := change(user.Roles); roles != nil {
+		user.Roles = roles
+		user.UpdatedAt = memoryNow()
+		user.Version++
+	}
+	return getPbUser(*user)
+}
+
+// appendEvent stores the event of a change in the event log, if there is one.
+// The caller must hold the client lock
+func (m *MemoryClient) appendEvent(ctx context.Context, eventType pb.UserEventType, before, after *entities.User) {
+	if m.EventLog == nil {
+		return
+	}
+	m.EventLog.appendEvent(newUserEvent(ctx, eventType, before, after))
+	m.EventLog.signal()
+}
+
+// findUser returns the position of the first stored user matching the filter.
+// As mongo lookups with emailCollation, string fields are compared ignoring case.
+// The caller must hold the client lock
+func (m *MemoryClient) findUser(filter bson.D) (int, bool) {
+	for i, user := range m.users {
+		if matchesFilter(user, filter, true) {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+// matchesFilter evaluates an equality filter, as built by getFindUserFilter and getListUsersFilter,
+// against a user entity using the same field names stored in mongo
+func matchesFilter(user entities.User, filter bson.D, ignoreCase bool) bool {
+	if len(filter) == 0 {
+		return true
+	}
+
+	raw, err := bson.Marshal(user)
+	if err != nil {
+		return false
+	}
+	var document bson.M
+	if err := bson.Unmarshal(raw, &document); err != nil {
+		return false
+	}
+
+	for _, e := range filter {
+		value, isString := e.Value.(string)
+		stored, isStoredString := document[e.Key].(string)
+		if ignoreCase && isString && isStoredString {
+			if !strings.EqualFold(value, stored) {
+				return false
+			}
+		} else if document[e.Key] != e.Value {
+			return false
+		}
+	}
+	return true
+}
+
+// memoryNow returns the current time with the precision and location mongo stores dates with,
+// so both clients return the same timestamps
+func memoryNow() time.Time {
+	return time.Now().UTC().Truncate(time.Millisecond)
+}
@@ -0,0 +1,138 @@
+package database
+
+import (
+	"context"
+	"sync"
+	"time"
+	"userManagement/entities"
+)
+
+// MemoryAPIKeyStore keeps API keys in memory, mirroring the behaviour of MongoAPIKeyStore.
+// They are lost when the process stops
+type MemoryAPIKeyStore struct {
+	mu   sync.RWMutex
+	keys []entities.APIKey
+}
+
+// NewMemoryAPIKeyStore creates a store without keys
+func NewMemoryAPIKeyStore() *MemoryAPIKeyStore {
+	return &MemoryAPIKeyStore{}
+}
+
+// CreateAPIKey stores a copy of the key
+func (m *MemoryAPIKeyStore) CreateAPIKey(ctx context.Context, key *entities.APIKey) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.keys = append(m.keys, copyAPIKey(*key))
+	return nil
+}
+
+// GetAPIKey returns a copy of the key with the received id
+func (m *MemoryAPIKeyStore) GetAPIKey(ctx context.Context, id string) (*entities.APIKey, error) {
+	return m.findAPIKey(ctx, func(key entities.APIKey) bool { return key.ID == id })
+}
+
+// FindAPIKey returns a copy of the key with the received hash
+func (m *MemoryAPIKeyStore) FindAPIKey(ctx context.Context, hash string) (*entities.APIKey, error) {
+	return m.findAPIKey(ctx, func(key entities.APIKey) bool { return key.Hash == hash })
+}
+
+// ListAPIKeys returns copies of the keys of the service, or of every key, in creation order
+func (m *MemoryAPIKeyStore) ListAPIKeys(ctx context.Context, service string) ([]*entities.APIKey, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	keys := []*entities.APIKey{}
+	for _, key := range m.keys {
+		if service == "" || key.Service == service {
+			found := copyAPIKey(key)
+			keys = append(keys, &found)
+		}
+	}
+	return keys, nil
+}
+
+// RotateAPIKey replaces the hash of a key which was not revoked
+func (m *MemoryAPIKeyStore) RotateAPIKey(ctx context.Context, id, hash string, now time.Time) (*entities.APIKey, error) {
+	return m.updateAPIKey(ctx, id, func(key *entities.APIKey) error {
+		if !key.RevokedAt.IsZero() {
+			return entities.RevokedAPIKeyError
+		}
+		key.Hash = hash
+		key.RotatedAt = now.UTC().Truncate(time.Millisecond)
+		return nil
+	})
+}
+
+// RevokeAPIKey marks a key as revoked, unless it was revoked before
+func (m *MemoryAPIKeyStore) RevokeAPIKey(ctx context.Context, id string, now time.Time) (*entities.APIKey, error) {
+	return m.updateAPIKey(ctx, id, func(key *entities.APIKey) error {
+		if key.RevokedAt.IsZero() {
+			key.RevokedAt = now.UTC().Truncate(time.Millisecond)
+		}
+		return nil
+	})
+}
+
+// TouchAPIKey stores when the key was last used
+func (m *MemoryAPIKeyStore) TouchAPIKey(ctx context.Context, id string, now time.Time) error {
+	_, err := m.updateAPIKey(ctx, id, func(key *entities.APIKey) error {
+		key.LastUsedAt = now.UTC().Truncate(time.Millisecond)
+		return nil
+	})
+	return err
+}
+
+// findAPIKey returns a copy of the first key matching
+func (m *MemoryAPIKeyStore) findAPIKey(ctx context.Context, matches func(key entities.APIKey) bool) (*entities.APIKey, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, key := range m.keys {
+		if matches(key) {
+			found := copyAPIKey(key)
+			return &found, nil
+		}
+	}
+	return nil, entities.NotFoundAPIKey
+}
+
+// updateAPIKey applies the update to the key with the received id, returning a copy of it after the update.
+// The key is left unchanged when the update fails
+func (m *MemoryAPIKeyStore) updateAPIKey(ctx context.Context, id string, update func(key *entities.APIKey) error) (*entities.APIKey, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.keys {
+		if m.keys[i].ID != id {
+			continue
+		}
+		updated := copyAPIKey(m.keys[i])
+		if err := update(&updated); err != nil {
+			return nil, err
+		}
+		m.keys[i] = updated
+		result := copyAPIKey(updated)
+		return &result, nil
+	}
+	return nil, entities.NotFoundAPIKey
+}
+
+// copyAPIKey copies a key along with its scopes, so stored keys are not modified through the returned ones
+func copyAPIKey(key entities.APIKey) entities.APIKey {
+	key.Scopes = append([]string{}, key.Scopes...)
+	return key
+}
@@ -0,0 +1,168 @@
+package database
+
+import (
+	"context"
+	"google.golang.org/protobuf/proto"
+	"sync"
+	"time"
+	pb "userManagement/proto"
+)
+
+// MemoryEventLog keeps events in memory, removing the delivered and dispatched ones older than its retention as new
+// ones are stored.
+// Events are lost when the process stops
+type MemoryEventLog struct {
+	mu        sync.RWMutex
+	events    []memoryEvent
+	sequence  int64
+	retention time.Duration
+	appended  chan struct{}
+}
+
+type memoryEvent struct {
+	event      *pb.UserEvent
+	delivered  bool
+	dispatched bool
+}
+
+// NewMemoryEventLog creates an empty event log. Events are kept forever when the retention is 0
+func NewMemoryEventLog(retention time.Duration) *MemoryEventLog {
+	return &MemoryEventLog{retention: retention, appended: make(chan struct{}, 1)}
+}
+
+// AppendEvent stores a copy of the event with the next sequence
+func (m *MemoryEventLog) AppendEvent(ctx context.Context, event *pb.UserEvent) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.appendEvent(event)
+	m.signal()
+	return nil
+}
+
+// appendEvent stores a copy of the event with the next sequence. It is used by MemoryClient while it holds
+// its lock, so events are stored in the same order the changes are done
+func (m *MemoryEventLog) appendEvent(event *pb.UserEvent) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sequence++
+	event.Sequence = m.sequence
+	m.events = append(m.events, memoryEvent{event: proto.Clone(event).(*pb.UserEvent)})
+
+	// Removal stops at the first event the relay did not publish or the webhook dispatcher did not read yet
+	if m.retention > 0 {
+		oldest := time.Now().Add(-m.retention)
+		kept := 0
+		for kept < len(m.events) && m.events[kept].delivered && m.events[kept].dispatched &&
+			m.events[kept].event.Timestamp.AsTime().Before(oldest) {
+			kept++
+		}
+		m.events = m.events[kept:]
+	}
+}
+
+// signal wakes up whoever is waiting for appended events, without blocking when nobody is
+func (m *MemoryEventLog) signal() {
+	select {
+	case m.appended <- struct{}{}:
+	default:
+	}
+}
+
+// ListEvents returns copies of the stored events matching the query, sorted by sequence
+func (m *MemoryEventLog) ListEvents(ctx context.Context, query EventQuery) ([]*pb.UserEvent, error) {
+	return m.listEvents(ctx, query.Limit, func(stored memoryEvent) bool {
+		switch {
+		case query.After > 0:
+			return stored.event.Sequence > query.After
+		case !query.Since.IsZero():
+			return !stored.event.Timestamp.AsTime().Before(query.Since)
+		}
+		return true
+	})
+}
+
+// ListUndeliveredEvents returns copies of the oldest events not delivered yet
+func (m *MemoryEventLog) ListUndeliveredEvents(ctx context.Context, limit int) ([]*pb.UserEvent, error) {
+	return m.listEvents(ctx, limit, func(stored memoryEvent) bool {
+		return !stored.delivered
+	})
+}
+
+func (m *MemoryEventLog) listEvents(ctx context.Context, limit int, matches func(memoryEvent) bool) ([]*pb.UserEvent, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	events := []*pb.UserEvent{}
+	for _, stored := range m.events {
+		if !matches(stored) {
+			continue
+		}
+		events = append(events, proto.Clone(stored.event).(*pb.UserEvent))
+		if limit > 0 && len(events) == limit {
+			break
+		}
+	}
+	return events, nil
+}
+
+// MarkEventsDelivered marks the events with the received sequences as delivered
+func (m *MemoryEventLog) MarkEventsDelivered(ctx context.Context, sequences []int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	delivered := map[int64]bool{}
+	for _, sequence := range sequences {
+		delivered[sequence] = true
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.events {
+		if delivered[m.events[i].event.Sequence] {
+			m.events[i].delivered = true
+		}
+	}
+	return nil
+}
+
+// MarkEventsDispatched marks the events up to the received sequence as dispatched
+func (m *MemoryEventLog) MarkEventsDispatched(ctx context.Context, through int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.events {
+		if m.events[i].event.Sequence > through {
+			break
+		}
+		m.events[i].dispatched = true
+	}
+	return nil
+}
+
+// LastSequence returns the sequence given to the last appended event
+func (m *MemoryEventLog) LastSequence(ctx context.Context) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.sequence, nil
+}
+
+// Appended receives a value after events are appended
+func (m *MemoryEventLog) Appended() <-chan struct{} {
+	return m.appended
+}
@@ -0,0 +1,92 @@
+package database
+
+import (
+	"context"
+	"sync"
+	"time"
+	"userManagement/entities"
+)
+
+// MemoryRefreshTokenStore keeps refresh tokens in memory, mirroring the behaviour of MongoRefreshTokenStore.
+// They are lost when the process stops
+type MemoryRefreshTokenStore struct {
+	mu     sync.Mutex
+	tokens map[string]entities.RefreshToken
+}
+
+// NewMemoryRefreshTokenStore creates a store without tokens
+func NewMemoryRefreshTokenStore() *MemoryRefreshTokenStore {
+	return &MemoryRefreshTokenStore{tokens: map[string]entities.RefreshToken{}}
+}
+
+// CreateRefreshToken stores a copy of the token, removing the expired ones as the mongo TTL index does
+func (m *MemoryRefreshTokenStore) CreateRefreshToken(ctx context.Context, token *entities.RefreshToken) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for hash, stored := range m.tokens {
+		if stored.ExpiresAt.Before(now) {
+			delete(m.tokens, hash)
+		}
+	}
+	m.tokens[token.Hash] = *token
+	return nil
+}
+
+// GetRefreshToken returns a copy of the token with the received hash
+func (m *MemoryRefreshTokenStore) GetRefreshToken(ctx context.Context, hash string) (*entities.RefreshToken, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	token, found := m.tokens[hash]
+	if !found {
+		return nil, entities.NotFoundRefreshToken
+	}
+	return &token, nil
+}
+
+// UseRefreshToken marks the token as used unless it was used or revoked before
+func (m *MemoryRefreshTokenStore) UseRefreshToken(ctx context.Context, hash string, now time.Time) (*entities.RefreshToken, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	token, found := m.tokens[hash]
+	if !found {
+		return nil, entities.NotFoundRefreshToken
+	}
+	if token.UsedAt.IsZero() && token.RevokedAt.IsZero() {
+		used := token
+		used.UsedAt = now.UTC().Truncate(time.Millisecond)
+		m.tokens[hash] = used
+	}
+	return &token, nil
+}
+
+// RevokeRefreshTokenFamily marks the tokens of the family as revoked
+func (m *MemoryRefreshTokenStore) RevokeRefreshTokenFamily(ctx context.Context, familyID string, now time.Time) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	revoked := 0
+	for hash, token := range m.tokens {
+		if token.FamilyID == familyID && token.RevokedAt.IsZero() {
+			token.RevokedAt = now.UTC().Truncate(time.Millisecond)
+			m.tokens[hash] = token
+			revoked++
+		}
+	}
+	return revoked, nil
+}
@@ -0,0 +1,248 @@
+package database
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"sort"
+	"sync"
+	"time"
+	"userManagement/entities"
+	pb "userManagement/proto"
+)
+
+// MemoryWebhookStore keeps webhooks and their deliveries in memory, mirroring the behaviour of MongoWebhookStore.
+// They are lost when the process stops
+type MemoryWebhookStore struct {
+	mu         sync.RWMutex
+	webhooks   []*pb.Webhook
+	deliveries []*pb.WebhookDelivery
+	cursor     int64
+}
+
+// NewMemoryWebhookStore creates a store without webhooks
+func NewMemoryWebhookStore() *MemoryWebhookStore {
+	return &MemoryWebhookStore{}
+}
+
+// CreateWebhook stores a copy of the webhook with a new id
+func (m *MemoryWebhookStore) CreateWebhook(ctx context.Context, webhook *pb.Webhook) (*pb.Webhook, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	created := proto.Clone(webhook).(*pb.Webhook)
+	created.Id = primitive.NewObjectID().Hex()
+	created.CreatedAt = timestamppb.New(memoryNow())
+	created.UpdatedAt = created.CreatedAt
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.webhooks = append(m.webhooks, created)
+	return proto.Clone(created).(*pb.Webhook), nil
+}
+
+// GetWebhook returns a copy of the webhook with the received id
+func (m *MemoryWebhookStore) GetWebhook(ctx context.Context, id string) (*pb.Webhook, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	index := m.findWebhook(id)
+	if index < 0 {
+		return nil, entities.NotFoundWebhook
+	}
+	return proto.Clone(m.webhooks[index]).(*pb.Webhook), nil
+}
+
+// ListWebhooks returns copies of every webhook, in creation order
+func (m *MemoryWebhookStore) ListWebhooks(ctx context.Context) ([]*pb.Webhook, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	webhooks := make([]*pb.Webhook, 0, len(m.webhooks))
+	for _, webhook := range m.webhooks {
+		webhooks = append(webhooks, proto.Clone(webhook).(*pb.Webhook))
+	}
+	return webhooks, nil
+}
+
+// UpdateWebhook replaces the webhook with the same id, keeping its creation time
+func (m *MemoryWebhookStore) UpdateWebhook(ctx context.Context, webhook *pb.Webhook) (*pb.Webhook, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	index := m.findWebhook(webhook.Id)
+	if index < 0 {
+		return nil, entities.NotFoundWebhook
+	}
+
+	updated := proto.Clone(webhook).(*pb.Webhook)
+	updated.CreatedAt = m.webhooks[index].CreatedAt
+	updated.UpdatedAt = timestamppb.New(memoryNow())
+	m.webhooks[index] = updated
+	return proto.Clone(updated).(*pb.Webhook), nil
+}
+
+// DeleteWebhook removes the webhook with the received id and its deliveries
+func (m *MemoryWebhookStore) DeleteWebhook(ctx context.Context, id string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	index := m.findWebhook(id)
+	if index < 0 {
+		return false, nil
+	}
+	m.webhooks = append(m.webhooks[:index], m.webhooks[index+1:]...)
+
+	kept := m.deliveries[:0]
+	for _, delivery := range m.deliveries {
+		if delivery.WebhookId != id {
+			kept = append(kept, delivery)
+		}
+	}
+	m.deliveries = kept
+	return true, nil
+}
+
+// CreateDeliveries stores copies of the deliveries with new ids
+func (m *MemoryWebhookStore) CreateDeliveries(ctx context.Context, deliveries []*pb.WebhookDelivery) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := timestamppb.New(memoryNow())
+	for _, delivery := range deliveries {
+		delivery.Id = primitive.NewObjectID().Hex()
+		delivery.CreatedAt = now
+		delivery.UpdatedAt = now
+		m.deliveries = append(m.deliveries, proto.Clone(delivery).(*pb.WebhookDelivery))
+	}
+	return nil
+}
+
+// UpdateDelivery replaces the delivery with the same id, keeping its creation time.
+// Deliveries of deleted webhooks are not stored again
+func (m *MemoryWebhookStore) UpdateDelivery(ctx context.Context, delivery *pb.WebhookDelivery) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, stored := range m.deliveries {
+		if stored.Id == delivery.Id {
+			delivery.CreatedAt = stored.CreatedAt
+			delivery.UpdatedAt = timestamppb.New(memoryNow())
+			m.deliveries[i] = proto.Clone(delivery).(*pb.WebhookDelivery)
+			return nil
+		}
+	}
+	return nil
+}
+
+// ListDeliveries returns copies of the deliveries matching the query, newest first
+func (m *MemoryWebhookStore) ListDeliveries(ctx context.Context, query DeliveryQuery) ([]*pb.WebhookDelivery, error) {
+	ids := map[string]bool{}
+	for _, id := range query.IDs {
+		ids[id] = true
+	}
+
+	return m.listDeliveries(ctx, query.Limit, false, func(delivery *pb.WebhookDelivery) bool {
+		return delivery.WebhookId == query.WebhookID &&
+			(query.Status == pb.WebhookDeliveryStatus_WEBHOOK_DELIVERY_STATUS_UNSPECIFIED || delivery.Status == query.Status) &&
+			(len(ids) == 0 || ids[delivery.Id])
+	})
+}
+
+// ListDueDeliveries returns copies of the pending deliveries of the received webhooks whose next attempt
+// is due, the most overdue first
+func (m *MemoryWebhookStore) ListDueDeliveries(ctx context.Context, now time.Time, webhookIDs []string, limit int) ([]*pb.WebhookDelivery, error) {
+	webhooks := map[string]bool{}
+	for _, id := range webhookIDs {
+		webhooks[id] = true
+	}
+
+	return m.listDeliveries(ctx, limit, true, func(delivery *pb.WebhookDelivery) bool {
+		return webhooks[delivery.WebhookId] &&
+			delivery.Status == pb.WebhookDeliveryStatus_PENDING &&
+			!delivery.NextAttemptAt.AsTime().After(now)
+	})
+}
+
+func (m *MemoryWebhookStore) listDeliveries(ctx context.Context, limit int, byNextAttempt bool, matches func(*pb.WebhookDelivery) bool) ([]*pb.WebhookDelivery, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	deliveries := []*pb.WebhookDelivery{}
+	for _, delivery := range m.deliveries {
+		if matches(delivery) {
+			deliveries = append(deliveries, proto.Clone(delivery).(*pb.WebhookDelivery))
+		}
+	}
+
+	// Deliveries are kept in creation order, which breaks the ties between equal next attempts
+	if byNextAttempt {
+		sort.SliceStable(deliveries, func(i, j int) bool {
+			return deliveries[i].NextAttemptAt.AsTime().Before(deliveries[j].NextAttemptAt.AsTime())
+		})
+	} else {
+		for i, j := 0, len(deliveries)-1; i < j; i, j = i+1, j-1 {
+			deliveries[i], deliveries[j] = deliveries[j], deliveries[i]
+		}
+	}
+	if limit > 0 && len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+	}
+	return deliveries, nil
+}
+
+// GetDispatchCursor returns the sequence of the last event whose deliveries were created
+func (m *MemoryWebhookStore) GetDispatchCursor(ctx context.Context) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.cursor, nil
+}
+
+// SetDispatchCursor stores the sequence of the last event whose deliveries were created
+func (m *MemoryWebhookStore) SetDispatchCursor(ctx context.Context, sequence int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cursor = sequence
+	return nil
+}
+
+// findWebhook returns the index of the webhook with the received id, or -1 when there is none
+func (m *MemoryWebhookStore) findWebhook(id string) int {
+	for i, webhook := range m.webhooks {
+		if webhook.Id == id {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,385 @@
+package database
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/protobuf/proto"
+	"strings"
+	"time"
+	"userManagement/entities"
+	"userManagement/infra/filter"
+	pb "userManagement/proto"
+)
+
+const (
+	// DefaultPageSize is the number of users listed when the request sets no page size
+	DefaultPageSize = 50
+	// MaxPageSize caps the number of users listed in a single page
+	MaxPageSize = 1000
+
+	idField = "_id"
+)
+
+var (
+	// sortableUserFields are the fields users can be ordered by, which are true for dates
+	sortableUserFields = map[string]bool{
+		firstNameField: false,
+		lastNameField:  false,
+		nicknameField:  false,
+		countryField:   false,
+		"email":        false,
+		"created_at":   true,
+		"updated_at":   true,
+	}
+)
+
+// sortField is a field users are ordered by
+type sortField struct {
+	Name string
+	Desc bool
+}
+
+// pageToken is encoded in the opaque page tokens, it holds the sort values of the last user returned
+// and a hash of the request it was issued for, so it cannot be used with a different filter or order
+type pageToken struct {
+	Query  string        `json:"q"`
+	Values []interface{} `json:"v"`
+}
+
+// getPageSize returns the number of users to list in a page, applying the default and maximum sizes
+func getPageSize(pageSize int32) (int, error) {
+	switch {
+	case pageSize < 0:
+		return 0, entities.InvalidPageSizeError
+	case pageSize == 0:
+		return DefaultPageSize, nil
+	case pageSize > MaxPageSize:
+		return MaxPageSize, nil
+	}
+	return int(pageSize), nil
+}
+
+// parseOrderBy parses a comma separated list of fields, each one optionally followed by "asc" or "desc".
+// The id is always added as last field, so users with the same values keep a stable order between pages
+func parseOrderBy(orderBy string) ([]sortField, error) {
+	var fields []sortField
+	if strings.TrimSpace(orderBy) == "" {
+		return []sortField{{Name: idField}}, nil
+	}
+
+	seen := map[string]bool{}
+	for _, part := range strings.Split(orderBy, ",") {
+		words := strings.Fields(part)
+		if len(words) == 0 || len(words) > 2 {
+			return nil, entities.InvalidOrderByError(strings.TrimSpace(part))
+		}
+
+		field := sortField{Name: words[0]}
+		if _, sortable := sortableUserFields[field.Name]; !sortable || seen[field.Name] {
+			return nil, entities.InvalidOrderByError(strings.TrimSpace(part))
+		}
+		if len(words) == 2 {
+			switch strings.ToLower(words[1]) {
+			case "asc":
+			case "desc":
+				field.Desc = true
+			default:
+				return nil, entities.InvalidOrderByError(strings.TrimSpace(part))
+			}
+		}
+
+		seen[field.Name] = true
+		fields = append(fields, field)
+	}
+	return append(fields, sortField{Name: idField}), nil
+}
+
+// getQueryHash identifies the filter and order of a list request, to validate the page tokens received
+func getQueryHash(req *pb.ListUsersReq) (string, error) {
+	userFilter, err := proto.MarshalOptions{Deterministic: true}.Marshal(req.GetUserFilter())
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+	hash.Write(userFilter)
+	hash.Write([]byte{0})
+	hash.Write([]byte(req.GetFilter()))
+	hash.Write([]byte{0})
+	hash.Write([]byte(req.GetOrderBy()))
+	return hex.EncodeToString(hash.Sum(nil)[:8]), nil
+}
+
+// getSortValue returns the value of the received field a user is sorted by.
+// Empty strings are returned as nil, since they are not stored in mongo
+func getSortValue(user entities.User, field string) interface{} {
+	var value string
+	switch field {
+	case idField:
+		return user.Id
+	case "created_at":
+		return user.CreatedAt
+	case "updated_at":
+		return user.UpdatedAt
+	case "email":
+		value = user.Email
+	case firstNameField:
+		value = user.FirstName
+	case lastNameField:
+		value = user.LastName
+	case nicknameField:
+		value = user.Nickname
+	case countryField:
+		value = user.Country
+	}
+
+	if value == "" {
+		return nil
+	}
+	return value
+}
+
+// encodePageToken builds the token to retrieve the users after the received one
+func encodePageToken(user entities.User, fields []sortField, queryHash string) (string, error) {
+	token := pageToken{Query: queryHash}
+	for _, field := range fields {
+		switch value := getSortValue(user, field.Name).(type) {
+		case primitive.ObjectID:
+			token.Values = append(token.Values, value.Hex())
+		case time.Time:
+			token.Values = append(token.Values, value.Format(time.RFC3339Nano))
+		default:
+			token.Values = append(token.Values, value)
+		}
+	}
+
+	encoded, err := json.Marshal(token)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(encoded), nil
+}
+
+// decodePageToken returns the sort values of the last user of the previous page.
+// No values are returned for an empty token
+func decodePageToken(encoded string, fields []sortField, queryHash string) ([]interface{}, error) {
+	if encoded == "" {
+		return nil, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, entities.InvalidPageTokenError
+	}
+	var token pageToken
+	if err := json.Unmarshal(raw, &token); err != nil || token.Query != queryHash || len(token.Values) != len(fields) {
+		return nil, entities.InvalidPageTokenError
+	}
+
+	values := make([]interface{}, len(fields))
+	for i, field := range fields {
+		if token.Values[i] == nil {
+			// Ids and dates are always set
+			if field.Name == idField || sortableUserFields[field.Name] {
+				return nil, entities.InvalidPageTokenError
+			}
+			continue
+		}
+		value, isString := token.Values[i].(string)
+		if !isString {
+			return nil, entities.InvalidPageTokenError
+		}
+
+		switch {
+		case field.Name == idField:
+			values[i], err = primitive.ObjectIDFromHex(value)
+		case sortableUserFields[field.Name]:
+			values[i], err = time.Parse(time.RFC3339Nano, value)
+		default:
+			values[i] = value
+		}
+		if err != nil {
+			return nil, entities.InvalidPageTokenError
+		}
+	}
+	return values, nil
+}
+
+// getMongoFilter builds the mongo filter matching both the equality and the expression filters of the query
+func (q *listQuery) getMongoFilter() bson.D {
+	if q.expr == nil {
+		return q.filter
+	}
+	return bson.D{{Key: "$and", Value: bson.A{q.filter, getMongoExpressionFilter(q.expr)}}}
+}
+
+// matches reports whether a user matches both the equality and the expression filters of the query
+func (q *listQuery) matches(user entities.User) bool {
+	return matchesFilter(user, q.filter, false) && matchesExpression(user, q.expr)
+}
+
+// getSortSpec builds the mongo sort document for the received fields
+func getSortSpec(fields []sortField) bson.D {
+	sortSpec := bson.D{}
+	for _, field := range fields {
+		direction := 1
+		if field.Desc {
+			direction = -1
+		}
+		sortSpec = append(sortSpec, primitive.E{Key: field.Name, Value: direction})
+	}
+	return sortSpec
+}
+
+// getCursorFilter builds the mongo filter matching the users sorted after the received sort values.
+// Missing fields sort before any value, as mongo does with null values
+func getCursorFilter(fields []sortField, values []interface{}) bson.D {
+	var clauses bson.A
+	for i, field := range fields {
+		clause := bson.D{}
+		for j := 0; j < i; j++ {
+			clause = append(clause, primitive.E{Key: fields[j].Name, Value: values[j]})
+		}
+
+		switch {
+		case !field.Desc && values[i] == nil:
+			clause = append(clause, primitive.E{Key: field.Name, Value: bson.D{{Key: "$ne", Value: nil}}})
+		case !field.Desc:
+			clause = append(clause, primitive.E{Key: field.Name, Value: bson.D{{Key: "$gt", Value: values[i]}}})
+		case values[i] == nil:
+			// Nothing sorts after a missing field in descending order
+			continue
+		default:
+			clause = append(clause, primitive.E{Key: "$or", Value: bson.A{
+				bson.D{{Key: field.Name, Value: bson.D{{Key: "$lt", Value: values[i]}}}},
+				bson.D{{Key: field.Name, Value: nil}},
+			}})
+		}
+		clauses = append(clauses, clause)
+	}
+	return bson.D{{Key: "$or", Value: clauses}}
+}
+
+// compareSortValues compares two values of the same field as mongo sorts them
+func compareSortValues(a, b interface{}) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+
+	switch a := a.(type) {
+	case primitive.ObjectID:
+		b := b.(primitive.ObjectID)
+		return bytes.Compare(a[:], b[:])
+	case time.Time:
+		b := b.(time.Time)
+		switch {
+		case a.Before(b):
+			return -1
+		case a.After(b):
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(a.(string), b.(string))
+}
+
+// compareUserToValues compares a user with the received sort values, following the order of the received fields
+func compareUserToValues(user entities.User, fields []sortField, values []interface{}) int {
+	for i, field := range fields {
+		result := compareSortValues(getSortValue(user, field.Name), values[i])
+		if field.Desc {
+			result = -result
+		}
+		if result != 0 {
+			return result
+		}
+	}
+	return 0
+}
+
+// getSortValues returns the values of every sort field of a user
+func getSortValues(user entities.User, fields []sortField) []interface{} {
+	values := make([]interface{}, len(fields))
+	for i, field := range fields {
+		values[i] = getSortValue(user, field.Name)
+	}
+	return values
+}
+
+// listQuery holds the parsed filters and pagination parameters of a list request
+type listQuery struct {
+	filter    bson.D
+	expr      filter.Expr
+	fields    []sortField
+	pageSize  int
+	after     []interface{}
+	queryHash string
+}
+
+// getListQuery validates and parses the filter, order and pagination parameters of a list request
+func getListQuery(req *pb.ListUsersReq) (*listQuery, error) {
+	userFilter, err := getListUsersFilter(req.GetUserFilter())
+	if err != nil {
+		return nil, err
+	}
+	expr, err := parseFilterExpression(req.GetFilter())
+	if err != nil {
+		return nil, err
+	}
+	fields, err := parseOrderBy(req.GetOrderBy())
+	if err != nil {
+		return nil, err
+	}
+	pageSize, err := getPageSize(req.GetPageSize())
+	if err != nil {
+		return nil, err
+	}
+	queryHash, err := getQueryHash(req)
+	if err != nil {
+		return nil, err
+	}
+	after, err := decodePageToken(req.GetPageToken(), fields, queryHash)
+	if err != nil {
+		return nil, err
+	}
+
+	return &listQuery{
+		filter:    userFilter,
+		expr:      expr,
+		fields:    fields,
+		pageSize:  pageSize,
+		after:     after,
+		queryHash: queryHash,
+	}, nil
+}
+
+// getPbUserPage builds the list response from the users found, which may include one user more than
+// the page size to know whether there is a next page
+func getPbUserPage(results []entities.User, query *listQuery) (*pb.ListActionResponse, error) {
+	var nextPageToken string
+	if len(results) > query.pageSize {
+		results = results[:query.pageSize]
+		var err error
+		nextPageToken, err = encodePageToken(results[len(results)-1], query.fields, query.queryHash)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	page, err := getPbUserList(results)
+	if err != nil {
+		return nil, err
+	}
+	page.NextPageToken = nextPageToken
+	return page, nil
+}
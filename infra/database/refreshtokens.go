@@ -0,0 +1,93 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+	"userManagement/entities"
+)
+
+// MongoRefreshTokenStore stores refresh tokens in a mongo collection. Expired tokens are removed by a TTL index
+type MongoRefreshTokenStore struct {
+	Collection *mongo.Collection
+}
+
+// NewMongoRefreshTokenStore creates the refresh token store in the database of the received client,
+// along with its indexes
+func NewMongoRefreshTokenStore(ctx context.Context, client *MongoClient, collection string) (*MongoRefreshTokenStore, error) {
+	store := &MongoRefreshTokenStore{Collection: client.Collection.Database().Collection(collection)}
+
+	_, err := store.Collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "family_id", Value: 1}},
+			Options: options.Index().SetName("family"),
+		},
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetName("expires_at_ttl").SetExpireAfterSeconds(0),
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not create refresh tokens indexes: %w", err)
+	}
+	return store, nil
+}
+
+// CreateRefreshToken stores the token
+func (m *MongoRefreshTokenStore) CreateRefreshToken(ctx context.Context, token *entities.RefreshToken) error {
+	_, err := m.Collection.InsertOne(ctx, token)
+	return err
+}
+
+// GetRefreshToken returns the token with the received hash
+func (m *MongoRefreshTokenStore) GetRefreshToken(ctx context.Context, hash string) (*entities.RefreshToken, error) {
+	var token entities.RefreshToken
+	err := m.Collection.FindOne(ctx, bson.D{{Key: "_id", Value: hash}}).Decode(&token)
+	if err == mongo.ErrNoDocuments {
+		return nil, entities.NotFoundRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// UseRefreshToken marks the token as used unless it was used or revoked before. Concurrent uses of the same
+// token are decided by mongo, only one of them finds it unused
+func (m *MongoRefreshTokenStore) UseRefreshToken(ctx context.Context, hash string, now time.Time) (*entities.RefreshToken, error) {
+	var token entities.RefreshToken
+	err := m.Collection.FindOneAndUpdate(ctx,
+		bson.D{
+			{Key: "_id", Value: hash},
+			{Key: "used_at", Value: bson.D{{Key: "$exists", Value: false}}},
+			{Key: "revoked_at", Value: bson.D{{Key: "$exists", Value: false}}},
+		},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "used_at", Value: now.UTC().Truncate(time.Millisecond)}}}},
+		options.FindOneAndUpdate().SetReturnDocument(options.Before),
+	).Decode(&token)
+	// Tokens which are not found unused are returned as they are, if they exist
+	if err == mongo.ErrNoDocuments {
+		return m.GetRefreshToken(ctx, hash)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// RevokeRefreshTokenFamily marks the tokens of the family as revoked
+func (m *MongoRefreshTokenStore) RevokeRefreshTokenFamily(ctx context.Context, familyID string, now time.Time) (int, error) {
+	result, err := m.Collection.UpdateMany(ctx,
+		bson.D{
+			{Key: "family_id", Value: familyID},
+			{Key: "revoked_at", Value: bson.D{{Key: "$exists", Value: false}}},
+		},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "revoked_at", Value: now.UTC().Truncate(time.Millisecond)}}}})
+	if err != nil {
+		return 0, err
+	}
+	return int(result.ModifiedCount), nil
+}
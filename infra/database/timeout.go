@@ -0,0 +1,124 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/mongo"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"time"
+	pb "userManagement/proto"
+)
+
+// Timeouts are the default time limits applied to every kind of database operation
+// when the caller did not set a deadline
+type Timeouts struct {
+	Read  time.Duration
+	Write time.Duration
+	List  time.Duration
+}
+
+// DefaultTimeouts returns the time limits used when nothing else is configured
+func DefaultTimeouts() Timeouts {
+	return Timeouts{
+		Read:  5 * time.Second,
+		Write: 10 * time.Second,
+		List:  30 * time.Second,
+	}
+}
+
+// TimeoutClient wraps a database client applying default timeouts to every operation, and translating
+// context cancellations and expired deadlines into the matching gRPC status errors
+type TimeoutClient struct {
+	client   AdapterInterface
+	timeouts Timeouts
+}
+
+// NewTimeoutClient wraps the received database client with the received default timeouts.
+// A zero timeout leaves the operations of that kind without a default deadline
+func NewTimeoutClient(client AdapterInterface, timeouts Timeouts) *TimeoutClient {
+	return &TimeoutClient{client: client, timeouts: timeouts}
+}
+
+func (t *TimeoutClient) CreateUser(ctx context.Context, req *pb.CreateUserReq) (string, error) {
+	ctx, cancel := withDefaultTimeout(ctx, t.timeouts.Write)
+	defer cancel()
+	id, err := t.client.CreateUser(ctx, req)
+	return id, contextStatusError(ctx, err)
+}
+
+func (t *TimeoutClient) GetUser(ctx context.Context, req *pb.GetUserReq) (*pb.UserActionResponse, error) {
+	ctx, cancel := withDefaultTimeout(ctx, t.timeouts.Read)
+	defer cancel()
+	user, err := t.client.GetUser(ctx, req)
+	return user, contextStatusError(ctx, err)
+}
+
+func (t *TimeoutClient) UpdateUser(ctx context.Context, req *pb.UpdateUserReq) (*pb.UserActionResponse, error) {
+	ctx, cancel := withDefaultTimeout(ctx, t.timeouts.Write)
+	defer cancel()
+	user, err := t.client.UpdateUser(ctx, req)
+	return user, contextStatusError(ctx, err)
+}
+
+func (t *TimeoutClient) DeleteUser(ctx context.Context, req *pb.DeleteUserReq) (*pb.DeletionActionResponse, error) {
+	ctx, cancel := withDefaultTimeout(ctx, t.timeouts.Write)
+	defer cancel()
+	deletion, err := t.client.DeleteUser(ctx, req)
+	return deletion, contextStatusError(ctx, err)
+}
+
+func (t *TimeoutClient) GetAllUsers(ctx context.Context, req *pb.ListUsersReq) (*pb.ListActionResponse, error) {
+	ctx, cancel := withDefaultTimeout(ctx, t.timeouts.List)
+	defer cancel()
+	users, err := t.client.GetAllUsers(ctx, req)
+	return users, contextStatusError(ctx, err)
+}
+
+func (t *TimeoutClient) VerifyPassword(ctx context.Context, req *pb.VerifyPasswordReq) (*pb.VerifyPasswordResponse, error) {
+	ctx, cancel := withDefaultTimeout(ctx, t.timeouts.Read)
+	defer cancel()
+	verification, err := t.client.VerifyPassword(ctx, req)
+	return verification, contextStatusError(ctx, err)
+}
+
+func (t *TimeoutClient) GrantRole(ctx context.Context, req *pb.GrantRoleReq) (*pb.UserActionResponse, error) {
+	ctx, cancel := withDefaultTimeout(ctx, t.timeouts.Write)
+	defer cancel()
+	user, err := t.client.GrantRole(ctx, req)
+	return user, contextStatusError(ctx, err)
+}
+
+func (t *TimeoutClient) RevokeRole(ctx context.Context, req *pb.RevokeRoleReq) (*pb.UserActionResponse, error) {
+	ctx, cancel := withDefaultTimeout(ctx, t.timeouts.Write)
+	defer cancel()
+	user, err := t.client.RevokeRole(ctx, req)
+	return user, contextStatusError(ctx, err)
+}
+
+// withDefaultTimeout applies the received timeout only if the context has no deadline yet
+func withDefaultTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if _, hasDeadline := ctx.Deadline(); hasDeadline || timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// contextStatusError translates errors caused by an expired deadline or a cancelled context
+// into DEADLINE_EXCEEDED and CANCELLED status errors. Any other error is returned as it is
+func contextStatusError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, isStatus := status.FromError(err); isStatus {
+		return err
+	}
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded) || ctx.Err() == context.DeadlineExceeded || mongo.IsTimeout(err):
+		return status.Error(codes.DeadlineExceeded, "database operation deadline exceeded")
+	case errors.Is(err, context.Canceled) || ctx.Err() == context.Canceled:
+		return status.Error(codes.Canceled, "database operation cancelled")
+	}
+	return err
+}
@@ -0,0 +1,121 @@
+package database
+
+import (
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"userManagement/entities"
+	pb "userManagement/proto"
+)
+
+const (
+	firstNameField = "first_name"
+	lastNameField  = "last_name"
+	nicknameField  = "nickname"
+	passwordField  = "password"
+	countryField   = "country"
+)
+
+var (
+	// updatableUserFields are the user fields that can be set in an update mask,
+	// named as in the User proto message, which matches the names they are stored with
+	updatableUserFields = map[string]bool{
+		firstNameField: true,
+		lastNameField:  true,
+		nicknameField:  true,
+		passwordField:  true,
+		countryField:   true,
+	}
+
+	// immutableUserFields identify users or are maintained by the service, so they are never updated
+	immutableUserFields = map[string]bool{
+		"email":      true,
+		"id":         true,
+		"created_at": true,
+		"updated_at": true,
+		"createdAt":  true,
+		"updatedAt":  true,
+	}
+)
+
+// ValidateUpdateMask checks that every path in the received mask is an updatable user field.
+// An empty mask is valid, and means that every updatable field must be replaced
+func ValidateUpdateMask(mask *fieldmaskpb.FieldMask) error {
+	for _, path := range mask.GetPaths() {
+		if immutableUserFields[path] {
+			return entities.ImmutableFieldError(path)
+		}
+		if !updatableUserFields[path] {
+			return entities.UnknownFieldError(path)
+		}
+	}
+	return nil
+}
+
+// UpdatesPassword reports whether the update request replaces the password of the user
+func UpdatesPassword(req *pb.UpdateUserReq) bool {
+	if len(req.GetUpdateMask().GetPaths()) == 0 {
+		return req.GetUser().GetPassword() != ""
+	}
+	for _, path := range req.UpdateMask.Paths {
+		if path == passwordField {
+			return true
+		}
+	}
+	return false
+}
+
+// getUpdateFields returns the user fields that an update request modifies.
+// Without an update mask every field is replaced but the password, which is only replaced when a new one is received
+func getUpdateFields(req *pb.UpdateUserReq) ([]string, error) {
+	user := req.GetUser()
+
+	if len(req.GetUpdateMask().GetPaths()) == 0 {
+		fields := []string{firstNameField, lastNameField, nicknameField, countryField}
+		if user.GetPassword() != "" {
+			fields = append(fields, passwordField)
+		}
+		return fields, nil
+	}
+
+	if err := ValidateUpdateMask(req.UpdateMask); err != nil {
+		return nil, err
+	}
+
+	var fields []string
+	seen := map[string]bool{}
+	for _, path := range req.UpdateMask.Paths {
+		if seen[path] {
+			continue
+		}
+		if path == passwordField && user.GetPassword() == "" {
+			return nil, entities.EmptyPasswordError
+		}
+		seen[path] = true
+		fields = append(fields, path)
+	}
+	return fields, nil
+}
+
+// getUserFieldValue returns the value of an updatable user field other than the password
+func getUserFieldValue(user *pb.User, field string) string {
+	switch field {
+	case firstNameField:
+		return user.GetFirstName()
+	case lastNameField:
+		return user.GetLastName()
+	case nicknameField:
+		return user.GetNickname()
+	case countryField:
+		return user.GetCountry()
+	}
+	return ""
+}
+
+// containsField reports whether the received field is among the fields to update
+func containsField(fields []string, field string) bool {
+	for _, f := range fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,351 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"time"
+	"userManagement/entities"
+	pb "userManagement/proto"
+)
+
+// dispatchCursorID identifies the document holding the sequence of the last event whose deliveries were created
+const dispatchCursorID = "dispatcher"
+
+// webhookDocument is the stored form of a webhook
+type webhookDocument struct {
+	Id        primitive.ObjectID `bson:"_id"`
+	URL       string             `bson:"url"`
+	Types     []int32            `bson:"types"`
+	Secret    string             `bson:"secret"`
+	Disabled  bool               `bson:"disabled"`
+	Format    int32              `bson:"format"`
+	CreatedAt time.Time          `bson:"created_at"`
+	UpdatedAt time.Time          `bson:"updated_at"`
+}
+
+// deliveryDocument is the stored form of a delivery. Its event is kept as protobuf payload
+type deliveryDocument struct {
+	Id             primitive.ObjectID `bson:"_id"`
+	WebhookId      string             `bson:"webhook_id"`
+	Payload        []byte             `bson:"payload"`
+	Status         int32              `bson:"status"`
+	Attempts       int32              `bson:"attempts"`
+	LastStatusCode int32              `bson:"last_status_code"`
+	LastError      string             `bson:"last_error"`
+	NextAttemptAt  time.Time          `bson:"next_attempt_at"`
+	CreatedAt      time.Time          `bson:"created_at"`
+	UpdatedAt      time.Time          `bson:"updated_at"`
+}
+
+// MongoWebhookStore stores webhooks in a mongo collection, and their deliveries and the dispatch cursor
+// in collections named after it
+type MongoWebhookStore struct {
+	Webhooks   *mongo.Collection
+	Deliveries *mongo.Collection
+	Cursors    *mongo.Collection
+}
+
+// NewMongoWebhookStore creates the webhook store in the database of the received client, along with its indexes
+func NewMongoWebhookStore(ctx context.Context, client *MongoClient, collection string) (*MongoWebhookStore, error) {
+	db := client.Collection.Database()
+	store := &MongoWebhookStore{
+		Webhooks:   db.Collection(collection),
+		Deliveries: db.Collection(collection + "_deliveries"),
+		Cursors:    db.Collection(collection + "_cursors"),
+	}
+
+	_, err := store.Deliveries.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "webhook_id", Value: 1}, {Key: "_id", Value: -1}},
+			Options: options.Index().SetName("webhook"),
+		},
+		{
+			Keys:    bson.D{{Key: "status", Value: 1}, {Key: "next_attempt_at", Value: 1}},
+			Options: options.Index().SetName("due"),
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not create webhook deliveries indexes: %w", err)
+	}
+	return store, nil
+}
+
+// CreateWebhook stores the webhook with a new id
+func (m *MongoWebhookStore) CreateWebhook(ctx context.Context, webhook *pb.Webhook) (*pb.Webhook, error) {
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	document := toWebhookDocument(webhook)
+	document.Id = primitive.NewObjectID()
+	document.CreatedAt = now
+	document.UpdatedAt = now
+
+	if _, err := m.Webhooks.InsertOne(ctx, document); err != nil {
+		return nil, err
+	}
+	return document.toProto(), nil
+}
+
+// GetWebhook returns the webhook with the received id
+func (m *MongoWebhookStore) GetWebhook(ctx context.Context, id string) (*pb.Webhook, error) {
+	var document webhookDocument
+	err := m.Webhooks.FindOne(ctx, bson.D{{Key: "_id", Value: toObjectID(id)}}).Decode(&document)
+	if err == mongo.ErrNoDocuments {
+		return nil, entities.NotFoundWebhook
+	}
+	if err != nil {
+		return nil, err
+	}
+	return document.toProto(), nil
+}
+
+// ListWebhooks returns every webhook, in creation order
+func (m *MongoWebhookStore) ListWebhooks(ctx context.Context) ([]*pb.Webhook, error) {
+	cursor, err := m.Webhooks.Find(ctx, bson.D{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	var documents []webhookDocument
+	if err := cursor.All(ctx, &documents); err != nil {
+		return nil, err
+	}
+
+	webhooks := make([]*pb.Webhook, 0, len(documents))
+	for _, document := range documents {
+		webhooks = append(webhooks, document.toProto())
+	}
+	return webhooks, nil
+}
+
+// UpdateWebhook replaces the webhook with the same id, keeping its creation time
+func (m *MongoWebhookStore) UpdateWebhook(ctx context.Context, webhook *pb.Webhook) (*pb.Webhook, error) {
+	document := toWebhookDocument(webhook)
+	var updated webhookDocument
+	err := m.Webhooks.FindOneAndUpdate(ctx,
+		bson.D{{Key: "_id", Value: document.Id}},
+		bson.D{{Key: "$set", Value: bson.D{
+			{Key: "url", Value: document.URL},
+			{Key: "types", Value: document.Types},
+			{Key: "secret", Value: document.Secret},
+			{Key: "disabled", Value: document.Disabled},
+			{Key: "format", Value: document.Format},
+			{Key: "updated_at", Value: time.Now().UTC().Truncate(time.Millisecond)},
+		}}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&updated)
+	if err == mongo.ErrNoDocuments {
+		return nil, entities.NotFoundWebhook
+	}
+	if err != nil {
+		return nil, err
+	}
+	return updated.toProto(), nil
+}
+
+// DeleteWebhook removes the webhook with the received id and its deliveries
+func (m *MongoWebhookStore) DeleteWebhook(ctx context.Context, id string) (bool, error) {
+	result, err := m.Webhooks.DeleteOne(ctx, bson.D{{Key: "_id", Value: toObjectID(id)}})
+	if err != nil || result.DeletedCount == 0 {
+		return false, err
+	}
+	_, err = m.Deliveries.DeleteMany(ctx, bson.D{{Key: "webhook_id", Value: id}})
+	return true, err
+}
+
+// CreateDeliveries stores the deliveries with new ids
+func (m *MongoWebhookStore) CreateDeliveries(ctx context.Context, deliveries []*pb.WebhookDelivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+
+	now := timestamppb.New(time.Now().UTC().Truncate(time.Millisecond))
+	documents := make([]interface{}, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		delivery.Id = primitive.NewObjectID().Hex()
+		delivery.CreatedAt = now
+		delivery.UpdatedAt = now
+		document, err := toDeliveryDocument(delivery)
+		if err != nil {
+			return err
+		}
+		documents = append(documents, document)
+	}
+	_, err := m.Deliveries.InsertMany(ctx, documents)
+	return err
+}
+
+// UpdateDelivery replaces the delivery with the same id, keeping its creation time.
+// Deliveries of deleted webhooks are not stored again
+func (m *MongoWebhookStore) UpdateDelivery(ctx context.Context, delivery *pb.WebhookDelivery) error {
+	delivery.UpdatedAt = timestamppb.New(time.Now().UTC().Truncate(time.Millisecond))
+	document, err := toDeliveryDocument(delivery)
+	if err != nil {
+		return err
+	}
+	_, err = m.Deliveries.UpdateOne(ctx,
+		bson.D{{Key: "_id", Value: document.Id}},
+		bson.D{{Key: "$set", Value: bson.D{
+			{Key: "status", Value: document.Status},
+			{Key: "attempts", Value: document.Attempts},
+			{Key: "last_status_code", Value: document.LastStatusCode},
+			{Key: "last_error", Value: document.LastError},
+			{Key: "next_attempt_at", Value: document.NextAttemptAt},
+			{Key: "updated_at", Value: document.UpdatedAt},
+		}}})
+	return err
+}
+
+// ListDeliveries returns the deliveries matching the query, newest first
+func (m *MongoWebhookStore) ListDeliveries(ctx context.Context, query DeliveryQuery) ([]*pb.WebhookDelivery, error) {
+	filter := bson.D{{Key: "webhook_id", Value: query.WebhookID}}
+	if query.Status != pb.WebhookDeliveryStatus_WEBHOOK_DELIVERY_STATUS_UNSPECIFIED {
+		filter = append(filter, bson.E{Key: "status", Value: int32(query.Status)})
+	}
+	if len(query.IDs) > 0 {
+		ids := make([]primitive.ObjectID, 0, len(query.IDs))
+		for _, id := range query.IDs {
+			ids = append(ids, toObjectID(id))
+		}
+		filter = append(filter, bson.E{Key: "_id", Value: bson.D{{Key: "$in", Value: ids}}})
+	}
+	return m.findDeliveries(ctx, filter, bson.D{{Key: "_id", Value: -1}}, query.Limit)
+}
+
+// ListDueDeliveries returns the pending deliveries of the received webhooks whose next attempt is due,
+// the most overdue first
+func (m *MongoWebhookStore) ListDueDeliveries(ctx context.Context, now time.Time, webhookIDs []string, limit int) ([]*pb.WebhookDelivery, error) {
+	if webhookIDs == nil {
+		webhookIDs = []string{}
+	}
+	filter := bson.D{
+		{Key: "status", Value: int32(pb.WebhookDeliveryStatus_PENDING)},
+		{Key: "webhook_id", Value: bson.D{{Key: "$in", Value: webhookIDs}}},
+		{Key: "next_attempt_at", Value: bson.D{{Key: "$lte", Value: now}}},
+	}
+	return m.findDeliveries(ctx, filter, bson.D{{Key: "next_attempt_at", Value: 1}, {Key: "_id", Value: 1}}, limit)
+}
+
+// GetDispatchCursor returns the sequence of the last event whose deliveries were created
+func (m *MongoWebhookStore) GetDispatchCursor(ctx context.Context) (int64, error) {
+	var cursor struct {
+		Sequence int64 `bson:"sequence"`
+	}
+	err := m.Cursors.FindOne(ctx, bson.D{{Key: "_id", Value: dispatchCursorID}}).Decode(&cursor)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+	return cursor.Sequence, err
+}
+
+// SetDispatchCursor stores the sequence of the last event whose deliveries were created
+func (m *MongoWebhookStore) SetDispatchCursor(ctx context.Context, sequence int64) error {
+	_, err := m.Cursors.UpdateOne(ctx,
+		bson.D{{Key: "_id", Value: dispatchCursorID}},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "sequence", Value: sequence}}}},
+		options.Update().SetUpsert(true))
+	return err
+}
+
+func (m *MongoWebhookStore) findDeliveries(ctx context.Context, filter, sort bson.D, limit int) ([]*pb.WebhookDelivery, error) {
+	opts := options.Find().SetSort(sort)
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+	cursor, err := m.Deliveries.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	var documents []deliveryDocument
+	if err := cursor.All(ctx, &documents); err != nil {
+		return nil, err
+	}
+
+	deliveries := make([]*pb.WebhookDelivery, 0, len(documents))
+	for _, document := range documents {
+		delivery, err := document.toProto()
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, nil
+}
+
+// toObjectID parses a stored id, invalid ids are turned into the zero id, which matches no document
+func toObjectID(id string) primitive.ObjectID {
+	objectID, _ := primitive.ObjectIDFromHex(id)
+	return objectID
+}
+
+func toWebhookDocument(webhook *pb.Webhook) webhookDocument {
+	types := make([]int32, 0, len(webhook.Types))
+	for _, eventType := range webhook.Types {
+		types = append(types, int32(eventType))
+	}
+	return webhookDocument{
+		Id:       toObjectID(webhook.Id),
+		URL:      webhook.Url,
+		Types:    types,
+		Secret:   webhook.Secret,
+		Disabled: webhook.Disabled,
+		Format:   int32(webhook.Format),
+	}
+}
+
+func (d webhookDocument) toProto() *pb.Webhook {
+	webhook := &pb.Webhook{
+		Id:        d.Id.Hex(),
+		Url:       d.URL,
+		Secret:    d.Secret,
+		Disabled:  d.Disabled,
+		Format:    pb.EventFormat(d.Format),
+		CreatedAt: timestamppb.New(d.CreatedAt),
+		UpdatedAt: timestamppb.New(d.UpdatedAt),
+	}
+	for _, eventType := range d.Types {
+		webhook.Types = append(webhook.Types, pb.UserEventType(eventType))
+	}
+	return webhook
+}
+
+func toDeliveryDocument(delivery *pb.WebhookDelivery) (deliveryDocument, error) {
+	payload, err := proto.Marshal(delivery.Event)
+	if err != nil {
+		return deliveryDocument{}, err
+	}
+	return deliveryDocument{
+		Id:             toObjectID(delivery.Id),
+		WebhookId:      delivery.WebhookId,
+		Payload:        payload,
+		Status:         int32(delivery.Status),
+		Attempts:       delivery.Attempts,
+		LastStatusCode: delivery.LastStatusCode,
+		LastError:      delivery.LastError,
+		NextAttemptAt:  delivery.NextAttemptAt.AsTime(),
+		CreatedAt:      delivery.CreatedAt.AsTime(),
+		UpdatedAt:      delivery.UpdatedAt.AsTime(),
+	}, nil
+}
+
+func (d deliveryDocument) toProto() (*pb.WebhookDelivery, error) {
+	var event pb.UserEvent
+	if err := proto.Unmarshal(d.Payload, &event); err != nil {
+		return nil, fmt.Errorf("could not decode event of delivery %s: %w", d.Id.Hex(), err)
+	}
+	return &pb.WebhookDelivery{
+		Id:             d.Id.Hex(),
+		WebhookId:      d.WebhookId,
+		Event:          &event,
+		Status:         pb.WebhookDeliveryStatus(d.Status),
+		Attempts:       d.Attempts,
+		LastStatusCode: d.LastStatusCode,
+		LastError:      d.LastError,
+		NextAttemptAt:  timestamppb.New(d.NextAttemptAt),
+		CreatedAt:      timestamppb.New(d.CreatedAt),
+		UpdatedAt:      timestamppb.New(d.UpdatedAt),
+	}, nil
+}
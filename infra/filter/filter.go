@@ -0,0 +1,278 @@
+// Package filter parses AIP-160 style filter expressions into an AST that every database client can translate.
+//
+// Restrictions compare a field with a value, e.g. country = "ES" or created_at > "2026-01-01T00:00:00Z".
+// The supported comparators are =, !=, <, <=, >, >= and : (has), which matches ignoring case. Values of
+// string fields ending in * match as prefixes when compared with =, != or :. Restrictions can be combined
+// with AND, OR and parentheses, and negated with NOT or -. As in AIP-160, OR binds tighter than AND, and
+// restrictions separated only by spaces are joined with AND.
+package filter
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// maxLength and maxDepth limit the size of the filters accepted
+	maxLength = 2048
+	maxDepth  = 32
+)
+
+// FieldType is the type of the values a field holds, which defines how it can be compared
+type FieldType int
+
+const (
+	String FieldType = iota
+	Timestamp
+)
+
+// Operator compares a field with a value
+type Operator string
+
+const (
+	Equal        Operator = "="
+	NotEqual     Operator = "!="
+	Less         Operator = "<"
+	LessEqual    Operator = "<="
+	Greater      Operator = ">"
+	GreaterEqual Operator = ">="
+	Has          Operator = ":"
+)
+
+// Expr is a node of a parsed filter
+type Expr interface {
+	isExpr()
+}
+
+// And matches when both expressions match
+type And struct {
+	Left, Right Expr
+}
+
+// Or matches when any of the expressions matches
+type Or struct {
+	Left, Right Expr
+}
+
+// Not matches when the expression does not match
+type Not struct {
+	Expr Expr
+}
+
+// Comparison matches when the field compared with the value using the operator is true
+type Comparison struct {
+	Field    string
+	Type     FieldType
+	Operator Operator
+	// Value is the compared string, without the trailing * of prefix matches
+	Value string
+	// Prefix is set when the field must start with the value
+	Prefix bool
+	// Time is the parsed value of timestamp fields
+	Time time.Time
+}
+
+func (And) isExpr()        {}
+func (Or) isExpr()         {}
+func (Not) isExpr()        {}
+func (Comparison) isExpr() {}
+
+// Error points at the token of a filter which could not be parsed
+type Error struct {
+	Position int
+	Token    string
+	Message  string
+}
+
+func (e *Error) Error() string {
+	if e.Token == "" {
+		return fmt.Sprintf("invalid filter at position %d: %s", e.Position, e.Message)
+	}
+	return fmt.Sprintf("invalid filter at position %d near %q: %s", e.Position, e.Token, e.Message)
+}
+
+// Parse parses the received filter, checking that it only uses the received fields.
+// An empty filter returns a nil expression, which matches everything
+func Parse(input string, fields map[string]FieldType) (Expr, error) {
+	if len(input) > maxLength {
+		return nil, &Error{Position: maxLength + 1, Message: fmt.Sprintf("filters cannot be longer than %d characters", maxLength)}
+	}
+
+	tokens, err := lex(input)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 1 {
+		return nil, nil
+	}
+
+	p := &parser{tokens: tokens, fields: fields}
+	expr, err := p.parseExpression(0)
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokenEOF {
+		return nil, p.errorAt(tok, "unexpected token")
+	}
+	return expr, nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+	fields map[string]FieldType
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) errorAt(tok token, message string) error {
+	return &Error{Position: tok.pos, Token: tok.text, Message: message}
+}
+
+// parseExpression parses sequences joined by AND
+func (p *parser) parseExpression(depth int) (Expr, error) {
+	left, err := p.parseSequence(depth)
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().isKeyword("AND") {
+		p.next()
+		right, err := p.parseSequence(depth)
+		if err != nil {
+			return nil, err
+		}
+		left = And{Left: left, Right: right}
+	}
+	return left, nil
+}
+
+// parseSequence parses factors separated only by spaces, which are joined with AND
+func (p *parser) parseSequence(depth int) (Expr, error) {
+	left, err := p.parseFactor(depth)
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().startsTerm() {
+		right, err := p.parseFactor(depth)
+		if err != nil {
+			return nil, err
+		}
+		left = And{Left: left, Right: right}
+	}
+	return left, nil
+}
+
+// parseFactor parses terms joined by OR
+func (p *parser) parseFactor(depth int) (Expr, error) {
+	left, err := p.parseTerm(depth)
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().isKeyword("OR") {
+		p.next()
+		right, err := p.parseTerm(depth)
+		if err != nil {
+			return nil, err
+		}
+		left = Or{Left: left, Right: right}
+	}
+	return left, nil
+}
+
+// parseTerm parses a restriction or a parenthesised expression, optionally negated
+func (p *parser) parseTerm(depth int) (Expr, error) {
+	tok := p.peek()
+	if depth > maxDepth {
+		return nil, p.errorAt(tok, "filter is nested too deeply")
+	}
+	if tok.kind == tokenMinus || tok.isKeyword("NOT") {
+		p.next()
+		expr, err := p.parseTerm(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return Not{Expr: expr}, nil
+	}
+
+	if tok.kind == tokenLParen {
+		p.next()
+		expr, err := p.parseExpression(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.kind != tokenRParen {
+			return nil, p.errorAt(closing, "expected )")
+		}
+		return expr, nil
+	}
+
+	return p.parseRestriction()
+}
+
+// parseRestriction parses a field comparison, validating the field and the value
+func (p *parser) parseRestriction() (Expr, error) {
+	fieldTok := p.next()
+	if fieldTok.kind != tokenWord || fieldTok.isKeyword("AND") || fieldTok.isKeyword("OR") {
+		return nil, p.errorAt(fieldTok, "expected a field name")
+	}
+	fieldType, known := p.fields[fieldTok.text]
+	if !known {
+		return nil, p.errorAt(fieldTok, "unknown field, it must be one of "+strings.Join(p.fieldNames(), ", "))
+	}
+
+	opTok := p.next()
+	if opTok.kind != tokenOperator {
+		return nil, p.errorAt(opTok, "expected a comparator after the field name")
+	}
+	comparison := Comparison{Field: fieldTok.text, Type: fieldType, Operator: Operator(opTok.text)}
+
+	valueTok := p.next()
+	if valueTok.kind != tokenWord && valueTok.kind != tokenString {
+		return nil, p.errorAt(valueTok, "expected a value")
+	}
+	comparison.Value = valueTok.text
+
+	if strings.HasSuffix(comparison.Value, "*") && fieldType == String {
+		switch comparison.Operator {
+		case Equal, NotEqual, Has:
+			comparison.Value = strings.TrimSuffix(comparison.Value, "*")
+			comparison.Prefix = true
+		}
+	}
+	if strings.Contains(comparison.Value, "*") && fieldType == String {
+		return nil, p.errorAt(valueTok, "wildcards are only allowed at the end of values compared with =, != or :")
+	}
+
+	if fieldType == Timestamp {
+		if comparison.Operator == Has {
+			return nil, p.errorAt(opTok, "timestamps cannot be compared with :")
+		}
+		parsed, err := time.Parse(time.RFC3339Nano, comparison.Value)
+		if err != nil {
+			return nil, p.errorAt(valueTok, "timestamps must follow RFC 3339, e.g. 2026-01-01T00:00:00Z")
+		}
+		comparison.Time = parsed
+	}
+	return comparison, nil
+}
+
+func (p *parser) fieldNames() []string {
+	names := make([]string, 0, len(p.fields))
+	for name := range p.fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,129 @@
+package filter
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenWord
+	tokenString
+	tokenOperator
+	tokenMinus
+	tokenLParen
+	tokenRParen
+)
+
+// token is a lexical unit of a filter, pos is its 1-based position in characters
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) isKeyword(keyword string) bool {
+	return t.kind == tokenWord && t.text == keyword
+}
+
+// startsTerm reports whether the token can start a restriction joined to the previous one without AND
+func (t token) startsTerm() bool {
+	switch t.kind {
+	case tokenMinus, tokenLParen, tokenString:
+		return true
+	case tokenWord:
+		return !t.isKeyword("AND") && !t.isKeyword("OR")
+	}
+	return false
+}
+
+// lex splits a filter into tokens, always ending with an EOF token
+func lex(input string) ([]token, error) {
+	var tokens []token
+	pos := 0
+	for offset := 0; offset < len(input); {
+		r, size := utf8.DecodeRuneInString(input[offset:])
+		pos++
+		start := pos
+
+		switch {
+		case unicode.IsSpace(r):
+			offset += size
+		case r == '(':
+			tokens = append(tokens, token{kind: tokenLParen, text: "(", pos: start})
+			offset += size
+		case r == ')':
+			tokens = append(tokens, token{kind: tokenRParen, text: ")", pos: start})
+			offset += size
+		case r == '-':
+			tokens = append(tokens, token{kind: tokenMinus, text: "-", pos: start})
+			offset += size
+		case strings.ContainsRune("=!<>:", r):
+			operator := lexOperator(input[offset:])
+			if operator == "" {
+				return nil, &Error{Position: start, Token: string(r), Message: "unknown comparator"}
+			}
+			tokens = append(tokens, token{kind: tokenOperator, text: operator, pos: start})
+			offset += len(operator)
+			pos += len(operator) - 1
+		case r == '"':
+			value, length, closed := lexString(input[offset+size:])
+			if !closed {
+				return nil, &Error{Position: start, Token: input[offset:], Message: "unterminated string"}
+			}
+			tokens = append(tokens, token{kind: tokenString, text: value, pos: start})
+			pos += utf8.RuneCountInString(input[offset:offset+size+length]) - 1
+			offset += size + length
+		case isWordRune(r):
+			end := offset
+			for end < len(input) {
+				next, nextSize := utf8.DecodeRuneInString(input[end:])
+				if !isWordRune(next) && !(end > offset && next == '-') {
+					break
+				}
+				end += nextSize
+			}
+			tokens = append(tokens, token{kind: tokenWord, text: input[offset:end], pos: start})
+			pos += utf8.RuneCountInString(input[offset:end]) - 1
+			offset = end
+		default:
+			return nil, &Error{Position: start, Token: string(r), Message: "unexpected character"}
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, pos: pos + 1}), nil
+}
+
+// lexOperator returns the comparator the input starts with, or an empty string if it is not valid
+func lexOperator(input string) string {
+	for _, operator := range []Operator{LessEqual, GreaterEqual, NotEqual, Equal, Less, Greater, Has} {
+		if strings.HasPrefix(input, string(operator)) {
+			return string(operator)
+		}
+	}
+	return ""
+}
+
+// lexString reads a quoted string after its opening quote, unescaping \" and \\.
+// It returns the value and the length of the input consumed, including the closing quote
+func lexString(input string) (string, int, bool) {
+	var value strings.Builder
+	for i := 0; i < len(input); i++ {
+		switch input[i] {
+		case '"':
+			return value.String(), i + 1, true
+		case '\\':
+			if i+1 < len(input) && (input[i+1] == '"' || input[i+1] == '\\') {
+				i++
+			}
+		}
+		value.WriteByte(input[i])
+	}
+	return "", 0, false
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("_.*@+", r)
+}
@@ -0,0 +1,80 @@
+// Package gateway customises the grpc-gateway mux which publishes the REST API.
+package gateway
+
+import (
+	"context"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"strings"
+	"userManagement/infra/auth"
+)
+
+// NewServeMux creates the REST API mux, which forwards the user version as ETag header, the X-Request-Id and
+// X-Api-Key headers and the client certificate of the caller to the gRPC server and returns failed preconditions
+// as 412 errors
+func NewServeMux(opts ...runtime.ServeMuxOption) *runtime.ServeMux {
+	opts = append([]runtime.ServeMuxOption{
+		runtime.WithIncomingHeaderMatcher(incomingHeaderMatcher),
+		runtime.WithMetadata(clientCertificate),
+		runtime.WithOutgoingHeaderMatcher(outgoingHeaderMatcher),
+		runtime.WithErrorHandler(errorHandler),
+	}, opts...)
+	return runtime.NewServeMux(opts...)
+}
+
+// incomingHeaderMatcher forwards the X-Request-Id header as x-request-id metadata, so events can be
+// correlated with the request which caused them, and the X-Api-Key header as x-api-key metadata, which
+// authenticates it as the Authorization header does. The gateway always forwards the Authorization header as
+// authorization metadata, and the rest of the headers as it does by default, but the client certificate one
+func incomingHeaderMatcher(key string) (string, bool) {
+	switch http.CanonicalHeaderKey(key) {
+	case "X-Request-Id":
+		return "x-request-id", true
+	case "X-Api-Key":
+		return "x-api-key", true
+	}
+	name, ok := runtime.DefaultHeaderMatcher(key)
+	if strings.EqualFold(name, auth.ClientCertificateHeader) {
+		return "", false
+	}
+	return name, ok
+}
+
+// clientCertificate forwards the subject of the client certificate verified on the REST connection, since the
+// gRPC server only sees the certificate of the gateway
+func clientCertificate(_ context.Context, r *http.Request) metadata.MD {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return metadata.Pairs(auth.ClientCertificateHeader, r.TLS.VerifiedChains[0][0].Subject.String())
+}
+
+// outgoingHeaderMatcher sends the etag gRPC header as the HTTP ETag header.
+// The rest of the headers keep the default gateway prefix
+func outgoingHeaderMatcher(key string) (string, bool) {
+	if key == "etag" {
+		return "ETag", true
+	}
+	return runtime.MetadataHeaderPrefix + key, true
+}
+
+// errorHandler returns FAILED_PRECONDITION errors, caused by user version mismatches, as 412 Precondition Failed
+func errorHandler(ctx context.Context, mux *runtime.ServeMux, marshaler runtime.Marshaler, w http.ResponseWriter, r *http.Request, err error) {
+	if status.Code(err) == codes.FailedPrecondition {
+		w = &statusWriter{ResponseWriter: w, status: http.StatusPreconditionFailed}
+	}
+	runtime.DefaultHTTPErrorHandler(ctx, mux, marshaler, w, r, err)
+}
+
+// statusWriter overrides the status code written by the default error handler
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(int) {
+	w.ResponseWriter.WriteHeader(w.status)
+}
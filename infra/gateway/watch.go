@@ -0,0 +1,305 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"golang.org/x/net/websocket"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	pb "userManagement/proto"
+)
+
+const (
+	// WatchPath is where the user events are served as Server-Sent Events and through WebSockets
+	WatchPath = "/v1/users:watch"
+	// DefaultHeartbeatInterval is the time between heartbeats sent to idle watchers, so proxies keep their connections
+	DefaultHeartbeatInterval = 15 * time.Second
+	// AccessTokenParameter is the query parameter, and the cookie, carrying the access token of browsers,
+	// whose EventSource and WebSocket cannot send the Authorization header
+	AccessTokenParameter = "access_token"
+)
+
+// WatchHandler serves the NotifyUserChanges stream to HTTP clients, as Server-Sent Events or, when the request
+// asks to upgrade the connection, as WebSocket text messages. Every event carries the same JSON as the REST API
+type WatchHandler struct {
+	client    pb.UserManagementClient
+	heartbeat time.Duration
+	origins   []string
+}
+
+// NewWatchHandler creates a handler streaming the events received from the grpc client,
+// sending a heartbeat every interval without events. Browsers can only watch from the origin of the gateway
+// and the allowed origins, where "*" allows any
+func NewWatchHandler(client pb.UserManagementClient, heartbeat time.Duration, allowedOrigins []string) *WatchHandler {
+	if heartbeat <= 0 {
+		heartbeat = DefaultHeartbeatInterval
+	}
+	return &WatchHandler{client: client, heartbeat: heartbeat, origins: allowedOrigins}
+}
+
+// ServeHTTP streams the events selected by the query parameters, which are the fields of NotifyUserChangesReq.
+// Server-Sent Events clients resume after the id of the last event received, sent as Last-Event-ID header.
+// Streams the server rejects, such as those without credentials, are answered with the status of the error
+func (h *WatchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "only GET is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// The origin is checked before opening the stream, so other sites cannot watch with the cookie of their visitors
+	if err := h.checkOrigin(r); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	req, err := parseWatchRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	events, errs, err := h.watch(ctx, r, req)
+	if err != nil {
+		st := status.Convert(err)
+		http.Error(w, st.Message(), runtime.HTTPStatusFromCode(st.Code()))
+		return
+	}
+
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		websocket.Server{Handler: func(conn *websocket.Conn) {
+			h.serveWebSocket(ctx, cancel, conn, events, errs)
+		}}.ServeHTTP(w, r)
+		return
+	}
+	h.serveEvents(ctx, w, events, errs)
+}
+
+// serveEvents streams the events as Server-Sent Events, with their sequence as id and a comment as heartbeat
+func (h *WatchHandler) serveEvents(ctx context.Context, w http.ResponseWriter, events <-chan *pb.UserEvent, errs <-chan error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(h.heartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case event := <-events:
+			data, err := protojson.Marshal(event)
+			if err != nil {
+				log.Printf("Could not encode event %s: %v", event.EventId, err)
+				return
+			}
+			if _, err := fmt.Fprintf(w, "id: %d\ndata: %s\n\n", event.Sequence, data); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		case err := <-errs:
+			if ctx.Err() == nil {
+				st := status.Convert(err)
+				fmt.Fprintf(w, "event: error\ndata: {\"code\":%d,\"message\":%q}\n\n", st.Code(), st.Message())
+				flusher.Flush()
+			}
+			return
+		case <-ctx.Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// serveWebSocket streams the events as text messages, with ping frames as heartbeat.
+// The connection is closed when the stream ends, and the stream ends when the client closes the connection
+func (h *WatchHandler) serveWebSocket(ctx context.Context, cancel context.CancelFunc, conn *websocket.Conn, events <-chan *pb.UserEvent, errs <-chan error) {
+	defer conn.Close()
+
+	// Messages from the client are discarded, reading them detects when it closes the connection
+	go func() {
+		defer cancel()
+		var discarded []byte
+		for websocket.Message.Receive(conn, &discarded) == nil {
+		}
+	}()
+
+	heartbeat := time.NewTicker(h.heartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case event := <-events:
+			data, err := protojson.Marshal(event)
+			if err != nil {
+				log.Printf("Could not encode event %s: %v", event.EventId, err)
+				return
+			}
+			if err := websocket.Message.Send(conn, string(data)); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			conn.PayloadType = websocket.PingFrame
+			_, err := conn.Write(nil)
+			conn.PayloadType = websocket.TextFrame
+			if err != nil {
+				return
+			}
+		case <-errs:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// watch opens the stream, returning the channels its events and its final error are sent to once the server accepted it
+func (h *WatchHandler) watch(ctx context.Context, r *http.Request, req *pb.NotifyUserChangesReq) (<-chan *pb.UserEvent, <-chan error, error) {
+	stream, err := h.client.NotifyUserChanges(outgoingContext(ctx, r), req)
+	if err != nil {
+		return nil, nil, err
+	}
+	// The server sends its headers once the stream is subscribed, and streams it rejects end without them
+	header, err := stream.Header()
+	if err == nil && header == nil {
+		_, err = stream.Recv()
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	events := make(chan *pb.UserEvent)
+	errs := make(chan error, 1)
+	go func() {
+		for {
+			event, err := stream.Recv()
+			if err != nil {
+				errs <- err
+				return
+			}
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events, errs, nil
+}
+
+// checkOrigin accepts the requests without Origin header, which are not sent by browsers, and the ones from the
+// origin of the gateway itself or from an allowed origin
+func (h *WatchHandler) checkOrigin(r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	if originURL, err := url.Parse(origin); err == nil && strings.EqualFold(originURL.Host, r.Host) {
+		return nil
+	}
+	for _, allowed := range h.origins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return nil
+		}
+	}
+	return fmt.Errorf("origin %s is not allowed to watch the user events", origin)
+}
+
+// outgoingContext forwards the request headers as the REST API does, so the stream is called like any other method.
+// Requests without credentials in their headers are authenticated with the access token of the access_token
+// query parameter or cookie, which is how browsers send it
+func outgoingContext(ctx context.Context, r *http.Request) context.Context {
+	md := metadata.MD{}
+	for key, values := range r.Header {
+		if name, ok := incomingHeaderMatcher(key); ok {
+			md.Append(name, values...)
+		}
+		if key == "Authorization" {
+			md.Append("authorization", values...)
+		}
+	}
+	if len(md.Get("authorization")) == 0 && len(md.Get("x-api-key")) == 0 {
+		if token := accessToken(r); token != "" {
+			md.Set("authorization", "Bearer "+token)
+		}
+	}
+	return metadata.NewOutgoingContext(ctx, metadata.Join(md, clientCertificate(ctx, r)))
+}
+
+// accessToken returns the access token of the query parameter, or else of the cookie
+func accessToken(r *http.Request) string {
+	if token := r.URL.Query().Get(AccessTokenParameter); token != "" {
+		return token
+	}
+	if cookie, err := r.Cookie(AccessTokenParameter); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+// parseWatchRequest builds the stream request from the query parameters, which can be repeated or comma
+// separated, and the Last-Event-ID header
+func parseWatchRequest(r *http.Request) (*pb.NotifyUserChangesReq, error) {
+	query := r.URL.Query()
+	req := &pb.NotifyUserChangesReq{
+		UserIds:       queryValues(query, "user_ids"),
+		Emails:        queryValues(query, "emails"),
+		Countries:     queryValues(query, "countries"),
+		ChangedFields: queryValues(query, "changed_fields"),
+	}
+	for _, name := range queryValues(query, "types") {
+		eventType, known := pb.UserEventType_value[strings.ToUpper(name)]
+		if !known {
+			return nil, fmt.Errorf("unknown event type %q", name)
+		}
+		req.Types = append(req.Types, pb.UserEventType(eventType))
+	}
+
+	resumeAfter := query.Get("resume_after")
+	if resumeAfter == "" {
+		resumeAfter = r.Header.Get("Last-Event-ID")
+	}
+	if resumeAfter != "" {
+		sequence, err := strconv.ParseInt(resumeAfter, 10, 64)
+		if err != nil || sequence < 0 {
+			return nil, fmt.Errorf("invalid event id to resume after %q", resumeAfter)
+		}
+		req.ResumeAfter = sequence
+	}
+	if since := query.Get("since_timestamp"); since != "" {
+		timestamp, err := time.Parse(time.RFC3339Nano, since)
+		if err != nil {
+			return nil, fmt.Errorf("invalid since_timestamp %q, it must be an RFC 3339 time", since)
+		}
+		req.SinceTimestamp = timestamppb.New(timestamp)
+	}
+	return req, nil
+}
+
+func queryValues(query url.Values, key string) []string {
+	var values []string
+	for _, value := range query[key] {
+		for _, part := range strings.Split(value, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				values = append(values, part)
+			}
+		}
+	}
+	return values
+}
@@ -0,0 +1,229 @@
+// Package notification broadcasts user change events to every subscribed stream.
+package notification
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+	pb "userManagement/proto"
+)
+
+// SlowConsumerPolicy defines what happens to the notifications of a subscriber whose buffer is full
+type SlowConsumerPolicy string
+
+const (
+	// DropOldest discards the oldest buffered notification to make room for the new one
+	DropOldest SlowConsumerPolicy = "drop-oldest"
+	// Disconnect closes the subscription, which ends its stream
+	Disconnect SlowConsumerPolicy = "disconnect"
+	// Block waits for the subscriber to make room up to the block timeout, dropping the notification after it
+	Block SlowConsumerPolicy = "block"
+)
+
+// ErrSlowConsumer is the reason a subscription is closed by the Disconnect policy
+var ErrSlowConsumer = errors.New("subscriber is too slow to receive notifications")
+
+// Config holds the settings of a broker
+type Config struct {
+	// BufferSize is the number of notifications buffered for each subscriber
+	BufferSize   int
+	Policy       SlowConsumerPolicy
+	BlockTimeout time.Duration
+}
+
+// DefaultConfig returns the broker settings used when nothing else is configured
+func DefaultConfig() Config {
+	return Config{
+		BufferSize:   64,
+		Policy:       DropOldest,
+		BlockTimeout: time.Second,
+	}
+}
+
+// Validate checks that the config can be used to create a broker
+func (c Config) Validate() error {
+	if c.BufferSize <= 0 {
+		return fmt.Errorf("notification buffer size must be positive, got %d", c.BufferSize)
+	}
+	switch c.Policy {
+	case DropOldest, Disconnect, Block:
+		return nil
+	}
+	return fmt.Errorf("unknown slow consumer policy %q", c.Policy)
+}
+
+// Stats are the counters of a broker
+type Stats struct {
+	Subscribers  int
+	Published    uint64
+	Dropped      uint64
+	Disconnected uint64
+}
+
+// Broker sends every published notification to all its subscribers, each one with its own bounded buffer
+type Broker struct {
+	config       Config
+	mu           sync.RWMutex
+	subscribers  map[*Subscription]struct{}
+	published    uint64
+	dropped      uint64
+	disconnected uint64
+}
+
+// NewBroker creates a broker without subscribers
+func NewBroker(config Config) *Broker {
+	return &Broker{config: config, subscribers: map[*Subscription]struct{}{}}
+}
+
+// Subscription receives the notifications published after it was created
+type Subscription struct {
+	broker    *Broker
+	events    chan *pb.UserEvent
+	done      chan struct{}
+	closeOnce sync.Once
+	err       error
+	// mu serializes the deliveries of concurrent publishers
+	mu      sync.Mutex
+	dropped uint64
+}
+
+// Subscribe registers a new subscriber, which is unregistered when the received context ends
+func (b *Broker) Subscribe(ctx context.Context) *Subscription {
+	sub := &Subscription{
+		broker: b,
+		events: make(chan *pb.UserEvent, b.config.BufferSize),
+		done:   make(chan struct{}),
+	}
+
+	b.mu.Lock()
+	b.subscribers[sub] = struct{}{}
+	b.mu.Unlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			sub.close(ctx.Err())
+		case <-sub.done:
+		}
+	}()
+	return sub
+}
+
+// Publish sends the notification to every subscriber, applying the slow consumer policy to those with a full buffer
+func (b *Broker) Publish(event *pb.UserEvent) {
+	atomic.AddUint64(&b.published, 1)
+
+	b.mu.RLock()
+	subscribers := make([]*Subscription, 0, len(b.subscribers))
+	for sub := range b.subscribers {
+		subscribers = append(subscribers, sub)
+	}
+	b.mu.RUnlock()
+
+	for _, sub := range subscribers {
+		sub.deliver(event, b.config)
+	}
+}
+
+// Stats returns the current counters of the broker
+func (b *Broker) Stats() Stats {
+	b.mu.RLock()
+	subscribers := len(b.subscribers)
+	b.mu.RUnlock()
+
+	return Stats{
+		Subscribers:  subscribers,
+		Published:    atomic.LoadUint64(&b.published),
+		Dropped:      atomic.LoadUint64(&b.dropped),
+		Disconnected: atomic.LoadUint64(&b.disconnected),
+	}
+}
+
+func (b *Broker) unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	delete(b.subscribers, sub)
+	b.mu.Unlock()
+}
+
+// Notifications returns the channel the notifications are received from
+func (s *Subscription) Notifications() <-chan *pb.UserEvent {
+	return s.events
+}
+
+// Done is closed when the subscription ends, either because its context ended or because it was disconnected
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns the reason the subscription ended, it must be called after Done is closed
+func (s *Subscription) Err() error {
+	return s.err
+}
+
+// Dropped returns the number of notifications this subscriber missed
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Close unregisters the subscription
+func (s *Subscription) Close() {
+	s.close(context.Canceled)
+}
+
+func (s *Subscription) close(err error) {
+	s.closeOnce.Do(func() {
+		s.err = err
+		s.broker.unsubscribe(s)
+		close(s.done)
+	})
+}
+
+func (s *Subscription) drop() {
+	atomic.AddUint64(&s.dropped, 1)
+	atomic.AddUint64(&s.broker.dropped, 1)
+}
+
+// deliver buffers the notification for the subscriber, applying the slow consumer policy when the buffer is full
+func (s *Subscription) deliver(event *pb.UserEvent, config Config) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	select {
+	case <-s.done:
+		return
+	case s.events <- event:
+		return
+	default:
+	}
+
+	switch config.Policy {
+	case DropOldest:
+		// The subscriber may receive concurrently, so the buffer may have room again after dropping or not
+		select {
+		case <-s.events:
+			s.drop()
+		default:
+		}
+		select {
+		case s.events <- event:
+		default:
+			s.drop()
+		}
+	case Disconnect:
+		s.drop()
+		atomic.AddUint64(&s.broker.disconnected, 1)
+		s.close(ErrSlowConsumer)
+	case Block:
+		timer := time.NewTimer(config.BlockTimeout)
+		defer timer.Stop()
+		select {
+		case s.events <- event:
+		case <-s.done:
+		case <-timer.C:
+			s.drop()
+		}
+	}
+}
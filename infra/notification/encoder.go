@@ -0,0 +1,48 @@
+package notification
+
+import (
+	"encoding/json"
+	"fmt"
+	"google.golang.org/protobuf/encoding/protojson"
+	"userManagement/infra/cloudevents"
+	pb "userManagement/proto"
+)
+
+const (
+	// FormatJSON publishes the UserEvent as JSON
+	FormatJSON = "json"
+	// FormatCloudEvents publishes the events as CloudEvents in JSON structured mode
+	FormatCloudEvents = "cloudevents"
+)
+
+// Encoder encodes the events published to a message bus, returning the message body and its content type
+type Encoder interface {
+	Encode(event *pb.UserEvent) ([]byte, string, error)
+}
+
+// NewEncoder creates the encoder of the received format. CloudEvents take their shared attributes from the config
+func NewEncoder(format string, events cloudevents.Config) (Encoder, error) {
+	switch format {
+	case FormatJSON:
+		return jsonEncoder{}, nil
+	case FormatCloudEvents:
+		return cloudEventsEncoder{config: events}, nil
+	}
+	return nil, fmt.Errorf("unknown event format %q", format)
+}
+
+type jsonEncoder struct{}
+
+func (jsonEncoder) Encode(event *pb.UserEvent) ([]byte, string, error) {
+	body, err := protojson.Marshal(event)
+	return body, "application/json", err
+}
+
+type cloudEventsEncoder struct {
+	config cloudevents.Config
+}
+
+func (e cloudEventsEncoder) Encode(event *pb.UserEvent) ([]byte, string, error) {
+	body, err := json.Marshal(cloudevents.New(e.config, event))
+	return body, cloudevents.StructuredContentType, err
+}
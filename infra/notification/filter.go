@@ -0,0 +1,97 @@
+package notification
+
+import (
+	"sort"
+	"strings"
+	"userManagement/entities"
+	pb "userManagement/proto"
+)
+
+// eventFields are the user fields described in events, which can be used to filter by changed field
+var eventFields = map[string]func(*pb.User) string{
+	"first_name": (*pb.User).GetFirstName,
+	"last_name":  (*pb.User).GetLastName,
+	"nickname":   (*pb.User).GetNickname,
+	"country":    (*pb.User).GetCountry,
+	"email":      (*pb.User).GetEmail,
+}
+
+// Filter selects the events sent to a subscriber. An event matches the filter when it matches every
+// criterion with values, and it matches a criterion when it matches any of its values
+type Filter struct {
+	Types         map[pb.UserEventType]bool
+	UserIDs       map[string]bool
+	Emails        map[string]bool
+	Countries     map[string]bool
+	ChangedFields map[string]bool
+}
+
+// NewFilter builds the filter of a NotifyUserChanges request, failing when it filters by changes
+// of fields which are not described in events
+func NewFilter(req *pb.NotifyUserChangesReq) (*Filter, error) {
+	filter := &Filter{
+		Types:         map[pb.UserEventType]bool{},
+		UserIDs:       toSet(req.GetUserIds(), nil),
+		Emails:        toSet(req.GetEmails(), strings.ToLower),
+		Countries:     toSet(req.GetCountries(), nil),
+		ChangedFields: toSet(req.GetChangedFields(), nil),
+	}
+	for _, eventType := range req.GetTypes() {
+		filter.Types[eventType] = true
+	}
+	for field := range filter.ChangedFields {
+		if eventFields[field] == nil {
+			return nil, entities.UnknownEventFieldError(field)
+		}
+	}
+	return filter, nil
+}
+
+// Matches checks whether the event must be sent to the subscriber
+func (f *Filter) Matches(event *pb.UserEvent) bool {
+	if len(f.Types) > 0 && !f.Types[event.Type] {
+		return false
+	}
+	if len(f.UserIDs) > 0 && !f.UserIDs[event.UserId] {
+		return false
+	}
+	if len(f.Emails) > 0 && !f.Emails[strings.ToLower(event.Email)] {
+		return false
+	}
+	if len(f.Countries) > 0 && !f.Countries[event.Before.GetCountry()] && !f.Countries[event.After.GetCountry()] {
+		return false
+	}
+	if len(f.ChangedFields) > 0 {
+		for _, field := range ChangedFields(event) {
+			if f.ChangedFields[field] {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// ChangedFields returns the user fields whose value differs before and after the event.
+// Creations and deletions change every field
+func ChangedFields(event *pb.UserEvent) []string {
+	var fields []string
+	for field, get := range eventFields {
+		if event.Before == nil || event.After == nil || get(event.Before) != get(event.After) {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+func toSet(values []string, normalize func(string) string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, value := range values {
+		if normalize != nil {
+			value = normalize(value)
+		}
+		set[value] = true
+	}
+	return set
+}
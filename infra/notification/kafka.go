@@ -0,0 +1,107 @@
+package notification
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/segmentio/kafka-go"
+	"strings"
+	"time"
+	pb "userManagement/proto"
+)
+
+// KafkaConfig holds the settings of the Kafka publisher
+type KafkaConfig struct {
+	// Brokers is the comma separated list of Kafka brokers addresses used to discover the cluster
+	Brokers string
+	Topic   string
+	// Timeout bounds the time waited for every published event to be acknowledged by all the in sync replicas
+	Timeout time.Duration
+}
+
+// DefaultKafkaConfig returns the Kafka settings used when nothing else is configured
+func DefaultKafkaConfig() KafkaConfig {
+	return KafkaConfig{
+		Brokers: "localhost:9092",
+		Topic:   "users.events",
+		Timeout: 10 * time.Second,
+	}
+}
+
+// Validate checks that the config can be used to create a Kafka publisher
+func (c KafkaConfig) Validate() error {
+	if len(c.brokers()) == 0 {
+		return errors.New("kafka brokers are required")
+	}
+	if c.Topic == "" {
+		return errors.New("kafka topic is required")
+	}
+	if c.Timeout <= 0 {
+		return fmt.Errorf("kafka timeout must be positive, got %s", c.Timeout)
+	}
+	return nil
+}
+
+func (c KafkaConfig) brokers() []string {
+	var brokers []string
+	for _, broker := range strings.Split(c.Brokers, ",") {
+		if broker = strings.TrimSpace(broker); broker != "" {
+			brokers = append(brokers, broker)
+		}
+	}
+	return brokers
+}
+
+// KafkaPublisher publishes the events to a Kafka topic, encoded by its encoder and keyed by user id so the
+// events of each user keep their order within its partition
+type KafkaPublisher struct {
+	writer  *kafka.Writer
+	encoder Encoder
+	timeout time.Duration
+}
+
+// NewKafkaPublisher creates a publisher producing to the topic of the config. The brokers are not contacted
+// until the first event is published
+func NewKafkaPublisher(config KafkaConfig, encoder Encoder) (*KafkaPublisher, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return &KafkaPublisher{
+		writer: &kafka.Writer{
+			Addr:         kafka.TCP(config.brokers()...),
+			Topic:        config.Topic,
+			Balancer:     &kafka.Hash{},
+			RequiredAcks: kafka.RequireAll,
+			// Events are written one at a time, waiting for a batch to fill would only delay them
+			BatchTimeout: time.Millisecond,
+			WriteTimeout: config.Timeout,
+		},
+		encoder: encoder,
+		timeout: config.Timeout,
+	}, nil
+}
+
+// Publish writes the event to the topic, waiting for it to be acknowledged
+func (p *KafkaPublisher) Publish(ctx context.Context, event *pb.UserEvent) error {
+	value, contentType, err := p.encoder.Encode(event)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	return p.writer.WriteMessages(ctx, kafka.Message{
+		Key:   []byte(event.UserId),
+		Value: value,
+		Headers: []kafka.Header{
+			{Key: "content-type", Value: []byte(contentType)},
+			{Key: EventIDHeader, Value: []byte(event.EventId)},
+			{Key: EventTypeHeader, Value: []byte(eventTypeName(event))},
+		},
+	})
+}
+
+// Close flushes the pending messages and closes the connections to the brokers
+func (p *KafkaPublisher) Close() error {
+	return p.writer.Close()
+}
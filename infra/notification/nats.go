@@ -0,0 +1,144 @@
+package notification
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"log"
+	"time"
+	pb "userManagement/proto"
+)
+
+const (
+	// EventIDHeader and EventTypeHeader are the message headers carrying the event id and lower cased type.
+	// NATS JetStream discards repeated messages by the Nats-Msg-Id header, which also carries the event id
+	EventIDHeader   = "Event-Id"
+	EventTypeHeader = "Event-Type"
+)
+
+// NATSConfig holds the settings of the NATS publisher
+type NATSConfig struct {
+	// URL of the NATS server, ignored when the server is embedded
+	URL string
+	// Subject prefix of the messages, followed by a dot and the lower cased event type, such as users.events.user_created
+	Subject string
+	// Embedded starts a NATS server in the process listening on EmbeddedHost and EmbeddedPort, -1 picks a random port
+	Embedded     bool
+	EmbeddedHost string
+	EmbeddedPort int
+	// Timeout bounds the connection and the time waited for the server to acknowledge every published event
+	Timeout time.Duration
+}
+
+// DefaultNATSConfig returns the NATS settings used when nothing else is configured
+func DefaultNATSConfig() NATSConfig {
+	return NATSConfig{
+		URL:          nats.DefaultURL,
+		Subject:      "users.events",
+		EmbeddedHost: "127.0.0.1",
+		EmbeddedPort: nats.DefaultPort,
+		Timeout:      5 * time.Second,
+	}
+}
+
+// Validate checks that the config can be used to create a NATS publisher
+func (c NATSConfig) Validate() error {
+	if c.URL == "" && !c.Embedded {
+		return errors.New("nats url is required unless the nats server is embedded")
+	}
+	if c.Subject == "" {
+		return errors.New("nats subject is required")
+	}
+	if c.Timeout <= 0 {
+		return fmt.Errorf("nats timeout must be positive, got %s", c.Timeout)
+	}
+	return nil
+}
+
+// NATSPublisher publishes the events to a NATS server, encoded by its encoder
+type NATSPublisher struct {
+	conn    *nats.Conn
+	server  *server.Server
+	encoder Encoder
+	subject string
+	timeout time.Duration
+}
+
+// NewNATSPublisher connects to the NATS server of the config, starting it first when it is embedded
+func NewNATSPublisher(config NATSConfig, encoder Encoder) (*NATSPublisher, error) {
+	publisher := &NATSPublisher{encoder: encoder, subject: config.Subject, timeout: config.Timeout}
+	url := config.URL
+	if config.Embedded {
+		embedded, err := server.NewServer(&server.Options{
+			Host:   config.EmbeddedHost,
+			Port:   config.EmbeddedPort,
+			NoLog:  true,
+			NoSigs: true,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("could not create embedded nats server: %w", err)
+		}
+		go embedded.Start()
+		if !embedded.ReadyForConnections(config.Timeout) {
+			embedded.Shutdown()
+			return nil, errors.New("embedded nats server did not start in time")
+		}
+		log.Printf("Embedded nats server listening at %s", embedded.ClientURL())
+		publisher.server = embedded
+		url = embedded.ClientURL()
+	}
+
+	conn, err := nats.Connect(url,
+		nats.Name("userManagement"),
+		nats.Timeout(config.Timeout),
+		nats.MaxReconnects(-1),
+	)
+	if err != nil {
+		publisher.shutdownServer()
+		return nil, fmt.Errorf("could not connect to nats at %s: %w", url, err)
+	}
+	publisher.conn = conn
+	return publisher, nil
+}
+
+// ClientURL returns the URL of the NATS server the events are published to
+func (p *NATSPublisher) ClientURL() string {
+	return p.conn.ConnectedUrl()
+}
+
+// Publish sends the event to the subject of its type, waiting for the server to receive it
+func (p *NATSPublisher) Publish(ctx context.Context, event *pb.UserEvent) error {
+	data, contentType, err := p.encoder.Encode(event)
+	if err != nil {
+		return err
+	}
+	msg := nats.NewMsg(p.subject + "." + eventTypeName(event))
+	msg.Data = data
+	msg.Header.Set("Content-Type", contentType)
+	msg.Header.Set(nats.MsgIdHdr, event.EventId)
+	msg.Header.Set(EventIDHeader, event.EventId)
+	msg.Header.Set(EventTypeHeader, eventTypeName(event))
+	if err := p.conn.PublishMsg(msg); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	return p.conn.FlushWithContext(ctx)
+}
+
+// Close sends the pending messages and closes the connection, stopping the embedded server if there is one
+func (p *NATSPublisher) Close() error {
+	err := p.conn.FlushTimeout(p.timeout)
+	p.conn.Close()
+	p.shutdownServer()
+	return err
+}
+
+func (p *NATSPublisher) shutdownServer() {
+	if p.server != nil {
+		p.server.Shutdown()
+	}
+}
@@ -0,0 +1,114 @@
+package notification
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"userManagement/infra/cloudevents"
+	pb "userManagement/proto"
+)
+
+const (
+	// PublisherInProcess only publishes the events to the streams of this process
+	PublisherInProcess = "inprocess"
+	// PublisherNATS also publishes the events to a NATS server, which can be embedded in the process
+	PublisherNATS = "nats"
+	// PublisherKafka also publishes the events to a Kafka topic
+	PublisherKafka = "kafka"
+)
+
+// EventPublisher sends the user events out of the event log. Publish returns once the event has been accepted,
+// so the relay only marks as delivered the events which were published
+type EventPublisher interface {
+	Publish(ctx context.Context, event *pb.UserEvent) error
+	Close() error
+}
+
+// PublisherConfig selects where the user events are published besides the streams of this process
+type PublisherConfig struct {
+	Kind string
+	// Format is how the events are encoded in the messages: json or cloudevents
+	Format string
+	NATS   NATSConfig
+	Kafka  KafkaConfig
+}
+
+// DefaultPublisherConfig returns the publisher settings used when nothing else is configured
+func DefaultPublisherConfig() PublisherConfig {
+	return PublisherConfig{
+		Kind:   PublisherInProcess,
+		Format: FormatJSON,
+		NATS:   DefaultNATSConfig(),
+		Kafka:  DefaultKafkaConfig(),
+	}
+}
+
+// Validate checks that the config can be used to create a publisher
+func (c PublisherConfig) Validate() error {
+	if _, err := NewEncoder(c.Format, cloudevents.DefaultConfig()); err != nil {
+		return err
+	}
+	switch c.Kind {
+	case PublisherInProcess:
+		return nil
+	case PublisherNATS:
+		return c.NATS.Validate()
+	case PublisherKafka:
+		return c.Kafka.Validate()
+	}
+	return fmt.Errorf("unknown event publisher %q", c.Kind)
+}
+
+// NewPublisher creates the publisher of the message bus selected in the config, which is nil when the events
+// are only published to the streams of this process. CloudEvents take their shared attributes from the received config
+func NewPublisher(config PublisherConfig, events cloudevents.Config) (EventPublisher, error) {
+	if config.Kind == PublisherInProcess {
+		return nil, nil
+	}
+	encoder, err := NewEncoder(config.Format, events)
+	if err != nil {
+		return nil, err
+	}
+	switch config.Kind {
+	case PublisherNATS:
+		nats, err := NewNATSPublisher(config.NATS, encoder)
+		if err != nil {
+			return nil, err
+		}
+		return nats, nil
+	case PublisherKafka:
+		kafka, err := NewKafkaPublisher(config.Kafka, encoder)
+		if err != nil {
+			return nil, err
+		}
+		return kafka, nil
+	}
+	return nil, fmt.Errorf("unknown event publisher %q", config.Kind)
+}
+
+// BrokerPublisher publishes the events to the subscriptions of a broker
+type BrokerPublisher struct {
+	broker *Broker
+}
+
+// NewBrokerPublisher creates a publisher sending the events to the received broker
+func NewBrokerPublisher(broker *Broker) *BrokerPublisher {
+	return &BrokerPublisher{broker: broker}
+}
+
+// Publish sends the event to the broker subscribers, it never fails
+func (p *BrokerPublisher) Publish(_ context.Context, event *pb.UserEvent) error {
+	p.broker.Publish(event)
+	return nil
+}
+
+// Close does nothing, the broker is kept for the streams using it
+func (p *BrokerPublisher) Close() error {
+	return nil
+}
+
+// eventTypeName returns the lower cased event type, appended to the NATS subjects and sent as Kafka header,
+// such as user_created
+func eventTypeName(event *pb.UserEvent) string {
+	return strings.ToLower(event.GetType().String())
+}
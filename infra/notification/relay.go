@@ -0,0 +1,134 @@
+package notification
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+	"userManagement/infra/database"
+)
+
+const (
+	// DefaultRelayInterval is the time between checks for undelivered events when no append is signalled
+	DefaultRelayInterval = time.Second
+	// relayBatchSize is the number of undelivered events read at once
+	relayBatchSize = 100
+)
+
+// Relay publishes the events stored in the event log through the publisher, in sequence order, and marks them
+// delivered. Events are published at least once: those published right before the process stops, or before
+// the publisher fails, may be published again
+type Relay struct {
+	eventLog  database.EventLogInterface
+	publisher EventPublisher
+	interval  time.Duration
+	// local follows the event log on its own when the publisher is a message bus, from localCursor
+	local       EventPublisher
+	localCursor int64
+}
+
+// NewRelay creates a relay which checks the event log for undelivered events every time events are
+// appended and, to pick up the ones appended by other processes or not delivered after errors, every interval
+func NewRelay(eventLog database.EventLogInterface, publisher EventPublisher, interval time.Duration) *Relay {
+	if interval <= 0 {
+		interval = DefaultRelayInterval
+	}
+	return &Relay{eventLog: eventLog, publisher: publisher, interval: interval}
+}
+
+// NewBusRelay creates a relay delivering the events to a message bus through the publisher, which also publishes
+// them to the local publisher feeding the streams of this process. The local publisher reads the event log with its
+// own cursor, so the streams keep receiving the events while the bus fails. It starts at the events appended after
+// the relay runs, since streams replay the older ones from the event log
+func NewBusRelay(eventLog database.EventLogInterface, bus, local EventPublisher, interval time.Duration) *Relay {
+	relay := NewRelay(eventLog, bus, interval)
+	relay.local = local
+	relay.localCursor = -1
+	return relay
+}
+
+// Run relays the undelivered events until the received context ends
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := r.relayLocal(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Could not relay user events to the streams: %v", err)
+		}
+		if err := r.relayPending(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Could not relay user events: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.eventLog.Appended():
+		case <-ticker.C:
+		}
+	}
+}
+
+// relayPending publishes every undelivered event, marking each batch delivered once it is published.
+// When the publisher fails, the events published before are marked delivered and the rest are retried later.
+// Only the published sequences are marked: without transactions, an event with a lower sequence may be stored
+// after the batch was read, and it is published by the next one
+func (r *Relay) relayPending(ctx context.Context) error {
+	for {
+		events, err := r.eventLog.ListUndeliveredEvents(ctx, relayBatchSize)
+		if err != nil || len(events) == 0 {
+			return err
+		}
+
+		published := make([]int64, 0, len(events))
+		for _, event := range events {
+			if err := r.publisher.Publish(ctx, event); err != nil {
+				_ = r.eventLog.MarkEventsDelivered(ctx, published)
+				return fmt.Errorf("could not publish event %d: %w", event.Sequence, err)
+			}
+			published = append(published, event.Sequence)
+		}
+		if err := r.eventLog.MarkEventsDelivered(ctx, published); err != nil {
+			return err
+		}
+		if len(events) < relayBatchSize {
+			return nil
+		}
+	}
+}
+
+// relayLocal publishes to the local publisher the events stored after its cursor, when the relay has one. Like the
+// webhook dispatcher, it waits for missing sequences until the event after them is older than the gap timeout
+func (r *Relay) relayLocal(ctx context.Context) error {
+	if r.local == nil {
+		return nil
+	}
+	if r.localCursor < 0 {
+		last, err := r.eventLog.LastSequence(ctx)
+		if err != nil {
+			return err
+		}
+		r.localCursor = last
+	}
+
+	for {
+		listed, err := r.eventLog.ListEvents(ctx, database.EventQuery{After: r.localCursor, Limit: relayBatchSize})
+		if err != nil || len(listed) == 0 {
+			return err
+		}
+		events, gaps := database.ContiguousEvents(r.localCursor, listed, time.Now())
+		for _, gap := range gaps {
+			log.Printf("Streams skipped the event sequences %d to %d, which were not stored within %v",
+				gap.First, gap.Last, database.SequenceGapTimeout)
+		}
+		for _, event := range events {
+			if err := r.local.Publish(ctx, event); err != nil {
+				return fmt.Errorf("could not publish event %d: %w", event.Sequence, err)
+			}
+			r.localCursor = event.Sequence
+		}
+		if len(events) < len(listed) || len(listed) < relayBatchSize {
+			return nil
+		}
+	}
+}
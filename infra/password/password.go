@@ -0,0 +1,89 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"golang.org/x/crypto/argon2"
+	"userManagement/entities"
+)
+
+const (
+	AlgorithmArgon2id = "argon2id"
+)
+
+// Params are the argon2id cost parameters used to derive a password key
+type Params struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+var (
+	// DefaultParams follows the argon2id recommendations from RFC 9106 for memory constrained environments
+	DefaultParams = Params{
+		Memory:      64 * 1024,
+		Iterations:  3,
+		Parallelism: 2,
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+)
+
+// Hash derives a key from the received password using argon2id and a random salt
+func Hash(password string, params Params) (*entities.PasswordHash, error) {
+	salt := make([]byte, params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	return &entities.PasswordHash{
+		Algorithm:   AlgorithmArgon2id,
+		Memory:      params.Memory,
+		Iterations:  params.Iterations,
+		Parallelism: params.Parallelism,
+		Salt:        salt,
+		Key:         argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength),
+	}, nil
+}
+
+// VerifyMissing derives a key from the password as Verify does for a user hashed with the params, reporting nothing.
+// Checking the password of a missing user takes as long as the one of an existing user, so timing does not tell
+// which emails are registered
+func VerifyMissing(password string, params Params) {
+	missing := entities.User{PasswordHash: &entities.PasswordHash{
+		Algorithm:   AlgorithmArgon2id,
+		Memory:      params.Memory,
+		Iterations:  params.Iterations,
+		Parallelism: params.Parallelism,
+		Salt:        make([]byte, params.SaltLength),
+		Key:         make([]byte, params.KeyLength),
+	}}
+	Verify(password, missing, params)
+}
+
+// Verify checks the received password against the stored user credentials.
+// Users that still have a plain text password or a hash derived with a lower cost than the received
+// params are reported as needing a rehash, which should only be done after a successful verification.
+func Verify(password string, user entities.User, params Params) (valid bool, needsRehash bool) {
+	if user.PasswordHash == nil {
+		if user.Password == "" {
+			return false, false
+		}
+		return subtle.ConstantTimeCompare([]byte(password), []byte(user.Password)) == 1, true
+	}
+
+	hash := user.PasswordHash
+	if hash.Algorithm != AlgorithmArgon2id {
+		return false, false
+	}
+
+	key := argon2.IDKey([]byte(password), hash.Salt, hash.Iterations, hash.Memory, hash.Parallelism, uint32(len(hash.Key)))
+	valid = subtle.ConstantTimeCompare(key, hash.Key) == 1
+
+	return valid, hash.Memory < params.Memory ||
+		hash.Iterations < params.Iterations ||
+		hash.Parallelism < params.Parallelism ||
+		uint32(len(hash.Key)) < params.KeyLength
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"context"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"log"
+	"time"
+	"userManagement/entities"
+	"userManagement/infra/auth"
+	pb "userManagement/proto"
+)
+
+// CreateAPIKey issues an API key for a service with the received scopes and expiry.
+// The key is only returned in this response
+func (s *UserManagementServer) CreateAPIKey(ctx context.Context, in *pb.CreateAPIKeyReq) (*pb.APIKey, error) {
+	log.Printf("Received API key creation request for service %s", in.GetApiKey().GetService())
+	if err := s.authorize(ctx, auth.PermissionAPIKeys); err != nil {
+		return nil, err
+	}
+	if s.APIKeys == nil {
+		return nil, entities.APIKeysDisabledError
+	}
+
+	var expiresAt time.Time
+	if in.GetApiKey().GetExpiresAt() != nil {
+		expiresAt = in.GetApiKey().GetExpiresAt().AsTime()
+	}
+	key, secret, err := s.APIKeys.Create(ctx, in.GetApiKey().GetService(), in.GetApiKey().GetScopes(), expiresAt)
+	if err != nil {
+		log.Printf("Could not create API key: %v", err)
+		return nil, err
+	}
+	log.Printf("API key %s created for service %s", key.ID, key.Service)
+	return getPbAPIKey(key, secret), nil
+}
+
+// ListAPIKeys retrieves the API keys of a service, or every key, without the keys themselves
+func (s *UserManagementServer) ListAPIKeys(ctx context.Context, in *pb.ListAPIKeysReq) (*pb.ListAPIKeysResponse, error) {
+	if err := s.authorize(ctx, auth.PermissionAPIKeys); err != nil {
+		return nil, err
+	}
+	if s.APIKeys == nil {
+		return nil, entities.APIKeysDisabledError
+	}
+
+	keys, err := s.APIKeys.List(ctx, in.Service)
+	if err != nil {
+		log.Printf("Could not list API keys: %v", err)
+		return nil, err
+	}
+	response := &pb.ListAPIKeysResponse{ApiKeys: make([]*pb.APIKey, 0, len(keys))}
+	for _, key := range keys {
+		response.ApiKeys = append(response.ApiKeys, getPbAPIKey(key, ""))
+	}
+	return response, nil
+}
+
+// RotateAPIKey replaces an API key which was not revoked with a new one, which is only returned in this response.
+// The previous key stops being valid at once
+func (s *UserManagementServer) RotateAPIKey(ctx context.Context, in *pb.RotateAPIKeyReq) (*pb.APIKey, error) {
+	log.Printf("Received API key rotation request for %s", in.ApiKeyId)
+	if err := s.authorize(ctx, auth.PermissionAPIKeys); err != nil {
+		return nil, err
+	}
+	if s.APIKeys == nil {
+		return nil, entities.APIKeysDisabledError
+	}
+
+	key, secret, err := s.APIKeys.Rotate(ctx, in.ApiKeyId)
+	if err != nil {
+		log.Printf("Could not rotate API key %s: %v", in.ApiKeyId, err)
+		return nil, err
+	}
+	log.Printf("API key %s rotated", key.ID)
+	return getPbAPIKey(key, secret), nil
+}
+
+// RevokeAPIKey revokes an API key, which can no longer authenticate requests
+func (s *UserManagementServer) RevokeAPIKey(ctx context.Context, in *pb.RevokeAPIKeyReq) (*pb.APIKey, error) {
+	log.Printf("Received API key revocation request for %s", in.ApiKeyId)
+	if err := s.authorize(ctx, auth.PermissionAPIKeys); err != nil {
+		return nil, err
+	}
+	if s.APIKeys == nil {
+		return nil, entities.APIKeysDisabledError
+	}
+
+	key, err := s.APIKeys.Revoke(ctx, in.ApiKeyId)
+	if err != nil {
+		log.Printf("Could not revoke API key %s: %v", in.ApiKeyId, err)
+		return nil, err
+	}
+	log.Printf("API key %s revoked", key.ID)
+	return getPbAPIKey(key, ""), nil
+}
+
+// getPbAPIKey builds the protobuf API key from the stored one, along with the key when it was just issued
+func getPbAPIKey(key *entities.APIKey, secret string) *pb.APIKey {
+	return &pb.APIKey{
+		Id:         key.ID,
+		Service:    key.Service,
+		Scopes:     key.Scopes,
+		Key:        secret,
+		CreatedAt:  getPbTimestamp(key.CreatedAt),
+		ExpiresAt:  getPbTimestamp(key.ExpiresAt),
+		RotatedAt:  getPbTimestamp(key.RotatedAt),
+		LastUsedAt: getPbTimestamp(key.LastUsedAt),
+		RevokedAt:  getPbTimestamp(key.RevokedAt),
+	}
+}
+
+// getPbTimestamp returns the protobuf timestamp of a time, which is unset when the time is zero
+func getPbTimestamp(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"context"
+	"log"
+	"userManagement/entities"
+	"userManagement/infra/auth"
+	pb "userManagement/proto"
+)
+
+// Login checks the password of the user with the received email and issues a signed access token, along with
+// a refresh token which obtains new ones
+func (s *UserManagementServer) Login(ctx context.Context, in *pb.LoginReq) (*pb.TokenResponse, error) {
+	log.Printf("Received login request for %s", in.Email)
+	if s.Auth == nil {
+		return nil, entities.AuthDisabledError
+	}
+	if in.Email == "" || in.Password == "" {
+		return nil, entities.InvalidCredentialsError
+	}
+
+	verification, err := s.DbClient.VerifyPassword(ctx, &pb.VerifyPasswordReq{UserId: in.Email, Password: in.Password})
+	if err != nil {
+		log.Printf("Could not verify password: %v", err)
+		return nil, err
+	}
+	if !verification.Valid {
+		log.Printf("Login of %s failed", in.Email)
+		return nil, entities.InvalidCredentialsError
+	}
+
+	subject, err := s.getSubject(ctx, verification.Id)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := s.Auth.Login(ctx, subject)
+	if err != nil {
+		log.Printf("Could not issue tokens: %v", err)
+		return nil, err
+	}
+	log.Printf("User %s logged in", subject.UserID)
+	return tokens, nil
+}
+
+// RefreshToken exchanges a refresh token, which cannot be used again, for a new access and refresh token
+func (s *UserManagementServer) RefreshToken(ctx context.Context, in *pb.RefreshTokenReq) (*pb.TokenResponse, error) {
+	if s.Auth == nil {
+		return nil, entities.AuthDisabledError
+	}
+
+	tokens, err := s.Auth.Refresh(ctx, in.RefreshToken, s.getSubject)
+	if err != nil {
+		log.Printf("Could not refresh tokens: %v", err)
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// Logout revokes every refresh token obtained from the same login as the received one.
+// Access tokens already issued are valid until they expire
+func (s *UserManagementServer) Logout(ctx context.Context, in *pb.LogoutReq) (*pb.LogoutResponse, error) {
+	if s.Auth == nil {
+		return nil, entities.AuthDisabledError
+	}
+
+	revoked, err := s.Auth.Logout(ctx, in.RefreshToken)
+	if err != nil {
+		log.Printf("Could not log out: %v", err)
+		return nil, err
+	}
+	return &pb.LogoutResponse{Revoked: revoked}, nil
+}
+
+// getSubject returns the user the tokens are issued to, as it is stored
+func (s *UserManagementServer) getSubject(ctx context.Context, userID string) (auth.Subject, error) {
+	user, err := s.DbClient.GetUser(ctx, &pb.GetUserReq{UserId: userID})
+	if err != nil {
+		return auth.Subject{}, err
+	}
+	return auth.Subject{UserID: user.Id, Email: user.GetUser().GetEmail(), Roles: user.Roles}, nil
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"context"
+	"google.golang.org/grpc/metadata"
+	"userManagement/infra/auth"
+	"userManagement/infra/database"
+)
+
+const (
+	// requestIDHeader identifies the request which performed a change, the gateway forwards it from X-Request-Id
+	requestIDHeader = "x-request-id"
+	// anonymousActor is the actor of the changes performed by requests which are not authenticated
+	anonymousActor = "anonymous"
+)
+
+// withEventMetadata adds who performs the request and its id to the context, so the database
+// describes the changes done with it in their events
+func withEventMetadata(ctx context.Context) context.Context {
+	return database.WithEventMetadata(ctx, database.EventMetadata{
+		Actor:     getActor(ctx),
+		RequestID: getRequestID(ctx),
+	})
+}
+
+// getActor returns who performed the request, the principal added by the authentication interceptor
+func getActor(ctx context.Context) string {
+	if principal, found := auth.PrincipalFromContext(ctx); found {
+		return principal.String()
+	}
+	return anonymousActor
+}
+
+// getRequestID returns the id received in the request metadata, or an empty one when the request has none
+func getRequestID(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(requestIDHeader); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
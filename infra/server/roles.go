@@ -0,0 +1,114 @@
+package server
+
+import (
+	"context"
+	"log"
+	"userManagement/entities"
+	"userManagement/infra/auth"
+	pb "userManagement/proto"
+)
+
+// GrantRole grants the support or admin role to a user found by id or email, which requires managing roles.
+// The user gets the permissions of the role once its access token is refreshed
+func (s *UserManagementServer) GrantRole(ctx context.Context, in *pb.GrantRoleReq) (*pb.UserActionResponse, error) {
+	log.Printf("Received request to grant role %s to user %s", in.Role, in.UserId)
+	if err := s.authorize(ctx, auth.PermissionRoles); err != nil {
+		return nil, err
+	}
+	if !auth.IsGrantableRole(in.Role) {
+		return nil, entities.InvalidRoleError
+	}
+
+	user, err := s.DbClient.GrantRole(ctx, in)
+	if err != nil {
+		log.Printf("Could not grant role: %v", err)
+		return nil, err
+	}
+
+	sendETag(ctx, user.Version)
+	log.Printf("User %s has roles %v", user.Id, user.Roles)
+	return user, nil
+}
+
+// RevokeRole revokes the support or admin role of a user found by id or email, which requires managing roles.
+// Access tokens already issued keep the role until they are refreshed
+func (s *UserManagementServer) RevokeRole(ctx context.Context, in *pb.RevokeRoleReq) (*pb.UserActionResponse, error) {
+	log.Printf("Received request to revoke role %s of user %s", in.Role, in.UserId)
+	if err := s.authorize(ctx, auth.PermissionRoles); err != nil {
+		return nil, err
+	}
+	if !auth.IsGrantableRole(in.Role) {
+		return nil, entities.InvalidRoleError
+	}
+
+	user, err := s.DbClient.RevokeRole(ctx, in)
+	if err != nil {
+		log.Printf("Could not revoke role: %v", err)
+		return nil, err
+	}
+
+	sendETag(ctx, user.Version)
+	log.Printf("User %s has roles %v", user.Id, user.Roles)
+	return user, nil
+}
+
+// authorize checks that the caller has the permission. Calls without credentials are denied,
+// unless the server trusts them
+func (s *UserManagementServer) authorize(ctx context.Context, permission auth.Permission) error {
+	principal, found := credentialedPrincipal(ctx)
+	if !found {
+		return s.authorizeAnonymous(permission)
+	}
+	if principal.Can(permission) {
+		return nil
+	}
+	log.Printf("Permission %s denied to %s", permission, principal)
+	return entities.PermissionDeniedError(string(permission))
+}
+
+// authorizeUser checks that the caller can act on the user found by id or email, either on any user with the
+// permission or on itself with the self permission. Callers lacking both are denied whether the user exists or not
+func (s *UserManagementServer) authorizeUser(ctx context.Context, userID string, self, permission auth.Permission) error {
+	principal, found := credentialedPrincipal(ctx)
+	if !found {
+		return s.authorizeAnonymous(permission)
+	}
+	if principal.Can(permission) {
+		return nil
+	}
+	if principal.Kind == auth.PrincipalUser && principal.Can(self) && s.isSelf(ctx, principal, userID) {
+		return nil
+	}
+	log.Printf("Permission %s denied to %s on user %s", permission, principal, userID)
+	return entities.PermissionDeniedError(string(permission))
+}
+
+// authorizeAnonymous lets the calls without credentials through only when the server trusts them. Otherwise they
+// are public method calls of anonymous callers, or calls the authenticator did not see, which are both denied
+func (s *UserManagementServer) authorizeAnonymous(permission auth.Permission) error {
+	if s.TrustUnauthenticated {
+		return nil
+	}
+	log.Printf("Permission %s denied to an anonymous caller", permission)
+	return entities.MissingCredentialsError
+}
+
+// credentialedPrincipal returns the principal of calls with credentials. The ones identified by their client
+// certificate alone are anonymous calls to public methods, so they are not found either
+func credentialedPrincipal(ctx context.Context) (*auth.Principal, bool) {
+	principal, found := auth.PrincipalFromContext(ctx)
+	if !found || principal.Kind == auth.PrincipalCertificate {
+		return nil, false
+	}
+	return principal, true
+}
+
+// isSelf reports whether the user found by id or email is the principal. Users found by email are looked up,
+// since the email of the access token may now belong to another user, if its owner was deleted
+func (s *UserManagementServer) isSelf(ctx context.Context, principal *auth.Principal, userID string) bool {
+	if userID == principal.ID {
+		return true
+	}
+	user, err := s.DbClient.GetUser(ctx, &pb.GetUserReq{UserId: userID})
+	return err == nil && user.Id == principal.ID
+}
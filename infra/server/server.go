@@ -2,94 +2,159 @@ package server
 
 import (
 	"context"
-	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 	"log"
+	"userManagement/infra/auth"
+	"userManagement/infra/cloudevents"
 	"userManagement/infra/database"
+	"userManagement/infra/notification"
 	pb "userManagement/proto"
 )
 
+// replayPageSize is the number of stored events read at once when replaying them
+const replayPageSize = 500
+
 type UserManagementServer struct {
 	pb.UnimplementedUserManagementServer
-	DbClient      database.AdapterInterface
-	NotifyChannel chan []string
+	DbClient database.AdapterInterface
+	Broker   *notification.Broker
+	// EventLog is where the database stores the event of every change, which a relay publishes to the broker.
+	// Streams replay the events they missed from it, events are only streamed live when it is nil
+	EventLog database.EventLogInterface
+	// Webhooks stores the registered webhooks and their deliveries, webhook requests fail when it is nil
+	Webhooks database.WebhookStoreInterface
+	// CloudEvents holds the attributes shared by the events streamed as CloudEvents
+	CloudEvents cloudevents.Config
+	// Auth issues the tokens obtained by logging in, authentication requests fail when it is nil
+	Auth *auth.Issuer
+	// APIKeys issues the API keys of services, API key requests fail when it is nil
+	APIKeys *auth.APIKeys
+	// TrustUnauthenticated authorizes every call without a principal. It is only meant for servers without
+	// authenticator, as in tests, whose callers are never authenticated
+	TrustUnauthenticated bool
 }
 
 // CreateUser creates a new user from the received request and returns user details
-// It sends a creation action notification
+// The database stores a creation event along with the user
 func (s *UserManagementServer) CreateUser(ctx context.Context, in *pb.CreateUserReq) (*pb.UserActionResponse, error) {
-	user := in.GetUser()
-	log.Printf("Received: %v", user)
+	// Only the email is logged, the request carries the password in plain text
+	log.Printf("Received request to create user %s", in.GetUser().GetEmail())
 
 	// Store new user in database
-	userId, err := s.DbClient.CreateUser(in)
+	userId, err := s.DbClient.CreateUser(withEventMetadata(ctx), in)
 	if err != nil {
 		return nil, err
 	}
 	log.Printf("User created!")
 
-	createdUser, err := s.GetUser(ctx, &pb.GetUserReq{UserId: userId})
+	// The created user is read from the database, since the caller may not be allowed to get it
+	createdUser, err := s.DbClient.GetUser(ctx, &pb.GetUserReq{UserId: userId})
 
 	if err != nil {
 		log.Printf("Could not retrieve created user data")
 		return nil, err
 	}
 
-	go s.notify(in.User.String(), "Created")
+	sendETag(ctx, createdUser.Version)
 	return createdUser, nil
 }
 
-// GetUser retrieves a user by id or by email
-// it sends a retrieving action notification
+// GetUser retrieves a user by id or by email. Users can only get themselves, unless they can read any user
 func (s *UserManagementServer) GetUser(ctx context.Context, in *pb.GetUserReq) (*pb.UserActionResponse, error) {
 	log.Printf("Received get user request: %v", in)
+	if err := s.authorizeUser(ctx, in.UserId, auth.PermissionReadSelf, auth.PermissionRead); err != nil {
+		return nil, err
+	}
 
-	user, err := s.DbClient.GetUser(in)
+	user, err := s.DbClient.GetUser(ctx, in)
 	if err != nil {
 		log.Printf("Failed when trying to retrieve user: %v", err)
 		return nil, err
 	}
 
-	go s.notify(in.UserId, "Retrieved")
+	sendETag(ctx, user.Version)
 	log.Printf("User successfully retrieved: %v", user)
 	return user, nil
 }
 
-// UpdateUser updates a user, who is found by email or ID. It uses the body of the request to update.
-// It sends an update action notification.
+// UpdateUser updates a user, who is found by email or ID. It uses the body of the request to update,
+// changing only the fields in the update mask when one is received.
+// When an expected version or an If-Match header is received, the user is only updated if its version matches.
+// The database stores an update event with the user before and after the update.
+// Users can only update themselves, unless they can update any user. Only the user itself and the callers allowed
+// to set any password can replace it, so the users who can edit others cannot take over their accounts
 func (s *UserManagementServer) UpdateUser(ctx context.Context, in *pb.UpdateUserReq) (*pb.UserActionResponse, error) {
-	log.Printf("Received update user request: %v", in)
+	// The update may carry a new password, so only the user and the updated fields are logged
+	log.Printf("Received update user request for user %s, fields %v", in.UserId, in.GetUpdateMask().GetPaths())
+	if err := s.authorizeUser(ctx, in.UserId, auth.PermissionUpdateSelf, auth.PermissionUpdate); err != nil {
+		return nil, err
+	}
+	if database.UpdatesPassword(in) {
+		if err := s.authorizeUser(ctx, in.UserId, auth.PermissionUpdateSelf, auth.PermissionUpdatePassword); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := database.ValidateUpdateMask(in.UpdateMask); err != nil {
+		log.Printf("Invalid update mask: %v", err)
+		return nil, err
+	}
+
+	expectedVersion, err := getExpectedVersion(ctx, in.ExpectedVersion)
+	if err != nil {
+		return nil, err
+	}
+	in.ExpectedVersion = expectedVersion
 
-	user, err := s.DbClient.UpdateUser(in)
+	user, err := s.DbClient.UpdateUser(withEventMetadata(ctx), in)
 	if err != nil {
 		log.Printf("Could not update user: %v", err)
 		return nil, err
 	}
 
-	go s.notify(in.UserId, "Updated")
+	sendETag(ctx, user.Version)
 	log.Printf("User successfully updated: %v", user)
 	return user, nil
 }
 
 // DeleteUser removes a user from the database by ID or email
-// Sends a deletion action notification
+// When an expected version or an If-Match header is received, the user is only deleted if its version matches.
+// The database stores a deletion event with the deleted user, unless it did not exist.
+// Users can only delete themselves, unless they can delete any user
 func (s *UserManagementServer) DeleteUser(ctx context.Context, in *pb.DeleteUserReq) (*pb.DeletionActionResponse, error) {
 	log.Printf("Received deletion user request: %v", in)
-	_, err := s.DbClient.DeleteUser(in)
+	if err := s.authorizeUser(ctx, in.UserId, auth.PermissionDeleteSelf, auth.PermissionDelete); err != nil {
+		return &pb.DeletionActionResponse{Deleted: false}, err
+	}
+
+	expectedVersion, err := getExpectedVersion(ctx, in.ExpectedVersion)
+	if err != nil {
+		return &pb.DeletionActionResponse{Deleted: false}, err
+	}
+	in.ExpectedVersion = expectedVersion
+
+	_, err = s.DbClient.DeleteUser(withEventMetadata(ctx), in)
 
 	if err != nil {
 		log.Printf("Could not delete : %v", err)
 		return &pb.DeletionActionResponse{Deleted: false}, err
 	}
 
-	go s.notify(in.UserId, "Deleted")
 	log.Printf("User deleted")
 	return &pb.DeletionActionResponse{Deleted: true}, nil
 }
 
-// ListUsers retrieves all stored users
+// ListUsers retrieves all stored users, which requires the permission to list them
 func (s *UserManagementServer) ListUsers(ctx context.Context, in *pb.ListUsersReq) (*pb.ListActionResponse, error) {
 	log.Printf("Retrieving all users...")
-	users, err := s.DbClient.GetAllUsers(in)
+	if err := s.authorize(ctx, auth.PermissionList); err != nil {
+		return nil, err
+	}
+
+	users, err := s.DbClient.GetAllUsers(ctx, in)
 	if err != nil {
 		log.Printf("Could not obtain user list: %v", err)
 		return nil, err
@@ -99,31 +164,144 @@ func (s *UserManagementServer) ListUsers(ctx context.Context, in *pb.ListUsersRe
 	return users, nil
 }
 
-// NotifyUserChanges creates a stream where action notifications are received.
-func (s *UserManagementServer) NotifyUserChanges(msg *pb.EmptyMsg, server pb.UserManagement_NotifyUserChangesServer) error {
+// VerifyPassword checks whether the received password matches the one stored for a user found by id or email.
+// The stored password hash is never returned. Users can only verify their own password, unless they can verify any
+func (s *UserManagementServer) VerifyPassword(ctx context.Context, in *pb.VerifyPasswordReq) (*pb.VerifyPasswordResponse, error) {
+	log.Printf("Received password verification request for user: %s", in.UserId)
+	if err := s.authorizeUser(ctx, in.UserId, auth.PermissionReadSelf, auth.PermissionVerifyPassword); err != nil {
+		return nil, err
+	}
+
+	verification, err := s.DbClient.VerifyPassword(ctx, in)
+	if err != nil {
+		log.Printf("Could not verify password: %v", err)
+		return nil, err
+	}
+
+	log.Printf("Password verification finished, valid: %t", verification.Valid)
+	return verification, nil
+}
+
+// NotifyUserChanges creates a stream where the events of the user changes matching the request filters are received.
+// When a sequence to resume after or a timestamp are received, the stored events are replayed before the new ones.
+// Every stream receives all the events, until the client disconnects or it is too slow to receive them
+// and the broker policy is to disconnect slow consumers.
+func (s *UserManagementServer) NotifyUserChanges(in *pb.NotifyUserChangesReq, server pb.UserManagement_NotifyUserChangesServer) error {
+	return s.streamEvents(server.Context(), in, server.Send)
+}
+
+// NotifyUserCloudEvents streams the same events as NotifyUserChanges, encoded in the CloudEvents protobuf format
+func (s *UserManagementServer) NotifyUserCloudEvents(in *pb.NotifyUserChangesReq, server pb.UserManagement_NotifyUserCloudEventsServer) error {
+	return s.streamEvents(server.Context(), in, func(event *pb.UserEvent) error {
+		cloudEvent, err := cloudevents.New(s.CloudEvents, event).Proto()
+		if err != nil {
+			return err
+		}
+		return server.Send(cloudEvent)
+	})
+}
+
+// streamEvents sends the events selected by the request until the context ends or the subscription is closed,
+// replaying the stored ones first when the request asks for them
+func (s *UserManagementServer) streamEvents(ctx context.Context, in *pb.NotifyUserChangesReq, send func(*pb.UserEvent) error) error {
 	log.Printf("Server side streaming started.")
+	if err := s.authorize(ctx, auth.PermissionWatch); err != nil {
+		return err
+	}
+	filter, err := notification.NewFilter(in)
+	if err != nil {
+		log.Printf("Invalid event filter: %v", err)
+		return err
+	}
+
+	// Subscribing before replaying keeps the events published meanwhile
+	sub := s.Broker.Subscribe(ctx)
+	defer sub.Close()
+	// Headers tell the client the stream was accepted before any event is sent
+	if err := grpc.SendHeader(ctx, metadata.MD{}); err != nil {
+		log.Printf("Could not send stream headers: %v", err)
+	}
+
+	var last int64
+	if in.GetResumeAfter() > 0 || in.GetSinceTimestamp() != nil {
+		query := database.EventQuery{After: in.GetResumeAfter()}
+		if query.After == 0 {
+			query.Since = in.GetSinceTimestamp().AsTime()
+		}
+		if last, err = s.replayEvents(ctx, send, filter, query); err != nil {
+			return err
+		}
+	}
+
 	for {
 		select {
-		case n := <-s.NotifyChannel:
-			log.Printf("Action notification received")
-			notification := pb.UserActionStream{}
-			action := fmt.Sprintf("User action performed: %s - %s", n[0], n[1])
-			notification.Action = action
+		case event := <-sub.Notifications():
+			// Events the buffer dropped are replayed from the event log before the next one
+			if last > 0 && event.Sequence > last+1 {
+				replayed, err := s.replayEvents(ctx, send, filter, database.EventQuery{After: last})
+				if err != nil {
+					return err
+				}
+				if replayed > last {
+					last = replayed
+				}
+			}
+			if event.Sequence != 0 && event.Sequence <= last {
+				continue
+			}
+			// Filtered events still count as received, so they are not taken for dropped ones
+			if !filter.Matches(event) {
+				last = event.Sequence
+				continue
+			}
 
-			err := server.Send(&notification)
+			log.Printf("User event received: %s %s", event.Type, event.EventId)
+			err := send(event)
 			if err != nil {
-				log.Printf("Could not send notification %s: %v", action, err)
+				log.Printf("Could not send event %s: %v", event.EventId, err)
 				return err
 			}
+			last = event.Sequence
+		case <-sub.Done():
+			if sub.Err() == notification.ErrSlowConsumer {
+				log.Printf("Stream disconnected after dropping %d notifications", sub.Dropped())
+				return status.Error(codes.ResourceExhausted, sub.Err().Error())
+			}
+			log.Printf("Server side streaming finished.")
+			return nil
 		}
 	}
 }
 
-// notify sends a notification through the server channel to later be sent through
-// a server side streaming
-func (s *UserManagementServer) notify(userEmail, action string) {
-	log.Print("Sending action notification...")
-	notification := []string{userEmail, action}
-	s.NotifyChannel <- notification
-	return
+// replayEvents sends the stored events matching the query and the filter, returning the sequence of the last one read.
+// No events are sent without event log
+func (s *UserManagementServer) replayEvents(ctx context.Context, send func(*pb.UserEvent) error, filter *notification.Filter, query database.EventQuery) (int64, error) {
+	last := query.After
+	if s.EventLog == nil {
+		return last, nil
+	}
+
+	query.Limit = replayPageSize
+	for {
+		events, err := s.EventLog.ListEvents(ctx, query)
+		if err != nil {
+			log.Printf("Could not replay events: %v", err)
+			return last, err
+		}
+		for _, event := range events {
+			if !filter.Matches(event) {
+				last = event.Sequence
+				continue
+			}
+			if err := send(event); err != nil {
+				log.Printf("Could not send event %s: %v", event.EventId, err)
+				return last, err
+			}
+			last = event.Sequence
+		}
+		if len(events) < query.Limit {
+			return last, nil
+		}
+		query = database.EventQuery{After: last, Limit: replayPageSize}
+	}
 }
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"strconv"
+	"strings"
+	"userManagement/entities"
+)
+
+const (
+	// etagHeader is the response header carrying the user version, sent by the gateway as ETag
+	etagHeader = "etag"
+	// The version precondition can be sent directly by gRPC clients, or forwarded by the gateway
+	// from the HTTP If-Match header
+	ifMatchHeader        = "if-match"
+	gatewayIfMatchHeader = "grpcgateway-if-match"
+)
+
+// ETag returns the entity tag identifying the received user version
+func ETag(version int64) string {
+	return fmt.Sprintf("%q", strconv.FormatInt(version, 10))
+}
+
+// parseETag returns the user version identified by an entity tag. A wildcard matches every version, so it returns 0
+func parseETag(etag string) (int64, error) {
+	etag = strings.TrimPrefix(strings.TrimSpace(etag), "W/")
+	if etag == "*" {
+		return 0, nil
+	}
+
+	version, err := strconv.ParseInt(strings.Trim(etag, `"`), 10, 64)
+	if err != nil || version <= 0 {
+		return 0, entities.InvalidVersionError
+	}
+	return version, nil
+}
+
+// getExpectedVersion returns the version precondition of a request. It is taken from the request field,
+// or from an If-Match header when the field is not set
+func getExpectedVersion(ctx context.Context, version int64) (int64, error) {
+	if version != 0 {
+		return version, nil
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, header := range []string{ifMatchHeader, gatewayIfMatchHeader} {
+		if values := md.Get(header); len(values) > 0 {
+			return parseETag(values[0])
+		}
+	}
+	return 0, nil
+}
+
+// sendETag sets the user version as ETag response header. It is ignored outside of a gRPC call
+func sendETag(ctx context.Context, version int64) {
+	_ = grpc.SetHeader(ctx, metadata.Pairs(etagHeader, ETag(version)))
+}
@@ -0,0 +1,237 @@
+package server
+
+import (
+	"context"
+	"google.golang.org/protobuf/proto"
+	"log"
+	"userManagement/entities"
+	"userManagement/infra/auth"
+	"userManagement/infra/database"
+	"userManagement/infra/webhook"
+	pb "userManagement/proto"
+)
+
+const (
+	// defaultDeliveriesPageSize and maxDeliveriesPageSize bound the number of webhook deliveries listed at once
+	defaultDeliveriesPageSize = 50
+	maxDeliveriesPageSize     = 500
+)
+
+// CreateWebhook registers a webhook which receives the events happening from now on.
+// The secret signing its deliveries is only returned in this response
+func (s *UserManagementServer) CreateWebhook(ctx context.Context, in *pb.CreateWebhookReq) (*pb.Webhook, error) {
+	log.Printf("Received webhook creation request for %s", in.GetWebhook().GetUrl())
+	if err := s.authorize(ctx, auth.PermissionWebhooks); err != nil {
+		return nil, err
+	}
+	if s.Webhooks == nil {
+		return nil, entities.WebhooksDisabledError
+	}
+
+	hook := &pb.Webhook{
+		Url:      in.GetWebhook().GetUrl(),
+		Types:    in.GetWebhook().GetTypes(),
+		Secret:   in.GetWebhook().GetSecret(),
+		Disabled: in.GetWebhook().GetDisabled(),
+		Format:   in.GetWebhook().GetFormat(),
+	}
+	if err := webhook.Validate(hook); err != nil {
+		log.Printf("Invalid webhook: %v", err)
+		return nil, err
+	}
+	if hook.Secret == "" {
+		hook.Secret = webhook.NewSecret()
+	}
+
+	created, err := s.Webhooks.CreateWebhook(ctx, hook)
+	if err != nil {
+		log.Printf("Could not create webhook: %v", err)
+		return nil, err
+	}
+	log.Printf("Webhook %s created", created.Id)
+	return created, nil
+}
+
+// GetWebhook retrieves a webhook by id, without its secret
+func (s *UserManagementServer) GetWebhook(ctx context.Context, in *pb.GetWebhookReq) (*pb.Webhook, error) {
+	if err := s.authorize(ctx, auth.PermissionWebhooks); err != nil {
+		return nil, err
+	}
+	if s.Webhooks == nil {
+		return nil, entities.WebhooksDisabledError
+	}
+
+	hook, err := s.Webhooks.GetWebhook(ctx, in.WebhookId)
+	if err != nil {
+		log.Printf("Could not retrieve webhook %s: %v", in.WebhookId, err)
+		return nil, err
+	}
+	return withoutSecret(hook), nil
+}
+
+// UpdateWebhook replaces the fields of a webhook in the update mask, or all of them but the secret when there
+// is no mask. The secret is also replaced when a new one is received without mask
+func (s *UserManagementServer) UpdateWebhook(ctx context.Context, in *pb.UpdateWebhookReq) (*pb.Webhook, error) {
+	log.Printf("Received webhook update request for %s", in.WebhookId)
+	if err := s.authorize(ctx, auth.PermissionWebhooks); err != nil {
+		return nil, err
+	}
+	if s.Webhooks == nil {
+		return nil, entities.WebhooksDisabledError
+	}
+
+	hook, err := s.Webhooks.GetWebhook(ctx, in.WebhookId)
+	if err != nil {
+		log.Printf("Could not update webhook %s: %v", in.WebhookId, err)
+		return nil, err
+	}
+
+	update := in.GetWebhook()
+	paths := in.GetUpdateMask().GetPaths()
+	if len(paths) == 0 {
+		paths = []string{"url", "types", "disabled", "format"}
+		if update.GetSecret() != "" {
+			paths = append(paths, "secret")
+		}
+	}
+	for _, path := range paths {
+		switch path {
+		case "url":
+			hook.Url = update.GetUrl()
+		case "types":
+			hook.Types = update.GetTypes()
+		case "secret":
+			hook.Secret = update.GetSecret()
+			if hook.Secret == "" {
+				hook.Secret = webhook.NewSecret()
+			}
+		case "disabled":
+			hook.Disabled = update.GetDisabled()
+		case "format":
+			hook.Format = update.GetFormat()
+		default:
+			return nil, entities.UnknownWebhookFieldError(path)
+		}
+	}
+	if err := webhook.Validate(hook); err != nil {
+		log.Printf("Invalid webhook: %v", err)
+		return nil, err
+	}
+
+	updated, err := s.Webhooks.UpdateWebhook(ctx, hook)
+	if err != nil {
+		log.Printf("Could not update webhook %s: %v", in.WebhookId, err)
+		return nil, err
+	}
+	log.Printf("Webhook %s updated", updated.Id)
+	return withoutSecret(updated), nil
+}
+
+// DeleteWebhook removes a webhook along with its deliveries, pending or not
+func (s *UserManagementServer) DeleteWebhook(ctx context.Context, in *pb.DeleteWebhookReq) (*pb.DeleteWebhookResponse, error) {
+	log.Printf("Received webhook deletion request for %s", in.WebhookId)
+	if err := s.authorize(ctx, auth.PermissionWebhooks); err != nil {
+		return nil, err
+	}
+	if s.Webhooks == nil {
+		return nil, entities.WebhooksDisabledError
+	}
+
+	deleted, err := s.Webhooks.DeleteWebhook(ctx, in.WebhookId)
+	if err != nil {
+		log.Printf("Could not delete webhook %s: %v", in.WebhookId, err)
+		return nil, err
+	}
+	return &pb.DeleteWebhookResponse{Deleted: deleted}, nil
+}
+
+// ListWebhooks retrieves every webhook, without their secrets
+func (s *UserManagementServer) ListWebhooks(ctx context.Context, _ *pb.ListWebhooksReq) (*pb.ListWebhooksResponse, error) {
+	if err := s.authorize(ctx, auth.PermissionWebhooks); err != nil {
+		return nil, err
+	}
+	if s.Webhooks == nil {
+		return nil, entities.WebhooksDisabledError
+	}
+
+	webhooks, err := s.Webhooks.ListWebhooks(ctx)
+	if err != nil {
+		log.Printf("Could not list webhooks: %v", err)
+		return nil, err
+	}
+	response := &pb.ListWebhooksResponse{Webhooks: make([]*pb.Webhook, 0, len(webhooks))}
+	for _, hook := range webhooks {
+		response.Webhooks = append(response.Webhooks, withoutSecret(hook))
+	}
+	return response, nil
+}
+
+// ListWebhookDeliveries retrieves the delivery history of a webhook, newest first.
+// Dead letters are listed filtering by the DEAD_LETTER status
+func (s *UserManagementServer) ListWebhookDeliveries(ctx context.Context, in *pb.ListWebhookDeliveriesReq) (*pb.ListWebhookDeliveriesResponse, error) {
+	if err := s.authorize(ctx, auth.PermissionWebhooks); err != nil {
+		return nil, err
+	}
+	if s.Webhooks == nil {
+		return nil, entities.WebhooksDisabledError
+	}
+	if in.PageSize < 0 {
+		return nil, entities.InvalidPageSizeError
+	}
+	pageSize := int(in.PageSize)
+	if pageSize == 0 {
+		pageSize = defaultDeliveriesPageSize
+	}
+	if pageSize > maxDeliveriesPageSize {
+		pageSize = maxDeliveriesPageSize
+	}
+
+	if _, err := s.Webhooks.GetWebhook(ctx, in.WebhookId); err != nil {
+		return nil, err
+	}
+	deliveries, err := s.Webhooks.ListDeliveries(ctx, database.DeliveryQuery{
+		WebhookID: in.WebhookId,
+		Status:    in.Status,
+		Limit:     pageSize,
+	})
+	if err != nil {
+		log.Printf("Could not list deliveries of webhook %s: %v", in.WebhookId, err)
+		return nil, err
+	}
+	return &pb.ListWebhookDeliveriesResponse{Deliveries: deliveries}, nil
+}
+
+// ReplayWebhook sends again the selected deliveries or dead letters of a webhook, and the stored events
+// after a sequence. Replayed deliveries get every attempt again
+func (s *UserManagementServer) ReplayWebhook(ctx context.Context, in *pb.ReplayWebhookReq) (*pb.ReplayWebhookResponse, error) {
+	log.Printf("Received webhook replay request: %v", in)
+	if err := s.authorize(ctx, auth.PermissionWebhooks); err != nil {
+		return nil, err
+	}
+	if s.Webhooks == nil {
+		return nil, entities.WebhooksDisabledError
+	}
+	if len(in.DeliveryIds) == 0 && !in.DeadLetters && in.AfterSequence <= 0 {
+		return nil, entities.EmptyWebhookReplayError
+	}
+
+	hook, err := s.Webhooks.GetWebhook(ctx, in.WebhookId)
+	if err != nil {
+		log.Printf("Could not replay webhook %s: %v", in.WebhookId, err)
+		return nil, err
+	}
+	scheduled, err := webhook.Replay(ctx, s.Webhooks, s.EventLog, hook, in)
+	if err != nil {
+		log.Printf("Could not replay webhook %s: %v", in.WebhookId, err)
+		return nil, err
+	}
+	log.Printf("Scheduled %d deliveries of webhook %s", scheduled, in.WebhookId)
+	return &pb.ReplayWebhookResponse{Scheduled: int32(scheduled)}, nil
+}
+
+// withoutSecret copies a webhook removing its secret, which is only returned when it is created
+func withoutSecret(hook *pb.Webhook) *pb.Webhook {
+	hook = proto.Clone(hook).(*pb.Webhook)
+	hook.Secret = ""
+	return hook
+}
@@ -0,0 +1,306 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+	"userManagement/infra/cloudevents"
+	"userManagement/infra/database"
+	pb "userManagement/proto"
+)
+
+const (
+	// batchSize is the number of events or due deliveries read at once
+	batchSize = 100
+	// maxResponseSize is the part of the webhook responses read, so connections can be reused
+	maxResponseSize = 64 << 10
+	userAgent       = "userManagement-webhooks"
+)
+
+// Dispatcher creates a delivery for every stored event matching each webhook, and sends the due deliveries.
+// Webhooks receive the events which happened after they were registered. Events are sent at least once:
+// receivers can discard repeated ones by the X-Webhook-Delivery header or the event id
+type Dispatcher struct {
+	store    database.WebhookStoreInterface
+	eventLog database.EventLogInterface
+	client   *http.Client
+	config   Config
+	events   cloudevents.Config
+}
+
+// NewDispatcher creates a dispatcher which reads the events from the event log. The events sent to webhooks
+// receiving CloudEvents take their shared attributes from the received config
+func NewDispatcher(store database.WebhookStoreInterface, eventLog database.EventLogInterface, config Config, events cloudevents.Config) *Dispatcher {
+	return &Dispatcher{
+		store:    store,
+		eventLog: eventLog,
+		client:   &http.Client{Timeout: config.Timeout},
+		config:   config,
+		events:   events,
+	}
+}
+
+// Run creates and sends deliveries every poll interval until the received context ends
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.config.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := d.createDeliveries(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Could not create webhook deliveries: %v", err)
+		}
+		if err := d.sendDueDeliveries(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Could not send webhook deliveries: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// createDeliveries creates the deliveries of the events stored after the dispatch cursor, moving it forward over
+// the events without missing sequences before them. The events the cursor passed are marked as dispatched,
+// so retention keeps them until then
+func (d *Dispatcher) createDeliveries(ctx context.Context) error {
+	cursor, err := d.store.GetDispatchCursor(ctx)
+	if err != nil {
+		return err
+	}
+
+	for {
+		listed, err := d.eventLog.ListEvents(ctx, database.EventQuery{After: cursor, Limit: batchSize})
+		if err != nil || len(listed) == 0 {
+			return err
+		}
+		events, gaps := database.ContiguousEvents(cursor, listed, time.Now())
+		if len(events) == 0 {
+			return nil
+		}
+		webhooks, err := d.store.ListWebhooks(ctx)
+		if err != nil {
+			return err
+		}
+
+		var deliveries []*pb.WebhookDelivery
+		for _, event := range events {
+			for _, webhook := range webhooks {
+				registered := !event.Timestamp.AsTime().Before(webhook.CreatedAt.AsTime())
+				if !webhook.Disabled && registered && Matches(webhook, event) {
+					deliveries = append(deliveries, newDelivery(webhook, event))
+				}
+			}
+		}
+		if err := d.store.CreateDeliveries(ctx, deliveries); err != nil {
+			return err
+		}
+
+		cursor = events[len(events)-1].Sequence
+		if err := d.store.SetDispatchCursor(ctx, cursor); err != nil {
+			return err
+		}
+		for _, gap := range gaps {
+			log.Printf("Webhook dispatch skipped the event sequences %d to %d, which were not stored within %v",
+				gap.First, gap.Last, database.SequenceGapTimeout)
+		}
+		if err := d.eventLog.MarkEventsDispatched(ctx, cursor); err != nil {
+			return err
+		}
+		if len(events) < len(listed) || len(listed) < batchSize {
+			return nil
+		}
+	}
+}
+
+// sendDueDeliveries sends the due deliveries of the enabled webhooks concurrently, recording their outcome
+func (d *Dispatcher) sendDueDeliveries(ctx context.Context) error {
+	webhooks, err := d.store.ListWebhooks(ctx)
+	if err != nil {
+		return err
+	}
+	enabled := map[string]*pb.Webhook{}
+	var ids []string
+	for _, webhook := range webhooks {
+		if !webhook.Disabled {
+			enabled[webhook.Id] = webhook
+			ids = append(ids, webhook.Id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	deliveries, err := d.store.ListDueDeliveries(ctx, time.Now(), ids, batchSize)
+	if err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	for _, delivery := range deliveries {
+		wg.Add(1)
+		go func(delivery *pb.WebhookDelivery) {
+			defer wg.Done()
+			d.attempt(ctx, enabled[delivery.WebhookId], delivery)
+			if err := d.store.UpdateDelivery(ctx, delivery); err != nil {
+				log.Printf("Could not store webhook delivery %s: %v", delivery.Id, err)
+			}
+		}(delivery)
+	}
+	wg.Wait()
+	return nil
+}
+
+// attempt sends the delivery, updating it with the outcome. Failed deliveries are scheduled again after the
+// backoff, or moved to the dead letters when they reach the maximum attempts
+func (d *Dispatcher) attempt(ctx context.Context, webhook *pb.Webhook, delivery *pb.WebhookDelivery) {
+	delivery.Attempts++
+	statusCode, err := d.send(ctx, webhook, delivery)
+	delivery.LastStatusCode = int32(statusCode)
+
+	if err == nil {
+		delivery.Status = pb.WebhookDeliveryStatus_SUCCEEDED
+		delivery.LastError = ""
+		return
+	}
+
+	delivery.LastError = err.Error()
+	if int(delivery.Attempts) >= d.config.MaxAttempts {
+		log.Printf("Webhook delivery %s moved to dead letters after %d attempts: %v", delivery.Id, delivery.Attempts, err)
+		delivery.Status = pb.WebhookDeliveryStatus_DEAD_LETTER
+		return
+	}
+	delivery.NextAttemptAt = timestamppb.New(time.Now().Add(d.config.Backoff(delivery.Attempts)))
+}
+
+// send posts the event of the delivery to the webhook in its format, returning the response status.
+// Responses other than 2xx are errors
+func (d *Dispatcher) send(ctx context.Context, webhook *pb.Webhook, delivery *pb.WebhookDelivery) (int, error) {
+	header := http.Header{}
+	body, err := d.encode(webhook.Format, delivery.Event, header)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.Url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := time.Now().Unix()
+	req.Header = header
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set(IDHeader, webhook.Id)
+	req.Header.Set(DeliveryHeader, delivery.Id)
+	req.Header.Set(EventHeader, delivery.Event.GetType().String())
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, Sign(webhook.Secret, timestamp, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxResponseSize))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected response status %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// encode returns the body of the event in the received format, setting its content type and, for CloudEvents
+// in binary mode, its attributes in the header
+func (d *Dispatcher) encode(format pb.EventFormat, event *pb.UserEvent, header http.Header) ([]byte, error) {
+	switch format {
+	case pb.EventFormat_CLOUDEVENTS_STRUCTURED:
+		header.Set("Content-Type", cloudevents.StructuredContentType)
+		return json.Marshal(cloudevents.New(d.events, event))
+	case pb.EventFormat_CLOUDEVENTS_BINARY:
+		return cloudevents.New(d.events, event).WriteBinary(header)
+	}
+	header.Set("Content-Type", "application/json")
+	return protojson.Marshal(event)
+}
+
+// Replay schedules again the deliveries selected by the request, and creates new ones for the stored events
+// after its sequence matching the webhook types. It returns the number of deliveries scheduled
+func Replay(ctx context.Context, store database.WebhookStoreInterface, eventLog database.EventLogInterface, webhook *pb.Webhook, req *pb.ReplayWebhookReq) (int, error) {
+	var deliveries []*pb.WebhookDelivery
+	if len(req.DeliveryIds) > 0 {
+		selected, err := store.ListDeliveries(ctx, database.DeliveryQuery{WebhookID: webhook.Id, IDs: req.DeliveryIds})
+		if err != nil {
+			return 0, err
+		}
+		deliveries = append(deliveries, selected...)
+	}
+	if req.DeadLetters {
+		deadLetters, err := store.ListDeliveries(ctx, database.DeliveryQuery{
+			WebhookID: webhook.Id,
+			Status:    pb.WebhookDeliveryStatus_DEAD_LETTER,
+		})
+		if err != nil {
+			return 0, err
+		}
+		deliveries = append(deliveries, deadLetters...)
+	}
+
+	scheduled := map[string]bool{}
+	for _, delivery := range deliveries {
+		if scheduled[delivery.Id] {
+			continue
+		}
+		delivery.Status = pb.WebhookDeliveryStatus_PENDING
+		delivery.Attempts = 0
+		delivery.NextAttemptAt = timestamppb.Now()
+		if err := store.UpdateDelivery(ctx, delivery); err != nil {
+			return len(scheduled), err
+		}
+		scheduled[delivery.Id] = true
+	}
+
+	created := 0
+	if req.AfterSequence > 0 && eventLog != nil {
+		query := database.EventQuery{After: req.AfterSequence, Limit: batchSize}
+		for {
+			events, err := eventLog.ListEvents(ctx, query)
+			if err != nil {
+				return len(scheduled) + created, err
+			}
+			var replayed []*pb.WebhookDelivery
+			for _, event := range events {
+				if Matches(webhook, event) {
+					replayed = append(replayed, newDelivery(webhook, event))
+				}
+			}
+			if err := store.CreateDeliveries(ctx, replayed); err != nil {
+				return len(scheduled) + created, err
+			}
+			created += len(replayed)
+			if len(events) < batchSize {
+				break
+			}
+			query.After = events[len(events)-1].Sequence
+		}
+	}
+	return len(scheduled) + created, nil
+}
+
+// newDelivery creates the pending delivery of an event to a webhook, due immediately
+func newDelivery(webhook *pb.Webhook, event *pb.UserEvent) *pb.WebhookDelivery {
+	return &pb.WebhookDelivery{
+		WebhookId:     webhook.Id,
+		Event:         event,
+		Status:        pb.WebhookDeliveryStatus_PENDING,
+		NextAttemptAt: timestamppb.Now(),
+	}
+}
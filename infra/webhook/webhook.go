@@ -0,0 +1,135 @@
+// Package webhook sends the user events to the registered webhooks as signed HTTP requests, retrying the
+// failed deliveries with exponential backoff until they succeed or are moved to the dead letters.
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+	"userManagement/entities"
+	pb "userManagement/proto"
+)
+
+// Headers sent with every delivery. The signature is the hex encoded HMAC-SHA256 of the timestamp header,
+// a dot and the body, keyed with the webhook secret and prefixed by "sha256="
+const (
+	IDHeader        = "X-Webhook-Id"
+	DeliveryHeader  = "X-Webhook-Delivery"
+	EventHeader     = "X-Webhook-Event"
+	TimestampHeader = "X-Webhook-Timestamp"
+	SignatureHeader = "X-Webhook-Signature"
+)
+
+// secretSize is the number of random bytes of the generated secrets
+const secretSize = 32
+
+// Config holds the settings of the deliveries
+type Config struct {
+	// MaxAttempts is the number of failed attempts after which a delivery is moved to the dead letters
+	MaxAttempts int
+	// InitialBackoff is the time waited after the first failed attempt, which doubles after every other one
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Timeout limits the time waited for a webhook to respond
+	Timeout time.Duration
+	// PollInterval is the time between checks for new events and due retries
+	PollInterval time.Duration
+}
+
+// DefaultConfig returns the delivery settings used when nothing else is configured
+func DefaultConfig() Config {
+	return Config{
+		MaxAttempts:    8,
+		InitialBackoff: 5 * time.Second,
+		MaxBackoff:     time.Hour,
+		Timeout:        10 * time.Second,
+		PollInterval:   time.Second,
+	}
+}
+
+// Validate checks that the config can be used to deliver events
+func (c Config) Validate() error {
+	if c.MaxAttempts <= 0 {
+		return fmt.Errorf("webhook max attempts must be positive, got %d", c.MaxAttempts)
+	}
+	if c.InitialBackoff <= 0 || c.MaxBackoff < c.InitialBackoff {
+		return fmt.Errorf("webhook backoff must be positive and its maximum cannot be lower than the initial one")
+	}
+	if c.Timeout <= 0 || c.PollInterval <= 0 {
+		return fmt.Errorf("webhook timeout and poll interval must be positive")
+	}
+	return nil
+}
+
+// Backoff returns the time waited before the next attempt of a delivery which failed the received attempts
+func (c Config) Backoff(attempts int32) time.Duration {
+	backoff := c.InitialBackoff
+	for i := int32(1); i < attempts && backoff < c.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > c.MaxBackoff {
+		return c.MaxBackoff
+	}
+	return backoff
+}
+
+// Sign returns the signature header of a delivery body sent at the received unix time
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature of a received delivery, given its timestamp header and body.
+// Receivers should also reject timestamps too far in the past, so captured requests cannot be replayed
+func Verify(secret, timestamp, signature string, body []byte) bool {
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal([]byte(signature), []byte(Sign(secret, unix, body)))
+}
+
+// NewSecret returns a random secret to sign deliveries
+func NewSecret() string {
+	secret := make([]byte, secretSize)
+	_, _ = rand.Read(secret)
+	return hex.EncodeToString(secret)
+}
+
+// Validate checks that the webhook URL and event types can be used to deliver events
+func Validate(webhook *pb.Webhook) error {
+	target, err := url.Parse(webhook.GetUrl())
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return entities.InvalidWebhookURLError
+	}
+	for _, eventType := range webhook.GetTypes() {
+		if _, known := pb.UserEventType_name[int32(eventType)]; !known || eventType == pb.UserEventType_USER_EVENT_TYPE_UNSPECIFIED {
+			return entities.InvalidWebhookTypeError
+		}
+	}
+	if _, known := pb.EventFormat_name[int32(webhook.GetFormat())]; !known {
+		return entities.InvalidWebhookFormatError
+	}
+	return nil
+}
+
+// Matches checks whether the event must be delivered to the webhook
+func Matches(webhook *pb.Webhook, event *pb.UserEvent) bool {
+	if len(webhook.Types) == 0 {
+		return true
+	}
+	for _, eventType := range webhook.Types {
+		if eventType == event.Type {
+			return true
+		}
+	}
+	return false
+}
@@ -3,14 +3,22 @@ package main
 import (
 	"context"
 	"flag"
-	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
 	"log"
 	"net"
 	"net/http"
+	"os"
+	"userManagement/entities"
+	"userManagement/infra/auth"
+	"userManagement/infra/certs"
+	"userManagement/infra/config"
 	"userManagement/infra/database"
+	"userManagement/infra/gateway"
+	"userManagement/infra/notification"
 	"userManagement/infra/server"
+	"userManagement/infra/webhook"
 	pb "userManagement/proto"
 )
 
@@ -22,46 +30,216 @@ func serveSwagger(w http.ResponseWriter, r *http.Request) {
 	http.ServeFile(w, r, "swagger/swagger.json")
 }
 
-func runAPIServer() {
+// runAPIServer serves the REST gateway, over TLS with the certificates of the reloader unless it is nil
+func runAPIServer(cfg *config.Config, reloader *certs.Reloader) {
 	ctx := context.Background()
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
-	// Connect to the GRPC server
-	dopts := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	// Connect to the GRPC server, which is reached over TLS when it serves it
+	transport := insecure.NewCredentials()
+	if reloader != nil {
+		tlsConfig, err := certs.ClientTLSConfig(cfg.TLS.Gateway, cfg.TLS.ReloadInterval)
+		if err != nil {
+			log.Fatalf("Failed to load gateway TLS config: %v", err)
+		}
+		transport = credentials.NewTLS(tlsConfig)
+	}
+	dopts := []grpc.DialOption{grpc.WithTransportCredentials(transport)}
+	conn, err := grpc.DialContext(ctx, port, dopts...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close()
 
 	// Register grpc-gateway
-	rmux := runtime.NewServeMux()
-	err := pb.RegisterUserManagementHandlerFromEndpoint(ctx, rmux, ":5566", dopts)
+	rmux := gateway.NewServeMux()
+	err = pb.RegisterUserManagementHandler(ctx, rmux, conn)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	// Serve the swagger-ui and swagger file
+	// Serve the swagger-ui and swagger file, and the change notifications as Server-Sent Events and WebSockets
 	mux := http.NewServeMux()
 	mux.Handle("/", rmux)
+	mux.Handle(gateway.WatchPath, gateway.NewWatchHandler(pb.NewUserManagementClient(conn), cfg.WatchHeartbeat, cfg.WatchOrigins()))
 	mux.HandleFunc("/swagger.json", serveSwagger)
 	sh := http.StripPrefix("/swagger/", http.FileServer(http.Dir("./swagger/")))
 	mux.Handle("/swagger/", sh)
 	log.Println("REST server ready...")
-	err = http.ListenAndServe(":8081", mux)
+	httpServer := &http.Server{Addr: ":8081", Handler: mux}
+	if reloader == nil {
+		err = httpServer.ListenAndServe()
+	} else {
+		httpServer.TLSConfig = certs.ServerTLSConfig(reloader, cfg.TLS.RequireClientCert, "h2", "http/1.1")
+		err = httpServer.ListenAndServeTLS("", "")
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
 }
 
+// stores holds the clients of every kind of data the service keeps in the database
+type stores struct {
+	users         database.AdapterInterface
+	events        database.EventLogInterface
+	webhooks      database.WebhookStoreInterface
+	refreshTokens database.RefreshTokenStoreInterface
+	apiKeys       database.APIKeyStoreInterface
+}
+
+// newStores creates the clients of the database selected in the config
+func newStores(ctx context.Context, cfg *config.Config) (*stores, error) {
+	if cfg.Database == config.DatabaseMemory {
+		log.Printf("Using in memory database, users, events, webhooks, refresh tokens and API keys will be lost when the server stops")
+		memoryClient := database.NewMemoryClient()
+		memoryClient.EventLog = database.NewMemoryEventLog(cfg.EventRetention)
+		return &stores{
+			users:         memoryClient,
+			events:        memoryClient.EventLog,
+			webhooks:      database.NewMemoryWebhookStore(),
+			refreshTokens: database.NewMemoryRefreshTokenStore(),
+			apiKeys:       database.NewMemoryAPIKeyStore(),
+		}, nil
+	}
+
+	mongoClient, err := database.NewMongoClient(ctx, cfg.Mongo)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Connected to mongo collection %s.%s", cfg.Mongo.Database, cfg.Mongo.Collection)
+
+	eventLog, err := database.NewMongoEventLog(ctx, mongoClient, cfg.Mongo.EventsCollection, cfg.EventRetention)
+	if err != nil {
+		_ = mongoClient.Disconnect(context.Background())
+		return nil, err
+	}
+	mongoClient.EventLog = eventLog
+
+	webhooks, err := database.NewMongoWebhookStore(ctx, mongoClient, cfg.Mongo.WebhooksCollection)
+	if err != nil {
+		_ = mongoClient.Disconnect(context.Background())
+		return nil, err
+	}
+	refreshTokens, err := database.NewMongoRefreshTokenStore(ctx, mongoClient, cfg.Mongo.RefreshTokensCollection)
+	if err != nil {
+		_ = mongoClient.Disconnect(context.Background())
+		return nil, err
+	}
+	apiKeys, err := database.NewMongoAPIKeyStore(ctx, mongoClient, cfg.Mongo.APIKeysCollection)
+	if err != nil {
+		_ = mongoClient.Disconnect(context.Background())
+		return nil, err
+	}
+	return &stores{users: mongoClient, events: eventLog, webhooks: webhooks, refreshTokens: refreshTokens, apiKeys: apiKeys}, nil
+}
+
+// newKeys loads the keys signing the access tokens, or generates one when no directory is configured
+func newKeys(cfg auth.Config) (*auth.KeySet, error) {
+	if cfg.KeysDir == "" {
+		log.Printf("No JWT keys directory configured, access tokens are signed with a generated key and will not be valid after a restart")
+		return auth.GenerateKeys()
+	}
+	keys, err := auth.LoadKeys(cfg.KeysDir, cfg.SigningKey)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Signing access tokens with key %s (%s)", keys.SigningKey().ID, keys.SigningKey().Algorithm())
+	return keys, nil
+}
+
+// grantAdmin grants the admin role to the user with the email, which may not have signed up yet
+func grantAdmin(dbClient database.AdapterInterface, email string) {
+	_, err := dbClient.GrantRole(context.Background(), &pb.GrantRoleReq{UserId: email, Role: auth.RoleAdmin})
+	if err == entities.NotFoundUser {
+		log.Printf("Admin %s not found, it will be granted the admin role at the next startup after signing up", email)
+		return
+	}
+	if err != nil {
+		log.Fatalf("Failed to grant admin role to %s: %v", email, err)
+	}
+	log.Printf("User %s has the admin role", email)
+}
+
 func main() {
-	flag.Parse()
-	go runAPIServer()
+	cfg, err := config.Load(os.Args[1:])
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+
+	db, err := newStores(context.Background(), cfg)
+	if err != nil {
+		log.Fatalf("Failed to create database client: %v", err)
+	}
+	dbClient := database.NewTimeoutClient(db.users, cfg.Timeouts)
+	if cfg.Auth.Admin != "" {
+		grantAdmin(dbClient, cfg.Auth.Admin)
+	}
+	keys, err := newKeys(cfg.Auth)
+	if err != nil {
+		log.Fatalf("Failed to load JWT keys: %v", err)
+	}
+
+	// The events stored along with the user changes are published to the streams, and to the configured
+	// message bus, by the relay
+	broker := notification.NewBroker(cfg.Broker)
+	local := notification.NewBrokerPublisher(broker)
+	bus, err := notification.NewPublisher(cfg.Publisher, cfg.CloudEvents)
+	if err != nil {
+		log.Fatalf("Failed to create event publisher: %v", err)
+	}
+	relay := notification.NewRelay(db.events, local, cfg.RelayInterval)
+	if bus != nil {
+		defer bus.Close()
+		relay = notification.NewBusRelay(db.events, bus, local, cfg.RelayInterval)
+	}
+	go relay.Run(context.Background())
+	go webhook.NewDispatcher(db.webhooks, db.events, cfg.Webhooks, cfg.CloudEvents).Run(context.Background())
+
+	// Both servers take their certificate from the reloader, so renewed certificates are served without restarting
+	var reloader *certs.Reloader
+	if cfg.TLS.Enabled() {
+		reloader, err = certs.NewReloader(cfg.TLS.CertFile, cfg.TLS.KeyFile, cfg.TLS.ClientCAFile, cfg.TLS.ReloadInterval)
+		if err != nil {
+			log.Fatalf("Failed to load TLS certificate: %v", err)
+		}
+	}
+	go runAPIServer(cfg, reloader)
 
 	lis, err := net.Listen("tcp", port)
 	if err != nil {
 		log.Fatalf("Failed to start server: %v", err)
 	}
 
-	s := grpc.NewServer()
+	// Every method but the public ones needs an access token or an API key
+	issuer := auth.NewIssuer(cfg.Auth, keys, db.refreshTokens)
+	apiKeys := auth.NewAPIKeys(db.apiKeys)
+	authenticator := auth.NewAuthenticator(issuer, apiKeys, cfg.Auth.Public())
+	if cfg.TLS.Gateway.CertFile != "" {
+		// The gateway forwards the client certificates of the REST callers, which is only trusted from its own
+		authenticator.GatewaySubject, err = certs.CertificateSubject(cfg.TLS.Gateway.CertFile)
+		if err != nil {
+			log.Fatalf("Failed to load gateway certificate: %v", err)
+		}
+	}
+	opts := []grpc.ServerOption{
+		grpc.UnaryInterceptor(authenticator.UnaryInterceptor()),
+		grpc.StreamInterceptor(authenticator.StreamInterceptor()),
+	}
+	if reloader != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(certs.ServerTLSConfig(reloader, cfg.TLS.RequireClientCert, "h2"))))
+	}
+	s := grpc.NewServer(opts...)
 	pb.RegisterUserManagementServer(s, &server.UserManagementServer{
-		DbClient:      database.DBClient,
-		NotifyChannel: make(chan []string),
+		DbClient:    dbClient,
+		Broker:      broker,
+		EventLog:    db.events,
+		Webhooks:    db.webhooks,
+		CloudEvents: cfg.CloudEvents,
+		Auth:        issuer,
+		APIKeys:     apiKeys,
 	})
 	log.Printf("Server listening at %v", lis.Addr())
 	if err := s.Serve(lis); err != nil {
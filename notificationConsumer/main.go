@@ -2,46 +2,95 @@ package main
 
 import (
 	"context"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	"flag"
+	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"userManagement/infra/consumer"
 	pb "userManagement/proto"
 )
 
-const (
-	address = "host.docker.internal:5566"
-)
+// parseFlags reads the consumer and sink settings from the command line arguments
+func parseFlags(args []string) (consumer.Config, consumer.SinkConfig, error) {
+	cfg := consumer.DefaultConfig()
+	sinkCfg := consumer.DefaultSinkConfig()
+	var types string
+
+	fs := flag.NewFlagSet("notificationConsumer", flag.ContinueOnError)
+	fs.StringVar(&cfg.Target, "target", cfg.Target, "Address of the userManagement grpc server")
+	fs.BoolVar(&cfg.TLS, "tls", cfg.TLS, "Connect to the server using TLS")
+	fs.StringVar(&cfg.TLSCAFile, "tls-ca-file", cfg.TLSCAFile, "CA certificates used to verify the server, the system ones are used when empty")
+	fs.StringVar(&cfg.TLSServerName, "tls-server-name", cfg.TLSServerName, "Name expected in the server certificate, taken from the target when empty")
+	fs.BoolVar(&cfg.TLSInsecureSkipVerify, "tls-insecure", cfg.TLSInsecureSkipVerify, "Skip the server certificate verification")
+	fs.StringVar(&cfg.TLSCertFile, "tls-cert-file", cfg.TLSCertFile, "Client certificate sent to servers verifying client certificates")
+	fs.StringVar(&cfg.TLSKeyFile, "tls-key-file", cfg.TLSKeyFile, "Private key of the client certificate")
+	fs.StringVar(&cfg.APIKey, "api-key", os.Getenv("USER_MANAGEMENT_API_KEY"), "API key with the events.watch scope authenticating the consumer, read from USER_MANAGEMENT_API_KEY when not set")
+	fs.Int64Var(&cfg.ResumeAfter, "resume-after", cfg.ResumeAfter, "Sequence of the last event already consumed, the stored events after it are received first")
+	fs.StringVar(&cfg.StateFile, "state-file", cfg.StateFile, "File keeping the sequence of the last event consumed, to resume after it when restarted")
+	fs.StringVar(&types, "types", "", "Comma separated types of the events received, such as USER_DELETED. Every event is received when empty")
+	fs.DurationVar(&cfg.InitialBackoff, "initial-backoff", cfg.InitialBackoff, "Time waited before reconnecting after the first failure, doubling after every other one")
+	fs.DurationVar(&cfg.MaxBackoff, "max-backoff", cfg.MaxBackoff, "Maximum time waited before reconnecting")
+
+	fs.StringVar(&sinkCfg.Sinks, "sinks", sinkCfg.Sinks, "Comma separated sinks the events are written to: stdout, file, http or exec")
+	fs.StringVar(&sinkCfg.Format, "format", sinkCfg.Format, "Encoding of the events written to the sinks: json or cloudevents")
+	fs.StringVar(&sinkCfg.File, "file", sinkCfg.File, "File the file sink appends the events to, one JSON per line")
+	fs.Int64Var(&sinkCfg.FileMaxSize, "file-max-size", sinkCfg.FileMaxSize, "Size in bytes at which the file of the file sink is rotated")
+	fs.IntVar(&sinkCfg.FileMaxBackups, "file-max-backups", sinkCfg.FileMaxBackups, "Number of rotated files kept by the file sink")
+	fs.StringVar(&sinkCfg.URL, "url", sinkCfg.URL, "URL the http sink posts the events to")
+	fs.DurationVar(&sinkCfg.HTTPTimeout, "http-timeout", sinkCfg.HTTPTimeout, "Time waited for the http sink URL to respond")
+	fs.StringVar(&sinkCfg.Command, "command", sinkCfg.Command, "Command the exec sink runs for every event, receiving it as standard input")
+	fs.DurationVar(&sinkCfg.ExecTimeout, "exec-timeout", sinkCfg.ExecTimeout, "Time the exec sink command may run before it is killed, 0 does not limit it")
+
+	if err := fs.Parse(args); err != nil {
+		return cfg, sinkCfg, err
+	}
+	for _, name := range strings.Split(types, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		eventType, known := pb.UserEventType_value[name]
+		if !known {
+			return cfg, sinkCfg, fmt.Errorf("unknown event type %q", name)
+		}
+		cfg.Types = append(cfg.Types, pb.UserEventType(eventType))
+	}
+	return cfg, sinkCfg, cfg.Validate()
+}
 
 func main() {
-	log.Print("Connecting to server")
-	conn, err := grpc.Dial(address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	cfg, sinkCfg, err := parseFlags(os.Args[1:])
+	if err == flag.ErrHelp {
+		return
+	}
 	if err != nil {
-		log.Fatalf("Could not connect to server: %v", err)
+		log.Fatalf("Invalid settings: %v", err)
 	}
-	log.Printf("Connected to grpc server: %v", conn)
-	defer func(conn *grpc.ClientConn) {
-		err := conn.Close()
-		if err != nil {
-			log.Fatalf("Could not close connection %v", err)
-		}
-	}(conn)
 
-	c := pb.NewUserManagementClient(conn)
+	sink, err := consumer.NewSink(sinkCfg)
+	if err != nil {
+		log.Fatalf("Could not create sinks: %v", err)
+	}
+	defer func() {
+		if err := sink.Close(); err != nil {
+			log.Printf("Could not close sinks: %v", err)
+		}
+	}()
 
-	changeStream, err := c.NotifyUserChanges(context.TODO(), &pb.EmptyMsg{})
-	log.Println("Server side streaming established")
+	c, err := consumer.New(cfg, sink)
 	if err != nil {
-		log.Printf("There was an error when recieving changes: %v", err)
-		return
+		log.Fatalf("Could not create consumer: %v", err)
 	}
 
-	for {
-		log.Print("Waiting for new notifications")
-		var notification pb.UserActionStream
-		err := changeStream.RecvMsg(&notification)
-		if err != nil {
-			log.Printf("Failed when recieving change: %v", err)
-		}
-		log.Printf(notification.Action)
+	// The event being written when a signal arrives is cancelled, and written again when the consumer is restarted
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	log.Printf("Consuming user events from %s", cfg.Target)
+	if err := c.Run(ctx); err != nil {
+		_ = sink.Close()
+		log.Fatalf("Consumer stopped: %v", err)
 	}
+	log.Printf("Consumer stopped after event %d", c.Last())
 }
@@ -22,12 +22,33 @@ const _ = grpc.SupportPackageIsVersion7
 //
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type UserManagementClient interface {
-	NotifyUserChanges(ctx context.Context, in *EmptyMsg, opts ...grpc.CallOption) (UserManagement_NotifyUserChangesClient, error)
+	NotifyUserChanges(ctx context.Context, in *NotifyUserChangesReq, opts ...grpc.CallOption) (UserManagement_NotifyUserChangesClient, error)
+	// NotifyUserCloudEvents streams the same events as NotifyUserChanges, encoded as CloudEvents
+	NotifyUserCloudEvents(ctx context.Context, in *NotifyUserChangesReq, opts ...grpc.CallOption) (UserManagement_NotifyUserCloudEventsClient, error)
 	CreateUser(ctx context.Context, in *CreateUserReq, opts ...grpc.CallOption) (*UserActionResponse, error)
 	GetUser(ctx context.Context, in *GetUserReq, opts ...grpc.CallOption) (*UserActionResponse, error)
 	UpdateUser(ctx context.Context, in *UpdateUserReq, opts ...grpc.CallOption) (*UserActionResponse, error)
 	DeleteUser(ctx context.Context, in *DeleteUserReq, opts ...grpc.CallOption) (*DeletionActionResponse, error)
 	ListUsers(ctx context.Context, in *ListUsersReq, opts ...grpc.CallOption) (*ListActionResponse, error)
+	VerifyPassword(ctx context.Context, in *VerifyPasswordReq, opts ...grpc.CallOption) (*VerifyPasswordResponse, error)
+	CreateWebhook(ctx context.Context, in *CreateWebhookReq, opts ...grpc.CallOption) (*Webhook, error)
+	GetWebhook(ctx context.Context, in *GetWebhookReq, opts ...grpc.CallOption) (*Webhook, error)
+	UpdateWebhook(ctx context.Context, in *UpdateWebhookReq, opts ...grpc.CallOption) (*Webhook, error)
+	DeleteWebhook(ctx context.Context, in *DeleteWebhookReq, opts ...grpc.CallOption) (*DeleteWebhookResponse, error)
+	ListWebhooks(ctx context.Context, in *ListWebhooksReq, opts ...grpc.CallOption) (*ListWebhooksResponse, error)
+	ListWebhookDeliveries(ctx context.Context, in *ListWebhookDeliveriesReq, opts ...grpc.CallOption) (*ListWebhookDeliveriesResponse, error)
+	ReplayWebhook(ctx context.Context, in *ReplayWebhookReq, opts ...grpc.CallOption) (*ReplayWebhookResponse, error)
+	Login(ctx context.Context, in *LoginReq, opts ...grpc.CallOption) (*TokenResponse, error)
+	RefreshToken(ctx context.Context, in *RefreshTokenReq, opts ...grpc.CallOption) (*TokenResponse, error)
+	Logout(ctx context.Context, in *LogoutReq, opts ...grpc.CallOption) (*LogoutResponse, error)
+	// GrantRole and RevokeRole change the roles of a user, which reach its access tokens when they are refreshed
+	GrantRole(ctx context.Context, in *GrantRoleReq, opts ...grpc.CallOption) (*UserActionResponse, error)
+	RevokeRole(ctx context.Context, in *RevokeRoleReq, opts ...grpc.CallOption) (*UserActionResponse, error)
+	CreateAPIKey(ctx context.Context, in *CreateAPIKeyReq, opts ...grpc.CallOption) (*APIKey, error)
+	ListAPIKeys(ctx context.Context, in *ListAPIKeysReq, opts ...grpc.CallOption) (*ListAPIKeysResponse, error)
+	// RotateAPIKey replaces a key with a new one, the previous key stops being valid
+	RotateAPIKey(ctx context.Context, in *RotateAPIKeyReq, opts ...grpc.CallOption) (*APIKey, error)
+	RevokeAPIKey(ctx context.Context, in *RevokeAPIKeyReq, opts ...grpc.CallOption) (*APIKey, error)
 }
 
 type userManagementClient struct {
@@ -38,7 +59,7 @@ func NewUserManagementClient(cc grpc.ClientConnInterface) UserManagementClient {
 	return &userManagementClient{cc}
 }
 
-func (c *userManagementClient) NotifyUserChanges(ctx context.Context, in *EmptyMsg, opts ...grpc.CallOption) (UserManagement_NotifyUserChangesClient, error) {
+func (c *userManagementClient) NotifyUserChanges(ctx context.Context, in *NotifyUserChangesReq, opts ...grpc.CallOption) (UserManagement_NotifyUserChangesClient, error) {
 	stream, err := c.cc.NewStream(ctx, &UserManagement_ServiceDesc.Streams[0], "/userManagement.UserManagement/NotifyUserChanges", opts...)
 	if err != nil {
 		return nil, err
@@ -54,7 +75,7 @@ func (c *userManagementClient) NotifyUserChanges(ctx context.Context, in *EmptyM
 }
 
 type UserManagement_NotifyUserChangesClient interface {
-	Recv() (*UserActionStream, error)
+	Recv() (*UserEvent, error)
 	grpc.ClientStream
 }
 
@@ -62,8 +83,40 @@ type userManagementNotifyUserChangesClient struct {
 	grpc.ClientStream
 }
 
-func (x *userManagementNotifyUserChangesClient) Recv() (*UserActionStream, error) {
-	m := new(UserActionStream)
+func (x *userManagementNotifyUserChangesClient) Recv() (*UserEvent, error) {
+	m := new(UserEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *userManagementClient) NotifyUserCloudEvents(ctx context.Context, in *NotifyUserChangesReq, opts ...grpc.CallOption) (UserManagement_NotifyUserCloudEventsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &UserManagement_ServiceDesc.Streams[1], "/userManagement.UserManagement/NotifyUserCloudEvents", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &userManagementNotifyUserCloudEventsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type UserManagement_NotifyUserCloudEventsClient interface {
+	Recv() (*CloudEvent, error)
+	grpc.ClientStream
+}
+
+type userManagementNotifyUserCloudEventsClient struct {
+	grpc.ClientStream
+}
+
+func (x *userManagementNotifyUserCloudEventsClient) Recv() (*CloudEvent, error) {
+	m := new(CloudEvent)
 	if err := x.ClientStream.RecvMsg(m); err != nil {
 		return nil, err
 	}
@@ -115,16 +168,190 @@ func (c *userManagementClient) ListUsers(ctx context.Context, in *ListUsersReq,
 	return out, nil
 }
 
+func (c *userManagementClient) VerifyPassword(ctx context.Context, in *VerifyPasswordReq, opts ...grpc.CallOption) (*VerifyPasswordResponse, error) {
+	out := new(VerifyPasswordResponse)
+	err := c.cc.Invoke(ctx, "/userManagement.UserManagement/VerifyPassword", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userManagementClient) CreateWebhook(ctx context.Context, in *CreateWebhookReq, opts ...grpc.CallOption) (*Webhook, error) {
+	out := new(Webhook)
+	err := c.cc.Invoke(ctx, "/userManagement.UserManagement/CreateWebhook", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userManagementClient) GetWebhook(ctx context.Context, in *GetWebhookReq, opts ...grpc.CallOption) (*Webhook, error) {
+	out := new(Webhook)
+	err := c.cc.Invoke(ctx, "/userManagement.UserManagement/GetWebhook", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userManagementClient) UpdateWebhook(ctx context.Context, in *UpdateWebhookReq, opts ...grpc.CallOption) (*Webhook, error) {
+	out := new(Webhook)
+	err := c.cc.Invoke(ctx, "/userManagement.UserManagement/UpdateWebhook", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userManagementClient) DeleteWebhook(ctx context.Context, in *DeleteWebhookReq, opts ...grpc.CallOption) (*DeleteWebhookResponse, error) {
+	out := new(DeleteWebhookResponse)
+	err := c.cc.Invoke(ctx, "/userManagement.UserManagement/DeleteWebhook", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userManagementClient) ListWebhooks(ctx context.Context, in *ListWebhooksReq, opts ...grpc.CallOption) (*ListWebhooksResponse, error) {
+	out := new(ListWebhooksResponse)
+	err := c.cc.Invoke(ctx, "/userManagement.UserManagement/ListWebhooks", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userManagementClient) ListWebhookDeliveries(ctx context.Context, in *ListWebhookDeliveriesReq, opts ...grpc.CallOption) (*ListWebhookDeliveriesResponse, error) {
+	out := new(ListWebhookDeliveriesResponse)
+	err := c.cc.Invoke(ctx, "/userManagement.UserManagement/ListWebhookDeliveries", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userManagementClient) ReplayWebhook(ctx context.Context, in *ReplayWebhookReq, opts ...grpc.CallOption) (*ReplayWebhookResponse, error) {
+	out := new(ReplayWebhookResponse)
+	err := c.cc.Invoke(ctx, "/userManagement.UserManagement/ReplayWebhook", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userManagementClient) Login(ctx context.Context, in *LoginReq, opts ...grpc.CallOption) (*TokenResponse, error) {
+	out := new(TokenResponse)
+	err := c.cc.Invoke(ctx, "/userManagement.UserManagement/Login", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userManagementClient) RefreshToken(ctx context.Context, in *RefreshTokenReq, opts ...grpc.CallOption) (*TokenResponse, error) {
+	out := new(TokenResponse)
+	err := c.cc.Invoke(ctx, "/userManagement.UserManagement/RefreshToken", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userManagementClient) Logout(ctx context.Context, in *LogoutReq, opts ...grpc.CallOption) (*LogoutResponse, error) {
+	out := new(LogoutResponse)
+	err := c.cc.Invoke(ctx, "/userManagement.UserManagement/Logout", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userManagementClient) GrantRole(ctx context.Context, in *GrantRoleReq, opts ...grpc.CallOption) (*UserActionResponse, error) {
+	out := new(UserActionResponse)
+	err := c.cc.Invoke(ctx, "/userManagement.UserManagement/GrantRole", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userManagementClient) RevokeRole(ctx context.Context, in *RevokeRoleReq, opts ...grpc.CallOption) (*UserActionResponse, error) {
+	out := new(UserActionResponse)
+	err := c.cc.Invoke(ctx, "/userManagement.UserManagement/RevokeRole", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userManagementClient) CreateAPIKey(ctx context.Context, in *CreateAPIKeyReq, opts ...grpc.CallOption) (*APIKey, error) {
+	out := new(APIKey)
+	err := c.cc.Invoke(ctx, "/userManagement.UserManagement/CreateAPIKey", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userManagementClient) ListAPIKeys(ctx context.Context, in *ListAPIKeysReq, opts ...grpc.CallOption) (*ListAPIKeysResponse, error) {
+	out := new(ListAPIKeysResponse)
+	err := c.cc.Invoke(ctx, "/userManagement.UserManagement/ListAPIKeys", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userManagementClient) RotateAPIKey(ctx context.Context, in *RotateAPIKeyReq, opts ...grpc.CallOption) (*APIKey, error) {
+	out := new(APIKey)
+	err := c.cc.Invoke(ctx, "/userManagement.UserManagement/RotateAPIKey", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userManagementClient) RevokeAPIKey(ctx context.Context, in *RevokeAPIKeyReq, opts ...grpc.CallOption) (*APIKey, error) {
+	out := new(APIKey)
+	err := c.cc.Invoke(ctx, "/userManagement.UserManagement/RevokeAPIKey", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // UserManagementServer is the server API for UserManagement service.
 // All implementations must embed UnimplementedUserManagementServer
 // for forward compatibility
 type UserManagementServer interface {
-	NotifyUserChanges(*EmptyMsg, UserManagement_NotifyUserChangesServer) error
+	NotifyUserChanges(*NotifyUserChangesReq, UserManagement_NotifyUserChangesServer) error
+	// NotifyUserCloudEvents streams the same events as NotifyUserChanges, encoded as CloudEvents
+	NotifyUserCloudEvents(*NotifyUserChangesReq, UserManagement_NotifyUserCloudEventsServer) error
 	CreateUser(context.Context, *CreateUserReq) (*UserActionResponse, error)
 	GetUser(context.Context, *GetUserReq) (*UserActionResponse, error)
 	UpdateUser(context.Context, *UpdateUserReq) (*UserActionResponse, error)
 	DeleteUser(context.Context, *DeleteUserReq) (*DeletionActionResponse, error)
 	ListUsers(context.Context, *ListUsersReq) (*ListActionResponse, error)
+	VerifyPassword(context.Context, *VerifyPasswordReq) (*VerifyPasswordResponse, error)
+	CreateWebhook(context.Context, *CreateWebhookReq) (*Webhook, error)
+	GetWebhook(context.Context, *GetWebhookReq) (*Webhook, error)
+	UpdateWebhook(context.Context, *UpdateWebhookReq) (*Webhook, error)
+	DeleteWebhook(context.Context, *DeleteWebhookReq) (*DeleteWebhookResponse, error)
+	ListWebhooks(context.Context, *ListWebhooksReq) (*ListWebhooksResponse, error)
+	ListWebhookDeliveries(context.Context, *ListWebhookDeliveriesReq) (*ListWebhookDeliveriesResponse, error)
+	ReplayWebhook(context.Context, *ReplayWebhookReq) (*ReplayWebhookResponse, error)
+	Login(context.Context, *LoginReq) (*TokenResponse, error)
+	RefreshToken(context.Context, *RefreshTokenReq) (*TokenResponse, error)
+	Logout(context.Context, *LogoutReq) (*LogoutResponse, error)
+	// GrantRole and RevokeRole change the roles of a user, which reach its access tokens when they are refreshed
+	GrantRole(context.Context, *GrantRoleReq) (*UserActionResponse, error)
+	RevokeRole(context.Context, *RevokeRoleReq) (*UserActionResponse, error)
+	CreateAPIKey(context.Context, *CreateAPIKeyReq) (*APIKey, error)
+	ListAPIKeys(context.Context, *ListAPIKeysReq) (*ListAPIKeysResponse, error)
+	// RotateAPIKey replaces a key with a new one, the previous key stops being valid
+	RotateAPIKey(context.Context, *RotateAPIKeyReq) (*APIKey, error)
+	RevokeAPIKey(context.Context, *RevokeAPIKeyReq) (*APIKey, error)
 	mustEmbedUnimplementedUserManagementServer()
 }
 
@@ -132,9 +359,12 @@ type UserManagementServer interface {
 type UnimplementedUserManagementServer struct {
 }
 
-func (UnimplementedUserManagementServer) NotifyUserChanges(*EmptyMsg, UserManagement_NotifyUserChangesServer) error {
+func (UnimplementedUserManagementServer) NotifyUserChanges(*NotifyUserChangesReq, UserManagement_NotifyUserChangesServer) error {
 	return status.Errorf(codes.Unimplemented, "method NotifyUserChanges not implemented")
 }
+func (UnimplementedUserManagementServer) NotifyUserCloudEvents(*NotifyUserChangesReq, UserManagement_NotifyUserCloudEventsServer) error {
+	return status.Errorf(codes.Unimplemented, "method NotifyUserCloudEvents not implemented")
+}
 func (UnimplementedUserManagementServer) CreateUser(context.Context, *CreateUserReq) (*UserActionResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method CreateUser not implemented")
 }
@@ -150,6 +380,57 @@ func (UnimplementedUserManagementServer) DeleteUser(context.Context, *DeleteUser
 func (UnimplementedUserManagementServer) ListUsers(context.Context, *ListUsersReq) (*ListActionResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method ListUsers not implemented")
 }
+func (UnimplementedUserManagementServer) VerifyPassword(context.Context, *VerifyPasswordReq) (*VerifyPasswordResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method VerifyPassword not implemented")
+}
+func (UnimplementedUserManagementServer) CreateWebhook(context.Context, *CreateWebhookReq) (*Webhook, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateWebhook not implemented")
+}
+func (UnimplementedUserManagementServer) GetWebhook(context.Context, *GetWebhookReq) (*Webhook, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetWebhook not implemented")
+}
+func (UnimplementedUserManagementServer) UpdateWebhook(context.Context, *UpdateWebhookReq) (*Webhook, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateWebhook not implemented")
+}
+func (UnimplementedUserManagementServer) DeleteWebhook(context.Context, *DeleteWebhookReq) (*DeleteWebhookResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteWebhook not implemented")
+}
+func (UnimplementedUserManagementServer) ListWebhooks(context.Context, *ListWebhooksReq) (*ListWebhooksResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListWebhooks not implemented")
+}
+func (UnimplementedUserManagementServer) ListWebhookDeliveries(context.Context, *ListWebhookDeliveriesReq) (*ListWebhookDeliveriesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListWebhookDeliveries not implemented")
+}
+func (UnimplementedUserManagementServer) ReplayWebhook(context.Context, *ReplayWebhookReq) (*ReplayWebhookResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReplayWebhook not implemented")
+}
+func (UnimplementedUserManagementServer) Login(context.Context, *LoginReq) (*TokenResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Login not implemented")
+}
+func (UnimplementedUserManagementServer) RefreshToken(context.Context, *RefreshTokenReq) (*TokenResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RefreshToken not implemented")
+}
+func (UnimplementedUserManagementServer) Logout(context.Context, *LogoutReq) (*LogoutResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Logout not implemented")
+}
+func (UnimplementedUserManagementServer) GrantRole(context.Context, *GrantRoleReq) (*UserActionResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GrantRole not implemented")
+}
+func (UnimplementedUserManagementServer) RevokeRole(context.Context, *RevokeRoleReq) (*UserActionResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RevokeRole not implemented")
+}
+func (UnimplementedUserManagementServer) CreateAPIKey(context.Context, *CreateAPIKeyReq) (*APIKey, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateAPIKey not implemented")
+}
+func (UnimplementedUserManagementServer) ListAPIKeys(context.Context, *ListAPIKeysReq) (*ListAPIKeysResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListAPIKeys not implemented")
+}
+func (UnimplementedUserManagementServer) RotateAPIKey(context.Context, *RotateAPIKeyReq) (*APIKey, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RotateAPIKey not implemented")
+}
+func (UnimplementedUserManagementServer) RevokeAPIKey(context.Context, *RevokeAPIKeyReq) (*APIKey, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RevokeAPIKey not implemented")
+}
 func (UnimplementedUserManagementServer) mustEmbedUnimplementedUserManagementServer() {}
 
 // UnsafeUserManagementServer may be embedded to opt out of forward compatibility for this service.
@@ -164,7 +445,7 @@ func RegisterUserManagementServer(s grpc.ServiceRegistrar, srv UserManagementSer
 }
 
 func _UserManagement_NotifyUserChanges_Handler(srv interface{}, stream grpc.ServerStream) error {
-	m := new(EmptyMsg)
+	m := new(NotifyUserChangesReq)
 	if err := stream.RecvMsg(m); err != nil {
 		return err
 	}
@@ -172,7 +453,7 @@ func _UserManagement_NotifyUserChanges_Handler(srv interface{}, stream grpc.Serv
 }
 
 type UserManagement_NotifyUserChangesServer interface {
-	Send(*UserActionStream) error
+	Send(*UserEvent) error
 	grpc.ServerStream
 }
 
@@ -180,7 +461,28 @@ type userManagementNotifyUserChangesServer struct {
 	grpc.ServerStream
 }
 
-func (x *userManagementNotifyUserChangesServer) Send(m *UserActionStream) error {
+func (x *userManagementNotifyUserChangesServer) Send(m *UserEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _UserManagement_NotifyUserCloudEvents_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(NotifyUserChangesReq)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(UserManagementServer).NotifyUserCloudEvents(m, &userManagementNotifyUserCloudEventsServer{stream})
+}
+
+type UserManagement_NotifyUserCloudEventsServer interface {
+	Send(*CloudEvent) error
+	grpc.ServerStream
+}
+
+type userManagementNotifyUserCloudEventsServer struct {
+	grpc.ServerStream
+}
+
+func (x *userManagementNotifyUserCloudEventsServer) Send(m *CloudEvent) error {
 	return x.ServerStream.SendMsg(m)
 }
 
@@ -274,6 +576,312 @@ func _UserManagement_ListUsers_Handler(srv interface{}, ctx context.Context, dec
 	return interceptor(ctx, in, info, handler)
 }
 
+func _UserManagement_VerifyPassword_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(VerifyPasswordReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserManagementServer).VerifyPassword(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/userManagement.UserManagement/VerifyPassword",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserManagementServer).VerifyPassword(ctx, req.(*VerifyPasswordReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UserManagement_CreateWebhook_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateWebhookReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserManagementServer).CreateWebhook(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/userManagement.UserManagement/CreateWebhook",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserManagementServer).CreateWebhook(ctx, req.(*CreateWebhookReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UserManagement_GetWebhook_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetWebhookReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserManagementServer).GetWebhook(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/userManagement.UserManagement/GetWebhook",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserManagementServer).GetWebhook(ctx, req.(*GetWebhookReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UserManagement_UpdateWebhook_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateWebhookReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserManagementServer).UpdateWebhook(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/userManagement.UserManagement/UpdateWebhook",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserManagementServer).UpdateWebhook(ctx, req.(*UpdateWebhookReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UserManagement_DeleteWebhook_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteWebhookReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserManagementServer).DeleteWebhook(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/userManagement.UserManagement/DeleteWebhook",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserManagementServer).DeleteWebhook(ctx, req.(*DeleteWebhookReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UserManagement_ListWebhooks_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListWebhooksReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserManagementServer).ListWebhooks(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/userManagement.UserManagement/ListWebhooks",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserManagementServer).ListWebhooks(ctx, req.(*ListWebhooksReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UserManagement_ListWebhookDeliveries_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListWebhookDeliveriesReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserManagementServer).ListWebhookDeliveries(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/userManagement.UserManagement/ListWebhookDeliveries",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserManagementServer).ListWebhookDeliveries(ctx, req.(*ListWebhookDeliveriesReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UserManagement_ReplayWebhook_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReplayWebhookReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserManagementServer).ReplayWebhook(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/userManagement.UserManagement/ReplayWebhook",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserManagementServer).ReplayWebhook(ctx, req.(*ReplayWebhookReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UserManagement_Login_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LoginReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserManagementServer).Login(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/userManagement.UserManagement/Login",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserManagementServer).Login(ctx, req.(*LoginReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UserManagement_RefreshToken_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RefreshTokenReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserManagementServer).RefreshToken(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/userManagement.UserManagement/RefreshToken",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserManagementServer).RefreshToken(ctx, req.(*RefreshTokenReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UserManagement_Logout_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LogoutReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserManagementServer).Logout(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/userManagement.UserManagement/Logout",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserManagementServer).Logout(ctx, req.(*LogoutReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UserManagement_GrantRole_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GrantRoleReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserManagementServer).GrantRole(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/userManagement.UserManagement/GrantRole",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserManagementServer).GrantRole(ctx, req.(*GrantRoleReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UserManagement_RevokeRole_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RevokeRoleReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserManagementServer).RevokeRole(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/userManagement.UserManagement/RevokeRole",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserManagementServer).RevokeRole(ctx, req.(*RevokeRoleReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UserManagement_CreateAPIKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateAPIKeyReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserManagementServer).CreateAPIKey(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/userManagement.UserManagement/CreateAPIKey",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserManagementServer).CreateAPIKey(ctx, req.(*CreateAPIKeyReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UserManagement_ListAPIKeys_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListAPIKeysReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserManagementServer).ListAPIKeys(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/userManagement.UserManagement/ListAPIKeys",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserManagementServer).ListAPIKeys(ctx, req.(*ListAPIKeysReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UserManagement_RotateAPIKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RotateAPIKeyReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserManagementServer).RotateAPIKey(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/userManagement.UserManagement/RotateAPIKey",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserManagementServer).RotateAPIKey(ctx, req.(*RotateAPIKeyReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UserManagement_RevokeAPIKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RevokeAPIKeyReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserManagementServer).RevokeAPIKey(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/userManagement.UserManagement/RevokeAPIKey",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserManagementServer).RevokeAPIKey(ctx, req.(*RevokeAPIKeyReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // UserManagement_ServiceDesc is the grpc.ServiceDesc for UserManagement service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -301,6 +909,74 @@ var UserManagement_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "ListUsers",
 			Handler:    _UserManagement_ListUsers_Handler,
 		},
+		{
+			MethodName: "VerifyPassword",
+			Handler:    _UserManagement_VerifyPassword_Handler,
+		},
+		{
+			MethodName: "CreateWebhook",
+			Handler:    _UserManagement_CreateWebhook_Handler,
+		},
+		{
+			MethodName: "GetWebhook",
+			Handler:    _UserManagement_GetWebhook_Handler,
+		},
+		{
+			MethodName: "UpdateWebhook",
+			Handler:    _UserManagement_UpdateWebhook_Handler,
+		},
+		{
+			MethodName: "DeleteWebhook",
+			Handler:    _UserManagement_DeleteWebhook_Handler,
+		},
+		{
+			MethodName: "ListWebhooks",
+			Handler:    _UserManagement_ListWebhooks_Handler,
+		},
+		{
+			MethodName: "ListWebhookDeliveries",
+			Handler:    _UserManagement_ListWebhookDeliveries_Handler,
+		},
+		{
+			MethodName: "ReplayWebhook",
+			Handler:    _UserManagement_ReplayWebhook_Handler,
+		},
+		{
+			MethodName: "Login",
+			Handler:    _UserManagement_Login_Handler,
+		},
+		{
+			MethodName: "RefreshToken",
+			Handler:    _UserManagement_RefreshToken_Handler,
+		},
+		{
+			MethodName: "Logout",
+			Handler:    _UserManagement_Logout_Handler,
+		},
+		{
+			MethodName: "GrantRole",
+			Handler:    _UserManagement_GrantRole_Handler,
+		},
+		{
+			MethodName: "RevokeRole",
+			Handler:    _UserManagement_RevokeRole_Handler,
+		},
+		{
+			MethodName: "CreateAPIKey",
+			Handler:    _UserManagement_CreateAPIKey_Handler,
+		},
+		{
+			MethodName: "ListAPIKeys",
+			Handler:    _UserManagement_ListAPIKeys_Handler,
+		},
+		{
+			MethodName: "RotateAPIKey",
+			Handler:    _UserManagement_RotateAPIKey_Handler,
+		},
+		{
+			MethodName: "RevokeAPIKey",
+			Handler:    _UserManagement_RevokeAPIKey_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -308,6 +984,11 @@ var UserManagement_ServiceDesc = grpc.ServiceDesc{
 			Handler:       _UserManagement_NotifyUserChanges_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "NotifyUserCloudEvents",
+			Handler:       _UserManagement_NotifyUserCloudEvents_Handler,
+			ServerStreams: true,
+		},
 	},
 	Metadata: "userManagement.proto",
 }
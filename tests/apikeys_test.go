@@ -0,0 +1,142 @@
+package tests
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"strings"
+	"testing"
+	"time"
+	"userManagement/infra/auth"
+	"userManagement/infra/database"
+	"userManagement/infra/server"
+	pb "userManagement/proto"
+)
+
+// newAPIKeysClient returns a client of a server issuing API keys, authenticated as the admin a@a.com
+func newAPIKeysClient(t *testing.T) (*server.UserManagementServer, pb.UserManagementClient, context.Context) {
+	userServer := newAuthServer(t)
+	userServer.APIKeys = auth.NewAPIKeys(database.NewMemoryAPIKeyStore())
+	client := newAuthenticatedClient(t, userServer, userServer.APIKeys)
+	mustCreateTestUser(t, userServer, "a@a.com")
+	mustGrantRole(t, userServer, "a@a.com", auth.RoleAdmin)
+	return userServer, client, loginAs(t, client, "a@a.com")
+}
+
+func mustCreateAPIKey(t *testing.T, client pb.UserManagementClient, admin context.Context, key *pb.APIKey) *pb.APIKey {
+	t.Helper()
+	created, err := client.CreateAPIKey(admin, &pb.CreateAPIKeyReq{ApiKey: key})
+	if err != nil {
+		t.Fatalf("Could not create API key: %v", err)
+	}
+	return created
+}
+
+func withAPIKey(key string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), auth.APIKeyHeader, key)
+}
+
+func TestAPIKeysAuthenticateServices(t *testing.T) {
+	userServer, client, admin := newAPIKeysClient(t)
+	created := mustCreateAPIKey(t, client, admin, &pb.APIKey{Service: "reports", Scopes: []string{string(auth.PermissionList)}})
+	assert.True(t, strings.HasPrefix(created.Key, auth.APIKeyPrefix))
+	assert.EqualValues(t, "reports", created.Service)
+	assert.NotNil(t, created.CreatedAt)
+	assert.Nil(t, created.ExpiresAt)
+
+	users, err := client.ListUsers(withAPIKey(created.Key), &pb.ListUsersReq{})
+	assert.Nil(t, err)
+	assert.Len(t, users.Users, 1)
+	_, err = client.GetUser(withAPIKey(created.Key), &pb.GetUserReq{UserId: "a@a.com"})
+	assertPermissionDenied(t, err, auth.PermissionRead)
+	_, err = client.CreateAPIKey(withAPIKey(created.Key), &pb.CreateAPIKeyReq{ApiKey: &pb.APIKey{Service: "reports"}})
+	assertPermissionDenied(t, err, auth.PermissionAPIKeys)
+
+	// The key itself is only returned when it is created, and its last use is stored
+	keys, err := client.ListAPIKeys(admin, &pb.ListAPIKeysReq{Service: "reports"})
+	assert.Nil(t, err)
+	if assert.Len(t, keys.ApiKeys, 1) {
+		assert.EqualValues(t, created.Id, keys.ApiKeys[0].Id)
+		assert.Empty(t, keys.ApiKeys[0].Key)
+		assert.NotNil(t, keys.ApiKeys[0].LastUsedAt)
+	}
+
+	// Users acting with an API key are the service itself
+	_, err = client.CreateUser(withAPIKey(created.Key), &pb.CreateUserReq{User: &pb.User{Email: "b@a.com", Password: "1234"}})
+	assert.Nil(t, err)
+	events, err := userServer.EventLog.ListEvents(context.Background(), database.EventQuery{After: 1, Limit: 1})
+	assert.Nil(t, err)
+	assert.EqualValues(t, "service:reports", events[0].Actor)
+}
+
+func TestRotateAndRevokeAPIKeys(t *testing.T) {
+	_, client, admin := newAPIKeysClient(t)
+	created := mustCreateAPIKey(t, client, admin, &pb.APIKey{Service: "reports", Scopes: []string{string(auth.PermissionList)}})
+
+	rotated, err := client.RotateAPIKey(admin, &pb.RotateAPIKeyReq{ApiKeyId: created.Id})
+	assert.Nil(t, err)
+	assert.NotEqual(t, created.Key, rotated.Key)
+	assert.NotNil(t, rotated.RotatedAt)
+	assert.EqualValues(t, created.Scopes, rotated.Scopes)
+
+	_, err = client.ListUsers(withAPIKey(created.Key), &pb.ListUsersReq{})
+	assert.EqualValues(t, codes.Unauthenticated, status.Code(err))
+	_, err = client.ListUsers(withAPIKey(rotated.Key), &pb.ListUsersReq{})
+	assert.Nil(t, err)
+
+	revoked, err := client.RevokeAPIKey(admin, &pb.RevokeAPIKeyReq{ApiKeyId: created.Id})
+	assert.Nil(t, err)
+	assert.NotNil(t, revoked.RevokedAt)
+	_, err = client.ListUsers(withAPIKey(rotated.Key), &pb.ListUsersReq{})
+	assert.EqualValues(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = client.RotateAPIKey(admin, &pb.RotateAPIKeyReq{ApiKeyId: created.Id})
+	assert.EqualValues(t, codes.FailedPrecondition, status.Code(err))
+	_, err = client.RevokeAPIKey(admin, &pb.RevokeAPIKeyReq{ApiKeyId: "missing"})
+	assert.EqualValues(t, codes.NotFound, status.Code(err))
+}
+
+func TestExpiredAPIKeys(t *testing.T) {
+	userServer, client, admin := newAPIKeysClient(t)
+	created := mustCreateAPIKey(t, client, admin, &pb.APIKey{
+		Service:   "reports",
+		Scopes:    []string{string(auth.PermissionList)},
+		ExpiresAt: timestamppb.New(time.Now().Add(time.Second)),
+	})
+	assert.NotNil(t, created.ExpiresAt)
+
+	_, err := client.ListUsers(withAPIKey(created.Key), &pb.ListUsersReq{})
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		_, err := userServer.APIKeys.VerifyAPIKey(context.Background(), created.Key)
+		return err != nil
+	}, 3*time.Second, 50*time.Millisecond)
+	_, err = client.ListUsers(withAPIKey(created.Key), &pb.ListUsersReq{})
+	assert.EqualValues(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestInvalidAPIKeys(t *testing.T) {
+	userServer, client, admin := newAPIKeysClient(t)
+
+	for _, key := range []*pb.APIKey{
+		{Service: ""},
+		{Service: "reports service"},
+		{Service: "reports", Scopes: []string{string(auth.PermissionReadSelf)}},
+		{Service: "reports", Scopes: []string{"users.everything"}},
+		{Service: "reports", ExpiresAt: timestamppb.New(time.Now().Add(-time.Hour))},
+	} {
+		_, err := client.CreateAPIKey(admin, &pb.CreateAPIKeyReq{ApiKey: key})
+		assert.EqualValues(t, codes.InvalidArgument, status.Code(err), key.String())
+	}
+
+	mustCreateTestUser(t, userServer, "b@a.com")
+	_, err := client.ListAPIKeys(loginAs(t, client, "b@a.com"), &pb.ListAPIKeysReq{})
+	assertPermissionDenied(t, err, auth.PermissionAPIKeys)
+
+	userServer.APIKeys = nil
+	_, err = client.ListAPIKeys(admin, &pb.ListAPIKeysReq{})
+	assert.EqualValues(t, codes.Unimplemented, status.Code(err))
+}
@@ -0,0 +1,348 @@
+package tests
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"userManagement/infra/auth"
+	"userManagement/infra/database"
+	"userManagement/infra/gateway"
+	"userManagement/infra/password"
+	"userManagement/infra/server"
+	pb "userManagement/proto"
+)
+
+// newAuthServer creates an events server issuing tokens signed with a generated key, which only authorizes the
+// calls of authenticated callers, so its methods called directly need a principal
+func newAuthServer(t *testing.T) *server.UserManagementServer {
+	keys, err := auth.GenerateKeys()
+	if err != nil {
+		t.Fatalf("Could not generate keys: %v", err)
+	}
+	userServer := newEventsServer(t)
+	userServer.Auth = auth.NewIssuer(auth.DefaultConfig(), keys, database.NewMemoryRefreshTokenStore())
+	userServer.TrustUnauthenticated = false
+	return userServer
+}
+
+func mustLogin(t *testing.T, client pb.UserManagementClient, email string) *pb.TokenResponse {
+	t.Helper()
+	tokens, err := client.Login(context.Background(), &pb.LoginReq{Email: email, Password: "1234"})
+	if err != nil {
+		t.Fatalf("Could not log in as %s: %v", email, err)
+	}
+	return tokens
+}
+
+// writeKey stores the received key, or its public part, in a PEM file named after the key id
+func writeKey(t *testing.T, dir, id string, key interface{}, public bool) {
+	t.Helper()
+	var block *pem.Block
+	if public {
+		der, err := x509.MarshalPKIXPublicKey(key)
+		if err != nil {
+			t.Fatalf("Could not encode public key: %v", err)
+		}
+		block = &pem.Block{Type: "PUBLIC KEY", Bytes: der}
+	} else {
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			t.Fatalf("Could not encode private key: %v", err)
+		}
+		block = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	}
+	if err := os.WriteFile(filepath.Join(dir, id+".pem"), pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatalf("Could not write key: %v", err)
+	}
+}
+
+func mustLoadKeys(t *testing.T, dir, signingKeyID string) *auth.KeySet {
+	t.Helper()
+	keys, err := auth.LoadKeys(dir, signingKeyID)
+	if err != nil {
+		t.Fatalf("Could not load keys: %v", err)
+	}
+	return keys
+}
+
+func getTokenHeader(t *testing.T, accessToken string) map[string]interface{} {
+	t.Helper()
+	token, _, err := jwt.NewParser().ParseUnverified(accessToken, &auth.Claims{})
+	if err != nil {
+		t.Fatalf("Could not parse access token: %v", err)
+	}
+	return token.Header
+}
+
+func TestLoginIssuesTokens(t *testing.T) {
+	userServer := newAuthServer(t)
+	client := newTestClient(t, userServer)
+	mustCreateTestUser(t, userServer, "a@a.com")
+	user, err := userServer.DbClient.GetUser(context.Background(), &pb.GetUserReq{UserId: "a@a.com"})
+	if err != nil {
+		t.Fatalf("Could not get user: %v", err)
+	}
+
+	tokens := mustLogin(t, client, "A@a.com")
+	assert.EqualValues(t, auth.TokenType, tokens.TokenType)
+	assert.EqualValues(t, 15*60, tokens.ExpiresIn)
+	assert.EqualValues(t, 30*24*60*60, tokens.RefreshExpiresIn)
+	assert.NotEmpty(t, tokens.RefreshToken)
+
+	claims, err := userServer.Auth.Verify(tokens.AccessToken)
+	assert.Nil(t, err)
+	assert.EqualValues(t, user.Id, claims.Subject)
+	assert.EqualValues(t, "a@a.com", claims.Email)
+	assert.EqualValues(t, "usermanagement", claims.Issuer)
+	assert.WithinDuration(t, time.Now().Add(15*time.Minute), claims.ExpiresAt.Time, 5*time.Second)
+	assert.EqualValues(t, auth.AlgorithmEdDSA, getTokenHeader(t, tokens.AccessToken)["alg"])
+}
+
+func TestLoginInvalidCredentials(t *testing.T) {
+	userServer := newAuthServer(t)
+	client := newTestClient(t, userServer)
+	mustCreateTestUser(t, userServer, "a@a.com")
+
+	for _, req := range []*pb.LoginReq{
+		{Email: "a@a.com", Password: "4321"},
+		{Email: "b@a.com", Password: "1234"},
+		{Email: "a@a.com"},
+	} {
+		_, err := client.Login(context.Background(), req)
+		assert.EqualValues(t, codes.Unauthenticated, status.Code(err))
+		assert.EqualValues(t, "invalid email or password", status.Convert(err).Message())
+	}
+
+	_, err := newTestClient(t, newEventsServer(t)).Login(context.Background(), &pb.LoginReq{Email: "a@a.com", Password: "1234"})
+	assert.EqualValues(t, codes.Unimplemented, status.Code(err))
+}
+
+func TestLoginMissingUserHashesPassword(t *testing.T) {
+	// Hashing must cost more than the call for the difference to show
+	password.DefaultParams = password.Params{Memory: 8 * 1024, Iterations: 2, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+	t.Cleanup(func() { password.DefaultParams = testParams })
+	userServer := newAuthServer(t)
+	client := newTestClient(t, userServer)
+	mustCreateTestUser(t, userServer, "a@a.com")
+
+	login := func(email string) time.Duration {
+		start := time.Now()
+		_, err := client.Login(context.Background(), &pb.LoginReq{Email: email, Password: "4321"})
+		assert.EqualValues(t, codes.Unauthenticated, status.Code(err))
+		return time.Since(start)
+	}
+	// Both logins derive a key, the missing user would otherwise answer orders of magnitude faster
+	existing, missing := login("a@a.com"), login("b@a.com")
+	assert.Greater(t, int64(missing), int64(existing/4), "missing %s, existing %s", missing, existing)
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	userServer := newAuthServer(t)
+	client := newTestClient(t, userServer)
+	mustCreateTestUser(t, userServer, "a@a.com")
+	login := mustLogin(t, client, "a@a.com")
+
+	refreshed, err := client.RefreshToken(context.Background(), &pb.RefreshTokenReq{RefreshToken: login.RefreshToken})
+	assert.Nil(t, err)
+	assert.NotEqual(t, login.RefreshToken, refreshed.RefreshToken)
+	assert.NotEqual(t, login.AccessToken, refreshed.AccessToken)
+	_, err = userServer.Auth.Verify(refreshed.AccessToken)
+	assert.Nil(t, err)
+
+	// Using the first token again revokes the token it was exchanged for too
+	_, err = client.RefreshToken(context.Background(), &pb.RefreshTokenReq{RefreshToken: login.RefreshToken})
+	assert.EqualValues(t, codes.Unauthenticated, status.Code(err))
+	_, err = client.RefreshToken(context.Background(), &pb.RefreshTokenReq{RefreshToken: refreshed.RefreshToken})
+	assert.EqualValues(t, codes.Unauthenticated, status.Code(err))
+
+	// Other logins are not affected
+	other := mustLogin(t, client, "a@a.com")
+	_, err = client.RefreshToken(context.Background(), &pb.RefreshTokenReq{RefreshToken: other.RefreshToken})
+	assert.Nil(t, err)
+
+	_, err = client.RefreshToken(context.Background(), &pb.RefreshTokenReq{RefreshToken: "unknown"})
+	assert.EqualValues(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestRefreshTokenOfDeletedUser(t *testing.T) {
+	userServer := newAuthServer(t)
+	client := newTestClient(t, userServer)
+	mustCreateTestUser(t, userServer, "a@a.com")
+	login := mustLogin(t, client, "a@a.com")
+
+	_, err := userServer.DbClient.DeleteUser(context.Background(), &pb.DeleteUserReq{UserId: "a@a.com"})
+	if err != nil {
+		t.Fatalf("Could not delete user: %v", err)
+	}
+	_, err = client.RefreshToken(context.Background(), &pb.RefreshTokenReq{RefreshToken: login.RefreshToken})
+	assert.EqualValues(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestLogoutRevokesFamily(t *testing.T) {
+	userServer := newAuthServer(t)
+	client := newTestClient(t, userServer)
+	mustCreateTestUser(t, userServer, "a@a.com")
+	login := mustLogin(t, client, "a@a.com")
+	refreshed, err := client.RefreshToken(context.Background(), &pb.RefreshTokenReq{RefreshToken: login.RefreshToken})
+	if err != nil {
+		t.Fatalf("Could not refresh tokens: %v", err)
+	}
+
+	logout, err := client.Logout(context.Background(), &pb.LogoutReq{RefreshToken: login.RefreshToken})
+	assert.Nil(t, err)
+	assert.True(t, logout.Revoked)
+
+	_, err = client.RefreshToken(context.Background(), &pb.RefreshTokenReq{RefreshToken: refreshed.RefreshToken})
+	assert.EqualValues(t, codes.Unauthenticated, status.Code(err))
+
+	logout, err = client.Logout(context.Background(), &pb.LogoutReq{RefreshToken: refreshed.RefreshToken})
+	assert.Nil(t, err)
+	assert.False(t, logout.Revoked)
+
+	_, err = client.Logout(context.Background(), &pb.LogoutReq{RefreshToken: "unknown"})
+	assert.EqualValues(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestSigningKeyRotation(t *testing.T) {
+	dir := t.TempDir()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Could not generate RSA key: %v", err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Could not generate Ed25519 key: %v", err)
+	}
+	writeKey(t, dir, "2023", rsaKey, false)
+	writeKey(t, dir, "2024", edKey, false)
+
+	_, err = auth.LoadKeys(dir, "")
+	assert.Error(t, err)
+
+	store := database.NewMemoryRefreshTokenStore()
+	subject := auth.Subject{UserID: "user", Email: "a@a.com"}
+	oldIssuer := auth.NewIssuer(auth.DefaultConfig(), mustLoadKeys(t, dir, "2023"), store)
+	oldTokens, err := oldIssuer.Login(context.Background(), subject)
+	if err != nil {
+		t.Fatalf("Could not issue tokens: %v", err)
+	}
+	assert.EqualValues(t, "2023", getTokenHeader(t, oldTokens.AccessToken)["kid"])
+	assert.EqualValues(t, auth.AlgorithmRS256, getTokenHeader(t, oldTokens.AccessToken)["alg"])
+
+	newIssuer := auth.NewIssuer(auth.DefaultConfig(), mustLoadKeys(t, dir, "2024"), store)
+	newTokens, err := newIssuer.Login(context.Background(), subject)
+	if err != nil {
+		t.Fatalf("Could not issue tokens: %v", err)
+	}
+	assert.EqualValues(t, "2024", getTokenHeader(t, newTokens.AccessToken)["kid"])
+	_, err = newIssuer.Verify(oldTokens.AccessToken)
+	assert.Nil(t, err)
+
+	// Keeping only the public part of the old key, tokens it signed are still verified
+	writeKey(t, dir, "2023", &rsaKey.PublicKey, true)
+	retiredIssuer := auth.NewIssuer(auth.DefaultConfig(), mustLoadKeys(t, dir, ""), store)
+	_, err = retiredIssuer.Verify(oldTokens.AccessToken)
+	assert.Nil(t, err)
+	_, err = auth.LoadKeys(dir, "2023")
+	assert.Error(t, err)
+
+	generated, err := auth.GenerateKeys()
+	if err != nil {
+		t.Fatalf("Could not generate keys: %v", err)
+	}
+	_, err = auth.NewIssuer(auth.DefaultConfig(), generated, store).Verify(newTokens.AccessToken)
+	assert.EqualValues(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestLoadKeysRejectsInvalidKeys(t *testing.T) {
+	_, err := auth.LoadKeys(t.TempDir(), "")
+	assert.Error(t, err)
+
+	dir := t.TempDir()
+	weakKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("Could not generate RSA key: %v", err)
+	}
+	writeKey(t, dir, "weak", weakKey, false)
+	_, err = auth.LoadKeys(dir, "")
+	assert.Error(t, err)
+
+	dir = t.TempDir()
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "invalid.pem"), []byte("not a key"), 0600))
+	_, err = auth.LoadKeys(dir, "")
+	assert.Error(t, err)
+}
+
+func TestVerifyRejectsInvalidTokens(t *testing.T) {
+	keys, err := auth.GenerateKeys()
+	if err != nil {
+		t.Fatalf("Could not generate keys: %v", err)
+	}
+	store := database.NewMemoryRefreshTokenStore()
+	subject := auth.Subject{UserID: "user"}
+	issuer := auth.NewIssuer(auth.DefaultConfig(), keys, store)
+
+	expiredConfig := auth.DefaultConfig()
+	expiredConfig.AccessTokenTTL = -time.Minute
+	expired, err := auth.NewIssuer(expiredConfig, keys, store).Login(context.Background(), subject)
+	if err != nil {
+		t.Fatalf("Could not issue tokens: %v", err)
+	}
+	_, err = issuer.Verify(expired.AccessToken)
+	assert.EqualValues(t, codes.Unauthenticated, status.Code(err))
+
+	otherConfig := auth.DefaultConfig()
+	otherConfig.Audience = "other"
+	other, err := auth.NewIssuer(otherConfig, keys, store).Login(context.Background(), subject)
+	if err != nil {
+		t.Fatalf("Could not issue tokens: %v", err)
+	}
+	_, err = issuer.Verify(other.AccessToken)
+	assert.EqualValues(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = issuer.Verify("not.a.token")
+	assert.EqualValues(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestLoginREST(t *testing.T) {
+	userServer := newAuthServer(t)
+	mustCreateTestUser(t, userServer, "a@a.com")
+	mux := gateway.NewServeMux()
+	if err := pb.RegisterUserManagementHandlerServer(context.Background(), mux, userServer); err != nil {
+		t.Fatalf("Could not register gateway handlers: %v", err)
+	}
+	gatewayServer := httptest.NewServer(mux)
+	defer gatewayServer.Close()
+
+	resp := doRequest(t, http.MethodPost, gatewayServer.URL+"/v1/auth:login", `{"email": "a@a.com", "password": "1234"}`, nil)
+	assert.EqualValues(t, http.StatusOK, resp.StatusCode)
+	var tokens struct {
+		AccessToken  string `json:"accessToken"`
+		TokenType    string `json:"tokenType"`
+		RefreshToken string `json:"refreshToken"`
+	}
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(&tokens))
+	assert.EqualValues(t, "Bearer", tokens.TokenType)
+	_, err := userServer.Auth.Verify(tokens.AccessToken)
+	assert.Nil(t, err)
+
+	resp = doRequest(t, http.MethodPost, gatewayServer.URL+"/v1/auth:refresh", `{"refresh_token": "`+tokens.RefreshToken+`"}`, nil)
+	assert.EqualValues(t, http.StatusOK, resp.StatusCode)
+
+	resp = doRequest(t, http.MethodPost, gatewayServer.URL+"/v1/auth:login", `{"email": "a@a.com", "password": "wrong"}`, nil)
+	assert.EqualValues(t, http.StatusUnauthorized, resp.StatusCode)
+}
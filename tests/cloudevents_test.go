@@ -0,0 +1,158 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"userManagement/infra/cloudevents"
+	pb "userManagement/proto"
+)
+
+func newTestUserEvent() *pb.UserEvent {
+	return &pb.UserEvent{
+		EventId:   "event",
+		Type:      pb.UserEventType_USER_CREATED,
+		UserId:    "user",
+		Email:     "a@a.com",
+		Timestamp: timestamppb.New(time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)),
+		Sequence:  7,
+	}
+}
+
+func TestCloudEventTypes(t *testing.T) {
+	assert.EqualValues(t, "com.usermanagement.user.created", cloudevents.Type(pb.UserEventType_USER_CREATED))
+	assert.EqualValues(t, "com.usermanagement.user.updated", cloudevents.Type(pb.UserEventType_USER_UPDATED))
+	assert.EqualValues(t, "com.usermanagement.user.deleted", cloudevents.Type(pb.UserEventType_USER_DELETED))
+}
+
+func TestCloudEventStructuredMode(t *testing.T) {
+	content, err := json.Marshal(cloudevents.New(cloudevents.DefaultConfig(), newTestUserEvent()))
+	if err != nil {
+		t.Fatalf("Could not encode cloudevent: %v", err)
+	}
+
+	var attributes map[string]interface{}
+	assert.Nil(t, json.Unmarshal(content, &attributes))
+	assert.EqualValues(t, "1.0", attributes["specversion"])
+	assert.EqualValues(t, "event", attributes["id"])
+	assert.EqualValues(t, "/usermanagement", attributes["source"])
+	assert.EqualValues(t, "com.usermanagement.user.created", attributes["type"])
+	assert.EqualValues(t, "user", attributes["subject"])
+	assert.EqualValues(t, "2023-01-02T03:04:05Z", attributes["time"])
+	assert.EqualValues(t, "application/json", attributes["datacontenttype"])
+	assert.EqualValues(t, cloudevents.DefaultConfig().DataSchema, attributes["dataschema"])
+	assert.EqualValues(t, "7", attributes["sequence"])
+	assert.EqualValues(t, "a@a.com", attributes["data"].(map[string]interface{})["email"])
+
+	header := http.Header{"Content-Type": {cloudevents.StructuredContentType + "; charset=utf-8"}}
+	event, err := cloudevents.ReadHTTP(header, content)
+	assert.Nil(t, err)
+	assert.EqualValues(t, "event", event.ID)
+	assert.EqualValues(t, 7, event.Sequence)
+	assert.EqualValues(t, "a@a.com", event.Data.Email)
+
+	_, err = cloudevents.ReadHTTP(header, []byte(`{"specversion": "0.3", "id": "1", "source": "/", "type": "t", "data": {}}`))
+	assert.Error(t, err)
+}
+
+func TestCloudEventBinaryMode(t *testing.T) {
+	header := http.Header{}
+	body, err := cloudevents.New(cloudevents.DefaultConfig(), newTestUserEvent()).WriteBinary(header)
+	if err != nil {
+		t.Fatalf("Could not encode cloudevent: %v", err)
+	}
+
+	assert.EqualValues(t, "application/json", header.Get("Content-Type"))
+	assert.EqualValues(t, "1.0", header.Get("ce-specversion"))
+	assert.EqualValues(t, "com.usermanagement.user.created", header.Get("ce-type"))
+	assert.EqualValues(t, "user", header.Get("ce-subject"))
+
+	event, err := cloudevents.ReadHTTP(header, body)
+	assert.Nil(t, err)
+	assert.EqualValues(t, cloudevents.New(cloudevents.DefaultConfig(), newTestUserEvent()).Time, event.Time)
+	assert.EqualValues(t, "event", event.Data.EventId)
+
+	header.Del("ce-id")
+	_, err = cloudevents.ReadHTTP(header, body)
+	assert.Error(t, err)
+}
+
+func TestCloudEventProtoFormat(t *testing.T) {
+	event, err := cloudevents.New(cloudevents.Config{}, newTestUserEvent()).Proto()
+	if err != nil {
+		t.Fatalf("Could not encode cloudevent: %v", err)
+	}
+
+	assert.EqualValues(t, "1.0", event.SpecVersion)
+	assert.EqualValues(t, "/usermanagement", event.Source)
+	assert.EqualValues(t, "user", event.Attributes["subject"].GetCeString())
+	assert.EqualValues(t, "7", event.Attributes["sequence"].GetCeString())
+	assert.NotContains(t, event.Attributes, "dataschema")
+
+	var data pb.UserEvent
+	assert.Nil(t, event.GetProtoData().UnmarshalTo(&data))
+	assert.EqualValues(t, "a@a.com", data.Email)
+}
+
+func TestNotifyUserCloudEvents(t *testing.T) {
+	userServer := newEventsServer(t)
+	client := newTestClient(t, userServer)
+	mustCreateTestUser(t, userServer, "a@a.com")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := client.NotifyUserCloudEvents(ctx, &pb.NotifyUserChangesReq{SinceTimestamp: timestamppb.New(time.Unix(0, 0))})
+	if err != nil {
+		t.Fatalf("Error when creating server side stream: %v", err)
+	}
+	event, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("Could not receive cloudevent: %v", err)
+	}
+
+	assert.EqualValues(t, "com.usermanagement.user.created", event.Type)
+	assert.EqualValues(t, "1", event.Attributes["sequence"].GetCeString())
+	var data pb.UserEvent
+	assert.Nil(t, event.GetProtoData().UnmarshalTo(&data))
+	assert.EqualValues(t, "a@a.com", data.Email)
+	assert.EqualValues(t, data.UserId, event.Attributes["subject"].GetCeString())
+}
+
+func TestWebhookCloudEventsFormat(t *testing.T) {
+	userServer := newWebhooksServer(t, testWebhookConfig())
+	received := make(chan cloudevents.Event, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		event, err := cloudevents.ReadHTTP(r.Header, body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		received <- event
+	}))
+	defer receiver.Close()
+
+	for _, format := range []pb.EventFormat{pb.EventFormat_CLOUDEVENTS_STRUCTURED, pb.EventFormat_CLOUDEVENTS_BINARY} {
+		_, err := userServer.CreateWebhook(context.Background(), &pb.CreateWebhookReq{Webhook: &pb.Webhook{Url: receiver.URL, Format: format}})
+		if err != nil {
+			t.Fatalf("Could not create webhook: %v", err)
+		}
+	}
+	mustCreateTestUser(t, userServer, "a@a.com")
+
+	for i := 0; i < 2; i++ {
+		select {
+		case event := <-received:
+			assert.EqualValues(t, "com.usermanagement.user.created", event.Type)
+			assert.EqualValues(t, "a@a.com", event.Data.Email)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("No cloudevent received by the webhook")
+		}
+	}
+}
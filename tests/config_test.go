@@ -0,0 +1,103 @@
+package tests
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"userManagement/infra/config"
+)
+
+func TestConfigDefaults(t *testing.T) {
+	cfg, err := config.Load(nil)
+	if err != nil {
+		t.Fatalf("Could not load config: %v", err)
+	}
+
+	assert.EqualValues(t, config.DatabaseMongo, cfg.Database)
+	assert.EqualValues(t, "userManagement", cfg.Mongo.Database)
+	assert.EqualValues(t, "users", cfg.Mongo.Collection)
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.json")
+	err := os.WriteFile(configFile, []byte(`{
+		"mongo-uri": "mongodb://file:27017/",
+		"mongo-database": "fileDatabase",
+		"mongo-collection": "fileCollection",
+		"mongo-max-pool-size": 10,
+		"mongo-tls": true,
+		"mongo-connect-timeout": "3s"
+	}`), 0600)
+	if err != nil {
+		t.Fatalf("Could not write config file: %v", err)
+	}
+
+	t.Setenv(config.EnvName("config"), configFile)
+	t.Setenv(config.EnvName("mongo-database"), "envDatabase")
+	t.Setenv(config.EnvName("mongo-collection"), "envCollection")
+
+	cfg, err := config.Load([]string{"-mongo-collection", "flagCollection", "-database", "memory"})
+	if err != nil {
+		t.Fatalf("Could not load config: %v", err)
+	}
+
+	assert.EqualValues(t, config.DatabaseMemory, cfg.Database)
+	assert.EqualValues(t, "mongodb://file:27017/", cfg.Mongo.URI)
+	assert.EqualValues(t, "envDatabase", cfg.Mongo.Database)
+	assert.EqualValues(t, "flagCollection", cfg.Mongo.Collection)
+	assert.EqualValues(t, 10, cfg.Mongo.MaxPoolSize)
+	assert.True(t, cfg.Mongo.TLS)
+	assert.EqualValues(t, 3*time.Second, cfg.Mongo.ConnectTimeout)
+}
+
+func TestConfigInvalidValues(t *testing.T) {
+	_, err := config.Load([]string{"-database", "postgres"})
+	assert.Error(t, err)
+
+	_, err = config.Load([]string{"-mongo-connect-timeout", "soon"})
+	assert.Error(t, err)
+
+	_, err = config.Load([]string{"-notify-slow-consumer-policy", "ignore"})
+	assert.Error(t, err)
+
+	_, err = config.Load([]string{"-notify-buffer-size", "0"})
+	assert.Error(t, err)
+
+	_, err = config.Load([]string{"-event-publisher", "rabbitmq"})
+	assert.Error(t, err)
+
+	_, err = config.Load([]string{"-event-publisher", "kafka", "-kafka-brokers", " , "})
+	assert.Error(t, err)
+
+	_, err = config.Load([]string{"-webhook-max-attempts", "0"})
+	assert.Error(t, err)
+
+	_, err = config.Load([]string{"-webhook-initial-backoff", "1m", "-webhook-max-backoff", "1s"})
+	assert.Error(t, err)
+
+	_, err = config.Load([]string{"-access-token-ttl", "0s"})
+	assert.Error(t, err)
+
+	_, err = config.Load([]string{"-jwt-signing-key", "2024"})
+	assert.Error(t, err)
+
+	_, err = config.Load([]string{"-auth-public-methods", "CreateUser,SignUp"})
+	assert.Error(t, err)
+
+	_, err = config.Load([]string{"-tls-cert-file", "server.pem"})
+	assert.Error(t, err)
+
+	_, err = config.Load([]string{"-tls-client-ca-file", "ca.pem"})
+	assert.Error(t, err)
+
+	_, err = config.Load([]string{"-tls-cert-file", "server.pem", "-tls-key-file", "server-key.pem",
+		"-tls-client-ca-file", "ca.pem", "-tls-require-client-cert"})
+	assert.Error(t, err)
+
+	configFile := filepath.Join(t.TempDir(), "config.json")
+	_ = os.WriteFile(configFile, []byte(`{"unknown-setting": 1}`), 0600)
+	_, err = config.Load([]string{"-config", configFile})
+	assert.Error(t, err)
+}
@@ -0,0 +1,211 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"userManagement/infra/consumer"
+	"userManagement/infra/server"
+	pb "userManagement/proto"
+)
+
+// channelSink sends the events written to it to a channel, failing the writes of the sequences in fail once
+type channelSink struct {
+	events chan *pb.UserEvent
+	fail   map[int64]bool
+}
+
+func (s *channelSink) Write(_ context.Context, event *pb.UserEvent) error {
+	if s.fail[event.Sequence] {
+		delete(s.fail, event.Sequence)
+		return errors.New("sink unavailable")
+	}
+	s.events <- event
+	return nil
+}
+
+func (s *channelSink) Close() error {
+	return nil
+}
+
+func (s *channelSink) receiveEmails(t *testing.T, count int) []string {
+	t.Helper()
+	var emails []string
+	for len(emails) < count {
+		select {
+		case event := <-s.events:
+			emails = append(emails, event.Email)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected %d events, got %v", count, emails)
+		}
+	}
+	return emails
+}
+
+// serveTCP serves the received server on the received address until the returned function is called
+func serveTCP(t *testing.T, userServer *server.UserManagementServer, address string) (string, func()) {
+	t.Helper()
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		t.Fatalf("Could not listen on %s: %v", address, err)
+	}
+	s := grpc.NewServer()
+	pb.RegisterUserManagementServer(s, userServer)
+	go func() {
+		_ = s.Serve(listener)
+	}()
+	t.Cleanup(s.Stop)
+	return listener.Addr().String(), s.Stop
+}
+
+func testConsumerConfig(target string) consumer.Config {
+	config := consumer.DefaultConfig()
+	config.Target = target
+	config.InitialBackoff = 10 * time.Millisecond
+	config.MaxBackoff = 20 * time.Millisecond
+	return config
+}
+
+// runConsumer runs the consumer until the test ends
+func runConsumer(t *testing.T, c *consumer.Consumer) {
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		_ = c.Run(ctx)
+		close(stopped)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-stopped
+	})
+}
+
+func TestConsumerBackoff(t *testing.T) {
+	config := consumer.Config{InitialBackoff: time.Second, MaxBackoff: 4 * time.Second}
+	for attempt, max := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second} {
+		backoff := config.Backoff(attempt + 1)
+		assert.GreaterOrEqual(t, backoff, max/2)
+		assert.LessOrEqual(t, backoff, max)
+	}
+}
+
+func TestConsumerReconnectsAndResumes(t *testing.T) {
+	userServer := newEventsServer(t)
+	address, stop := serveTCP(t, userServer, "127.0.0.1:0")
+	sink := &channelSink{events: make(chan *pb.UserEvent, 10), fail: map[int64]bool{2: true}}
+	c, err := consumer.New(testConsumerConfig(address), sink)
+	if err != nil {
+		t.Fatalf("Could not create consumer: %v", err)
+	}
+	runConsumer(t, c)
+	waitForSubscribers(t, userServer.Broker, 1)
+
+	mustCreateTestUser(t, userServer, "a@a.com")
+	mustCreateTestUser(t, userServer, "b@a.com")
+	// The failed write of the second event is retried after reconnecting
+	assert.EqualValues(t, []string{"a@a.com", "b@a.com"}, sink.receiveEmails(t, 2))
+
+	// Events stored while the server is down are received once it is back
+	stop()
+	mustCreateTestUser(t, userServer, "c@a.com")
+	serveTCP(t, userServer, address)
+	mustCreateTestUser(t, userServer, "d@a.com")
+	assert.EqualValues(t, []string{"c@a.com", "d@a.com"}, sink.receiveEmails(t, 2))
+	assert.Empty(t, sink.events)
+}
+
+func TestConsumerStateFile(t *testing.T) {
+	userServer := newEventsServer(t)
+	address, _ := serveTCP(t, userServer, "127.0.0.1:0")
+	mustCreateTestUser(t, userServer, "a@a.com")
+	mustCreateTestUser(t, userServer, "b@a.com")
+
+	config := testConsumerConfig(address)
+	config.StateFile = filepath.Join(t.TempDir(), "state")
+	assert.Nil(t, os.WriteFile(config.StateFile, []byte("1\n"), 0600))
+	sink := &channelSink{events: make(chan *pb.UserEvent, 10)}
+	c, err := consumer.New(config, sink)
+	if err != nil {
+		t.Fatalf("Could not create consumer: %v", err)
+	}
+	runConsumer(t, c)
+
+	assert.EqualValues(t, []string{"b@a.com"}, sink.receiveEmails(t, 1))
+	assert.Eventually(t, func() bool {
+		state, _ := os.ReadFile(config.StateFile)
+		return string(state) == "2"
+	}, 5*time.Second, 5*time.Millisecond)
+}
+
+func TestFileSinkRotates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	encode, _ := consumer.NewEncoder(consumer.FormatJSON)
+	sink, err := consumer.NewFileSink(path, 40, 2, encode)
+	if err != nil {
+		t.Fatalf("Could not create file sink: %v", err)
+	}
+	defer sink.Close()
+
+	for _, id := range []string{"1", "2", "3", "4"} {
+		assert.Nil(t, sink.Write(context.Background(), &pb.UserEvent{EventId: id, Sequence: 1}))
+	}
+
+	for file, id := range map[string]string{path: "4", path + ".1": "3", path + ".2": "2"} {
+		content, err := os.ReadFile(file)
+		assert.Nil(t, err)
+		assert.EqualValues(t, `{"eventId":"`+id+`","sequence":"1"}`+"\n", string(content))
+	}
+	_, err = os.Stat(path + ".3")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestHTTPSink(t *testing.T) {
+	statuses := make(chan int, 2)
+	statuses <- http.StatusOK
+	statuses <- http.StatusServiceUnavailable
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(<-statuses)
+	}))
+	defer receiver.Close()
+
+	sink, err := consumer.NewSink(consumer.SinkConfig{Sinks: consumer.SinkHTTP, Format: consumer.FormatCloudEvents, URL: receiver.URL, HTTPTimeout: time.Second})
+	if err != nil {
+		t.Fatalf("Could not create http sink: %v", err)
+	}
+	defer sink.Close()
+
+	assert.Nil(t, sink.Write(context.Background(), &pb.UserEvent{EventId: "1"}))
+	assert.Error(t, sink.Write(context.Background(), &pb.UserEvent{EventId: "2"}))
+}
+
+func TestExecSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "event.json")
+	sink, err := consumer.NewSink(consumer.SinkConfig{Sinks: consumer.SinkExec, Format: consumer.FormatJSON, Command: "cp /dev/stdin " + path})
+	if err != nil {
+		t.Fatalf("Could not create exec sink: %v", err)
+	}
+	defer sink.Close()
+
+	assert.Nil(t, sink.Write(context.Background(), &pb.UserEvent{EventId: "1"}))
+	content, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.EqualValues(t, `{"eventId":"1"}`, strings.TrimSpace(string(content)))
+
+	failing, _ := consumer.NewSink(consumer.SinkConfig{Sinks: consumer.SinkExec, Format: consumer.FormatJSON, Command: "false"})
+	assert.Error(t, failing.Write(context.Background(), &pb.UserEvent{EventId: "1"}))
+
+	// Commands running longer than the timeout are killed
+	hanging, _ := consumer.NewSink(consumer.SinkConfig{Sinks: consumer.SinkExec, Format: consumer.FormatJSON, Command: "sleep 10", ExecTimeout: 50 * time.Millisecond})
+	started := time.Now()
+	assert.Error(t, hanging.Write(context.Background(), &pb.UserEvent{EventId: "1"}))
+	assert.Less(t, time.Since(started), 5*time.Second)
+}
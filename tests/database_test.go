@@ -1,95 +1,277 @@
 package tests
 
 import (
+	"context"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"os"
+	"sync"
 	"testing"
+	"time"
+	"userManagement/entities"
+	"userManagement/infra/config"
 	"userManagement/infra/database"
+	"userManagement/infra/database/databasetest"
 	pb "userManagement/proto"
 )
 
-var (
-	client = database.DBClient
-)
+func TestMemoryClientConformance(t *testing.T) {
+	databasetest.RunAdapterConformance(t, func(t *testing.T) database.AdapterInterface {
+		return database.NewMemoryClient()
+	})
+}
+
+// newMongoTestClient connects to the mongodb configured with the same environment variables used by the service,
+// storing the users in the received collection, and disconnects when the test ends. It requires a mongodb instance,
+// so the test is skipped unless the USER_MANAGEMENT_TEST_DATABASE environment variable is set to "mongo".
+// Tests use dedicated collections, since they empty them
+func newMongoTestClient(t *testing.T, collection string) *database.MongoClient {
+	t.Helper()
+	if os.Getenv("USER_MANAGEMENT_TEST_DATABASE") != "mongo" {
+		t.Skip("USER_MANAGEMENT_TEST_DATABASE is not set to mongo")
+	}
+
+	cfg, err := config.Load(nil)
+	if err != nil {
+		t.Fatalf("Could not load config: %v", err)
+	}
+	cfg.Mongo.Collection = collection
 
-func TestDBCreateUser(t *testing.T) {
-	createdID, err := client.CreateUser(&pb.CreateUserReq{User: testUser})
+	mongoClient, err := database.NewMongoClient(context.Background(), cfg.Mongo)
 	if err != nil {
-		return
+		t.Fatalf("Could not connect to mongo: %v", err)
 	}
+	t.Cleanup(func() { _ = mongoClient.Disconnect(context.Background()) })
+	return mongoClient
+}
 
-	assert.EqualValues(t, 24, len(createdID))
+// TestMongoClientConformance requires a mongodb instance, see newMongoTestClient
+func TestMongoClientConformance(t *testing.T) {
+	mongoClient := newMongoTestClient(t, "users_conformance")
+
+	databasetest.RunAdapterConformance(t, func(t *testing.T) database.AdapterInterface {
+		_, err := mongoClient.Collection.DeleteMany(context.Background(), bson.D{})
+		if err != nil {
+			t.Fatalf("Could not clean users collection: %v", err)
+		}
+		return mongoClient
+	})
 }
 
-func TestDBCreateRepeatedUser(t *testing.T) {
-	_, err := client.CreateUser(&pb.CreateUserReq{User: testUser})
-	if err == nil {
-		t.Fatal("Create a user with an already registered email should not be permitted")
-	}
+func TestMemoryEventLogConformance(t *testing.T) {
+	databasetest.RunEventLogConformance(t, func(t *testing.T) database.EventLogInterface {
+		return database.NewMemoryEventLog(0)
+	})
 }
 
-func TestDBGetUser(t *testing.T) {
-	user, err := client.GetUser(&pb.GetUserReq{UserId: userID})
+// TestMongoEventLogConformance requires a mongodb instance, see newMongoTestClient
+func TestMongoEventLogConformance(t *testing.T) {
+	mongoClient := newMongoTestClient(t, "users_conformance")
+
+	eventLog, err := database.NewMongoEventLog(context.Background(), mongoClient, "user_events_conformance", 0)
 	if err != nil {
-		t.Fatal("Could not retrieve user")
+		t.Fatalf("Could not create event log: %v", err)
 	}
 
-	assert.EqualValues(t, testResponse.User.Email, user.User.Email)
+	databasetest.RunEventLogConformance(t, func(t *testing.T) database.EventLogInterface {
+		_, err := eventLog.Collection.DeleteMany(context.Background(), bson.D{})
+		if err != nil {
+			t.Fatalf("Could not clean events collection: %v", err)
+		}
+		return eventLog
+	})
+}
+
+func TestMemoryWebhookStoreConformance(t *testing.T) {
+	databasetest.RunWebhookStoreConformance(t, func(t *testing.T) database.WebhookStoreInterface {
+		return database.NewMemoryWebhookStore()
+	})
 }
 
-func TestDBGetAllUsersFilterLess(t *testing.T) {
-	users, err := client.GetAllUsers(&pb.ListUsersReq{Filter: nil})
+// TestMongoWebhookStoreConformance requires a mongodb instance, see newMongoTestClient
+func TestMongoWebhookStoreConformance(t *testing.T) {
+	mongoClient := newMongoTestClient(t, "users_conformance")
 
+	store, err := database.NewMongoWebhookStore(context.Background(), mongoClient, "webhooks_conformance")
 	if err != nil {
-		t.Fatalf("Failed when retrieving users")
+		t.Fatalf("Could not create webhook store: %v", err)
 	}
 
-	assert.EqualValues(t, "testing", users.Users[0].User.FirstName)
+	databasetest.RunWebhookStoreConformance(t, func(t *testing.T) database.WebhookStoreInterface {
+		for _, collection := range []*mongo.Collection{store.Webhooks, store.Deliveries, store.Cursors} {
+			if _, err := collection.DeleteMany(context.Background(), bson.D{}); err != nil {
+				t.Fatalf("Could not clean webhook collections: %v", err)
+			}
+		}
+		return store
+	})
 }
 
-func TestDBGetAllUsersWithFilter(t *testing.T) {
-	users, err := client.GetAllUsers(&pb.ListUsersReq{
-		Filter: &pb.User{
-			Country: "ES",
-		},
+func TestMemoryRefreshTokenStoreConformance(t *testing.T) {
+	databasetest.RunRefreshTokenStoreConformance(t, func(t *testing.T) database.RefreshTokenStoreInterface {
+		return database.NewMemoryRefreshTokenStore()
 	})
+}
+
+// TestMongoRefreshTokenStoreConformance requires a mongodb instance, see newMongoTestClient
+func TestMongoRefreshTokenStoreConformance(t *testing.T) {
+	mongoClient := newMongoTestClient(t, "users_conformance")
 
+	store, err := database.NewMongoRefreshTokenStore(context.Background(), mongoClient, "refresh_tokens_conformance")
 	if err != nil {
-		t.Fatalf("Failed when retrieving users")
+		t.Fatalf("Could not create refresh token store: %v", err)
 	}
 
-	assert.EqualValues(t, "testing", users.Users[0].User.FirstName)
+	databasetest.RunRefreshTokenStoreConformance(t, func(t *testing.T) database.RefreshTokenStoreInterface {
+		if _, err := store.Collection.DeleteMany(context.Background(), bson.D{}); err != nil {
+			t.Fatalf("Could not clean refresh tokens collection: %v", err)
+		}
+		return store
+	})
 }
 
-func TestDBUpdateUser(t *testing.T) {
-	user, err := client.UpdateUser(&pb.UpdateUserReq{
-		UserId: userID,
-		User: &pb.User{
-			FirstName: "testing-updated",
-			LastName:  "user",
-			Email:     "a@a.com",
-			Nickname:  "a",
-			Password:  "1234",
-			Country:   "ES",
-		},
-	})
+func TestMemoryEventLogRetention(t *testing.T) {
+	eventLog := database.NewMemoryEventLog(time.Hour)
+	appendEvent := func(timestamp time.Time) {
+		err := eventLog.AppendEvent(context.Background(), &pb.UserEvent{Timestamp: timestamppb.New(timestamp)})
+		if err != nil {
+			t.Fatalf("Could not append event: %v", err)
+		}
+	}
+	listSequences := func() []int64 {
+		events, err := eventLog.ListEvents(context.Background(), database.EventQuery{})
+		if err != nil {
+			t.Fatalf("Could not list events: %v", err)
+		}
+		var sequences []int64
+		for _, event := range events {
+			sequences = append(sequences, event.Sequence)
+		}
+		return sequences
+	}
 
-	if err != nil {
-		t.Fatal("Could not update user")
+	// Old events are kept until they are delivered and dispatched
+	appendEvent(time.Now().Add(-2 * time.Hour))
+	appendEvent(time.Now())
+	assert.EqualValues(t, []int64{1, 2}, listSequences())
+
+	if err := eventLog.MarkEventsDelivered(context.Background(), []int64{1}); err != nil {
+		t.Fatalf("Could not mark events delivered: %v", err)
 	}
+	appendEvent(time.Now())
+	assert.EqualValues(t, []int64{1, 2, 3}, listSequences())
 
-	assert.EqualValues(t, user.User.FirstName, "testing-updated")
+	if err := eventLog.MarkEventsDispatched(context.Background(), 1); err != nil {
+		t.Fatalf("Could not mark events dispatched: %v", err)
+	}
+	appendEvent(time.Now())
+	assert.EqualValues(t, []int64{2, 3, 4}, listSequences())
 }
 
-func TestDBDeleteUser(t *testing.T) {
-	_, err := client.DeleteUser(&pb.DeleteUserReq{UserId: userID})
-	if err != nil {
-		t.Fatal("Could not delete user")
+func TestMemoryConcurrentCreateSameEmail(t *testing.T) {
+	memoryClient := database.NewMemoryClient()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := memoryClient.CreateUser(context.Background(), &pb.CreateUserReq{User: testUser})
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	created := 0
+	for err := range errs {
+		if err == nil {
+			created++
+		} else {
+			assert.EqualValues(t, entities.AlreadyRegisteredEmailError, err)
+		}
 	}
+	assert.EqualValues(t, 1, created)
+}
+
+func TestTimeoutClientDefaultDeadline(t *testing.T) {
+	mockDBClient := new(DBAdapterMock)
+	timeoutClient := database.NewTimeoutClient(mockDBClient, database.Timeouts{Read: 10 * time.Millisecond})
+
+	mockDBClient.On("GetUser", mock.Anything, &pb.GetUserReq{UserId: userID}).
+		Run(func(args mock.Arguments) {
+			ctx := args.Get(0).(context.Context)
+			_, hasDeadline := ctx.Deadline()
+			assert.True(t, hasDeadline)
+			<-ctx.Done()
+		}).
+		Return((*pb.UserActionResponse)(nil), context.DeadlineExceeded)
+
+	_, err := timeoutClient.GetUser(context.Background(), &pb.GetUserReq{UserId: userID})
+
+	assert.EqualValues(t, codes.DeadlineExceeded, status.Code(err))
+	mockDBClient.AssertExpectations(t)
 }
 
-func TestDBGetMissingUser(t *testing.T) {
-	_, err := client.GetUser(&pb.GetUserReq{UserId: userID})
-	if err == nil {
-		t.Fatal("This test is supposed to retrieve a non-existing user, an error should occur")
+func TestTimeoutClientKeepsCallerDeadline(t *testing.T) {
+	mockDBClient := new(DBAdapterMock)
+	timeoutClient := database.NewTimeoutClient(mockDBClient, database.Timeouts{Read: time.Millisecond})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	callerDeadline, _ := ctx.Deadline()
+
+	mockDBClient.On("GetUser", mock.Anything, &pb.GetUserReq{UserId: userID}).
+		Run(func(args mock.Arguments) {
+			deadline, _ := args.Get(0).(context.Context).Deadline()
+			assert.EqualValues(t, callerDeadline, deadline)
+		}).
+		Return(testResponse, nil)
+
+	_, err := timeoutClient.GetUser(ctx, &pb.GetUserReq{UserId: userID})
+
+	assert.NoError(t, err)
+	mockDBClient.AssertExpectations(t)
+}
+
+func TestTimeoutClientCancelledContext(t *testing.T) {
+	timeoutClient := database.NewTimeoutClient(database.NewMemoryClient(), database.DefaultTimeouts())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := timeoutClient.GetAllUsers(ctx, &pb.ListUsersReq{})
+	assert.EqualValues(t, codes.Canceled, status.Code(err))
+
+	_, err = timeoutClient.GetUser(context.Background(), &pb.GetUserReq{UserId: userID})
+	assert.EqualValues(t, entities.NotFoundUser, err, "status errors must be returned unchanged")
+}
+
+func TestMemoryAPIKeyStoreConformance(t *testing.T) {
+	databasetest.RunAPIKeyStoreConformance(t, func(t *testing.T) database.APIKeyStoreInterface {
+		return database.NewMemoryAPIKeyStore()
+	})
+}
+
+// TestMongoAPIKeyStoreConformance requires a mongodb instance, see newMongoTestClient
+func TestMongoAPIKeyStoreConformance(t *testing.T) {
+	mongoClient := newMongoTestClient(t, "users_conformance")
+
+	store, err := database.NewMongoAPIKeyStore(context.Background(), mongoClient, "api_keys_conformance")
+	if err != nil {
+		t.Fatalf("Could not create API key store: %v", err)
 	}
+
+	databasetest.RunAPIKeyStoreConformance(t, func(t *testing.T) database.APIKeyStoreInterface {
+		if _, err := store.Collection.DeleteMany(context.Background(), bson.D{}); err != nil {
+			t.Fatalf("Could not clean API keys collection: %v", err)
+		}
+		return store
+	})
 }
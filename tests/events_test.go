@@ -0,0 +1,279 @@
+package tests
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"net"
+	"testing"
+	"time"
+	"userManagement/infra/database"
+	"userManagement/infra/notification"
+	"userManagement/infra/server"
+	pb "userManagement/proto"
+)
+
+// newEventsServer creates a server backed by in memory users and events, whose events are
+// published by a relay running until the test ends. It trusts every caller, as it has no authenticator
+func newEventsServer(t *testing.T) *server.UserManagementServer {
+	dbClient := database.NewMemoryClient()
+	dbClient.EventLog = database.NewMemoryEventLog(0)
+	broker := notification.NewBroker(notification.DefaultConfig())
+	startRelay(t, dbClient.EventLog, broker)
+
+	return &server.UserManagementServer{
+		DbClient: dbClient,
+		Broker:   broker,
+		EventLog: dbClient.EventLog,
+		// Servers with authenticator stop trusting their callers
+		TrustUnauthenticated: true,
+	}
+}
+
+// startRelay relays the events of the event log to the broker until the test ends
+func startRelay(t *testing.T, eventLog database.EventLogInterface, broker *notification.Broker) {
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		notification.NewRelay(eventLog, notification.NewBrokerPublisher(broker), 10*time.Millisecond).Run(ctx)
+		close(stopped)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-stopped
+	})
+}
+
+// newTestClient serves the received server through an in memory connection, created with the received
+// options, and returns a client calling it
+func newTestClient(t *testing.T, userServer *server.UserManagementServer, opts ...grpc.ServerOption) pb.UserManagementClient {
+	listener := bufconn.Listen(bufSize)
+	s := grpc.NewServer(opts...)
+	pb.RegisterUserManagementServer(s, userServer)
+	go func() {
+		_ = s.Serve(listener)
+	}()
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return listener.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to dial bufnet: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewUserManagementClient(conn)
+}
+
+func mustCreateTestUser(t *testing.T, userServer *server.UserManagementServer, email string) {
+	t.Helper()
+	_, err := userServer.CreateUser(context.Background(), &pb.CreateUserReq{User: &pb.User{Email: email, Password: "1234"}})
+	if err != nil {
+		t.Fatalf("Could not create user %s: %v", email, err)
+	}
+}
+
+// receiveEmails receives the received number of events from the stream, returning their emails and sequences
+func receiveEmails(t *testing.T, stream pb.UserManagement_NotifyUserChangesClient, count int) ([]string, []int64) {
+	t.Helper()
+	var emails []string
+	var sequences []int64
+	for i := 0; i < count; i++ {
+		event, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("Could not receive event: %v", err)
+		}
+		emails = append(emails, event.Email)
+		sequences = append(sequences, event.Sequence)
+	}
+	return emails, sequences
+}
+
+func TestNotifyChangesResumeAfter(t *testing.T) {
+	userServer := newEventsServer(t)
+	client := newTestClient(t, userServer)
+	mustCreateTestUser(t, userServer, "a@a.com")
+	mustCreateTestUser(t, userServer, "b@a.com")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := client.NotifyUserChanges(ctx, &pb.NotifyUserChangesReq{ResumeAfter: 1})
+	if err != nil {
+		t.Fatalf("Error when creating server side stream: %v", err)
+	}
+
+	emails, sequences := receiveEmails(t, stream, 1)
+	assert.EqualValues(t, []string{"b@a.com"}, emails)
+	assert.EqualValues(t, []int64{2}, sequences)
+
+	waitForSubscribers(t, userServer.Broker, 1)
+	mustCreateTestUser(t, userServer, "c@a.com")
+
+	emails, sequences = receiveEmails(t, stream, 1)
+	assert.EqualValues(t, []string{"c@a.com"}, emails)
+	assert.EqualValues(t, []int64{3}, sequences)
+}
+
+func TestNotifyChangesSinceTimestamp(t *testing.T) {
+	userServer := newEventsServer(t)
+	client := newTestClient(t, userServer)
+	mustCreateTestUser(t, userServer, "a@a.com")
+	mustCreateTestUser(t, userServer, "b@a.com")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := client.NotifyUserChanges(ctx, &pb.NotifyUserChangesReq{SinceTimestamp: timestamppb.New(time.Now().Add(-time.Minute))})
+	if err != nil {
+		t.Fatalf("Error when creating server side stream: %v", err)
+	}
+
+	emails, _ := receiveEmails(t, stream, 2)
+	assert.EqualValues(t, []string{"a@a.com", "b@a.com"}, emails)
+}
+
+func TestNotifyChangesLiveOnly(t *testing.T) {
+	userServer := newEventsServer(t)
+	client := newTestClient(t, userServer)
+	mustCreateTestUser(t, userServer, "a@a.com")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := client.NotifyUserChanges(ctx, &pb.NotifyUserChangesReq{})
+	if err != nil {
+		t.Fatalf("Error when creating server side stream: %v", err)
+	}
+	waitForSubscribers(t, userServer.Broker, 1)
+	mustCreateTestUser(t, userServer, "b@a.com")
+
+	emails, sequences := receiveEmails(t, stream, 1)
+	assert.EqualValues(t, []string{"b@a.com"}, emails)
+	assert.EqualValues(t, []int64{2}, sequences)
+}
+
+func TestNotifyChangesEventMetadata(t *testing.T) {
+	userServer := newEventsServer(t)
+	sub := userServer.Broker.Subscribe(context.Background())
+	defer sub.Close()
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-request-id", "request-1"))
+	created, err := userServer.CreateUser(ctx, &pb.CreateUserReq{User: &pb.User{FirstName: "first", Email: "a@a.com", Password: "1234"}})
+	if err != nil {
+		t.Fatalf("Could not create user: %v", err)
+	}
+	_, err = userServer.UpdateUser(context.Background(), &pb.UpdateUserReq{UserId: created.Id, User: &pb.User{FirstName: "second"}})
+	if err != nil {
+		t.Fatalf("Could not update user: %v", err)
+	}
+
+	event := <-sub.Notifications()
+	assert.EqualValues(t, pb.UserEventType_USER_CREATED, event.Type)
+	assert.EqualValues(t, created.Id, event.UserId)
+	assert.EqualValues(t, "request-1", event.RequestId)
+	assert.EqualValues(t, "anonymous", event.Actor)
+	assert.Nil(t, event.Before)
+	assert.Empty(t, event.After.Password)
+
+	event = <-sub.Notifications()
+	assert.EqualValues(t, pb.UserEventType_USER_UPDATED, event.Type)
+	assert.NotEmpty(t, event.RequestId)
+	assert.EqualValues(t, "first", event.Before.FirstName)
+	assert.EqualValues(t, "second", event.After.FirstName)
+}
+
+func TestRelayPublishesInSequenceOrder(t *testing.T) {
+	eventLog := database.NewMemoryEventLog(0)
+	for _, id := range []string{"1", "2", "3"} {
+		if err := eventLog.AppendEvent(context.Background(), &pb.UserEvent{EventId: id}); err != nil {
+			t.Fatalf("Could not append event: %v", err)
+		}
+	}
+
+	broker := notification.NewBroker(notification.DefaultConfig())
+	sub := broker.Subscribe(context.Background())
+	defer sub.Close()
+	startRelay(t, eventLog, broker)
+
+	assert.EqualValues(t, []string{"1", "2", "3"}, receiveEventIDs(sub, 3))
+
+	if err := eventLog.AppendEvent(context.Background(), &pb.UserEvent{EventId: "4"}); err != nil {
+		t.Fatalf("Could not append event: %v", err)
+	}
+	assert.EqualValues(t, []string{"4"}, receiveEventIDs(sub, 1))
+
+	undelivered, err := eventLog.ListUndeliveredEvents(context.Background(), 0)
+	assert.Nil(t, err)
+	assert.Empty(t, undelivered)
+}
+
+func TestRelayResumesUndeliveredEvents(t *testing.T) {
+	eventLog := database.NewMemoryEventLog(0)
+	for _, id := range []string{"1", "2", "3"} {
+		if err := eventLog.AppendEvent(context.Background(), &pb.UserEvent{EventId: id}); err != nil {
+			t.Fatalf("Could not append event: %v", err)
+		}
+	}
+	// The first event was delivered before the previous relay stopped
+	if err := eventLog.MarkEventsDelivered(context.Background(), []int64{1}); err != nil {
+		t.Fatalf("Could not mark events delivered: %v", err)
+	}
+
+	broker := notification.NewBroker(notification.DefaultConfig())
+	sub := broker.Subscribe(context.Background())
+	defer sub.Close()
+	startRelay(t, eventLog, broker)
+
+	assert.EqualValues(t, []string{"2", "3"}, receiveEventIDs(sub, 2))
+}
+
+func TestNotifyChangesFilters(t *testing.T) {
+	userServer := newEventsServer(t)
+	client := newTestClient(t, userServer)
+	mustCreateTestUser(t, userServer, "a@a.com")
+	mustCreateTestUser(t, userServer, "b@a.com")
+	_, err := userServer.DeleteUser(context.Background(), &pb.DeleteUserReq{UserId: "a@a.com"})
+	if err != nil {
+		t.Fatalf("Could not delete user: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := client.NotifyUserChanges(ctx, &pb.NotifyUserChangesReq{
+		ResumeAfter: 1,
+		Types:       []pb.UserEventType{pb.UserEventType_USER_DELETED},
+	})
+	if err != nil {
+		t.Fatalf("Error when creating server side stream: %v", err)
+	}
+
+	emails, sequences := receiveEmails(t, stream, 1)
+	assert.EqualValues(t, []string{"a@a.com"}, emails)
+	assert.EqualValues(t, []int64{3}, sequences)
+
+	waitForSubscribers(t, userServer.Broker, 1)
+	mustCreateTestUser(t, userServer, "c@a.com")
+	_, err = userServer.DeleteUser(context.Background(), &pb.DeleteUserReq{UserId: "b@a.com"})
+	if err != nil {
+		t.Fatalf("Could not delete user: %v", err)
+	}
+
+	emails, sequences = receiveEmails(t, stream, 1)
+	assert.EqualValues(t, []string{"b@a.com"}, emails)
+	assert.EqualValues(t, []int64{5}, sequences)
+}
+
+func TestNotifyChangesInvalidFilter(t *testing.T) {
+	client := newTestClient(t, newEventsServer(t))
+
+	stream, err := client.NotifyUserChanges(context.Background(), &pb.NotifyUserChangesReq{ChangedFields: []string{"password"}})
+	if err != nil {
+		t.Fatalf("Error when creating server side stream: %v", err)
+	}
+	_, err = stream.Recv()
+	assert.EqualValues(t, codes.InvalidArgument, status.Code(err))
+}
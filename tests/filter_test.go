@@ -0,0 +1,47 @@
+package tests
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"userManagement/infra/filter"
+)
+
+var filterFields = map[string]filter.FieldType{
+	"country":    filter.String,
+	"last_name":  filter.String,
+	"created_at": filter.Timestamp,
+}
+
+func TestParseFilterPrecedence(t *testing.T) {
+	expr, err := filter.Parse(`country = "ES" AND last_name = "A" OR -last_name : "b*"`, filterFields)
+	if err != nil {
+		t.Fatalf("Could not parse filter: %v", err)
+	}
+
+	assert.EqualValues(t, filter.And{
+		Left: filter.Comparison{Field: "country", Operator: filter.Equal, Value: "ES"},
+		Right: filter.Or{
+			Left:  filter.Comparison{Field: "last_name", Operator: filter.Equal, Value: "A"},
+			Right: filter.Not{Expr: filter.Comparison{Field: "last_name", Operator: filter.Has, Value: "b", Prefix: true}},
+		},
+	}, expr)
+}
+
+func TestParseEmptyFilter(t *testing.T) {
+	expr, err := filter.Parse("  ", filterFields)
+
+	assert.NoError(t, err)
+	assert.Nil(t, expr)
+}
+
+func TestParseFilterErrorPosition(t *testing.T) {
+	_, err := filter.Parse(`country = "ES" AND ñame = "A"`, filterFields)
+
+	var filterErr *filter.Error
+	if !errors.As(err, &filterErr) {
+		t.Fatalf("Expected a filter error, got %v", err)
+	}
+	assert.EqualValues(t, 20, filterErr.Position)
+	assert.EqualValues(t, "ñame", filterErr.Token)
+}
@@ -0,0 +1,115 @@
+package tests
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"userManagement/infra/database"
+	"userManagement/infra/gateway"
+	"userManagement/infra/notification"
+	"userManagement/infra/server"
+	pb "userManagement/proto"
+)
+
+// newTestGateway serves the REST API in process, backed by an in memory database
+func newTestGateway(t *testing.T) *httptest.Server {
+	gatewayServer, _ := newTestGatewayWithBroker(t)
+	return gatewayServer
+}
+
+// newTestGatewayWithBroker serves the REST API in process, returning the broker its events are published to
+func newTestGatewayWithBroker(t *testing.T) (*httptest.Server, *notification.Broker) {
+	dbClient := database.NewMemoryClient()
+	dbClient.EventLog = database.NewMemoryEventLog(0)
+	broker := notification.NewBroker(notification.DefaultConfig())
+	startRelay(t, dbClient.EventLog, broker)
+
+	mux := gateway.NewServeMux()
+	err := pb.RegisterUserManagementHandlerServer(context.Background(), mux, &server.UserManagementServer{
+		DbClient: dbClient,
+		Broker:   broker,
+		EventLog: dbClient.EventLog,
+		// The gateway calls the server directly, without authenticator
+		TrustUnauthenticated: true,
+	})
+	if err != nil {
+		t.Fatalf("Could not register gateway handlers: %v", err)
+	}
+
+	gatewayServer := httptest.NewServer(mux)
+	t.Cleanup(gatewayServer.Close)
+	return gatewayServer, broker
+}
+
+func doRequest(t *testing.T, method, url, body string, headers map[string]string) *http.Response {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Could not build request: %v", err)
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request %s %s failed: %v", method, url, err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestGatewayETagPreconditions(t *testing.T) {
+	gatewayServer := newTestGateway(t)
+	usersURL := gatewayServer.URL + "/v1/users"
+
+	resp := doRequest(t, http.MethodPost, usersURL, `{"firstName":"testing","email":"a@a.com","password":"1234"}`, nil)
+	assert.EqualValues(t, http.StatusOK, resp.StatusCode)
+
+	resp = doRequest(t, http.MethodGet, usersURL+"/a@a.com", "", nil)
+	assert.EqualValues(t, `"1"`, resp.Header.Get("ETag"))
+
+	resp = doRequest(t, http.MethodPatch, usersURL+"/a@a.com", `{"country":"UK"}`, map[string]string{"If-Match": `"1"`})
+	assert.EqualValues(t, http.StatusOK, resp.StatusCode)
+	assert.EqualValues(t, `"2"`, resp.Header.Get("ETag"))
+
+	resp = doRequest(t, http.MethodPatch, usersURL+"/a@a.com", `{"country":"FR"}`, map[string]string{"If-Match": `"1"`})
+	assert.EqualValues(t, http.StatusPreconditionFailed, resp.StatusCode)
+
+	resp = doRequest(t, http.MethodDelete, usersURL+"/a@a.com", "", map[string]string{"If-Match": `"1"`})
+	assert.EqualValues(t, http.StatusPreconditionFailed, resp.StatusCode)
+
+	resp = doRequest(t, http.MethodDelete, usersURL+"/a@a.com", "", map[string]string{"If-Match": `"2"`})
+	assert.EqualValues(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestGatewayUserEvents(t *testing.T) {
+	gatewayServer, broker := newTestGatewayWithBroker(t)
+	usersURL := gatewayServer.URL + "/v1/users"
+	sub := broker.Subscribe(context.Background())
+	defer sub.Close()
+
+	doRequest(t, http.MethodPost, usersURL, `{"firstName":"testing","email":"a@a.com","password":"1234"}`, map[string]string{"X-Request-Id": "create-1"})
+	doRequest(t, http.MethodGet, usersURL+"/a@a.com", "", nil)
+	doRequest(t, http.MethodDelete, usersURL+"/a@a.com", "", nil)
+	doRequest(t, http.MethodDelete, usersURL+"/a@a.com", "", nil)
+
+	created := <-sub.Notifications()
+	assert.EqualValues(t, pb.UserEventType_USER_CREATED, created.Type)
+	assert.EqualValues(t, "create-1", created.RequestId)
+	assert.EqualValues(t, "a@a.com", created.Email)
+	assert.Nil(t, created.Before)
+	assert.Empty(t, created.After.Password)
+
+	deleted := <-sub.Notifications()
+	assert.EqualValues(t, pb.UserEventType_USER_DELETED, deleted.Type)
+	assert.EqualValues(t, created.UserId, deleted.UserId)
+	assert.NotEqual(t, created.EventId, deleted.EventId)
+	assert.EqualValues(t, "testing", deleted.Before.FirstName)
+	assert.Nil(t, deleted.After)
+
+	// Reads and deletions of missing users publish no events
+	assert.Empty(t, sub.Notifications())
+	assert.EqualValues(t, 2, broker.Stats().Published)
+}
@@ -5,12 +5,17 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
 	"log"
 	"net"
 	"testing"
 	"time"
+	"userManagement/infra/notification"
 	"userManagement/infra/server"
 	pb "userManagement/proto"
 )
@@ -47,8 +52,9 @@ var (
 	}
 
 	grpcServer = server.UserManagementServer{
-		DbClient:      nil,
-		NotifyChannel: make(chan []string),
+		DbClient:             nil,
+		Broker:               notification.NewBroker(notification.DefaultConfig()),
+		TrustUnauthenticated: true,
 	}
 )
 
@@ -56,37 +62,52 @@ type DBAdapterMock struct {
 	mock.Mock
 }
 
-func (m *DBAdapterMock) GetUser(req *pb.GetUserReq) (*pb.UserActionResponse, error) {
-	args := m.Called(req)
+func (m *DBAdapterMock) GetUser(ctx context.Context, req *pb.GetUserReq) (*pb.UserActionResponse, error) {
+	args := m.Called(ctx, req)
 	return args.Get(0).(*pb.UserActionResponse), args.Error(1)
 }
 
-func (m *DBAdapterMock) UpdateUser(req *pb.UpdateUserReq) (*pb.UserActionResponse, error) {
-	args := m.Called(req)
+func (m *DBAdapterMock) UpdateUser(ctx context.Context, req *pb.UpdateUserReq) (*pb.UserActionResponse, error) {
+	args := m.Called(ctx, req)
 	return args.Get(0).(*pb.UserActionResponse), args.Error(1)
 }
 
-func (m *DBAdapterMock) DeleteUser(req *pb.DeleteUserReq) (*pb.DeletionActionResponse, error) {
-	args := m.Called(req)
+func (m *DBAdapterMock) DeleteUser(ctx context.Context, req *pb.DeleteUserReq) (*pb.DeletionActionResponse, error) {
+	args := m.Called(ctx, req)
 	return args.Get(0).(*pb.DeletionActionResponse), args.Error(1)
 }
 
-func (m *DBAdapterMock) GetAllUsers(req *pb.ListUsersReq) (*pb.ListActionResponse, error) {
-	args := m.Called(req)
+func (m *DBAdapterMock) GetAllUsers(ctx context.Context, req *pb.ListUsersReq) (*pb.ListActionResponse, error) {
+	args := m.Called(ctx, req)
 	return args.Get(0).(*pb.ListActionResponse), args.Error(1)
 }
 
-func (m *DBAdapterMock) CreateUser(req *pb.CreateUserReq) (string, error) {
-	args := m.Called(req)
+func (m *DBAdapterMock) CreateUser(ctx context.Context, req *pb.CreateUserReq) (string, error) {
+	args := m.Called(ctx, req)
 	return args.String(0), args.Error(1)
 }
 
+func (m *DBAdapterMock) VerifyPassword(ctx context.Context, req *pb.VerifyPasswordReq) (*pb.VerifyPasswordResponse, error) {
+	args := m.Called(ctx, req)
+	return args.Get(0).(*pb.VerifyPasswordResponse), args.Error(1)
+}
+
+func (m *DBAdapterMock) GrantRole(ctx context.Context, req *pb.GrantRoleReq) (*pb.UserActionResponse, error) {
+	args := m.Called(ctx, req)
+	return args.Get(0).(*pb.UserActionResponse), args.Error(1)
+}
+
+func (m *DBAdapterMock) RevokeRole(ctx context.Context, req *pb.RevokeRoleReq) (*pb.UserActionResponse, error) {
+	args := m.Called(ctx, req)
+	return args.Get(0).(*pb.UserActionResponse), args.Error(1)
+}
+
 func TestGetUser(t *testing.T) {
 	mockDBClient := new(DBAdapterMock)
 
 	grpcServer.DbClient = mockDBClient
 
-	mockDBClient.On("GetUser", &pb.GetUserReq{UserId: userID}).Return(testResponse, nil)
+	mockDBClient.On("GetUser", mock.Anything, &pb.GetUserReq{UserId: userID}).Return(testResponse, nil)
 
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 
@@ -108,7 +129,7 @@ func TestUpdateUser(t *testing.T) {
 
 	grpcServer.DbClient = mockDBClient
 
-	mockDBClient.On("UpdateUser", &pb.UpdateUserReq{
+	mockDBClient.On("UpdateUser", mock.Anything, &pb.UpdateUserReq{
 		UserId: userID,
 		User:   testUser,
 	}).Return(testResponse, nil)
@@ -130,12 +151,65 @@ func TestUpdateUser(t *testing.T) {
 	assert.EqualValues(t, testResponse, resp)
 }
 
+func TestUpdateUserImmutableField(t *testing.T) {
+	mockDBClient := new(DBAdapterMock)
+
+	grpcServer.DbClient = mockDBClient
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+
+	defer cancel()
+
+	_, err := grpcServer.UpdateUser(ctx, &pb.UpdateUserReq{
+		UserId:     userID,
+		User:       testUser,
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"email"}},
+	})
+
+	assert.EqualValues(t, codes.InvalidArgument, status.Code(err))
+	mockDBClient.AssertNotCalled(t, "UpdateUser", mock.Anything, mock.Anything)
+}
+
+func TestUpdateUserIfMatch(t *testing.T) {
+	mockDBClient := new(DBAdapterMock)
+
+	grpcServer.DbClient = mockDBClient
+
+	mockDBClient.On("UpdateUser", mock.Anything, &pb.UpdateUserReq{
+		UserId:          userID,
+		User:            testUser,
+		ExpectedVersion: 3,
+	}).Return(testResponse, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+
+	defer cancel()
+
+	ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("grpcgateway-if-match", `"3"`))
+	_, err := grpcServer.UpdateUser(ctx, &pb.UpdateUserReq{
+		UserId: userID,
+		User:   testUser,
+	})
+	if err != nil {
+		t.Fatalf("Update User test failed: %v", err)
+	}
+
+	mockDBClient.AssertExpectations(t)
+
+	ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("if-match", "not-a-version"))
+	_, err = grpcServer.UpdateUser(ctx, &pb.UpdateUserReq{
+		UserId: userID,
+		User:   testUser,
+	})
+	assert.EqualValues(t, codes.InvalidArgument, status.Code(err))
+}
+
 func TestDeleteUser(t *testing.T) {
 	mockDBClient := new(DBAdapterMock)
 
 	grpcServer.DbClient = mockDBClient
 
-	mockDBClient.On("DeleteUser", &pb.DeleteUserReq{
+	mockDBClient.On("DeleteUser", mock.Anything, &pb.DeleteUserReq{
 		UserId: userID,
 	}).Return(&pb.DeletionActionResponse{Deleted: true}, nil)
 
@@ -160,7 +234,7 @@ func TestListUsers(t *testing.T) {
 
 	grpcServer.DbClient = mockDBClient
 
-	mockDBClient.On("GetAllUsers", &pb.ListUsersReq{}).
+	mockDBClient.On("GetAllUsers", mock.Anything, &pb.ListUsersReq{}).
 		Return(&pb.ListActionResponse{
 			Users: []*pb.UserActionResponse{testResponse},
 		}, nil)
@@ -169,7 +243,7 @@ func TestListUsers(t *testing.T) {
 
 	defer cancel()
 
-	resp, err := grpcServer.ListUsers(ctx, &pb.ListUsersReq{Filter: nil})
+	resp, err := grpcServer.ListUsers(ctx, &pb.ListUsersReq{UserFilter: nil})
 	if err != nil {
 		t.Fatalf("Get User test failed: %v", err)
 	}
@@ -183,11 +257,11 @@ func TestCreateUser(t *testing.T) {
 	mockDBClient := new(DBAdapterMock)
 	grpcServer.DbClient = mockDBClient
 
-	mockDBClient.On("CreateUser", &pb.CreateUserReq{
+	mockDBClient.On("CreateUser", mock.Anything, &pb.CreateUserReq{
 		User: testUser,
 	}).Return(userID, nil)
 
-	mockDBClient.On("GetUser", &pb.GetUserReq{UserId: userID}).
+	mockDBClient.On("GetUser", mock.Anything, &pb.GetUserReq{UserId: userID}).
 		Return(testResponse, nil)
 
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
@@ -203,18 +277,35 @@ func TestCreateUser(t *testing.T) {
 	assert.EqualValues(t, resp, testResponse)
 }
 
-// TestNotifyChanges establishes a server side streaming to receive notifications
-// from user changes.
-func TestNotifyChanges(t *testing.T) {
+func TestVerifyPassword(t *testing.T) {
 	mockDBClient := new(DBAdapterMock)
 	grpcServer.DbClient = mockDBClient
 
+	mockDBClient.On("VerifyPassword", mock.Anything, &pb.VerifyPasswordReq{
+		UserId:   userID,
+		Password: "1234",
+	}).Return(&pb.VerifyPasswordResponse{Valid: true, Id: "1"}, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+
+	defer cancel()
+	resp, err := grpcServer.VerifyPassword(ctx, &pb.VerifyPasswordReq{UserId: userID, Password: "1234"})
+	if err != nil {
+		t.Fatalf("Verify password test failed: %v", err)
+	}
+
+	mockDBClient.AssertExpectations(t)
+
+	assert.True(t, resp.Valid)
+	assert.EqualValues(t, "1", resp.Id)
+}
+
+// TestNotifyChanges establishes a server side streaming to receive the events
+// of user changes.
+func TestNotifyChanges(t *testing.T) {
 	lis = bufconn.Listen(bufSize)
 	s := grpc.NewServer()
 
-	mockDBClient.On("GetUser", &pb.GetUserReq{UserId: userID}).
-		Return(testResponse, nil)
-
 	pb.RegisterUserManagementServer(s, &grpcServer)
 	go func() {
 		if err := s.Serve(lis); err != nil {
@@ -233,25 +324,47 @@ func TestNotifyChanges(t *testing.T) {
 	}
 	defer conn.Close()
 	client := pb.NewUserManagementClient(conn)
-	resp, err := client.NotifyUserChanges(ctx, &pb.EmptyMsg{})
+	resp, err := client.NotifyUserChanges(ctx, &pb.NotifyUserChangesReq{})
 	if err != nil {
 		t.Fatalf("Error when creating server side stream: %v", err)
 	}
+	waitForSubscribers(t, grpcServer.Broker, 1)
 
-	_, err = grpcServer.GetUser(ctx, &pb.GetUserReq{UserId: userID})
-	if err != nil {
-		return
-	}
+	grpcServer.Broker.Publish(&pb.UserEvent{
+		EventId:   "event-1",
+		Type:      pb.UserEventType_USER_UPDATED,
+		UserId:    userID,
+		Email:     "a@a.com",
+		RequestId: "request-1",
+		Before:    &pb.User{FirstName: "before"},
+		After:     &pb.User{FirstName: "testing"},
+	})
 
-	var notification pb.UserActionStream
-	err = resp.RecvMsg(&notification)
+	var event pb.UserEvent
+	err = resp.RecvMsg(&event)
 	if err != nil {
-		t.Fatalf("Error when recieving user action notification")
+		t.Fatalf("Error when recieving user event")
 	}
 
-	assert.EqualValues(t, "User action performed: a@a.com - Retrieved", notification.Action)
+	assert.EqualValues(t, pb.UserEventType_USER_UPDATED, event.Type)
+	assert.EqualValues(t, "event-1", event.EventId)
+	assert.EqualValues(t, userID, event.UserId)
+	assert.EqualValues(t, "request-1", event.RequestId)
+	assert.EqualValues(t, "before", event.Before.FirstName)
+	assert.EqualValues(t, "testing", event.After.FirstName)
+}
 
-	mockDBClient.AssertExpectations(t)
+// waitForSubscribers waits until the broker has the received number of subscribers,
+// as streams subscribe after the client receives the stream
+func waitForSubscribers(t *testing.T, broker *notification.Broker, subscribers int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for broker.Stats().Subscribers != subscribers {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d subscribers, got %d", subscribers, broker.Stats().Subscribers)
+		}
+		time.Sleep(time.Millisecond)
+	}
 }
 
 func bufDialer(context.Context, string) (net.Conn, error) {
@@ -0,0 +1,168 @@
+package tests
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"userManagement/entities"
+	"userManagement/infra/auth"
+	"userManagement/infra/database"
+	"userManagement/infra/gateway"
+	"userManagement/infra/server"
+	pb "userManagement/proto"
+)
+
+// staticAPIKeys accepts the keys of its map, authenticating them as the principal they are mapped to
+type staticAPIKeys map[string]*auth.Principal
+
+func (k staticAPIKeys) VerifyAPIKey(_ context.Context, key string) (*auth.Principal, error) {
+	if principal, found := k[key]; found {
+		return principal, nil
+	}
+	return nil, entities.InvalidAPIKeyError
+}
+
+// newAuthenticatedClient returns a client of the server, which authenticates every call but the default public ones
+func newAuthenticatedClient(t *testing.T, userServer *server.UserManagementServer, apiKeys auth.APIKeyVerifier) pb.UserManagementClient {
+	authenticator := auth.NewAuthenticator(userServer.Auth, apiKeys, auth.DefaultPublicMethods)
+	return newTestClient(t, userServer,
+		grpc.UnaryInterceptor(authenticator.UnaryInterceptor()),
+		grpc.StreamInterceptor(authenticator.StreamInterceptor()))
+}
+
+func withAuthorization(ctx context.Context, authorization string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, auth.AuthorizationHeader, authorization)
+}
+
+func TestInterceptorRejectsAnonymousCalls(t *testing.T) {
+	userServer := newAuthServer(t)
+	client := newAuthenticatedClient(t, userServer, nil)
+	mustCreateTestUser(t, userServer, "a@a.com")
+
+	_, err := client.ListUsers(context.Background(), &pb.ListUsersReq{})
+	assert.EqualValues(t, entities.MissingCredentialsError.Error(), status.Convert(err).Err().Error())
+
+	for _, authorization := range []string{"Bearer invalid", "Basic YTphQGEuY29tOjEyMzQ=", "ApiKey key", "Bearer"} {
+		_, err = client.DeleteUser(withAuthorization(context.Background(), authorization), &pb.DeleteUserReq{UserId: "a@a.com"})
+		assert.EqualValues(t, codes.Unauthenticated, status.Code(err), authorization)
+	}
+
+	stream, err := client.NotifyUserChanges(context.Background(), &pb.NotifyUserChangesReq{})
+	if err != nil {
+		t.Fatalf("Error when creating server side stream: %v", err)
+	}
+	_, err = stream.Recv()
+	assert.EqualValues(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = userServer.DbClient.GetUser(context.Background(), &pb.GetUserReq{UserId: "a@a.com"})
+	assert.Nil(t, err)
+}
+
+func TestInterceptorPublicMethods(t *testing.T) {
+	userServer := newAuthServer(t)
+	client := newAuthenticatedClient(t, userServer, nil)
+
+	_, err := client.CreateUser(context.Background(), &pb.CreateUserReq{User: &pb.User{Email: "a@a.com", Password: "1234"}})
+	assert.Nil(t, err)
+
+	// Invalid credentials do not prevent calling public methods, as an expired token sent along a login
+	_, err = client.Login(withAuthorization(context.Background(), "Bearer expired"), &pb.LoginReq{Email: "a@a.com", Password: "1234"})
+	assert.Nil(t, err)
+}
+
+func TestInterceptorBearerToken(t *testing.T) {
+	userServer := newAuthServer(t)
+	client := newAuthenticatedClient(t, userServer, nil)
+	mustCreateTestUser(t, userServer, "a@a.com")
+	mustGrantRole(t, userServer, "a@a.com", auth.RoleAdmin)
+	tokens := mustLogin(t, client, "a@a.com")
+	ctx := withAuthorization(context.Background(), "Bearer "+tokens.AccessToken)
+
+	users, err := client.ListUsers(ctx, &pb.ListUsersReq{})
+	assert.Nil(t, err)
+	assert.Len(t, users.Users, 1)
+
+	streamCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	stream, err := client.NotifyUserChanges(streamCtx, &pb.NotifyUserChangesReq{})
+	if err != nil {
+		t.Fatalf("Error when creating server side stream: %v", err)
+	}
+	if _, err := stream.Header(); err != nil {
+		t.Fatalf("Stream was not accepted: %v", err)
+	}
+
+	updated, err := client.UpdateUser(ctx, &pb.UpdateUserReq{UserId: "a@a.com", User: &pb.User{FirstName: "first"}})
+	if err != nil {
+		t.Fatalf("Could not update user: %v", err)
+	}
+	event, err := stream.Recv()
+	assert.Nil(t, err)
+	assert.EqualValues(t, pb.UserEventType_USER_UPDATED, event.Type)
+	assert.EqualValues(t, "user:"+updated.Id, event.Actor)
+
+	// Creations without credentials are anonymous
+	events, err := userServer.EventLog.ListEvents(context.Background(), database.EventQuery{Limit: 1})
+	assert.Nil(t, err)
+	assert.EqualValues(t, "anonymous", events[0].Actor)
+}
+
+func TestInterceptorAPIKeys(t *testing.T) {
+	userServer := newAuthServer(t)
+	service := &auth.Principal{Kind: auth.PrincipalService, ID: "reports", Scopes: []string{string(auth.PermissionList)}, KeyID: "1"}
+	client := newAuthenticatedClient(t, userServer, staticAPIKeys{"secret-key": service})
+
+	_, err := client.ListUsers(metadata.AppendToOutgoingContext(context.Background(), auth.APIKeyHeader, "secret-key"), &pb.ListUsersReq{})
+	assert.Nil(t, err)
+	_, err = client.ListUsers(withAuthorization(context.Background(), "ApiKey secret-key"), &pb.ListUsersReq{})
+	assert.Nil(t, err)
+
+	_, err = client.ListUsers(metadata.AppendToOutgoingContext(context.Background(), auth.APIKeyHeader, "other-key"), &pb.ListUsersReq{})
+	assert.EqualValues(t, entities.InvalidAPIKeyError.Error(), status.Convert(err).Err().Error())
+
+	_, err = client.CreateUser(withAuthorization(context.Background(), "ApiKey secret-key"), &pb.CreateUserReq{User: &pb.User{Email: "a@a.com", Password: "1234"}})
+	assert.Nil(t, err)
+	events, err := userServer.EventLog.ListEvents(context.Background(), database.EventQuery{Limit: 1})
+	assert.Nil(t, err)
+	assert.EqualValues(t, "service:reports", events[0].Actor)
+}
+
+func TestGatewayForwardsCredentials(t *testing.T) {
+	userServer := newAuthServer(t)
+	service := &auth.Principal{Kind: auth.PrincipalService, ID: "reports", Scopes: []string{string(auth.PermissionList)}, KeyID: "1"}
+	client := newAuthenticatedClient(t, userServer, staticAPIKeys{"secret-key": service})
+	mustCreateTestUser(t, userServer, "a@a.com")
+	mustGrantRole(t, userServer, "a@a.com", auth.RoleAdmin)
+	tokens := mustLogin(t, client, "a@a.com")
+
+	mux := gateway.NewServeMux()
+	if err := pb.RegisterUserManagementHandlerClient(context.Background(), mux, client); err != nil {
+		t.Fatalf("Could not register gateway handlers: %v", err)
+	}
+	httpMux := http.NewServeMux()
+	httpMux.Handle("/", mux)
+	httpMux.Handle(gateway.WatchPath, gateway.NewWatchHandler(client, time.Minute, nil))
+	gatewayServer := httptest.NewServer(httpMux)
+	defer gatewayServer.Close()
+
+	resp := doRequest(t, http.MethodGet, gatewayServer.URL+"/v1/users", "", nil)
+	assert.EqualValues(t, http.StatusUnauthorized, resp.StatusCode)
+	resp = doRequest(t, http.MethodGet, gatewayServer.URL+"/v1/users", "", map[string]string{"Authorization": "Bearer " + tokens.AccessToken})
+	assert.EqualValues(t, http.StatusOK, resp.StatusCode)
+	resp = doRequest(t, http.MethodGet, gatewayServer.URL+"/v1/users", "", map[string]string{"X-Api-Key": "secret-key"})
+	assert.EqualValues(t, http.StatusOK, resp.StatusCode)
+
+	resp = doRequest(t, http.MethodGet, gatewayServer.URL+gateway.WatchPath, "", nil)
+	assert.EqualValues(t, http.StatusUnauthorized, resp.StatusCode)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	openEventStream(t, ctx, gatewayServer.URL+gateway.WatchPath, http.Header{"Authorization": {"Bearer " + tokens.AccessToken}})
+}
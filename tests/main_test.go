@@ -0,0 +1,13 @@
+package tests
+
+import (
+	"os"
+	"testing"
+	"userManagement/infra/password"
+)
+
+// TestMain lowers the password hashing cost, since tests hash many passwords and do not need the production one
+func TestMain(m *testing.M) {
+	password.DefaultParams = testParams
+	os.Exit(m.Run())
+}
@@ -0,0 +1,169 @@
+package tests
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+	"userManagement/infra/notification"
+	pb "userManagement/proto"
+)
+
+func newTestBroker(bufferSize int, policy notification.SlowConsumerPolicy) *notification.Broker {
+	return notification.NewBroker(notification.Config{BufferSize: bufferSize, Policy: policy, BlockTimeout: 10 * time.Millisecond})
+}
+
+func receiveEventIDs(sub *notification.Subscription, count int) []string {
+	var ids []string
+	for i := 0; i < count; i++ {
+		select {
+		case n := <-sub.Notifications():
+			ids = append(ids, n.EventId)
+		case <-time.After(time.Second):
+			return ids
+		}
+	}
+	return ids
+}
+
+func TestBrokerBroadcastsToEverySubscriber(t *testing.T) {
+	broker := newTestBroker(10, notification.DropOldest)
+	first := broker.Subscribe(context.Background())
+	second := broker.Subscribe(context.Background())
+
+	broker.Publish(&pb.UserEvent{EventId: "Created"})
+	broker.Publish(&pb.UserEvent{EventId: "Updated"})
+
+	assert.EqualValues(t, []string{"Created", "Updated"}, receiveEventIDs(first, 2))
+	assert.EqualValues(t, []string{"Created", "Updated"}, receiveEventIDs(second, 2))
+}
+
+func TestBrokerWithoutSubscribersDoesNotBlock(t *testing.T) {
+	broker := newTestBroker(1, notification.Block)
+
+	for i := 0; i < 100; i++ {
+		broker.Publish(&pb.UserEvent{EventId: "Created"})
+	}
+
+	assert.EqualValues(t, 100, broker.Stats().Published)
+	assert.EqualValues(t, 0, broker.Stats().Dropped)
+}
+
+func TestBrokerUnsubscribesWhenContextEnds(t *testing.T) {
+	broker := newTestBroker(10, notification.DropOldest)
+	ctx, cancel := context.WithCancel(context.Background())
+	sub := broker.Subscribe(ctx)
+	assert.EqualValues(t, 1, broker.Stats().Subscribers)
+
+	cancel()
+
+	select {
+	case <-sub.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("Subscription was not closed when its context ended")
+	}
+	assert.EqualValues(t, 0, broker.Stats().Subscribers)
+	assert.EqualValues(t, context.Canceled, sub.Err())
+}
+
+func TestBrokerDropOldest(t *testing.T) {
+	broker := newTestBroker(2, notification.DropOldest)
+	sub := broker.Subscribe(context.Background())
+
+	for _, id := range []string{"Created", "Retrieved", "Updated", "Deleted"} {
+		broker.Publish(&pb.UserEvent{EventId: id})
+	}
+
+	assert.EqualValues(t, []string{"Updated", "Deleted"}, receiveEventIDs(sub, 2))
+	assert.EqualValues(t, 2, sub.Dropped())
+	assert.EqualValues(t, 2, broker.Stats().Dropped)
+}
+
+func TestBrokerDisconnectsSlowConsumers(t *testing.T) {
+	broker := newTestBroker(1, notification.Disconnect)
+	slow := broker.Subscribe(context.Background())
+	fast := broker.Subscribe(context.Background())
+
+	broker.Publish(&pb.UserEvent{EventId: "Created"})
+	receiveEventIDs(fast, 1)
+	broker.Publish(&pb.UserEvent{EventId: "Updated"})
+
+	select {
+	case <-slow.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("Slow subscriber was not disconnected")
+	}
+	assert.EqualValues(t, notification.ErrSlowConsumer, slow.Err())
+	assert.EqualValues(t, []string{"Updated"}, receiveEventIDs(fast, 1))
+	assert.EqualValues(t, notification.Stats{Subscribers: 1, Published: 2, Dropped: 1, Disconnected: 1}, broker.Stats())
+}
+
+func TestBrokerBlockWithTimeout(t *testing.T) {
+	broker := newTestBroker(1, notification.Block)
+	sub := broker.Subscribe(context.Background())
+
+	broker.Publish(&pb.UserEvent{EventId: "Created"})
+	go func() {
+		time.Sleep(time.Millisecond)
+		receiveEventIDs(sub, 1)
+	}()
+	broker.Publish(&pb.UserEvent{EventId: "Updated"})
+	broker.Publish(&pb.UserEvent{EventId: "Deleted"})
+
+	assert.EqualValues(t, []string{"Updated"}, receiveEventIDs(sub, 1))
+	assert.EqualValues(t, 1, sub.Dropped())
+}
+
+func TestFilterMatches(t *testing.T) {
+	updated := &pb.UserEvent{
+		Type:   pb.UserEventType_USER_UPDATED,
+		UserId: "1",
+		Email:  "a@a.com",
+		Before: &pb.User{Email: "a@a.com", Country: "UK", Nickname: "a"},
+		After:  &pb.User{Email: "a@a.com", Country: "FR", Nickname: "a"},
+	}
+	deleted := &pb.UserEvent{
+		Type:   pb.UserEventType_USER_DELETED,
+		UserId: "2",
+		Email:  "b@a.com",
+		Before: &pb.User{Email: "b@a.com", Country: "ES"},
+	}
+
+	cases := []struct {
+		name    string
+		req     *pb.NotifyUserChangesReq
+		updated bool
+		deleted bool
+	}{
+		{"no filters", &pb.NotifyUserChangesReq{}, true, true},
+		{"types", &pb.NotifyUserChangesReq{Types: []pb.UserEventType{pb.UserEventType_USER_DELETED}}, false, true},
+		{"user ids", &pb.NotifyUserChangesReq{UserIds: []string{"1", "3"}}, true, false},
+		{"emails ignoring case", &pb.NotifyUserChangesReq{Emails: []string{"B@A.com"}}, false, true},
+		{"country before the change", &pb.NotifyUserChangesReq{Countries: []string{"UK"}}, true, false},
+		{"country after the change", &pb.NotifyUserChangesReq{Countries: []string{"FR", "ES"}}, true, true},
+		{"changed fields", &pb.NotifyUserChangesReq{ChangedFields: []string{"country"}}, true, true},
+		{"unchanged fields", &pb.NotifyUserChangesReq{ChangedFields: []string{"nickname"}}, false, true},
+		{"every filter", &pb.NotifyUserChangesReq{
+			Types:         []pb.UserEventType{pb.UserEventType_USER_UPDATED},
+			Countries:     []string{"FR"},
+			ChangedFields: []string{"country"},
+		}, true, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			filter, err := notification.NewFilter(c.req)
+			if err != nil {
+				t.Fatalf("Could not build filter: %v", err)
+			}
+			assert.EqualValues(t, c.updated, filter.Matches(updated))
+			assert.EqualValues(t, c.deleted, filter.Matches(deleted))
+		})
+	}
+}
+
+func TestFilterUnknownChangedField(t *testing.T) {
+	_, err := notification.NewFilter(&pb.NotifyUserChangesReq{ChangedFields: []string{"password"}})
+	assert.EqualValues(t, codes.InvalidArgument, status.Code(err))
+}
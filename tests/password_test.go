@@ -0,0 +1,61 @@
+package tests
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"userManagement/entities"
+	"userManagement/infra/password"
+)
+
+var (
+	testParams = password.Params{
+		Memory:      1024,
+		Iterations:  1,
+		Parallelism: 1,
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+)
+
+func TestPasswordHashAndVerify(t *testing.T) {
+	hash, err := password.Hash("1234", testParams)
+	if err != nil {
+		t.Fatalf("Could not hash password: %v", err)
+	}
+
+	assert.EqualValues(t, password.AlgorithmArgon2id, hash.Algorithm)
+	assert.NotEqualValues(t, []byte("1234"), hash.Key)
+
+	valid, needsRehash := password.Verify("1234", entities.User{PasswordHash: hash}, testParams)
+	assert.True(t, valid)
+	assert.False(t, needsRehash)
+
+	valid, _ = password.Verify("4321", entities.User{PasswordHash: hash}, testParams)
+	assert.False(t, valid)
+}
+
+func TestPasswordHashesAreSalted(t *testing.T) {
+	first, _ := password.Hash("1234", testParams)
+	second, _ := password.Hash("1234", testParams)
+
+	assert.NotEqualValues(t, first.Key, second.Key)
+}
+
+func TestPasswordVerifyPlainTextNeedsRehash(t *testing.T) {
+	valid, needsRehash := password.Verify("1234", entities.User{Password: "1234"}, testParams)
+	assert.True(t, valid)
+	assert.True(t, needsRehash)
+
+	valid, _ = password.Verify("4321", entities.User{Password: "1234"}, testParams)
+	assert.False(t, valid)
+}
+
+func TestPasswordVerifyLowerCostNeedsRehash(t *testing.T) {
+	hash, _ := password.Hash("1234", testParams)
+
+	raisedParams := testParams
+	raisedParams.Iterations = 2
+	valid, needsRehash := password.Verify("1234", entities.User{PasswordHash: hash}, raisedParams)
+	assert.True(t, valid)
+	assert.True(t, needsRehash)
+}
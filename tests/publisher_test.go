@@ -0,0 +1,207 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/encoding/protojson"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"userManagement/infra/cloudevents"
+	"userManagement/infra/database"
+	"userManagement/infra/notification"
+	pb "userManagement/proto"
+)
+
+// failingPublisher records the ids of the published events, failing once with the event ids in failOn
+type failingPublisher struct {
+	mu        sync.Mutex
+	failOn    map[string]bool
+	published []string
+}
+
+func (p *failingPublisher) Publish(_ context.Context, event *pb.UserEvent) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.failOn[event.EventId] {
+		delete(p.failOn, event.EventId)
+		return errors.New("bus unavailable")
+	}
+	p.published = append(p.published, event.EventId)
+	return nil
+}
+
+func (p *failingPublisher) Close() error {
+	return nil
+}
+
+func (p *failingPublisher) publishedIDs() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string{}, p.published...)
+}
+
+// unavailablePublisher fails every publication, counting the attempts, like a message bus which is down
+type unavailablePublisher struct {
+	attempts int32
+}
+
+func (p *unavailablePublisher) Publish(context.Context, *pb.UserEvent) error {
+	atomic.AddInt32(&p.attempts, 1)
+	return errors.New("bus unavailable")
+}
+
+func (p *unavailablePublisher) Close() error {
+	return nil
+}
+
+func mustNewEncoder(t *testing.T, format string) notification.Encoder {
+	t.Helper()
+	encoder, err := notification.NewEncoder(format, cloudevents.DefaultConfig())
+	if err != nil {
+		t.Fatalf("Could not create encoder: %v", err)
+	}
+	return encoder
+}
+
+func mustAppendEvents(t *testing.T, eventLog database.EventLogInterface, events ...*pb.UserEvent) {
+	t.Helper()
+	for _, event := range events {
+		if err := eventLog.AppendEvent(context.Background(), event); err != nil {
+			t.Fatalf("Could not append event: %v", err)
+		}
+	}
+}
+
+func TestRelayRetriesFailedPublications(t *testing.T) {
+	eventLog := database.NewMemoryEventLog(0)
+	mustAppendEvents(t, eventLog, &pb.UserEvent{EventId: "1"}, &pb.UserEvent{EventId: "2"}, &pb.UserEvent{EventId: "3"})
+	publisher := &failingPublisher{failOn: map[string]bool{"2": true}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go notification.NewRelay(eventLog, publisher, 10*time.Millisecond).Run(ctx)
+
+	assert.Eventually(t, func() bool { return len(publisher.publishedIDs()) == 3 }, 5*time.Second, 5*time.Millisecond)
+	assert.EqualValues(t, []string{"1", "2", "3"}, publisher.publishedIDs())
+	assert.Eventually(t, func() bool {
+		undelivered, err := eventLog.ListUndeliveredEvents(context.Background(), 0)
+		return err == nil && len(undelivered) == 0
+	}, 5*time.Second, 5*time.Millisecond)
+}
+
+func TestBusRelayFailureDoesNotBlockStreams(t *testing.T) {
+	eventLog := database.NewMemoryEventLog(0)
+	mustAppendEvents(t, eventLog, &pb.UserEvent{EventId: "0"})
+	broker := notification.NewBroker(notification.DefaultConfig())
+	sub := broker.Subscribe(context.Background())
+	defer sub.Close()
+	bus := &unavailablePublisher{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go notification.NewBusRelay(eventLog, bus, notification.NewBrokerPublisher(broker), 10*time.Millisecond).Run(ctx)
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&bus.attempts) > 0 }, 5*time.Second, 5*time.Millisecond)
+
+	// The streams receive the events appended while the bus fails, which are kept for it
+	mustAppendEvents(t, eventLog, &pb.UserEvent{EventId: "1"}, &pb.UserEvent{EventId: "2"})
+	assert.EqualValues(t, []string{"1", "2"}, receiveEventIDs(sub, 2))
+	undelivered, err := eventLog.ListUndeliveredEvents(context.Background(), 0)
+	assert.Nil(t, err)
+	assert.Len(t, undelivered, 3)
+}
+
+func TestNATSPublisher(t *testing.T) {
+	config := notification.DefaultNATSConfig()
+	config.Embedded = true
+	config.EmbeddedPort = -1
+	publisher, err := notification.NewNATSPublisher(config, mustNewEncoder(t, notification.FormatJSON))
+	if err != nil {
+		t.Fatalf("Could not create nats publisher: %v", err)
+	}
+	defer publisher.Close()
+
+	conn, err := nats.Connect(publisher.ClientURL())
+	if err != nil {
+		t.Fatalf("Could not connect to the embedded nats server: %v", err)
+	}
+	defer conn.Close()
+	msgs := make(chan *nats.Msg, 10)
+	sub, err := conn.ChanSubscribe("users.events.>", msgs)
+	if err != nil {
+		t.Fatalf("Could not subscribe: %v", err)
+	}
+	defer sub.Unsubscribe()
+	if err := conn.Flush(); err != nil {
+		t.Fatalf("Could not flush subscription: %v", err)
+	}
+
+	eventLog := database.NewMemoryEventLog(0)
+	mustAppendEvents(t, eventLog,
+		&pb.UserEvent{EventId: "1", Type: pb.UserEventType_USER_CREATED, UserId: "a"},
+		&pb.UserEvent{EventId: "2", Type: pb.UserEventType_USER_DELETED, UserId: "a"},
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go notification.NewRelay(eventLog, publisher, 10*time.Millisecond).Run(ctx)
+
+	for _, expected := range []struct{ id, subject string }{
+		{"1", "users.events.user_created"},
+		{"2", "users.events.user_deleted"},
+	} {
+		select {
+		case msg := <-msgs:
+			var event pb.UserEvent
+			assert.Nil(t, protojson.Unmarshal(msg.Data, &event))
+			assert.EqualValues(t, expected.id, event.EventId)
+			assert.EqualValues(t, expected.subject, msg.Subject)
+			assert.EqualValues(t, expected.id, msg.Header.Get(nats.MsgIdHdr))
+		case <-time.After(5 * time.Second):
+			t.Fatalf("No message received from nats")
+		}
+	}
+}
+
+func TestPublisherEncoders(t *testing.T) {
+	event := &pb.UserEvent{EventId: "1", Type: pb.UserEventType_USER_DELETED, UserId: "a"}
+
+	body, contentType, err := mustNewEncoder(t, notification.FormatJSON).Encode(event)
+	assert.Nil(t, err)
+	assert.EqualValues(t, "application/json", contentType)
+	assert.JSONEq(t, `{"eventId": "1", "type": "USER_DELETED", "userId": "a"}`, string(body))
+
+	body, contentType, err = mustNewEncoder(t, notification.FormatCloudEvents).Encode(event)
+	assert.Nil(t, err)
+	assert.EqualValues(t, cloudevents.StructuredContentType, contentType)
+	var decoded cloudevents.Event
+	assert.Nil(t, json.Unmarshal(body, &decoded))
+	assert.EqualValues(t, "com.usermanagement.user.deleted", decoded.Type)
+	assert.EqualValues(t, "a", decoded.Subject)
+
+	_, err = notification.NewEncoder("xml", cloudevents.DefaultConfig())
+	assert.Error(t, err)
+}
+
+// TestKafkaPublisher needs a Kafka cluster with the users.events topic, whose brokers are set in
+// USER_MANAGEMENT_TEST_KAFKA_BROKERS
+func TestKafkaPublisher(t *testing.T) {
+	brokers := os.Getenv("USER_MANAGEMENT_TEST_KAFKA_BROKERS")
+	if brokers == "" {
+		t.Skip("USER_MANAGEMENT_TEST_KAFKA_BROKERS is not set")
+	}
+	config := notification.DefaultKafkaConfig()
+	config.Brokers = brokers
+	publisher, err := notification.NewKafkaPublisher(config, mustNewEncoder(t, notification.FormatJSON))
+	if err != nil {
+		t.Fatalf("Could not create kafka publisher: %v", err)
+	}
+	defer publisher.Close()
+
+	err = publisher.Publish(context.Background(), &pb.UserEvent{EventId: "1", Type: pb.UserEventType_USER_CREATED, UserId: "a"})
+	assert.Nil(t, err)
+}
@@ -0,0 +1,211 @@
+package tests
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"testing"
+	"userManagement/infra/auth"
+	"userManagement/infra/server"
+	pb "userManagement/proto"
+)
+
+func mustGrantRole(t *testing.T, userServer *server.UserManagementServer, email, role string) {
+	t.Helper()
+	_, err := userServer.DbClient.GrantRole(context.Background(), &pb.GrantRoleReq{UserId: email, Role: role})
+	if err != nil {
+		t.Fatalf("Could not grant role %s to %s: %v", role, email, err)
+	}
+}
+
+// newRolesClient returns an authenticated client of a server with the users a@a.com and b@a.com,
+// along with the user ids by email
+func newRolesClient(t *testing.T) (*server.UserManagementServer, pb.UserManagementClient, map[string]string) {
+	userServer := newAuthServer(t)
+	client := newAuthenticatedClient(t, userServer, nil)
+	ids := map[string]string{}
+	for _, email := range []string{"a@a.com", "b@a.com"} {
+		mustCreateTestUser(t, userServer, email)
+		user, err := userServer.DbClient.GetUser(context.Background(), &pb.GetUserReq{UserId: email})
+		if err != nil {
+			t.Fatalf("Could not get user %s: %v", email, err)
+		}
+		ids[email] = user.Id
+	}
+	return userServer, client, ids
+}
+
+// loginAs returns the context of the requests of the user
+func loginAs(t *testing.T, client pb.UserManagementClient, email string) context.Context {
+	t.Helper()
+	return withAuthorization(context.Background(), "Bearer "+mustLogin(t, client, email).AccessToken)
+}
+
+func assertPermissionDenied(t *testing.T, err error, permission auth.Permission) {
+	t.Helper()
+	assert.EqualValues(t, codes.PermissionDenied, status.Code(err))
+	assert.Contains(t, status.Convert(err).Message(), string(permission))
+}
+
+func TestUsersActOnThemselves(t *testing.T) {
+	_, client, ids := newRolesClient(t)
+	ctx := loginAs(t, client, "a@a.com")
+
+	for _, id := range []string{"a@a.com", ids["a@a.com"]} {
+		user, err := client.GetUser(ctx, &pb.GetUserReq{UserId: id})
+		assert.Nil(t, err)
+		assert.EqualValues(t, []string{auth.RoleUser}, user.GetRoles())
+	}
+	_, err := client.UpdateUser(ctx, &pb.UpdateUserReq{UserId: "a@a.com", User: &pb.User{FirstName: "first"}})
+	assert.Nil(t, err)
+	_, err = client.VerifyPassword(ctx, &pb.VerifyPasswordReq{UserId: ids["a@a.com"], Password: "1234"})
+	assert.Nil(t, err)
+
+	for _, id := range []string{"b@a.com", ids["b@a.com"], "missing@a.com"} {
+		_, err = client.GetUser(ctx, &pb.GetUserReq{UserId: id})
+		assertPermissionDenied(t, err, auth.PermissionRead)
+	}
+	_, err = client.UpdateUser(ctx, &pb.UpdateUserReq{UserId: "b@a.com", User: &pb.User{FirstName: "first"}})
+	assertPermissionDenied(t, err, auth.PermissionUpdate)
+	_, err = client.DeleteUser(ctx, &pb.DeleteUserReq{UserId: "b@a.com"})
+	assertPermissionDenied(t, err, auth.PermissionDelete)
+	_, err = client.VerifyPassword(ctx, &pb.VerifyPasswordReq{UserId: "b@a.com", Password: "1234"})
+	assertPermissionDenied(t, err, auth.PermissionVerifyPassword)
+	_, err = client.ListUsers(ctx, &pb.ListUsersReq{})
+	assertPermissionDenied(t, err, auth.PermissionList)
+	_, err = client.ListWebhooks(ctx, &pb.ListWebhooksReq{})
+	assertPermissionDenied(t, err, auth.PermissionWebhooks)
+
+	stream, err := client.NotifyUserChanges(ctx, &pb.NotifyUserChangesReq{})
+	if err != nil {
+		t.Fatalf("Error when creating server side stream: %v", err)
+	}
+	_, err = stream.Recv()
+	assertPermissionDenied(t, err, auth.PermissionWatch)
+
+	deleted, err := client.DeleteUser(ctx, &pb.DeleteUserReq{UserId: "a@a.com"})
+	assert.Nil(t, err)
+	assert.True(t, deleted.Deleted)
+}
+
+func TestSupportAndAdminRoles(t *testing.T) {
+	userServer, client, _ := newRolesClient(t)
+	mustGrantRole(t, userServer, "a@a.com", auth.RoleSupport)
+	support := loginAs(t, client, "a@a.com")
+
+	user, err := client.GetUser(support, &pb.GetUserReq{UserId: "b@a.com"})
+	assert.Nil(t, err)
+	assert.EqualValues(t, []string{auth.RoleUser}, user.GetRoles())
+	_, err = client.UpdateUser(support, &pb.UpdateUserReq{UserId: "b@a.com", User: &pb.User{FirstName: "first"}})
+	assert.Nil(t, err)
+	_, err = client.ListUsers(support, &pb.ListUsersReq{})
+	assertPermissionDenied(t, err, auth.PermissionList)
+	_, err = client.DeleteUser(support, &pb.DeleteUserReq{UserId: "b@a.com"})
+	assertPermissionDenied(t, err, auth.PermissionDelete)
+	_, err = client.GrantRole(support, &pb.GrantRoleReq{UserId: "b@a.com", Role: auth.RoleSupport})
+	assertPermissionDenied(t, err, auth.PermissionRoles)
+
+	mustGrantRole(t, userServer, "a@a.com", auth.RoleAdmin)
+	admin := loginAs(t, client, "a@a.com")
+	users, err := client.ListUsers(admin, &pb.ListUsersReq{})
+	assert.Nil(t, err)
+	assert.Len(t, users.Users, 2)
+	assert.EqualValues(t, []string{auth.RoleUser, auth.RoleSupport, auth.RoleAdmin}, users.Users[0].Roles)
+	_, err = client.DeleteUser(admin, &pb.DeleteUserReq{UserId: "b@a.com"})
+	assert.Nil(t, err)
+}
+
+func TestGrantAndRevokeRoles(t *testing.T) {
+	userServer, client, _ := newRolesClient(t)
+	mustGrantRole(t, userServer, "a@a.com", auth.RoleAdmin)
+	admin := loginAs(t, client, "a@a.com")
+	tokens := mustLogin(t, client, "b@a.com")
+	user := withAuthorization(context.Background(), "Bearer "+tokens.AccessToken)
+
+	granted, err := client.GrantRole(admin, &pb.GrantRoleReq{UserId: "b@a.com", Role: auth.RoleSupport})
+	assert.Nil(t, err)
+	assert.EqualValues(t, []string{auth.RoleUser, auth.RoleSupport}, granted.Roles)
+	assert.EqualValues(t, 2, granted.Version)
+
+	// Granting a role twice leaves the user unchanged
+	again, err := client.GrantRole(admin, &pb.GrantRoleReq{UserId: "b@a.com", Role: auth.RoleSupport})
+	assert.Nil(t, err)
+	assert.EqualValues(t, granted.Version, again.Version)
+
+	// The role reaches the access token of the user once it is refreshed
+	_, err = client.GetUser(user, &pb.GetUserReq{UserId: "a@a.com"})
+	assertPermissionDenied(t, err, auth.PermissionRead)
+	tokens, err = client.RefreshToken(context.Background(), &pb.RefreshTokenReq{RefreshToken: tokens.RefreshToken})
+	if err != nil {
+		t.Fatalf("Could not refresh tokens: %v", err)
+	}
+	user = withAuthorization(context.Background(), "Bearer "+tokens.AccessToken)
+	_, err = client.GetUser(user, &pb.GetUserReq{UserId: "a@a.com"})
+	assert.Nil(t, err)
+
+	revoked, err := client.RevokeRole(admin, &pb.RevokeRoleReq{UserId: "b@a.com", Role: auth.RoleSupport})
+	assert.Nil(t, err)
+	assert.EqualValues(t, []string{auth.RoleUser}, revoked.Roles)
+	assert.EqualValues(t, 3, revoked.Version)
+
+	for _, role := range []string{auth.RoleUser, "root", ""} {
+		_, err = client.GrantRole(admin, &pb.GrantRoleReq{UserId: "b@a.com", Role: role})
+		assert.EqualValues(t, codes.InvalidArgument, status.Code(err), role)
+		_, err = client.RevokeRole(admin, &pb.RevokeRoleReq{UserId: "b@a.com", Role: role})
+		assert.EqualValues(t, codes.InvalidArgument, status.Code(err), role)
+	}
+	_, err = client.GrantRole(admin, &pb.GrantRoleReq{UserId: "missing@a.com", Role: auth.RoleAdmin})
+	assert.EqualValues(t, codes.NotFound, status.Code(err))
+}
+
+func TestSupportCannotSetPasswords(t *testing.T) {
+	userServer, client, _ := newRolesClient(t)
+	mustGrantRole(t, userServer, "a@a.com", auth.RoleSupport)
+	mustGrantRole(t, userServer, "b@a.com", auth.RoleAdmin)
+	support := loginAs(t, client, "a@a.com")
+
+	for _, req := range []*pb.UpdateUserReq{
+		{UserId: "b@a.com", User: &pb.User{FirstName: "first", Password: "taken"}},
+		{UserId: "b@a.com", User: &pb.User{Password: "taken"}, UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"password"}}},
+	} {
+		_, err := client.UpdateUser(support, req)
+		assertPermissionDenied(t, err, auth.PermissionUpdatePassword)
+	}
+	_, err := client.Login(context.Background(), &pb.LoginReq{Email: "b@a.com", Password: "taken"})
+	assert.EqualValues(t, codes.Unauthenticated, status.Code(err))
+
+	// Users still replace their own password, and admins any password
+	_, err = client.UpdateUser(support, &pb.UpdateUserReq{UserId: "a@a.com", User: &pb.User{Password: "changed"}})
+	assert.Nil(t, err)
+	admin := loginAs(t, client, "b@a.com")
+	_, err = client.UpdateUser(admin, &pb.UpdateUserReq{UserId: "a@a.com", User: &pb.User{Password: "reset"}})
+	assert.Nil(t, err)
+	_, err = client.Login(context.Background(), &pb.LoginReq{Email: "a@a.com", Password: "reset"})
+	assert.Nil(t, err)
+}
+
+func TestAnonymousCallsAreDenied(t *testing.T) {
+	userServer := newAuthServer(t)
+	authenticator := auth.NewAuthenticator(userServer.Auth, nil, []string{"CreateUser", "Login", "ListUsers", "NotifyUserChanges"})
+	client := newTestClient(t, userServer,
+		grpc.UnaryInterceptor(authenticator.UnaryInterceptor()),
+		grpc.StreamInterceptor(authenticator.StreamInterceptor()))
+	mustCreateTestUser(t, userServer, "a@a.com")
+
+	// Public methods checking a permission are not open to callers without credentials
+	_, err := client.ListUsers(context.Background(), &pb.ListUsersReq{})
+	assert.EqualValues(t, codes.Unauthenticated, status.Code(err))
+	stream, err := client.NotifyUserChanges(context.Background(), &pb.NotifyUserChangesReq{})
+	if err != nil {
+		t.Fatalf("Error when creating server side stream: %v", err)
+	}
+	_, err = stream.Recv()
+	assert.EqualValues(t, codes.Unauthenticated, status.Code(err))
+
+	mustGrantRole(t, userServer, "a@a.com", auth.RoleAdmin)
+	_, err = client.ListUsers(loginAs(t, client, "a@a.com"), &pb.ListUsersReq{})
+	assert.Nil(t, err)
+}
@@ -0,0 +1,305 @@
+package tests
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"userManagement/infra/auth"
+	"userManagement/infra/certs"
+	"userManagement/infra/database"
+	"userManagement/infra/gateway"
+	"userManagement/infra/server"
+	pb "userManagement/proto"
+)
+
+// testCA signs the certificates of the TLS tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	dir  string
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	ca := &testCA{dir: t.TempDir()}
+	ca.cert, ca.key = ca.sign(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	})
+	writePEM(t, ca.path("ca.pem"), "CERTIFICATE", ca.cert.Raw)
+	return ca
+}
+
+func (ca *testCA) path(name string) string {
+	return filepath.Join(ca.dir, name)
+}
+
+// sign issues the template, self signed when the CA has no certificate yet
+func (ca *testCA) sign(t *testing.T, template *x509.Certificate) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Could not generate key: %v", err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatalf("Could not generate serial: %v", err)
+	}
+	template.SerialNumber = serial
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	parent, signer := template, key
+	if ca.cert != nil {
+		parent, signer = ca.cert, ca.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatalf("Could not create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Could not parse certificate: %v", err)
+	}
+	return cert, key
+}
+
+// issue writes a certificate for the common name and its key as <name>.pem and <name>-key.pem
+func (ca *testCA) issue(t *testing.T, name, commonName string, usage x509.ExtKeyUsage) (string, string) {
+	t.Helper()
+	cert, key := ca.sign(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: commonName},
+		DNSNames:    []string{"localhost"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{usage},
+	})
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("Could not encode key: %v", err)
+	}
+	certFile, keyFile := ca.path(name+".pem"), ca.path(name+"-key.pem")
+	writePEM(t, certFile, "CERTIFICATE", cert.Raw)
+	writePEM(t, keyFile, "PRIVATE KEY", der)
+	return certFile, keyFile
+}
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatalf("Could not write %s: %v", path, err)
+	}
+}
+
+// serveTLS serves the server over TLS with the certificate server.pem of the CA, verifying client certificates
+// against it, and returns its address. The certificates forwarded by the client with the subject CN=gateway are trusted
+func serveTLS(t *testing.T, userServer *server.UserManagementServer, ca *testCA, requireClientCert bool, reloadInterval time.Duration) string {
+	t.Helper()
+	certFile, keyFile := ca.path("server.pem"), ca.path("server-key.pem")
+	reloader, err := certs.NewReloader(certFile, keyFile, ca.path("ca.pem"), reloadInterval)
+	if err != nil {
+		t.Fatalf("Could not load server certificate: %v", err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not listen: %v", err)
+	}
+	authenticator := auth.NewAuthenticator(userServer.Auth, nil, auth.DefaultPublicMethods)
+	authenticator.GatewaySubject = "CN=gateway"
+	s := grpc.NewServer(
+		grpc.Creds(credentials.NewTLS(certs.ServerTLSConfig(reloader, requireClientCert, "h2"))),
+		grpc.UnaryInterceptor(authenticator.UnaryInterceptor()),
+		grpc.StreamInterceptor(authenticator.StreamInterceptor()))
+	pb.RegisterUserManagementServer(s, userServer)
+	go func() {
+		_ = s.Serve(listener)
+	}()
+	t.Cleanup(s.Stop)
+	return listener.Addr().String()
+}
+
+// dialTLS returns a client of the server at the address, which verifies it with the CA and sends the client certificate
+func dialTLS(t *testing.T, address string, clientConfig certs.ClientConfig) pb.UserManagementClient {
+	t.Helper()
+	tlsConfig, err := certs.ClientTLSConfig(clientConfig, time.Minute)
+	if err != nil {
+		t.Fatalf("Could not load client TLS config: %v", err)
+	}
+	conn, err := grpc.Dial(address, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	if err != nil {
+		t.Fatalf("Could not dial %s: %v", address, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewUserManagementClient(conn)
+}
+
+func TestTLSClientCertificateIdentity(t *testing.T) {
+	ca := newTestCA(t)
+	ca.issue(t, "server", "localhost", x509.ExtKeyUsageServerAuth)
+	certFile, keyFile := ca.issue(t, "reports", "reports", x509.ExtKeyUsageClientAuth)
+	userServer := newAuthServer(t)
+	address := serveTLS(t, userServer, ca, false, time.Minute)
+
+	client := dialTLS(t, address, certs.ClientConfig{CAFile: ca.path("ca.pem"), ServerName: "localhost", CertFile: certFile, KeyFile: keyFile})
+	_, err := client.CreateUser(context.Background(), &pb.CreateUserReq{User: &pb.User{Email: "a@a.com", Password: "1234"}})
+	assert.Nil(t, err)
+
+	// The certificate identifies the callers of public methods, but does not authenticate them on the rest
+	events, err := userServer.EventLog.ListEvents(context.Background(), database.EventQuery{Limit: 1})
+	assert.Nil(t, err)
+	assert.EqualValues(t, "certificate:CN=reports", events[0].Actor)
+	_, err = client.ListUsers(context.Background(), &pb.ListUsersReq{})
+	assert.EqualValues(t, codes.Unauthenticated, status.Code(err))
+
+	// Clients without certificate are still accepted when they are not required
+	anonymous := dialTLS(t, address, certs.ClientConfig{CAFile: ca.path("ca.pem"), ServerName: "localhost"})
+	_, err = anonymous.CreateUser(context.Background(), &pb.CreateUserReq{User: &pb.User{Email: "b@a.com", Password: "1234"}})
+	assert.Nil(t, err)
+	events, err = userServer.EventLog.ListEvents(context.Background(), database.EventQuery{After: 1, Limit: 1})
+	assert.Nil(t, err)
+	assert.EqualValues(t, "anonymous", events[0].Actor)
+}
+
+func TestTLSRequireClientCert(t *testing.T) {
+	ca := newTestCA(t)
+	ca.issue(t, "server", "localhost", x509.ExtKeyUsageServerAuth)
+	certFile, keyFile := ca.issue(t, "reports", "reports", x509.ExtKeyUsageClientAuth)
+	// Certificates of other CAs are rejected
+	other := newTestCA(t)
+	otherCertFile, otherKeyFile := other.issue(t, "reports", "reports", x509.ExtKeyUsageClientAuth)
+	address := serveTLS(t, newAuthServer(t), ca, true, time.Minute)
+
+	for _, clientConfig := range []certs.ClientConfig{
+		{CAFile: ca.path("ca.pem"), ServerName: "localhost"},
+		{CAFile: ca.path("ca.pem"), ServerName: "localhost", CertFile: otherCertFile, KeyFile: otherKeyFile},
+	} {
+		client := dialTLS(t, address, clientConfig)
+		_, err := client.CreateUser(context.Background(), &pb.CreateUserReq{User: &pb.User{Email: "a@a.com", Password: "1234"}})
+		assert.EqualValues(t, codes.Unavailable, status.Code(err), clientConfig.CertFile)
+	}
+
+	client := dialTLS(t, address, certs.ClientConfig{CAFile: ca.path("ca.pem"), ServerName: "localhost", CertFile: certFile, KeyFile: keyFile})
+	_, err := client.CreateUser(context.Background(), &pb.CreateUserReq{User: &pb.User{Email: "a@a.com", Password: "1234"}})
+	assert.Nil(t, err)
+}
+
+func TestTLSCertificateReload(t *testing.T) {
+	ca := newTestCA(t)
+	ca.issue(t, "server", "localhost", x509.ExtKeyUsageServerAuth)
+	address := serveTLS(t, newEventsServer(t), ca, false, 10*time.Millisecond)
+	tlsConfig, err := certs.ClientTLSConfig(certs.ClientConfig{CAFile: ca.path("ca.pem"), ServerName: "localhost"}, time.Minute)
+	if err != nil {
+		t.Fatalf("Could not load client TLS config: %v", err)
+	}
+	tlsConfig.NextProtos = []string{"h2"}
+	serverName := func() string {
+		conn, err := tls.Dial("tcp", address, tlsConfig)
+		if err != nil {
+			t.Fatalf("Could not connect to %s: %v", address, err)
+		}
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+	}
+	assert.EqualValues(t, "localhost", serverName())
+
+	// The renewed certificate is served to the next connections once the change is checked
+	certFile, keyFile := ca.issue(t, "server", "renewed", x509.ExtKeyUsageServerAuth)
+	later := time.Now().Add(time.Minute)
+	for _, path := range []string{certFile, keyFile} {
+		if err := os.Chtimes(path, later, later); err != nil {
+			t.Fatalf("Could not change %s: %v", path, err)
+		}
+	}
+	assert.Eventually(t, func() bool { return serverName() == "renewed" }, 3*time.Second, 20*time.Millisecond)
+}
+
+func TestTLSGatewayForwardsClientCertificate(t *testing.T) {
+	ca := newTestCA(t)
+	serverCertFile, serverKeyFile := ca.issue(t, "server", "localhost", x509.ExtKeyUsageServerAuth)
+	gatewayCertFile, gatewayKeyFile := ca.issue(t, "gateway", "gateway", x509.ExtKeyUsageClientAuth)
+	reportsCertFile, reportsKeyFile := ca.issue(t, "reports", "reports", x509.ExtKeyUsageClientAuth)
+	userServer := newAuthServer(t)
+	address := serveTLS(t, userServer, ca, false, time.Minute)
+
+	subject, err := certs.CertificateSubject(gatewayCertFile)
+	assert.Nil(t, err)
+	assert.EqualValues(t, "CN=gateway", subject)
+
+	// The gateway serves the REST API over TLS, verifying client certificates, and calls the server with its own
+	client := dialTLS(t, address, certs.ClientConfig{CAFile: ca.path("ca.pem"), ServerName: "localhost", CertFile: gatewayCertFile, KeyFile: gatewayKeyFile})
+	mux := gateway.NewServeMux()
+	if err := pb.RegisterUserManagementHandlerClient(context.Background(), mux, client); err != nil {
+		t.Fatalf("Could not register gateway handlers: %v", err)
+	}
+	reloader, err := certs.NewReloader(serverCertFile, serverKeyFile, ca.path("ca.pem"), time.Minute)
+	if err != nil {
+		t.Fatalf("Could not load server certificate: %v", err)
+	}
+	gatewayServer := httptest.NewUnstartedServer(mux)
+	gatewayServer.TLS = certs.ServerTLSConfig(reloader, false, "http/1.1")
+	gatewayServer.StartTLS()
+	defer gatewayServer.Close()
+
+	createUser := func(clientConfig certs.ClientConfig, email string, header http.Header) string {
+		t.Helper()
+		tlsConfig, err := certs.ClientTLSConfig(clientConfig, time.Minute)
+		if err != nil {
+			t.Fatalf("Could not load client TLS config: %v", err)
+		}
+		httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+		req, err := http.NewRequest(http.MethodPost, gatewayServer.URL+"/v1/users", strings.NewReader(`{"email": "`+email+`", "password": "1234"}`))
+		if err != nil {
+			t.Fatalf("Could not create request: %v", err)
+		}
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			t.Fatalf("Could not create user %s: %v", email, err)
+		}
+		resp.Body.Close()
+		assert.EqualValues(t, http.StatusOK, resp.StatusCode)
+		events, err := userServer.EventLog.ListEvents(context.Background(), database.EventQuery{})
+		assert.Nil(t, err)
+		return events[len(events)-1].Actor
+	}
+
+	caConfig := certs.ClientConfig{CAFile: ca.path("ca.pem"), ServerName: "127.0.0.1"}
+	reportsConfig := caConfig
+	reportsConfig.CertFile, reportsConfig.KeyFile = reportsCertFile, reportsKeyFile
+	assert.EqualValues(t, "certificate:CN=reports", createUser(reportsConfig, "a@a.com", nil))
+	// REST callers without certificate do not get the one of the gateway, nor can they send their own subject
+	assert.EqualValues(t, "anonymous", createUser(caConfig, "b@a.com", nil))
+	assert.EqualValues(t, "anonymous", createUser(caConfig, "c@a.com", http.Header{"Grpc-Metadata-X-Client-Certificate": {"CN=admin"}}))
+
+	// Clients other than the gateway cannot forward a subject either
+	reports := dialTLS(t, address, certs.ClientConfig{CAFile: ca.path("ca.pem"), ServerName: "localhost", CertFile: reportsCertFile, KeyFile: reportsKeyFile})
+	ctx := metadata.AppendToOutgoingContext(context.Background(), auth.ClientCertificateHeader, "CN=admin")
+	_, err = reports.CreateUser(ctx, &pb.CreateUserReq{User: &pb.User{Email: "d@a.com", Password: "1234"}})
+	assert.Nil(t, err)
+	events, err := userServer.EventLog.ListEvents(context.Background(), database.EventQuery{After: 3})
+	assert.Nil(t, err)
+	assert.EqualValues(t, "certificate:CN=reports", events[0].Actor)
+}
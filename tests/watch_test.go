@@ -0,0 +1,282 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/websocket"
+	"google.golang.org/protobuf/encoding/protojson"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"userManagement/infra/auth"
+	"userManagement/infra/gateway"
+	"userManagement/infra/server"
+	pb "userManagement/proto"
+)
+
+// sinceMinuteAgo selects the events stored during the last minute, which are all the events of a test
+func sinceMinuteAgo() string {
+	return "since_timestamp=" + time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+}
+
+// newTestWatchServer serves the watch handler of the received server until the test ends
+func newTestWatchServer(t *testing.T, userServer *server.UserManagementServer, heartbeat time.Duration) *httptest.Server {
+	handler := gateway.NewWatchHandler(newTestClient(t, userServer), heartbeat, nil)
+	httpServer := httptest.NewServer(handler)
+	t.Cleanup(httpServer.Close)
+	return httpServer
+}
+
+// sseFrame is an event received from a Server-Sent Events stream, or a comment when it has no data
+type sseFrame struct {
+	id      string
+	event   string
+	data    string
+	comment string
+}
+
+// openEventStream requests the Server-Sent Events stream, failing the test when it is not opened
+func openEventStream(t *testing.T, ctx context.Context, url string, header http.Header) *bufio.Reader {
+	t.Helper()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatalf("Could not create request: %v", err)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Could not open event stream: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Unexpected event stream status %s", resp.Status)
+	}
+	assert.EqualValues(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	return bufio.NewReader(resp.Body)
+}
+
+// readFrame reads the lines of the stream until the blank line ending a frame
+func readFrame(t *testing.T, reader *bufio.Reader) sseFrame {
+	t.Helper()
+	var frame sseFrame
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Could not read event stream: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			return frame
+		case strings.HasPrefix(line, ":"):
+			frame.comment = strings.TrimSpace(line[1:])
+		case strings.HasPrefix(line, "id: "):
+			frame.id = line[len("id: "):]
+		case strings.HasPrefix(line, "event: "):
+			frame.event = line[len("event: "):]
+		case strings.HasPrefix(line, "data: "):
+			frame.data = line[len("data: "):]
+		}
+	}
+}
+
+// readEvents reads the received number of events, skipping heartbeats, returning their emails and ids
+func readEvents(t *testing.T, reader *bufio.Reader, count int) ([]string, []string) {
+	t.Helper()
+	var emails, ids []string
+	for len(emails) < count {
+		frame := readFrame(t, reader)
+		if frame.data == "" {
+			continue
+		}
+		var event pb.UserEvent
+		if err := protojson.Unmarshal([]byte(frame.data), &event); err != nil {
+			t.Fatalf("Could not decode event %q: %v", frame.data, err)
+		}
+		assert.EqualValues(t, strconv.FormatInt(event.Sequence, 10), frame.id)
+		emails = append(emails, event.Email)
+		ids = append(ids, frame.id)
+	}
+	return emails, ids
+}
+
+func TestWatchServerSentEvents(t *testing.T) {
+	userServer := newEventsServer(t)
+	httpServer := newTestWatchServer(t, userServer, time.Minute)
+	mustCreateTestUser(t, userServer, "a@a.com")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	reader := openEventStream(t, ctx, httpServer.URL+gateway.WatchPath+"?"+sinceMinuteAgo(), nil)
+
+	emails, ids := readEvents(t, reader, 1)
+	assert.EqualValues(t, []string{"a@a.com"}, emails)
+	assert.EqualValues(t, []string{"1"}, ids)
+
+	waitForSubscribers(t, userServer.Broker, 1)
+	mustCreateTestUser(t, userServer, "b@a.com")
+
+	emails, ids = readEvents(t, reader, 1)
+	assert.EqualValues(t, []string{"b@a.com"}, emails)
+	assert.EqualValues(t, []string{"2"}, ids)
+}
+
+func TestWatchResumesAfterLastEventID(t *testing.T) {
+	userServer := newEventsServer(t)
+	httpServer := newTestWatchServer(t, userServer, time.Minute)
+	mustCreateTestUser(t, userServer, "a@a.com")
+	mustCreateTestUser(t, userServer, "b@a.com")
+	mustCreateTestUser(t, userServer, "c@a.com")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	reader := openEventStream(t, ctx, httpServer.URL+gateway.WatchPath, http.Header{"Last-Event-ID": {"1"}})
+
+	emails, ids := readEvents(t, reader, 2)
+	assert.EqualValues(t, []string{"b@a.com", "c@a.com"}, emails)
+	assert.EqualValues(t, []string{"2", "3"}, ids)
+}
+
+func TestWatchFilters(t *testing.T) {
+	userServer := newEventsServer(t)
+	httpServer := newTestWatchServer(t, userServer, time.Minute)
+	mustCreateTestUser(t, userServer, "a@a.com")
+	mustCreateTestUser(t, userServer, "b@a.com")
+	_, err := userServer.DeleteUser(context.Background(), &pb.DeleteUserReq{UserId: "b@a.com"})
+	if err != nil {
+		t.Fatalf("Could not delete user: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	reader := openEventStream(t, ctx, httpServer.URL+gateway.WatchPath+"?"+sinceMinuteAgo()+"&types=user_deleted,user_updated", nil)
+
+	emails, ids := readEvents(t, reader, 1)
+	assert.EqualValues(t, []string{"b@a.com"}, emails)
+	assert.EqualValues(t, []string{"3"}, ids)
+}
+
+func TestWatchHeartbeat(t *testing.T) {
+	httpServer := newTestWatchServer(t, newEventsServer(t), 20*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	reader := openEventStream(t, ctx, httpServer.URL+gateway.WatchPath, nil)
+
+	frame := readFrame(t, reader)
+	assert.EqualValues(t, sseFrame{comment: "heartbeat"}, frame)
+}
+
+func TestWatchInvalidRequest(t *testing.T) {
+	httpServer := newTestWatchServer(t, newEventsServer(t), time.Minute)
+
+	for _, query := range []string{"changed_fields=password", "types=USER_RENAMED", "resume_after=last", "since_timestamp=yesterday"} {
+		resp, err := http.Get(httpServer.URL + gateway.WatchPath + "?" + query)
+		if err != nil {
+			t.Fatalf("Could not request %s: %v", query, err)
+		}
+		resp.Body.Close()
+		assert.EqualValues(t, http.StatusBadRequest, resp.StatusCode, query)
+	}
+
+	resp, err := http.Post(httpServer.URL+gateway.WatchPath, "application/json", nil)
+	if err != nil {
+		t.Fatalf("Could not post: %v", err)
+	}
+	resp.Body.Close()
+	assert.EqualValues(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
+
+func TestWatchWebSocket(t *testing.T) {
+	userServer := newEventsServer(t)
+	httpServer := newTestWatchServer(t, userServer, 20*time.Millisecond)
+	mustCreateTestUser(t, userServer, "a@a.com")
+
+	url := "ws" + strings.TrimPrefix(httpServer.URL, "http") + gateway.WatchPath + "?" + sinceMinuteAgo()
+	conn, err := websocket.Dial(url, "", httpServer.URL)
+	if err != nil {
+		t.Fatalf("Could not open websocket: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	receive := func() *pb.UserEvent {
+		var message string
+		if err := websocket.Message.Receive(conn, &message); err != nil {
+			t.Fatalf("Could not receive websocket message: %v", err)
+		}
+		var event pb.UserEvent
+		if err := protojson.Unmarshal([]byte(message), &event); err != nil {
+			t.Fatalf("Could not decode event %q: %v", message, err)
+		}
+		return &event
+	}
+
+	event := receive()
+	assert.EqualValues(t, "a@a.com", event.Email)
+	assert.EqualValues(t, 1, event.Sequence)
+
+	waitForSubscribers(t, userServer.Broker, 1)
+	// Heartbeats are answered by the client while the stream is idle
+	time.Sleep(50 * time.Millisecond)
+	mustCreateTestUser(t, userServer, "b@a.com")
+
+	event = receive()
+	assert.EqualValues(t, "b@a.com", event.Email)
+	assert.EqualValues(t, 2, event.Sequence)
+}
+
+func TestWatchFromBrowser(t *testing.T) {
+	userServer := newAuthServer(t)
+	client := newAuthenticatedClient(t, userServer, nil)
+	mustCreateTestUser(t, userServer, "a@a.com")
+	mustGrantRole(t, userServer, "a@a.com", auth.RoleAdmin)
+	tokens := mustLogin(t, client, "a@a.com")
+	httpServer := httptest.NewServer(gateway.NewWatchHandler(client, time.Minute, []string{"https://admin.example.com"}))
+	defer httpServer.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	reader := openEventStream(t, ctx, httpServer.URL+gateway.WatchPath+"?"+sinceMinuteAgo()+"&access_token="+tokens.AccessToken, nil)
+	emails, _ := readEvents(t, reader, 1)
+	assert.EqualValues(t, []string{"a@a.com"}, emails)
+
+	cookie := http.Header{"Cookie": {gateway.AccessTokenParameter + "=" + tokens.AccessToken}, "Origin": {"https://admin.example.com"}}
+	reader = openEventStream(t, ctx, httpServer.URL+gateway.WatchPath+"?"+sinceMinuteAgo(), cookie)
+	emails, _ = readEvents(t, reader, 1)
+	assert.EqualValues(t, []string{"a@a.com"}, emails)
+
+	url := "ws" + strings.TrimPrefix(httpServer.URL, "http") + gateway.WatchPath + "?" + sinceMinuteAgo() + "&access_token=" + tokens.AccessToken
+	conn, err := websocket.Dial(url, "", httpServer.URL)
+	if err != nil {
+		t.Fatalf("Could not open websocket from the gateway origin: %v", err)
+	}
+	conn.Close()
+
+	_, err = websocket.Dial(url, "", "https://evil.example.com")
+	assert.NotNil(t, err)
+	req, err := http.NewRequest(http.MethodGet, httpServer.URL+gateway.WatchPath, nil)
+	if err != nil {
+		t.Fatalf("Could not create request: %v", err)
+	}
+	req.Header = http.Header{"Cookie": cookie["Cookie"], "Origin": {"https://evil.example.com"}}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Could not request the watch endpoint: %v", err)
+	}
+	resp.Body.Close()
+	assert.EqualValues(t, http.StatusForbidden, resp.StatusCode)
+
+	resp, err = http.Get(httpServer.URL + gateway.WatchPath + "?access_token=invalid")
+	if err != nil {
+		t.Fatalf("Could not request the watch endpoint: %v", err)
+	}
+	resp.Body.Close()
+	assert.EqualValues(t, http.StatusUnauthorized, resp.StatusCode)
+}
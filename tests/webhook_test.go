@@ -0,0 +1,304 @@
+package tests
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+	"userManagement/infra/cloudevents"
+	"userManagement/infra/database"
+	"userManagement/infra/server"
+	"userManagement/infra/webhook"
+	pb "userManagement/proto"
+)
+
+// webhookReceiver records the events posted to it and whether their signature is valid, responding with
+// the status set in status instead when it is not 0
+type webhookReceiver struct {
+	*httptest.Server
+	events   chan *pb.UserEvent
+	verified chan bool
+	status   int32
+}
+
+func newWebhookReceiver(t *testing.T, secret string) *webhookReceiver {
+	receiver := &webhookReceiver{events: make(chan *pb.UserEvent, 10), verified: make(chan bool, 10)}
+	receiver.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status := atomic.LoadInt32(&receiver.status); status != 0 {
+			w.WriteHeader(int(status))
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		var event pb.UserEvent
+		if err := protojson.Unmarshal(body, &event); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		receiver.verified <- webhook.Verify(secret, r.Header.Get(webhook.TimestampHeader), r.Header.Get(webhook.SignatureHeader), body)
+		receiver.events <- &event
+	}))
+	t.Cleanup(receiver.Close)
+	return receiver
+}
+
+func (r *webhookReceiver) receive(t *testing.T) *pb.UserEvent {
+	t.Helper()
+	select {
+	case event := <-r.events:
+		assert.True(t, <-r.verified, "signature must be valid")
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatalf("No event received by the webhook")
+		return nil
+	}
+}
+
+// newWebhooksServer creates a server backed by in memory users, events and webhooks, whose deliveries
+// are sent by a dispatcher running until the test ends
+func newWebhooksServer(t *testing.T, config webhook.Config) *server.UserManagementServer {
+	userServer := newEventsServer(t)
+	userServer.Webhooks = database.NewMemoryWebhookStore()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		webhook.NewDispatcher(userServer.Webhooks, userServer.EventLog, config, cloudevents.DefaultConfig()).Run(ctx)
+		close(stopped)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-stopped
+	})
+	return userServer
+}
+
+func testWebhookConfig() webhook.Config {
+	return webhook.Config{
+		MaxAttempts:    2,
+		InitialBackoff: 10 * time.Millisecond,
+		MaxBackoff:     10 * time.Millisecond,
+		Timeout:        time.Second,
+		PollInterval:   10 * time.Millisecond,
+	}
+}
+
+// waitForDeliveries waits until the webhook has the received number of deliveries with the received status
+func waitForDeliveries(t *testing.T, userServer *server.UserManagementServer, webhookID string, status pb.WebhookDeliveryStatus, count int) []*pb.WebhookDelivery {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp, err := userServer.ListWebhookDeliveries(context.Background(), &pb.ListWebhookDeliveriesReq{WebhookId: webhookID, Status: status})
+		if err != nil {
+			t.Fatalf("Could not list deliveries: %v", err)
+		}
+		if len(resp.Deliveries) == count {
+			return resp.Deliveries
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d %s deliveries, got %d", count, status, len(resp.Deliveries))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWebhookSignature(t *testing.T) {
+	body := []byte(`{"eventId":"1"}`)
+	signature := webhook.Sign("secret", 1700000000, body)
+
+	assert.Regexp(t, "^sha256=[0-9a-f]{64}$", signature)
+	assert.True(t, webhook.Verify("secret", "1700000000", signature, body))
+	assert.False(t, webhook.Verify("other", "1700000000", signature, body))
+	assert.False(t, webhook.Verify("secret", "1700000001", signature, body))
+	assert.False(t, webhook.Verify("secret", "1700000000", signature, []byte(`{"eventId":"2"}`)))
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	config := webhook.Config{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second}
+
+	assert.EqualValues(t, time.Second, config.Backoff(1))
+	assert.EqualValues(t, 2*time.Second, config.Backoff(2))
+	assert.EqualValues(t, 4*time.Second, config.Backoff(3))
+	assert.EqualValues(t, 5*time.Second, config.Backoff(4))
+	assert.EqualValues(t, 5*time.Second, config.Backoff(40))
+}
+
+func TestWebhookCRUD(t *testing.T) {
+	userServer := newEventsServer(t)
+	userServer.Webhooks = database.NewMemoryWebhookStore()
+	ctx := context.Background()
+
+	_, err := userServer.CreateWebhook(ctx, &pb.CreateWebhookReq{Webhook: &pb.Webhook{Url: "ftp://localhost"}})
+	assert.EqualValues(t, codes.InvalidArgument, status.Code(err))
+
+	created, err := userServer.CreateWebhook(ctx, &pb.CreateWebhookReq{Webhook: &pb.Webhook{Url: "http://localhost/hook"}})
+	if err != nil {
+		t.Fatalf("Could not create webhook: %v", err)
+	}
+	assert.Len(t, created.Secret, 64)
+
+	found, err := userServer.GetWebhook(ctx, &pb.GetWebhookReq{WebhookId: created.Id})
+	assert.Nil(t, err)
+	assert.EqualValues(t, "http://localhost/hook", found.Url)
+	assert.Empty(t, found.Secret)
+
+	updated, err := userServer.UpdateWebhook(ctx, &pb.UpdateWebhookReq{
+		WebhookId:  created.Id,
+		Webhook:    &pb.Webhook{Types: []pb.UserEventType{pb.UserEventType_USER_DELETED}, Url: "ignored"},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"types"}},
+	})
+	assert.Nil(t, err)
+	assert.EqualValues(t, "http://localhost/hook", updated.Url)
+	assert.EqualValues(t, []pb.UserEventType{pb.UserEventType_USER_DELETED}, updated.Types)
+	assert.Empty(t, updated.Secret)
+
+	_, err = userServer.UpdateWebhook(ctx, &pb.UpdateWebhookReq{
+		WebhookId:  created.Id,
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"id"}},
+	})
+	assert.EqualValues(t, codes.InvalidArgument, status.Code(err))
+
+	list, err := userServer.ListWebhooks(ctx, &pb.ListWebhooksReq{})
+	assert.Nil(t, err)
+	assert.Len(t, list.Webhooks, 1)
+	assert.Empty(t, list.Webhooks[0].Secret)
+
+	deleted, err := userServer.DeleteWebhook(ctx, &pb.DeleteWebhookReq{WebhookId: created.Id})
+	assert.Nil(t, err)
+	assert.True(t, deleted.Deleted)
+	_, err = userServer.GetWebhook(ctx, &pb.GetWebhookReq{WebhookId: created.Id})
+	assert.EqualValues(t, codes.NotFound, status.Code(err))
+}
+
+func TestWebhookDisabled(t *testing.T) {
+	_, err := newEventsServer(t).ListWebhooks(context.Background(), &pb.ListWebhooksReq{})
+	assert.EqualValues(t, codes.Unimplemented, status.Code(err))
+}
+
+func TestWebhookDeliversMatchingEvents(t *testing.T) {
+	userServer := newWebhooksServer(t, testWebhookConfig())
+	receiver := newWebhookReceiver(t, "secret")
+	created, err := userServer.CreateWebhook(context.Background(), &pb.CreateWebhookReq{Webhook: &pb.Webhook{
+		Url:    receiver.URL,
+		Types:  []pb.UserEventType{pb.UserEventType_USER_DELETED},
+		Secret: "secret",
+	}})
+	if err != nil {
+		t.Fatalf("Could not create webhook: %v", err)
+	}
+
+	mustCreateTestUser(t, userServer, "a@a.com")
+	_, err = userServer.DeleteUser(context.Background(), &pb.DeleteUserReq{UserId: "a@a.com"})
+	if err != nil {
+		t.Fatalf("Could not delete user: %v", err)
+	}
+
+	event := receiver.receive(t)
+	assert.EqualValues(t, pb.UserEventType_USER_DELETED, event.Type)
+	assert.EqualValues(t, "a@a.com", event.Email)
+
+	deliveries := waitForDeliveries(t, userServer, created.Id, pb.WebhookDeliveryStatus_SUCCEEDED, 1)
+	assert.EqualValues(t, 1, deliveries[0].Attempts)
+	assert.EqualValues(t, http.StatusOK, deliveries[0].LastStatusCode)
+	assert.Empty(t, receiver.events)
+}
+
+func TestWebhookDeadLetterAndReplay(t *testing.T) {
+	userServer := newWebhooksServer(t, testWebhookConfig())
+	receiver := newWebhookReceiver(t, "secret")
+	atomic.StoreInt32(&receiver.status, http.StatusInternalServerError)
+	created, err := userServer.CreateWebhook(context.Background(), &pb.CreateWebhookReq{Webhook: &pb.Webhook{
+		Url:    receiver.URL,
+		Secret: "secret",
+	}})
+	if err != nil {
+		t.Fatalf("Could not create webhook: %v", err)
+	}
+
+	mustCreateTestUser(t, userServer, "a@a.com")
+	deadLetters := waitForDeliveries(t, userServer, created.Id, pb.WebhookDeliveryStatus_DEAD_LETTER, 1)
+	assert.EqualValues(t, 2, deadLetters[0].Attempts)
+	assert.EqualValues(t, http.StatusInternalServerError, deadLetters[0].LastStatusCode)
+	assert.Contains(t, deadLetters[0].LastError, "500")
+
+	atomic.StoreInt32(&receiver.status, 0)
+	replayed, err := userServer.ReplayWebhook(context.Background(), &pb.ReplayWebhookReq{WebhookId: created.Id, DeadLetters: true})
+	assert.Nil(t, err)
+	assert.EqualValues(t, 1, replayed.Scheduled)
+
+	event := receiver.receive(t)
+	assert.EqualValues(t, deadLetters[0].Event.EventId, event.EventId)
+	waitForDeliveries(t, userServer, created.Id, pb.WebhookDeliveryStatus_SUCCEEDED, 1)
+
+	// Stored events are replayed as new deliveries
+	replayed, err = userServer.ReplayWebhook(context.Background(), &pb.ReplayWebhookReq{WebhookId: created.Id, AfterSequence: 0})
+	assert.EqualValues(t, codes.InvalidArgument, status.Code(err))
+	mustCreateTestUser(t, userServer, "b@a.com")
+	assert.EqualValues(t, "b@a.com", receiver.receive(t).Email)
+
+	replayed, err = userServer.ReplayWebhook(context.Background(), &pb.ReplayWebhookReq{WebhookId: created.Id, AfterSequence: 1})
+	assert.Nil(t, err)
+	assert.EqualValues(t, 1, replayed.Scheduled)
+	assert.EqualValues(t, "b@a.com", receiver.receive(t).Email)
+	waitForDeliveries(t, userServer, created.Id, pb.WebhookDeliveryStatus_SUCCEEDED, 3)
+}
+
+// lateEventLog leaves an event out of the listings until it is released, as mongo without transactions does
+// with an event stored after others with higher sequences
+type lateEventLog struct {
+	*database.MemoryEventLog
+	hidden int64
+}
+
+func (l *lateEventLog) ListEvents(ctx context.Context, query database.EventQuery) ([]*pb.UserEvent, error) {
+	events, err := l.MemoryEventLog.ListEvents(ctx, query)
+	var listed []*pb.UserEvent
+	for _, event := range events {
+		if event.Sequence != atomic.LoadInt64(&l.hidden) {
+			listed = append(listed, event)
+		}
+	}
+	return listed, err
+}
+
+func TestWebhookWaitsForLateEvents(t *testing.T) {
+	dbClient := database.NewMemoryClient()
+	dbClient.EventLog = database.NewMemoryEventLog(0)
+	eventLog := &lateEventLog{MemoryEventLog: dbClient.EventLog, hidden: 2}
+	userServer := &server.UserManagementServer{DbClient: dbClient, EventLog: eventLog, Webhooks: database.NewMemoryWebhookStore(), TrustUnauthenticated: true}
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		webhook.NewDispatcher(userServer.Webhooks, eventLog, testWebhookConfig(), cloudevents.DefaultConfig()).Run(ctx)
+		close(stopped)
+	}()
+	defer func() {
+		cancel()
+		<-stopped
+	}()
+
+	receiver := newWebhookReceiver(t, "secret")
+	_, err := userServer.CreateWebhook(context.Background(), &pb.CreateWebhookReq{Webhook: &pb.Webhook{Url: receiver.URL, Secret: "secret"}})
+	if err != nil {
+		t.Fatalf("Could not create webhook: %v", err)
+	}
+	for _, email := range []string{"a@a.com", "b@a.com", "c@a.com"} {
+		mustCreateTestUser(t, userServer, email)
+	}
+
+	// The events after the missing sequence wait for it, instead of leaving it behind the dispatch cursor
+	assert.EqualValues(t, "a@a.com", receiver.receive(t).Email)
+	time.Sleep(100 * time.Millisecond)
+	assert.Empty(t, receiver.events)
+
+	atomic.StoreInt64(&eventLog.hidden, 0)
+	emails := []string{receiver.receive(t).Email, receiver.receive(t).Email}
+	assert.ElementsMatch(t, []string{"b@a.com", "c@a.com"}, emails)
+}